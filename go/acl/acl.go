@@ -0,0 +1,167 @@
+package acl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sashankg/hold/p2p"
+)
+
+type Permission string
+
+const (
+	Read  Permission = "read"
+	Write Permission = "write"
+	// Upload allows storing blobs, which are not in a namespace.
+	Upload Permission = "upload"
+	// Admin allows changing the schema, collecting blobs and reading the
+	// status and profiles of the node, none of which are in a namespace.
+	Admin Permission = "admin"
+)
+
+const (
+	// Everyone stands for every peer in Policy.Peers, including requests
+	// that did not come over libp2p.
+	Everyone = "*"
+	// AnyNamespace stands for every namespace in Role.Namespaces.
+	AnyNamespace = "*"
+)
+
+type Role struct {
+	// Namespaces maps a namespace to the permissions on its collections.
+	// Collections without a namespace are in "".
+	Namespaces map[string][]Permission `yaml:"namespaces"`
+	Upload     bool                    `yaml:"upload"`
+	Admin      bool                    `yaml:"admin"`
+}
+
+// Policy gives peers roles by name. Peers without a role are denied
+// everything, so a policy without peers only allows the node itself, unless
+// it is Open.
+type Policy struct {
+	Roles map[string]Role     `yaml:"roles"`
+	Peers map[string][]string `yaml:"peers"`
+	// Open allows everything to every peer, for nodes that only trusted
+	// peers can reach.
+	Open bool `yaml:"open"`
+}
+
+type Authorizer interface {
+	// Known reports whether the peer of a request has any role or
+	// capability.
+	Known(ctx context.Context) bool
+	// Allowed reports whether the peer of a request has a permission on a
+	// collection, from its roles or from the capabilities added by
+	// Tokens.Authenticate. Upload and Admin ignore the namespace and
+	// collection.
+	Allowed(ctx context.Context, namespace string, collection string, permission Permission) bool
+}
+
+type aclImpl struct {
+	open     bool
+	self     peer.ID
+	peers    map[peer.ID][]Role
+	everyone []Role
+}
+
+// NewAcl returns an Authorizer for policy, which also allows everything to
+// self, the peer id of the node.
+func NewAcl(policy Policy, self peer.ID) (*aclImpl, error) {
+	errs := []error{}
+	for name, role := range policy.Roles {
+		for namespace, permissions := range role.Namespaces {
+			for _, permission := range permissions {
+				if permission != Read && permission != Write {
+					errs = append(errs, fmt.Errorf(
+						"role %s has invalid permission %s on namespace %s, should be read or write",
+						name, permission, namespace,
+					))
+				}
+			}
+		}
+	}
+	acl := &aclImpl{
+		open:  policy.Open,
+		self:  self,
+		peers: map[peer.ID][]Role{},
+	}
+	for id, roleNames := range policy.Peers {
+		roles := []Role{}
+		for _, roleName := range roleNames {
+			role, ok := policy.Roles[roleName]
+			if !ok {
+				errs = append(errs, fmt.Errorf("peer %s has unknown role %s", id, roleName))
+				continue
+			}
+			roles = append(roles, role)
+		}
+		if id == Everyone {
+			acl.everyone = roles
+			continue
+		}
+		peerId, err := peer.Decode(id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("peer %s is not a peer id: %w", id, err))
+			continue
+		}
+		acl.peers[peerId] = roles
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return acl, nil
+}
+
+var _ Authorizer = &aclImpl{}
+
+func (a *aclImpl) Known(ctx context.Context) bool {
+	return a.open || a.isSelf(ctx) || len(a.roles(ctx)) > 0 || len(capabilitiesFromContext(ctx)) > 0
+}
+
+func (a *aclImpl) Allowed(ctx context.Context, namespace string, collection string, permission Permission) bool {
+	if a.open || a.isSelf(ctx) {
+		return true
+	}
+	for _, capability := range capabilitiesFromContext(ctx) {
+		if capability.allows(namespace, collection, permission) {
+			return true
+		}
+	}
+	for _, role := range a.roles(ctx) {
+		if permission == Upload {
+			if role.Upload {
+				return true
+			}
+			continue
+		}
+		if permission == Admin {
+			if role.Admin {
+				return true
+			}
+			continue
+		}
+		for _, key := range []string{namespace, AnyNamespace} {
+			for _, granted := range role.Namespaces[key] {
+				if granted == permission {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (a *aclImpl) isSelf(ctx context.Context) bool {
+	id, ok := p2p.PeerFromContext(ctx)
+	return ok && a.self != "" && id == a.self
+}
+
+func (a *aclImpl) roles(ctx context.Context) []Role {
+	roles := a.everyone
+	if id, ok := p2p.PeerFromContext(ctx); ok {
+		roles = append(roles[:len(roles):len(roles)], a.peers[id]...)
+	}
+	return roles
+}
@@ -0,0 +1,104 @@
+package acl_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sashankg/hold/acl"
+	"github.com/sashankg/hold/p2p"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	owner  = "QmNpBvAKWrjigDHP4Mn3LpqCmin5F2K9TiVFoFGTC6ayV3"
+	friend = "QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"
+)
+
+func TestAcl(t *testing.T) {
+	authorizer, err := acl.NewAcl(acl.Policy{
+		Roles: map[string]acl.Role{
+			"owner": {
+				Namespaces: map[string][]acl.Permission{acl.AnyNamespace: {acl.Read, acl.Write}},
+				Upload:     true,
+				Admin:      true,
+			},
+			"photos": {
+				Namespaces: map[string][]acl.Permission{"photos": {acl.Read}},
+			},
+			"public": {
+				Namespaces: map[string][]acl.Permission{"": {acl.Read}},
+			},
+		},
+		Peers: map[string][]string{
+			owner:        {"owner"},
+			friend:       {"photos"},
+			acl.Everyone: {"public"},
+		},
+	}, "")
+	require.NoError(t, err)
+
+	withPeer := func(id string) context.Context {
+		peerId, err := peer.Decode(id)
+		require.NoError(t, err)
+		return p2p.WithPeer(context.Background(), peerId)
+	}
+	ownerCtx, friendCtx := withPeer(owner), withPeer(friend)
+	anonymousCtx := context.Background()
+
+	require.True(t, authorizer.Allowed(ownerCtx, "photos", "Photo", acl.Write))
+	require.True(t, authorizer.Allowed(ownerCtx, "", "Post", acl.Write))
+	require.True(t, authorizer.Allowed(ownerCtx, "", "", acl.Upload))
+	require.True(t, authorizer.Allowed(ownerCtx, "", "", acl.Admin))
+
+	require.True(t, authorizer.Allowed(friendCtx, "photos", "Photo", acl.Read))
+	require.True(t, authorizer.Allowed(friendCtx, "", "Post", acl.Read))
+	require.False(t, authorizer.Allowed(friendCtx, "photos", "Photo", acl.Write))
+	require.False(t, authorizer.Allowed(friendCtx, "notes", "Note", acl.Read))
+	require.False(t, authorizer.Allowed(friendCtx, "", "", acl.Upload))
+	require.False(t, authorizer.Allowed(friendCtx, "", "", acl.Admin))
+
+	require.True(t, authorizer.Known(anonymousCtx))
+	require.True(t, authorizer.Allowed(anonymousCtx, "", "Post", acl.Read))
+	require.False(t, authorizer.Allowed(anonymousCtx, "photos", "Photo", acl.Read))
+}
+
+func TestAclOpen(t *testing.T) {
+	authorizer, err := acl.NewAcl(acl.Policy{Open: true}, "")
+	require.NoError(t, err)
+	require.True(t, authorizer.Known(context.Background()))
+	require.True(t, authorizer.Allowed(context.Background(), "photos", "Photo", acl.Write))
+}
+
+func TestAclDefault(t *testing.T) {
+	self, err := peer.Decode(owner)
+	require.NoError(t, err)
+	other, err := peer.Decode(friend)
+	require.NoError(t, err)
+	authorizer, err := acl.NewAcl(acl.Policy{}, self)
+	require.NoError(t, err)
+
+	selfCtx := p2p.WithPeer(context.Background(), self)
+	require.True(t, authorizer.Known(selfCtx))
+	require.True(t, authorizer.Allowed(selfCtx, "", "", acl.Admin))
+	for _, ctx := range []context.Context{p2p.WithPeer(context.Background(), other), context.Background()} {
+		require.False(t, authorizer.Known(ctx))
+		require.False(t, authorizer.Allowed(ctx, "photos", "Photo", acl.Read))
+		require.False(t, authorizer.Allowed(ctx, "", "", acl.Admin))
+	}
+}
+
+func TestAclErrors(t *testing.T) {
+	_, err := acl.NewAcl(acl.Policy{
+		Roles: map[string]acl.Role{
+			"admin": {Namespaces: map[string][]acl.Permission{"": {"delete"}}},
+		},
+		Peers: map[string][]string{
+			"not a peer": {"admin"},
+			owner:        {"owner"},
+		},
+	}, "")
+	require.ErrorContains(t, err, "invalid permission delete")
+	require.ErrorContains(t, err, "peer not a peer is not a peer id")
+	require.ErrorContains(t, err, "unknown role owner")
+}
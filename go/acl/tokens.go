@@ -0,0 +1,271 @@
+package acl
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sashankg/hold/p2p"
+)
+
+// AnyCollection stands for every collection of a namespace in a Capability.
+const AnyCollection = "*"
+
+// Capability grants permissions on a collection, or on every collection of a
+// namespace. Namespace can be AnyNamespace.
+type Capability struct {
+	Namespace   string       `json:"ns"`
+	Collection  string       `json:"col"`
+	Permissions []Permission `json:"can"`
+}
+
+func (c Capability) allows(namespace string, collection string, permission Permission) bool {
+	if !c.can(permission) {
+		return false
+	}
+	if permission == Upload || permission == Admin {
+		return true
+	}
+	return (c.Namespace == AnyNamespace || c.Namespace == namespace) &&
+		(c.Collection == AnyCollection || c.Collection == collection)
+}
+
+func (c Capability) can(permission Permission) bool {
+	for _, granted := range c.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// covers reports whether a delegated capability is an attenuation of c.
+func (c Capability) covers(delegated Capability) bool {
+	if c.Namespace != AnyNamespace && c.Namespace != delegated.Namespace {
+		return false
+	}
+	if c.Collection != AnyCollection && c.Collection != delegated.Collection {
+		return false
+	}
+	for _, permission := range delegated.Permissions {
+		if !c.can(permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenPayload follows the UCAN claims. Proof is the token the capabilities
+// were delegated from, and is empty for tokens issued by the node.
+type tokenPayload struct {
+	Issuer       string       `json:"iss"`
+	Audience     string       `json:"aud"`
+	Capabilities []Capability `json:"att"`
+	Expiry       int64        `json:"exp"`
+	Proof        string       `json:"prf,omitempty"`
+}
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+}
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Delegate signs a token that passes capabilities to the audience until
+// expiry. The key is the issuer's, which has to be the audience of the parent
+// token, and the capabilities and expiry can only narrow the parent's. An
+// empty parent issues a root token, which is only valid if the key is the
+// node's.
+func Delegate(
+	parent string,
+	key crypto.PrivKey,
+	audience peer.ID,
+	capabilities []Capability,
+	expiry time.Time,
+) (string, error) {
+	issuer, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	payload := tokenPayload{
+		Issuer:       issuer.String(),
+		Audience:     audience.String(),
+		Capabilities: capabilities,
+		Expiry:       expiry.Unix(),
+		Proof:        parent,
+	}
+	if parent != "" {
+		parentPayload, err := decodeToken(parent)
+		if err != nil {
+			return "", err
+		}
+		if err := checkDelegation(parentPayload, &payload); err != nil {
+			return "", err
+		}
+	}
+	header, err := json.Marshal(tokenHeader{Algorithm: key.Type().String(), Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(body)
+	signature, err := key.Sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// decodeToken checks the signature of a token against the public key of its
+// issuer, which is extracted from the peer id.
+func decodeToken(token string) (*tokenPayload, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	payload := &tokenPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	issuer, err := peer.Decode(payload.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: issuer: %w", ErrInvalidToken, err)
+	}
+	publicKey, err := issuer.ExtractPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("%w: issuer key: %w", ErrInvalidToken, err)
+	}
+	ok, err := publicKey.Verify([]byte(parts[0]+"."+parts[1]), signature)
+	if err != nil || !ok {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+	return payload, nil
+}
+
+func checkDelegation(parent *tokenPayload, payload *tokenPayload) error {
+	if parent.Audience != payload.Issuer {
+		return fmt.Errorf("%w: issuer %s is not the audience of its proof", ErrInvalidToken, payload.Issuer)
+	}
+	if payload.Expiry > parent.Expiry {
+		return fmt.Errorf("%w: expires after its proof", ErrInvalidToken)
+	}
+	for _, capability := range payload.Capabilities {
+		covered := false
+		for _, parentCapability := range parent.Capabilities {
+			if parentCapability.covers(capability) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return fmt.Errorf("%w: capability on %s/%s is not in its proof",
+				ErrInvalidToken, capability.Namespace, capability.Collection)
+		}
+	}
+	return nil
+}
+
+// Tokens issues root tokens with the node's key, and verifies token chains
+// that lead back to one.
+type Tokens struct {
+	key    crypto.PrivKey
+	issuer peer.ID
+	now    func() time.Time
+}
+
+func NewTokens(key crypto.PrivKey, now func() time.Time) (*Tokens, error) {
+	issuer, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Tokens{
+		key:    key,
+		issuer: issuer,
+		now:    now,
+	}, nil
+}
+
+func (t *Tokens) Issue(audience peer.ID, capabilities []Capability, expiry time.Time) (string, error) {
+	return Delegate("", t.key, audience, capabilities, expiry)
+}
+
+// Verify returns the capabilities of a token held by the peer of a request.
+func (t *Tokens) Verify(ctx context.Context, token string) ([]Capability, error) {
+	holder, ok := p2p.PeerFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("%w: request has no peer", ErrInvalidToken)
+	}
+	payload, err := decodeToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if payload.Audience != holder.String() {
+		return nil, fmt.Errorf("%w: not issued to %s", ErrInvalidToken, holder)
+	}
+	capabilities := payload.Capabilities
+	now := t.now().Unix()
+	for {
+		if payload.Expiry < now {
+			return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+		}
+		if payload.Proof == "" {
+			break
+		}
+		parent, err := decodeToken(payload.Proof)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkDelegation(parent, payload); err != nil {
+			return nil, err
+		}
+		payload = parent
+	}
+	if payload.Issuer != t.issuer.String() {
+		return nil, fmt.Errorf("%w: not issued by this node", ErrInvalidToken)
+	}
+	return capabilities, nil
+}
+
+// Authenticate adds the capabilities of the bearer token in an Authorization
+// header to the context, for Authorizer.Allowed. Without a header the context
+// is unchanged.
+func (t *Tokens) Authenticate(ctx context.Context, authorization string) (context.Context, error) {
+	if authorization == "" {
+		return ctx, nil
+	}
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return nil, fmt.Errorf("%w: not a bearer token", ErrInvalidToken)
+	}
+	capabilities, err := t.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, capabilitiesKey{}, capabilities), nil
+}
+
+type capabilitiesKey struct{}
+
+func capabilitiesFromContext(ctx context.Context) []Capability {
+	capabilities, _ := ctx.Value(capabilitiesKey{}).([]Capability)
+	return capabilities
+}
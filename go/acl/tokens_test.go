@@ -0,0 +1,88 @@
+package acl_test
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sashankg/hold/acl"
+	"github.com/sashankg/hold/p2p"
+	"github.com/stretchr/testify/require"
+)
+
+func newKey(t *testing.T) (crypto.PrivKey, peer.ID) {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	return key, id
+}
+
+func TestTokens(t *testing.T) {
+	nodeKey, _ := newKey(t)
+	appKey, app := newKey(t)
+	pluginKey, plugin := newKey(t)
+	otherKey, _ := newKey(t)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tokens, err := acl.NewTokens(nodeKey, func() time.Time { return now })
+	require.NoError(t, err)
+	authorizer, err := acl.NewAcl(acl.Policy{
+		Roles: map[string]acl.Role{"none": {}},
+		Peers: map[string][]string{owner: {"none"}},
+	}, "")
+	require.NoError(t, err)
+
+	photos := acl.Capability{Namespace: "photos", Collection: acl.AnyCollection, Permissions: []acl.Permission{acl.Read, acl.Write}}
+	appToken, err := tokens.Issue(app, []acl.Capability{photos}, now.Add(30*24*time.Hour))
+	require.NoError(t, err)
+
+	appCtx := p2p.WithPeer(context.Background(), app)
+	ctx, err := tokens.Authenticate(appCtx, "Bearer "+appToken)
+	require.NoError(t, err)
+	require.True(t, authorizer.Known(ctx))
+	require.True(t, authorizer.Allowed(ctx, "photos", "Photo", acl.Write))
+	require.False(t, authorizer.Allowed(ctx, "notes", "Note", acl.Read))
+	require.False(t, authorizer.Known(appCtx))
+
+	// the app passes read access to albums on to a plugin
+	albums := acl.Capability{Namespace: "photos", Collection: "Album", Permissions: []acl.Permission{acl.Read}}
+	pluginToken, err := acl.Delegate(appToken, appKey, plugin, []acl.Capability{albums}, now.Add(24*time.Hour))
+	require.NoError(t, err)
+	ctx, err = tokens.Authenticate(p2p.WithPeer(context.Background(), plugin), "Bearer "+pluginToken)
+	require.NoError(t, err)
+	require.True(t, authorizer.Allowed(ctx, "photos", "Album", acl.Read))
+	require.False(t, authorizer.Allowed(ctx, "photos", "Album", acl.Write))
+	require.False(t, authorizer.Allowed(ctx, "photos", "Photo", acl.Read))
+
+	// capabilities and expiry cannot be widened
+	notes := acl.Capability{Namespace: "notes", Collection: acl.AnyCollection, Permissions: []acl.Permission{acl.Read}}
+	_, err = acl.Delegate(appToken, appKey, plugin, []acl.Capability{notes}, now.Add(time.Hour))
+	require.ErrorIs(t, err, acl.ErrInvalidToken)
+	_, err = acl.Delegate(appToken, appKey, plugin, []acl.Capability{albums}, now.Add(365*24*time.Hour))
+	require.ErrorIs(t, err, acl.ErrInvalidToken)
+	// only the audience can delegate
+	_, err = acl.Delegate(appToken, pluginKey, plugin, []acl.Capability{albums}, now.Add(time.Hour))
+	require.ErrorIs(t, err, acl.ErrInvalidToken)
+
+	// tokens are bound to their audience
+	_, err = tokens.Authenticate(p2p.WithPeer(context.Background(), plugin), "Bearer "+appToken)
+	require.ErrorIs(t, err, acl.ErrInvalidToken)
+	// root tokens have to be issued by the node
+	otherToken, err := acl.Delegate("", otherKey, app, []acl.Capability{photos}, now.Add(time.Hour))
+	require.NoError(t, err)
+	_, err = tokens.Authenticate(appCtx, "Bearer "+otherToken)
+	require.ErrorIs(t, err, acl.ErrInvalidToken)
+	// tampering breaks the signature
+	_, err = tokens.Authenticate(appCtx, "Bearer "+appToken[:len(appToken)-4]+"AAAA")
+	require.ErrorIs(t, err, acl.ErrInvalidToken)
+
+	now = now.Add(2 * 24 * time.Hour)
+	_, err = tokens.Authenticate(p2p.WithPeer(context.Background(), plugin), "Bearer "+pluginToken)
+	require.ErrorContains(t, err, "expired")
+	_, err = tokens.Authenticate(appCtx, "Bearer "+appToken)
+	require.NoError(t, err)
+}
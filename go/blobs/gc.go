@@ -0,0 +1,75 @@
+package blobs
+
+import (
+	"context"
+	"time"
+)
+
+// ReferenceSource finds the blobs that are still in use.
+type ReferenceSource interface {
+	// ReferencedBlobs returns the digest of every blob that is referenced.
+	ReferencedBlobs(ctx context.Context) (map[string]bool, error)
+}
+
+// GarbageCollector deletes blobs that no record refers to.
+//
+// Blobs younger than the grace period are kept, because they may have just
+// been uploaded for a record that is about to be written.
+type GarbageCollector struct {
+	store       *storeImpl
+	references  ReferenceSource
+	gracePeriod time.Duration
+}
+
+func NewGarbageCollector(store *storeImpl, references ReferenceSource, gracePeriod time.Duration) *GarbageCollector {
+	return &GarbageCollector{
+		store,
+		references,
+		gracePeriod,
+	}
+}
+
+// GcReport lists the blobs that a collection deleted, or would delete in a
+// dry run.
+type GcReport struct {
+	DryRun     bool    `json:"dryRun"`
+	Referenced int     `json:"referenced"`
+	Deleted    []*Blob `json:"deleted"`
+	FreedBytes int64   `json:"freedBytes"`
+}
+
+// Collect marks the referenced blobs and sweeps the others that are older
+// than the grace period. Nothing is deleted if dryRun is set.
+func (c *GarbageCollector) Collect(ctx context.Context, dryRun bool) (*GcReport, error) {
+	// writes that refer to blobs and uploads wait until the sweep is done, so
+	// that no blob is referred to after it is marked
+	c.store.references.Lock()
+	defer c.store.references.Unlock()
+
+	candidates, err := c.store.List(ctx, time.Now().Add(-c.gracePeriod))
+	if err != nil {
+		return nil, err
+	}
+	referenced, err := c.references.ReferencedBlobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &GcReport{
+		DryRun:     dryRun,
+		Referenced: len(referenced),
+		Deleted:    []*Blob{},
+	}
+	for _, blob := range candidates {
+		if referenced[blob.Digest] {
+			continue
+		}
+		if !dryRun {
+			if err := c.store.Delete(ctx, blob.Digest); err != nil {
+				return nil, err
+			}
+		}
+		report.Deleted = append(report.Deleted, blob)
+		report.FreedBytes += blob.Size
+	}
+	return report, nil
+}
@@ -0,0 +1,144 @@
+package blobs_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashankg/hold/blobs"
+	"github.com/sashankg/hold/dao"
+	"github.com/sashankg/hold/testing/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGarbageCollector(t *testing.T) {
+	ctx := context.Background()
+	testDao := util.NewMemoryDao(t)
+	require.NoError(t, testDao.AddCollections(ctx, []*dao.Collection{{
+		Name:    "Post",
+		Version: "1",
+		Fields: map[string]dao.CollectionField{
+			"photo":       {Name: "photo", Type: dao.BlobType},
+			"attachments": {Name: "attachments", Type: dao.BlobType, IsList: true},
+		},
+	}}))
+	store, err := blobs.NewStore(testDao.SchemaDb, t.TempDir(), &blobs.Sha256Hasher{})
+	require.NoError(t, err)
+	digests := []string{}
+	for _, content := range []string{"photo", "attachment", "orphan"} {
+		blob, err := store.Put(ctx, strings.NewReader(content), "")
+		require.NoError(t, err)
+		digests = append(digests, blob.Digest)
+	}
+	_, err = testDao.RecordDb.Exec(`
+		INSERT INTO Post (id, photo) VALUES (1, ?), (2, NULL);
+		INSERT INTO Post_attachments (owner, position, value) VALUES (2, 0, ?);
+	`, digests[0], digests[1])
+	require.NoError(t, err)
+
+	// the blobs were just uploaded
+	report, err := blobs.NewGarbageCollector(store, testDao, time.Hour).Collect(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, report.Deleted)
+
+	collector := blobs.NewGarbageCollector(store, testDao, -time.Hour)
+	report, err = collector.Collect(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Referenced)
+	require.Len(t, report.Deleted, 1)
+	require.Equal(t, digests[2], report.Deleted[0].Digest)
+	require.Equal(t, int64(len("orphan")), report.FreedBytes)
+	_, err = store.Stat(ctx, digests[2])
+	require.NoError(t, err)
+
+	report, err = collector.Collect(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, report.Deleted, 1)
+	_, _, err = store.Open(ctx, digests[2])
+	require.Error(t, err)
+	_, _, err = store.Open(ctx, digests[0])
+	require.NoError(t, err)
+}
+
+func TestGarbageCollectorRace(t *testing.T) {
+	ctx := context.Background()
+	testDao := util.NewMemoryDao(t)
+	store, err := blobs.NewStore(testDao.SchemaDb, t.TempDir(), &blobs.Sha256Hasher{})
+	require.NoError(t, err)
+	collector := blobs.NewGarbageCollector(store, testDao, time.Hour)
+
+	// uploading a blob again keeps it for the grace period
+	blob, err := store.Put(ctx, strings.NewReader("again"), "")
+	require.NoError(t, err)
+	_, err = testDao.SchemaDb.Exec(`UPDATE blobs SET created_at = '2000-01-01 00:00:00'`)
+	require.NoError(t, err)
+	_, err = store.Put(ctx, strings.NewReader("again"), "")
+	require.NoError(t, err)
+	report, err := collector.Collect(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, report.Deleted)
+
+	// a write that refers to blobs holds off the sweep
+	_, err = testDao.SchemaDb.Exec(`UPDATE blobs SET created_at = '2000-01-01 00:00:00'`)
+	require.NoError(t, err)
+	release := store.Refer()
+	collected := make(chan error)
+	go func() {
+		_, err := collector.Collect(ctx, false)
+		collected <- err
+	}()
+	select {
+	case <-collected:
+		t.Fatal("collected while a write refers to blobs")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, err = store.Stat(ctx, blob.Digest)
+	require.NoError(t, err)
+	release()
+	require.NoError(t, <-collected)
+	_, err = store.Stat(ctx, blob.Digest)
+	require.Error(t, err)
+}
+
+func TestGarbageCollectorHistory(t *testing.T) {
+	ctx := context.Background()
+	testDao := util.NewMemoryDao(t)
+	require.NoError(t, testDao.AddCollections(ctx, []*dao.Collection{{
+		Name:    "Post",
+		Version: "1",
+		Fields: map[string]dao.CollectionField{
+			"photo":       {Name: "photo", Type: dao.BlobType},
+			"attachments": {Name: "attachments", Type: dao.BlobType, IsList: true},
+		},
+	}}))
+	postId, err := testDao.GetCollectionId(ctx, dao.CollectionSpec{Name: "Post"})
+	require.NoError(t, err)
+	store, err := blobs.NewStore(testDao.SchemaDb, t.TempDir(), &blobs.Sha256Hasher{})
+	require.NoError(t, err)
+	photo, err := store.Put(ctx, strings.NewReader("photo"), "")
+	require.NoError(t, err)
+	attachment, err := store.Put(ctx, strings.NewReader("attachment"), "")
+	require.NoError(t, err)
+	collector := blobs.NewGarbageCollector(store, testDao, -time.Hour)
+
+	// only an earlier version of the post refers to the blobs
+	_, err = testDao.SetRecord(ctx, map[string]any{
+		"photo":       photo.Digest,
+		"attachments": []any{attachment.Digest},
+	}, nil, postId)
+	require.NoError(t, err)
+	_, err = testDao.PatchRecord(ctx, 1, map[string]any{"photo": nil, "attachments": []any{}}, nil, postId)
+	require.NoError(t, err)
+	report, err := collector.Collect(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Referenced)
+	require.Empty(t, report.Deleted)
+
+	// deleting the post purges its history
+	_, err = testDao.DeleteRecord(ctx, 1, nil, postId)
+	require.NoError(t, err)
+	report, err = collector.Collect(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, report.Deleted, 2)
+}
@@ -0,0 +1,274 @@
+package blobs
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Store keeps uploaded content on disk under the digest of the content, so
+// identical uploads are stored once.
+type Store interface {
+	// Put stores the content of reader. The mime type is sniffed from the
+	// content if it is empty.
+	Put(ctx context.Context, reader io.Reader, mimeType string) (*Blob, error)
+	// Stat returns the metadata of a blob, or sql.ErrNoRows if there is no
+	// blob with the digest.
+	Stat(ctx context.Context, digest string) (*Blob, error)
+	// Open returns the content of a blob along with its metadata.
+	Open(ctx context.Context, digest string) (*os.File, *Blob, error)
+	// List returns the blobs created before a time, oldest first.
+	List(ctx context.Context, createdBefore time.Time) ([]*Blob, error)
+	// Delete removes a blob and its content.
+	Delete(ctx context.Context, digest string) error
+	// Refer keeps the garbage collector from sweeping until the returned
+	// function is called. Writes that refer to blobs hold it from when they
+	// check that the blobs exist until they commit.
+	Refer() (release func())
+}
+
+type Blob struct {
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	MimeType  string    `json:"mimeType"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Hasher creates the hash that blobs are identified by.
+type Hasher interface {
+	New() hash.Hash
+}
+
+type Sha256Hasher struct{}
+
+func (h *Sha256Hasher) New() hash.Hash {
+	return sha256.New()
+}
+
+// ErrInvalidDigest is returned for digests that are not lowercase hex.
+var ErrInvalidDigest = errors.New("invalid digest")
+
+var digestMatcher = regexp.MustCompile("^[0-9a-f]{16,128}$")
+
+func CheckDigest(digest string) error {
+	if !digestMatcher.MatchString(digest) {
+		return ErrInvalidDigest
+	}
+	return nil
+}
+
+type storeImpl struct {
+	db     *sql.DB
+	dir    string
+	hasher Hasher
+	// references is held for writing by the garbage collector from when it
+	// lists blobs until it sweeps them.
+	references sync.RWMutex
+}
+
+var _ Store = (*storeImpl)(nil)
+
+// NewStore returns a Store that keeps content in dir and metadata in the
+// blobs table of db.
+func NewStore(db *sql.DB, dir string, hasher Hasher) (*storeImpl, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0o755); err != nil {
+		return nil, err
+	}
+	return &storeImpl{
+		db:     db,
+		dir:    dir,
+		hasher: hasher,
+	}, nil
+}
+
+// Put implements Store. The content is written to a temporary file while it
+// is hashed, then moved to its path if no blob has the same digest.
+func (s *storeImpl) Put(ctx context.Context, reader io.Reader, mimeType string) (*Blob, error) {
+	file, err := os.CreateTemp(filepath.Join(s.dir, "tmp"), "put-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	hash := s.hasher.New()
+	sniffer := &sniffWriter{}
+	size, err := io.Copy(io.MultiWriter(file, hash, sniffer), reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	if mimeType == "" {
+		mimeType = http.DetectContentType(sniffer.head)
+	}
+	return s.commit(ctx, file.Name(), hex.EncodeToString(hash.Sum(nil)), size, mimeType)
+}
+
+// commit moves a finished file into the store under digest and records its
+// metadata. An existing blob with the digest is kept, but is created again as
+// far as the grace period of the garbage collector is concerned, as the
+// upload is likely to be referred to soon.
+func (s *storeImpl) commit(
+	ctx context.Context,
+	name string,
+	digest string,
+	size int64,
+	mimeType string,
+) (*Blob, error) {
+	defer s.Refer()()
+
+	result, err := sq.Update("blobs").
+		Set("created_at", sq.Expr("CURRENT_TIMESTAMP")).
+		Where(sq.Eq{"digest": digest}).
+		RunWith(s.db).
+		ExecContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected > 0 {
+		return s.Stat(ctx, digest)
+	}
+	path := s.path(digest)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(name, path); err != nil {
+		return nil, err
+	}
+	_, err = sq.Insert("blobs").
+		Options("OR IGNORE").
+		Columns("digest", "size", "mime_type").
+		Values(digest, size, mimeType).
+		RunWith(s.db).
+		ExecContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stat(ctx, digest)
+}
+
+// Stat implements Store.
+func (s *storeImpl) Stat(ctx context.Context, digest string) (*Blob, error) {
+	if err := CheckDigest(digest); err != nil {
+		return nil, err
+	}
+	blob := &Blob{}
+	err := sq.Select("digest", "size", "mime_type", "created_at").
+		From("blobs").
+		Where(sq.Eq{"digest": digest}).
+		RunWith(s.db).
+		QueryRowContext(ctx).
+		Scan(&blob.Digest, &blob.Size, &blob.MimeType, &blob.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// Open implements Store.
+func (s *storeImpl) Open(ctx context.Context, digest string) (*os.File, *Blob, error) {
+	blob, err := s.Stat(ctx, digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(s.path(digest))
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, blob, nil
+}
+
+// List implements Store.
+func (s *storeImpl) List(ctx context.Context, createdBefore time.Time) ([]*Blob, error) {
+	rows, err := sq.Select("digest", "size", "mime_type", "created_at").
+		From("blobs").
+		// created_at is set by CURRENT_TIMESTAMP, which is UTC in this format
+		Where(sq.Lt{"created_at": createdBefore.UTC().Format(time.DateTime)}).
+		OrderBy("created_at", "digest").
+		RunWith(s.db).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	blobs := []*Blob{}
+	for rows.Next() {
+		blob := &Blob{}
+		if err := rows.Scan(&blob.Digest, &blob.Size, &blob.MimeType, &blob.CreatedAt); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, rows.Err()
+}
+
+// Delete implements Store. The metadata is deleted first, so a blob is
+// never listed without its content.
+func (s *storeImpl) Delete(ctx context.Context, digest string) error {
+	if err := CheckDigest(digest); err != nil {
+		return err
+	}
+	result, err := sq.Delete("blobs").
+		Where(sq.Eq{"digest": digest}).
+		RunWith(s.db).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	if err := os.Remove(s.path(digest)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Refer implements Store.
+func (s *storeImpl) Refer() func() {
+	s.references.RLock()
+	return s.references.RUnlock
+}
+
+// path shards blobs into directories by the first two bytes of the digest,
+// so no directory holds too many files.
+func (s *storeImpl) path(digest string) string {
+	return filepath.Join(s.dir, digest[0:2], digest[2:4], digest)
+}
+
+// sniffWriter keeps the first bytes written to it for content sniffing.
+type sniffWriter struct {
+	head []byte
+}
+
+func (w *sniffWriter) Write(p []byte) (int, error) {
+	if remaining := sniffLen - len(w.head); remaining > 0 {
+		w.head = append(w.head, p[:min(remaining, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// sniffLen is the most http.DetectContentType looks at.
+const sniffLen = 512
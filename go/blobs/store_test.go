@@ -0,0 +1,51 @@
+package blobs_test
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sashankg/hold/blobs"
+	"github.com/sashankg/hold/testing/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := blobs.NewStore(util.NewMemoryDao(t).SchemaDb, dir, &blobs.Sha256Hasher{})
+	require.NoError(t, err)
+
+	blob, err := store.Put(ctx, strings.NewReader("hello world"), "")
+	require.NoError(t, err)
+	require.Equal(t, "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", blob.Digest)
+	require.Equal(t, int64(11), blob.Size)
+	require.Equal(t, "text/plain; charset=utf-8", blob.MimeType)
+	require.FileExists(t, filepath.Join(dir, "b9", "4d", blob.Digest))
+
+	// identical content is stored once, with the metadata of the first put
+	again, err := store.Put(ctx, strings.NewReader("hello world"), "text/markdown")
+	require.NoError(t, err)
+	require.Equal(t, blob, again)
+	tmp, err := os.ReadDir(filepath.Join(dir, "tmp"))
+	require.NoError(t, err)
+	require.Empty(t, tmp)
+
+	file, stat, err := store.Open(ctx, blob.Digest)
+	require.NoError(t, err)
+	defer file.Close()
+	require.Equal(t, blob, stat)
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(content))
+
+	_, err = store.Stat(ctx, strings.Repeat("0", 64))
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = store.Stat(ctx, "../../schema.db")
+	require.ErrorIs(t, err, blobs.ErrInvalidDigest)
+}
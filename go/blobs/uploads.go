@@ -0,0 +1,359 @@
+package blobs
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Uploads keeps the state of resumable uploads, which are appended to in
+// chunks and put into the Store once they are complete.
+type Uploads interface {
+	Create(ctx context.Context, spec UploadSpec) (*Upload, error)
+	// Get returns an upload that has not expired, or sql.ErrNoRows.
+	Get(ctx context.Context, id string) (*Upload, error)
+	// Append writes the content of reader at offset, which has to be the
+	// offset of the upload. Content that was received before reader fails
+	// is kept. The upload is finalized when it reaches its length.
+	Append(ctx context.Context, id string, offset int64, reader io.Reader) (*Upload, error)
+	Delete(ctx context.Context, id string) error
+	// Expire deletes the uploads that expired before now.
+	Expire(ctx context.Context, now time.Time) (int, error)
+}
+
+// UploadSpec describes an upload before its content is sent.
+type UploadSpec struct {
+	Length int64
+	// Metadata is kept as it is for clients to read back.
+	Metadata string
+	// MimeType of the blob, or empty to sniff it from the content.
+	MimeType string
+	// Digest is checked against the content when the upload is finalized,
+	// unless it is empty.
+	Digest string
+}
+
+type Upload struct {
+	UploadSpec
+	Id     string
+	Offset int64
+	// BlobDigest is the digest of the blob once the upload is finalized.
+	BlobDigest string
+	ExpiresAt  time.Time
+}
+
+func (u *Upload) IsFinal() bool {
+	return u.BlobDigest != ""
+}
+
+var (
+	// ErrOffsetMismatch is returned by Append when the offset is not the
+	// offset of the upload.
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	// ErrDigestMismatch is returned by Append when the complete content does
+	// not have the digest of the upload. The upload is deleted.
+	ErrDigestMismatch = errors.New("upload digest mismatch")
+)
+
+type uploadsImpl struct {
+	db         *sql.DB
+	store      *storeImpl
+	dir        string
+	expiration time.Duration
+	locks      sync.Map
+}
+
+var _ Uploads = (*uploadsImpl)(nil)
+
+// NewUploads returns Uploads that keep partial content next to the blobs of
+// store. Uploads expire when they have not been appended to for expiration.
+func NewUploads(db *sql.DB, store *storeImpl, expiration time.Duration) (*uploadsImpl, error) {
+	dir := filepath.Join(store.dir, "uploads")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &uploadsImpl{
+		db:         db,
+		store:      store,
+		dir:        dir,
+		expiration: expiration,
+	}, nil
+}
+
+// Create implements Uploads.
+func (u *uploadsImpl) Create(ctx context.Context, spec UploadSpec) (*Upload, error) {
+	if spec.Digest != "" {
+		if err := CheckDigest(spec.Digest); err != nil {
+			return nil, err
+		}
+	}
+	id, err := newUploadId()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Create(u.path(id))
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	upload := &Upload{
+		UploadSpec: spec,
+		Id:         id,
+		ExpiresAt:  time.Now().UTC().Add(u.expiration),
+	}
+	_, err = sq.Insert("uploads").
+		Columns("id", "length", "metadata", "mime_type", "expected_digest", "expires_at").
+		Values(id, spec.Length, spec.Metadata, spec.MimeType, spec.Digest, upload.ExpiresAt).
+		RunWith(u.db).
+		ExecContext(ctx)
+	if err != nil {
+		os.Remove(u.path(id))
+		return nil, err
+	}
+	if spec.Length == 0 {
+		return u.finalize(ctx, upload)
+	}
+	return upload, nil
+}
+
+// Get implements Uploads.
+func (u *uploadsImpl) Get(ctx context.Context, id string) (*Upload, error) {
+	upload := &Upload{}
+	var blobDigest *string
+	err := sq.Select(
+		"id",
+		"length",
+		"upload_offset",
+		"metadata",
+		"mime_type",
+		"expected_digest",
+		"blob_digest",
+		"expires_at",
+	).
+		From("uploads").
+		Where(sq.Eq{"id": id}).
+		Where(sq.Gt{"expires_at": time.Now().UTC()}).
+		RunWith(u.db).
+		QueryRowContext(ctx).
+		Scan(
+			&upload.Id,
+			&upload.Length,
+			&upload.Offset,
+			&upload.Metadata,
+			&upload.MimeType,
+			&upload.Digest,
+			&blobDigest,
+			&upload.ExpiresAt,
+		)
+	if err != nil {
+		return nil, err
+	}
+	if blobDigest != nil {
+		upload.BlobDigest = *blobDigest
+	}
+	return upload, nil
+}
+
+// Append implements Uploads.
+func (u *uploadsImpl) Append(
+	ctx context.Context,
+	id string,
+	offset int64,
+	reader io.Reader,
+) (*Upload, error) {
+	defer u.lock(id)()
+
+	upload, err := u.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.IsFinal() || offset != upload.Offset {
+		return nil, ErrOffsetMismatch
+	}
+	file, err := os.OpenFile(u.path(id), os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	// a write that failed before its offset was saved may have left content
+	// past the offset
+	if err := file.Truncate(offset); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	written, copyErr := io.Copy(file, io.LimitReader(reader, upload.Length-offset))
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+
+	upload.Offset += written
+	upload.ExpiresAt = time.Now().UTC().Add(u.expiration)
+	_, err = sq.Update("uploads").
+		Set("upload_offset", upload.Offset).
+		Set("expires_at", upload.ExpiresAt).
+		Where(sq.Eq{"id": id}).
+		RunWith(u.db).
+		ExecContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if copyErr != nil {
+		return upload, copyErr
+	}
+	if upload.Offset == upload.Length {
+		return u.finalize(ctx, upload)
+	}
+	return upload, nil
+}
+
+// finalize hashes the complete content and moves it into the store.
+func (u *uploadsImpl) finalize(ctx context.Context, upload *Upload) (*Upload, error) {
+	file, err := os.Open(u.path(upload.Id))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := u.store.hasher.New()
+	sniffer := &sniffWriter{}
+	size, err := io.Copy(io.MultiWriter(hash, sniffer), file)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if upload.Digest != "" && upload.Digest != digest {
+		if err := u.remove(ctx, upload.Id); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: content has digest %s", ErrDigestMismatch, digest)
+	}
+	mimeType := upload.MimeType
+	if mimeType == "" {
+		mimeType = http.DetectContentType(sniffer.head)
+	}
+	blob, err := u.store.commit(ctx, file.Name(), digest, size, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	upload.BlobDigest = blob.Digest
+	_, err = sq.Update("uploads").
+		Set("blob_digest", upload.BlobDigest).
+		Where(sq.Eq{"id": upload.Id}).
+		RunWith(u.db).
+		ExecContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// the content is only still there if the store already had the blob
+	os.Remove(file.Name())
+	return upload, nil
+}
+
+// Delete implements Uploads.
+func (u *uploadsImpl) Delete(ctx context.Context, id string) error {
+	defer u.lock(id)()
+	return u.remove(ctx, id)
+}
+
+// remove deletes an upload that matches conditions, or returns
+// sql.ErrNoRows. The caller holds the lock of the upload.
+func (u *uploadsImpl) remove(ctx context.Context, id string, conditions ...sq.Sqlizer) error {
+	deleteQuery := sq.Delete("uploads").Where(sq.Eq{"id": id})
+	for _, condition := range conditions {
+		deleteQuery = deleteQuery.Where(condition)
+	}
+	result, err := deleteQuery.RunWith(u.db).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	u.locks.Delete(id)
+	if err := os.Remove(u.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// lock locks the upload with id until the returned function is called, so
+// that it is not deleted while it is appended to.
+func (u *uploadsImpl) lock(id string) func() {
+	lock, _ := u.locks.LoadOrStore(id, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
+
+// Expire implements Uploads. Uploads that were appended to since they were
+// listed are kept.
+func (u *uploadsImpl) Expire(ctx context.Context, now time.Time) (int, error) {
+	expired := sq.LtOrEq{"expires_at": now.UTC()}
+	rows, err := sq.Select("id").
+		From("uploads").
+		Where(expired).
+		RunWith(u.db).
+		QueryContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, id := range ids {
+		err := func() error {
+			defer u.lock(id)()
+			return u.remove(ctx, id, expired)
+		}()
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (u *uploadsImpl) path(id string) string {
+	return filepath.Join(u.dir, id)
+}
+
+func newUploadId() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
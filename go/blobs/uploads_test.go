@@ -0,0 +1,81 @@
+package blobs_test
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashankg/hold/blobs"
+	"github.com/sashankg/hold/testing/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadsExpire(t *testing.T) {
+	ctx := context.Background()
+	db := util.NewMemoryDao(t).SchemaDb
+	store, err := blobs.NewStore(db, t.TempDir(), &blobs.Sha256Hasher{})
+	require.NoError(t, err)
+	uploads, err := blobs.NewUploads(db, store, time.Minute)
+	require.NoError(t, err)
+
+	upload, err := uploads.Create(ctx, blobs.UploadSpec{Length: 10})
+	require.NoError(t, err)
+	upload, err = uploads.Append(ctx, upload.Id, 0, strings.NewReader("hello"))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), upload.Offset)
+	require.False(t, upload.IsFinal())
+
+	expired, err := uploads.Expire(ctx, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 0, expired)
+
+	expired, err = uploads.Expire(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, expired)
+	_, err = uploads.Get(ctx, upload.Id)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestUploadsDeleteWhileAppending(t *testing.T) {
+	ctx := context.Background()
+	db := util.NewMemoryDao(t).SchemaDb
+	store, err := blobs.NewStore(db, t.TempDir(), &blobs.Sha256Hasher{})
+	require.NoError(t, err)
+	uploads, err := blobs.NewUploads(db, store, time.Minute)
+	require.NoError(t, err)
+
+	upload, err := uploads.Create(ctx, blobs.UploadSpec{Length: 10})
+	require.NoError(t, err)
+	reader, writer := io.Pipe()
+	var appendedUpload *blobs.Upload
+	appended := make(chan error)
+	go func() {
+		var err error
+		appendedUpload, err = uploads.Append(ctx, upload.Id, 0, reader)
+		appended <- err
+	}()
+	// the append is copying once the pipe is read from
+	_, err = writer.Write([]byte("hel"))
+	require.NoError(t, err)
+
+	deleted := make(chan error)
+	go func() {
+		deleted <- uploads.Delete(ctx, upload.Id)
+	}()
+	select {
+	case <-deleted:
+		t.Fatal("upload deleted while appending")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, err = writer.Write([]byte("lo"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	require.NoError(t, <-appended)
+	require.Equal(t, int64(5), appendedUpload.Offset)
+	require.NoError(t, <-deleted)
+	_, err = uploads.Get(ctx, upload.Id)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
@@ -10,6 +10,10 @@ type MutationResolver interface {
 	MutationFields() graphql.Fields
 }
 
+type SubscriptionResolver interface {
+	SubscriptionFields() graphql.Fields
+}
+
 type TypeSource interface {
 	Types() []graphql.Type
 }
@@ -0,0 +1,117 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+type ChangeOperation string
+
+const (
+	ChangeSet    ChangeOperation = "SET"
+	ChangePatch  ChangeOperation = "PATCH"
+	ChangeDelete ChangeOperation = "DELETE"
+)
+
+// Change is a record that was written. Records whose references were set to
+// null by a delete are patched.
+type Change struct {
+	CollectionId int
+	Id           int
+	Operation    ChangeOperation
+	// Source is the source of the write given to WithChangeSource, and is
+	// empty for writes made on this node.
+	Source string
+}
+
+type changeSourceKey struct{}
+
+// WithChangeSource marks the writes made with ctx as coming from elsewhere,
+// such as a replica, so that listeners can tell them apart from local ones.
+func WithChangeSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, changeSourceKey{}, source)
+}
+
+// ChangeListener is called after every write to the records commits, with
+// the records the write changed. It is called synchronously by the writer,
+// so it should not block.
+type ChangeListener func(changes []Change)
+
+// WriteHook is called inside the transaction of every write to the records,
+// once the records are written and before it commits. An error rolls the
+// write back, and is returned by it.
+type WriteHook func(ctx context.Context, recordTx *sql.Tx, changes []Change) error
+
+type changeHooks struct {
+	mutex      sync.RWMutex
+	listeners  map[int]ChangeListener
+	writeHooks map[int]WriteHook
+	nextId     int
+}
+
+func newChangeHooks() *changeHooks {
+	return &changeHooks{
+		listeners:  map[int]ChangeListener{},
+		writeHooks: map[int]WriteHook{},
+	}
+}
+
+// OnChange implements RecordDao.
+func (o *daoImpl) OnChange(listener ChangeListener) func() {
+	hooks := o.changes
+	hooks.mutex.Lock()
+	defer hooks.mutex.Unlock()
+	id := hooks.nextId
+	hooks.nextId++
+	hooks.listeners[id] = listener
+	return func() {
+		hooks.mutex.Lock()
+		defer hooks.mutex.Unlock()
+		delete(hooks.listeners, id)
+	}
+}
+
+// OnWrite implements RecordDao.
+func (o *daoImpl) OnWrite(hook WriteHook) func() {
+	hooks := o.changes
+	hooks.mutex.Lock()
+	defer hooks.mutex.Unlock()
+	id := hooks.nextId
+	hooks.nextId++
+	hooks.writeHooks[id] = hook
+	return func() {
+		hooks.mutex.Lock()
+		defer hooks.mutex.Unlock()
+		delete(hooks.writeHooks, id)
+	}
+}
+
+// write calls the write hooks before recordTx commits.
+func (h *changeHooks) write(ctx context.Context, recordTx *sql.Tx, changes []Change) error {
+	setSource(ctx, changes)
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for _, hook := range h.writeHooks {
+		if err := hook(ctx, recordTx, changes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *changeHooks) fire(ctx context.Context, changes []Change) {
+	setSource(ctx, changes)
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for _, listener := range h.listeners {
+		listener(changes)
+	}
+}
+
+func setSource(ctx context.Context, changes []Change) {
+	source, _ := ctx.Value(changeSourceKey{}).(string)
+	for i := range changes {
+		changes[i].Source = source
+	}
+}
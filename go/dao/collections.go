@@ -2,6 +2,9 @@ package dao
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"strings"
 
 	sq "github.com/Masterminds/squirrel"
@@ -11,27 +14,71 @@ type CollectionDao interface {
 	FindCollectionBySpec(ctx context.Context, spec CollectionSpec) (*Collection, error)
 	FindCollectionById(ctx context.Context, id int) (*Collection, error)
 	GetCollectionId(ctx context.Context, spec CollectionSpec) (int, error)
+	ListCollections(ctx context.Context) ([]*Collection, error)
 
 	AddCollections(ctx context.Context, collection []*Collection /*inout*/) error
 	AddCollectionField(ctx context.Context, collection *Collection, field CollectionField) error
+	UpdateCollectionField(ctx context.Context, collection *Collection, field CollectionField) error
+	RetypeCollectionField(ctx context.Context, collection *Collection, field CollectionField) error
+	DropCollectionField(ctx context.Context, collection *Collection, fieldName string) error
+	SetCollectionVersion(ctx context.Context, collection *Collection) error
 }
 
 var _ CollectionDao = (*daoImpl)(nil)
 
 type Collection struct {
-	Id      int
-	Name    string
-	Domain  string
-	Version string
-	Fields  map[string]CollectionField
-	Table   string
+	Id      int                        `json:"id"`
+	Name    string                     `json:"name"`
+	Domain  string                     `json:"domain"`
+	Version string                     `json:"version"`
+	Fields  map[string]CollectionField `json:"fields"`
+	Table   string                     `json:"-"`
 }
 
 type CollectionField struct {
-	Name   string
-	Type   string
-	Ref    int
-	IsList bool
+	Name              string `json:"name"`
+	Type              string `json:"type"`
+	Ref               int    `json:"ref,omitempty"`
+	IsList            bool   `json:"isList,omitempty"`
+	NonNull           bool   `json:"nonNull,omitempty"`
+	DeprecationReason string `json:"deprecationReason,omitempty"`
+	// InverseOf is the object field of the Ref collection that points back at
+	// this collection. Inverse fields are lists that are not stored, they are
+	// read from the records of Ref.
+	InverseOf string `json:"inverseOf,omitempty"`
+	// OnDelete is what happens to this field when the record it refers to is
+	// deleted, one of the OnDelete constants.
+	OnDelete string `json:"onDelete,omitempty"`
+	// Searchable String fields are indexed for full-text search of their
+	// collection, see SearchRecords.
+	Searchable bool `json:"searchable,omitempty"`
+}
+
+const (
+	// OnDeleteCascade deletes the records that refer to a deleted record.
+	OnDeleteCascade = "CASCADE"
+	// OnDeleteRestrict fails to delete a record that is referred to.
+	OnDeleteRestrict = "RESTRICT"
+	// OnDeleteSetNull sets references to a deleted record to null, and
+	// removes them from lists.
+	OnDeleteSetNull = "SET_NULL"
+)
+
+// IsStored reports whether the field has a column or list table of its own.
+func (f CollectionField) IsStored() bool {
+	return f.InverseOf == ""
+}
+
+// OnDeleteAction returns the OnDelete action of the field. Without one,
+// references are set to null unless the field is non-null.
+func (f CollectionField) OnDeleteAction() string {
+	if f.OnDelete != "" {
+		return f.OnDelete
+	}
+	if f.NonNull && !f.IsList {
+		return OnDeleteRestrict
+	}
+	return OnDeleteSetNull
 }
 
 type CollectionSpec struct {
@@ -43,13 +90,18 @@ func (o *daoImpl) FindCollectionBySpec(
 	ctx context.Context,
 	spec CollectionSpec,
 ) (*Collection, error) {
-	collectionQuery := sq.Select("id", "name", "domain").
+	collectionQuery := sq.Select("id", "name", "domain", "version").
 		From("collections").
 		Where(sq.Eq{"name": spec.Name, "domain": spec.Namespace}).
 		RunWith(o.schemaDb).
 		QueryRowContext(ctx)
 	collection := &Collection{}
-	if err := collectionQuery.Scan(&collection.Id, &collection.Name, &collection.Domain); err != nil {
+	if err := collectionQuery.Scan(
+		&collection.Id,
+		&collection.Name,
+		&collection.Domain,
+		&collection.Version,
+	); err != nil {
 		return nil, err
 	}
 	if err := o.populateFields(ctx, collection); err != nil {
@@ -62,13 +114,18 @@ func (o *daoImpl) FindCollectionById(
 	ctx context.Context,
 	id int,
 ) (*Collection, error) {
-	collectionQuery := sq.Select("id", "name", "domain").
+	collectionQuery := sq.Select("id", "name", "domain", "version").
 		From("collections").
 		Where(sq.Eq{"id": id}).
 		RunWith(o.schemaDb).
 		QueryRowContext(ctx)
 	collection := &Collection{}
-	if err := collectionQuery.Scan(&collection.Id, &collection.Name, &collection.Domain); err != nil {
+	if err := collectionQuery.Scan(
+		&collection.Id,
+		&collection.Name,
+		&collection.Domain,
+		&collection.Version,
+	); err != nil {
 		return nil, err
 	}
 	if err := o.populateFields(ctx, collection); err != nil {
@@ -83,7 +140,12 @@ func (o *daoImpl) populateFields(
 ) error {
 	var ref *int
 	var isList *bool
-	fieldRows, err := sq.Select("name", "type", "ref", "is_list").
+	var nonNull *bool
+	var deprecationReason *string
+	var inverseOf *string
+	var onDelete *string
+	var searchable *bool
+	fieldRows, err := sq.Select("name", "type", "ref", "is_list", "is_non_null", "deprecation_reason", "inverse_of", "on_delete", "is_searchable").
 		From("collection_fields").
 		Where(sq.Eq{"collection_id": collection.Id}).
 		RunWith(o.schemaDb).
@@ -95,7 +157,17 @@ func (o *daoImpl) populateFields(
 	fields := map[string]CollectionField{}
 	for fieldRows.Next() {
 		field := CollectionField{}
-		if err := fieldRows.Scan(&field.Name, &field.Type, &ref, &isList); err != nil {
+		if err := fieldRows.Scan(
+			&field.Name,
+			&field.Type,
+			&ref,
+			&isList,
+			&nonNull,
+			&deprecationReason,
+			&inverseOf,
+			&onDelete,
+			&searchable,
+		); err != nil {
 			return err
 		}
 		if ref != nil {
@@ -104,6 +176,21 @@ func (o *daoImpl) populateFields(
 		if isList != nil {
 			field.IsList = *isList
 		}
+		if nonNull != nil {
+			field.NonNull = *nonNull
+		}
+		if deprecationReason != nil {
+			field.DeprecationReason = *deprecationReason
+		}
+		if inverseOf != nil {
+			field.InverseOf = *inverseOf
+		}
+		if onDelete != nil {
+			field.OnDelete = *onDelete
+		}
+		if searchable != nil {
+			field.Searchable = *searchable
+		}
 		fields[field.Name] = field
 	}
 	collection.Fields = fields
@@ -155,8 +242,14 @@ func (o *daoImpl) AddCollections(
 				"type",
 				"ref",
 				"is_list",
+				"is_non_null",
+				"deprecation_reason",
+				"inverse_of",
+				"on_delete",
+				"is_searchable",
 			)
-		sqlCols := []string{}
+		sqlCols := []string{"id INTEGER PRIMARY KEY"}
+		listFields := []CollectionField{}
 		for _, field := range collection.Fields {
 			insertFieldsQuery = insertFieldsQuery.
 				Values(
@@ -165,16 +258,33 @@ func (o *daoImpl) AddCollections(
 					field.Type,
 					field.Ref,
 					field.IsList,
+					field.NonNull,
+					nullableString(field.DeprecationReason),
+					nullableString(field.InverseOf),
+					nullableString(field.OnDelete),
+					field.Searchable,
 				)
-			sqlCols = append(sqlCols, field.Name+" "+schemaTypeToSqlType(field.Type))
+			if !field.IsStored() {
+				continue
+			}
+			if field.IsList {
+				listFields = append(listFields, field)
+				continue
+			}
+			sqlCol := field.Name + " " + schemaTypeToSqlType(field.Type)
+			if field.NonNull {
+				sqlCol += " NOT NULL"
+			}
+			sqlCols = append(sqlCols, sqlCol)
 		}
-		_, err = insertFieldsQuery.RunWith(schemaTx).ExecContext(ctx)
-		if err != nil {
-			return err
+		if len(collection.Fields) > 0 {
+			_, err = insertFieldsQuery.RunWith(schemaTx).ExecContext(ctx)
+			if err != nil {
+				return err
+			}
 		}
 
-		createTable, _, err := sq.ConcatExpr(`CREATE TABLE `, collection.Name, ` (
-		id INTEGER PRIMARY KEY,`,
+		createTable, _, err := sq.ConcatExpr(`CREATE TABLE `, collection.Name, ` (`,
 			strings.Join(sqlCols, ", "),
 			`)`,
 		).ToSql()
@@ -185,16 +295,28 @@ func (o *daoImpl) AddCollections(
 		if err != nil {
 			return err
 		}
+		for _, field := range listFields {
+			createListTable, err := listTableDefinition(collection, field)
+			if err != nil {
+				return err
+			}
+			_, err = recordTx.ExecContext(ctx, createListTable)
+			if err != nil {
+				return err
+			}
+		}
+		if err := createSearchTable(ctx, recordTx, collection); err != nil {
+			return err
+		}
 	}
-	if err := schemaTx.Commit(); err != nil {
-		return err
-	}
-	return recordTx.Commit()
+	// the metadata is committed last, so that it never describes tables
+	// that do not exist
+	return commitFieldChange(recordTx, schemaTx)
 }
 
 func schemaTypeToSqlType(schemaType string) string {
 	switch schemaType {
-	case "String":
+	case "String", BlobType:
 		return "TEXT"
 	case "Boolean":
 	case "Int":
@@ -207,6 +329,38 @@ func schemaTypeToSqlType(schemaType string) string {
 	return "INTEGER"
 }
 
+// nonNullConstraint is the constraint for a column added to an existing
+// table. SQLite needs a default to fill in existing rows, so they get the
+// zero value of the column type.
+func nonNullConstraint(field CollectionField) string {
+	if !field.NonNull {
+		return ``
+	}
+	switch schemaTypeToSqlType(field.Type) {
+	case "TEXT":
+		return ` NOT NULL DEFAULT ''`
+	case "REAL":
+		return ` NOT NULL DEFAULT 0.0`
+	}
+	return ` NOT NULL DEFAULT 0`
+}
+
+// listTableName is the name of the table holding the elements of a list
+// field. Each row is one element, ordered by position within its owner.
+func listTableName(collectionName string, fieldName string) string {
+	return collectionName + "_" + fieldName
+}
+
+func listTableDefinition(collection *Collection, field CollectionField) (string, error) {
+	createTable, _, err := sq.ConcatExpr(`CREATE TABLE `, listTableName(collection.Name, field.Name), ` (
+		owner INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		value `, schemaTypeToSqlType(field.Type), `,
+		PRIMARY KEY (owner, position)
+	)`).ToSql()
+	return createTable, err
+}
+
 // AddCollectionField implements CollectionDao.
 func (o *daoImpl) AddCollectionField(
 	ctx context.Context,
@@ -226,29 +380,116 @@ func (o *daoImpl) AddCollectionField(
 			"type",
 			"ref",
 			"is_list",
+			"is_non_null",
+			"deprecation_reason",
+			"inverse_of",
+			"on_delete",
+			"is_searchable",
 		).Values(
 		collection.Id,
 		field.Name,
 		field.Type,
 		field.Ref,
 		field.IsList,
+		field.NonNull,
+		nullableString(field.DeprecationReason),
+		nullableString(field.InverseOf),
+		nullableString(field.OnDelete),
+		field.Searchable,
 	)
 	_, err = insertFieldQuery.RunWith(schemaTx).ExecContext(ctx)
 	if err != nil {
 		return err
 	}
-	addColumn, _, err := sq.ConcatExpr(`ALTER TABLE `, collection.Name, ` ADD COLUMN `, field.Name, ` `, schemaTypeToSqlType(field.Type)).
-		ToSql()
+	if !field.IsStored() {
+		return schemaTx.Commit()
+	}
+
+	recordTx, err := o.recordDb.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	_, err = o.recordDb.ExecContext(ctx, addColumn)
-	if err != nil {
+	defer recordTx.Rollback()
+
+	if err := addFieldStorage(ctx, recordTx, collection, field); err != nil {
+		return err
+	}
+	if field.Searchable {
+		if err := rebuildSearchTable(ctx, recordTx, withField(collection, field)); err != nil {
+			return err
+		}
+	}
+	return commitFieldChange(recordTx, schemaTx)
+}
+
+// commitFieldChange commits a change to a field, with the schema metadata
+// last. The record tables are in another database, so the two commits are
+// not atomic, but the changes to the record tables can be run again: if the
+// metadata fails to commit, registering the schema again finishes the change.
+func commitFieldChange(recordTx *sql.Tx, schemaTx *sql.Tx) error {
+	if err := recordTx.Commit(); err != nil {
 		return err
 	}
 	return schemaTx.Commit()
 }
 
+// ErrNonNullReference is returned when a non-null reference field is added to
+// a collection that already has records, which would have nothing to refer to.
+var ErrNonNullReference = errors.New("non-null reference fields cannot be added to collections with records")
+
+// addFieldStorage adds the column or list table of a field. Any column or
+// list table left with the same name by a change whose metadata failed to
+// commit is dropped first.
+func addFieldStorage(ctx context.Context, recordTx *sql.Tx, collection *Collection, field CollectionField) error {
+	if err := dropFieldStorage(ctx, recordTx, collection, field); err != nil {
+		return err
+	}
+	if field.NonNull && field.Ref > 0 && !field.IsList {
+		var hasRecords bool
+		err := recordTx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM `+collection.Name+`)`).
+			Scan(&hasRecords)
+		if err != nil {
+			return err
+		}
+		if hasRecords {
+			return fmt.Errorf("%w: %s.%s", ErrNonNullReference, collection.Name, field.Name)
+		}
+	}
+	var addColumn string
+	var err error
+	if field.IsList {
+		addColumn, err = listTableDefinition(collection, field)
+	} else {
+		addColumn, _, err = sq.ConcatExpr(`ALTER TABLE `, collection.Name, ` ADD COLUMN `, field.Name, ` `, schemaTypeToSqlType(field.Type), nonNullConstraint(field)).
+			ToSql()
+	}
+	if err != nil {
+		return err
+	}
+	_, err = recordTx.ExecContext(ctx, addColumn)
+	return err
+}
+
+// dropFieldStorage drops the column or list table of a field, if it exists.
+func dropFieldStorage(ctx context.Context, recordTx *sql.Tx, collection *Collection, field CollectionField) error {
+	if field.IsList {
+		_, err := recordTx.ExecContext(ctx, `DROP TABLE IF EXISTS `+listTableName(collection.Name, field.Name))
+		return err
+	}
+	var exists bool
+	err := recordTx.QueryRowContext(
+		ctx,
+		`SELECT EXISTS (SELECT 1 FROM pragma_table_info(?) WHERE name = ?)`,
+		collection.Name,
+		field.Name,
+	).Scan(&exists)
+	if err != nil || !exists {
+		return err
+	}
+	_, err = recordTx.ExecContext(ctx, `ALTER TABLE `+collection.Name+` DROP COLUMN `+field.Name)
+	return err
+}
+
 // GetCollectionId implements CollectionDao.
 func (o *daoImpl) GetCollectionId(ctx context.Context, spec CollectionSpec) (int, error) {
 	collectionQuery := sq.Select("id").
@@ -262,3 +503,285 @@ func (o *daoImpl) GetCollectionId(ctx context.Context, spec CollectionSpec) (int
 	}
 	return id, nil
 }
+
+// ListCollections implements CollectionDao.
+func (o *daoImpl) ListCollections(ctx context.Context) ([]*Collection, error) {
+	collectionRows, err := sq.Select("id", "name", "domain", "version").
+		From("collections").
+		OrderBy("id").
+		RunWith(o.schemaDb).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	collections := []*Collection{}
+	for collectionRows.Next() {
+		collection := &Collection{}
+		if err := collectionRows.Scan(
+			&collection.Id,
+			&collection.Name,
+			&collection.Domain,
+			&collection.Version,
+		); err != nil {
+			collectionRows.Close()
+			return nil, err
+		}
+		collections = append(collections, collection)
+	}
+	if err := collectionRows.Err(); err != nil {
+		collectionRows.Close()
+		return nil, err
+	}
+	if err := collectionRows.Close(); err != nil {
+		return nil, err
+	}
+	// fields are populated after the rows are closed so the connection is free
+	for _, collection := range collections {
+		if err := o.populateFields(ctx, collection); err != nil {
+			return nil, err
+		}
+	}
+	return collections, nil
+}
+
+// UpdateCollectionField implements CollectionDao. Only what can change
+// without dropping the values of the field, such as the deprecation reason,
+// the on delete action, whether the field is searchable and whether it is
+// non-null, is updated.
+func (o *daoImpl) UpdateCollectionField(
+	ctx context.Context,
+	collection *Collection,
+	field CollectionField,
+) error {
+	schemaTx, err := o.schemaDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer schemaTx.Rollback()
+
+	_, err = sq.Update("collection_fields").
+		Set("is_non_null", field.NonNull).
+		Set("deprecation_reason", nullableString(field.DeprecationReason)).
+		Set("on_delete", nullableString(field.OnDelete)).
+		Set("is_searchable", field.Searchable).
+		Set("updated_at", sq.Expr("CURRENT_TIMESTAMP")).
+		Where(sq.Eq{"collection_id": collection.Id, "name": field.Name}).
+		RunWith(schemaTx).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	oldField := collection.Fields[field.Name]
+	// only columns are constrained, the elements of a list are always
+	// nullable
+	nullabilityChanged := oldField.NonNull != field.NonNull && field.IsStored() && !field.IsList
+	if oldField.Searchable == field.Searchable && !nullabilityChanged {
+		return schemaTx.Commit()
+	}
+
+	recordTx, err := o.recordDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer recordTx.Rollback()
+
+	if nullabilityChanged {
+		// the triggers of the search table would stop the column from being
+		// dropped
+		if oldField.Searchable {
+			if err := rebuildSearchTable(ctx, recordTx, withoutField(collection, field.Name)); err != nil {
+				return err
+			}
+		}
+		if err := changeNullability(ctx, recordTx, collection, field); err != nil {
+			return err
+		}
+	}
+	if oldField.Searchable != field.Searchable || (nullabilityChanged && field.Searchable) {
+		if err := rebuildSearchTable(ctx, recordTx, withField(collection, field)); err != nil {
+			return err
+		}
+	}
+	return commitFieldChange(recordTx, schemaTx)
+}
+
+// ErrNullValues is returned when a field is made non-null while some records
+// have no value for it.
+var ErrNullValues = errors.New("fields holding null values cannot be made non-null")
+
+// changeNullability replaces the column of a field with one that has the
+// constraint of field and the same values, since SQLite cannot change the
+// constraints of a column.
+func changeNullability(ctx context.Context, recordTx *sql.Tx, collection *Collection, field CollectionField) error {
+	if field.NonNull {
+		var hasNulls bool
+		err := recordTx.QueryRowContext(
+			ctx,
+			`SELECT EXISTS (SELECT 1 FROM `+collection.Name+` WHERE `+field.Name+` IS NULL)`,
+		).Scan(&hasNulls)
+		if err != nil {
+			return err
+		}
+		if hasNulls {
+			return fmt.Errorf("%w: %s.%s", ErrNullValues, collection.Name, field.Name)
+		}
+	}
+	// names starting with __ are reserved by GraphQL, so no field has it
+	column := "__" + field.Name
+	for _, statement := range []string{
+		`ALTER TABLE ` + collection.Name + ` ADD COLUMN ` + column + ` ` + schemaTypeToSqlType(field.Type) + nonNullConstraint(field),
+		`UPDATE ` + collection.Name + ` SET ` + column + ` = ` + field.Name,
+		`ALTER TABLE ` + collection.Name + ` DROP COLUMN ` + field.Name,
+		`ALTER TABLE ` + collection.Name + ` RENAME COLUMN ` + column + ` TO ` + field.Name,
+	} {
+		if _, err := recordTx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RetypeCollectionField implements CollectionDao. The field is replaced by
+// one with the same name and a new type in a single change, and the data
+// stored in it is dropped.
+func (o *daoImpl) RetypeCollectionField(
+	ctx context.Context,
+	collection *Collection,
+	field CollectionField,
+) error {
+	oldField, ok := collection.Fields[field.Name]
+	if !ok {
+		return fmt.Errorf("invalid field %s for collection %s", field.Name, collection.Name)
+	}
+
+	schemaTx, err := o.schemaDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer schemaTx.Rollback()
+
+	_, err = sq.Update("collection_fields").
+		Set("type", field.Type).
+		Set("ref", field.Ref).
+		Set("is_list", field.IsList).
+		Set("is_non_null", field.NonNull).
+		Set("deprecation_reason", nullableString(field.DeprecationReason)).
+		Set("inverse_of", nullableString(field.InverseOf)).
+		Set("on_delete", nullableString(field.OnDelete)).
+		Set("is_searchable", field.Searchable).
+		Set("updated_at", sq.Expr("CURRENT_TIMESTAMP")).
+		Where(sq.Eq{"collection_id": collection.Id, "name": field.Name}).
+		RunWith(schemaTx).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	recordTx, err := o.recordDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer recordTx.Rollback()
+
+	// the triggers of the search table would stop the column from being
+	// dropped
+	if oldField.Searchable {
+		if err := rebuildSearchTable(ctx, recordTx, withoutField(collection, field.Name)); err != nil {
+			return err
+		}
+	}
+	if oldField.IsStored() {
+		if err := dropFieldStorage(ctx, recordTx, collection, oldField); err != nil {
+			return err
+		}
+	}
+	if field.IsStored() {
+		if err := addFieldStorage(ctx, recordTx, collection, field); err != nil {
+			return err
+		}
+	}
+	if field.Searchable {
+		if err := rebuildSearchTable(ctx, recordTx, withField(collection, field)); err != nil {
+			return err
+		}
+	}
+	if err := commitFieldChange(recordTx, schemaTx); err != nil {
+		return err
+	}
+	collection.Fields[field.Name] = field
+	return nil
+}
+
+// DropCollectionField implements CollectionDao. The column or list table of
+// the field is dropped along with all of its data.
+func (o *daoImpl) DropCollectionField(
+	ctx context.Context,
+	collection *Collection,
+	fieldName string,
+) error {
+	field, ok := collection.Fields[fieldName]
+	if !ok {
+		return fmt.Errorf("invalid field %s for collection %s", fieldName, collection.Name)
+	}
+
+	schemaTx, err := o.schemaDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer schemaTx.Rollback()
+
+	_, err = sq.Delete("collection_fields").
+		Where(sq.Eq{"collection_id": collection.Id, "name": fieldName}).
+		RunWith(schemaTx).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !field.IsStored() {
+		if err := schemaTx.Commit(); err != nil {
+			return err
+		}
+		delete(collection.Fields, fieldName)
+		return nil
+	}
+
+	recordTx, err := o.recordDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer recordTx.Rollback()
+
+	// the triggers of the search table would stop the column from being
+	// dropped
+	if field.Searchable {
+		if err := rebuildSearchTable(ctx, recordTx, withoutField(collection, fieldName)); err != nil {
+			return err
+		}
+	}
+	if err := dropFieldStorage(ctx, recordTx, collection, field); err != nil {
+		return err
+	}
+	if err := commitFieldChange(recordTx, schemaTx); err != nil {
+		return err
+	}
+	delete(collection.Fields, fieldName)
+	return nil
+}
+
+// SetCollectionVersion implements CollectionDao.
+func (o *daoImpl) SetCollectionVersion(ctx context.Context, collection *Collection) error {
+	_, err := sq.Update("collections").
+		Set("version", collection.Version).
+		Set("updated_at", sq.Expr("CURRENT_TIMESTAMP")).
+		Where(sq.Eq{"id": collection.Id}).
+		RunWith(o.schemaDb).
+		ExecContext(ctx)
+	return err
+}
+
+func nullableString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
@@ -1 +1,222 @@
 package dao_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sashankg/hold/dao"
+	"github.com/sashankg/hold/testing/util"
+	"github.com/stretchr/testify/require"
+)
+
+// columns returns whether each column of a record table is NOT NULL, by
+// name.
+func columns(t *testing.T, db *sql.DB, table string) map[string]bool {
+	rows, err := db.Query(`SELECT name, "notnull" FROM pragma_table_info(?)`, table)
+	require.NoError(t, err)
+	defer rows.Close()
+	result := map[string]bool{}
+	for rows.Next() {
+		var name string
+		var notNull bool
+		require.NoError(t, rows.Scan(&name, &notNull))
+		result[name] = notNull
+	}
+	require.NoError(t, rows.Err())
+	return result
+}
+
+func newPeopleCollection(t *testing.T, testDao dao.Dao) *dao.Collection {
+	person := &dao.Collection{
+		Name:    "Person",
+		Version: "1",
+		Fields: map[string]dao.CollectionField{
+			"name":     {Name: "name", Type: "String", NonNull: true},
+			"nickname": {Name: "nickname", Type: "String"},
+			"emails":   {Name: "emails", Type: "String", IsList: true},
+		},
+	}
+	require.NoError(t, testDao.AddCollections(context.Background(), []*dao.Collection{person}))
+	return person
+}
+
+func TestAddCollections(t *testing.T) {
+	ctx := context.Background()
+	testDao := util.NewMemoryDao(t)
+	person := newPeopleCollection(t, testDao)
+
+	require.Equal(t, map[string]bool{"id": false, "name": true, "nickname": false}, columns(t, testDao.RecordDb, "Person"))
+	require.Equal(t, map[string]bool{"owner": true, "position": true, "value": false}, columns(t, testDao.RecordDb, "Person_emails"))
+	found, err := testDao.FindCollectionById(ctx, person.Id)
+	require.NoError(t, err)
+	require.Equal(t, person.Fields, found.Fields)
+
+	// the metadata is not committed when the record table cannot be created
+	_, err = testDao.RecordDb.Exec(`CREATE TABLE Post (id INTEGER PRIMARY KEY)`)
+	require.NoError(t, err)
+	err = testDao.AddCollections(ctx, []*dao.Collection{{
+		Name:    "Post",
+		Version: "1",
+		Fields:  map[string]dao.CollectionField{"title": {Name: "title", Type: "String"}},
+	}})
+	require.Error(t, err)
+	_, err = testDao.GetCollectionId(ctx, dao.CollectionSpec{Name: "Post"})
+	require.Error(t, err)
+}
+
+func TestAddCollectionField(t *testing.T) {
+	ctx := context.Background()
+	testDao := util.NewMemoryDao(t)
+	person := newPeopleCollection(t, testDao)
+	_, err := testDao.SetRecord(ctx, map[string]any{"name": "ada"}, nil, person.Id)
+	require.NoError(t, err)
+
+	// existing records get the zero value of a non-null field
+	for _, field := range []dao.CollectionField{
+		{Name: "age", Type: "Int", NonNull: true},
+		{Name: "height", Type: "Float", NonNull: true},
+		{Name: "bio", Type: "String", NonNull: true},
+		{Name: "verified", Type: "Boolean", NonNull: true},
+	} {
+		require.NoError(t, testDao.AddCollectionField(ctx, person, field))
+		require.True(t, columns(t, testDao.RecordDb, "Person")[field.Name], field.Name)
+	}
+	record, err := testDao.GetRecord(ctx, 1, []dao.Selection{
+		{FieldName: "age"},
+		{FieldName: "height"},
+		{FieldName: "bio"},
+		{FieldName: "verified"},
+	}, person.Id)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"age": 0, "height": 0, "bio": "", "verified": 0}`, string(record))
+
+	// left behind by a change whose metadata failed to commit
+	_, err = testDao.RecordDb.Exec(`ALTER TABLE Person ADD COLUMN rating TEXT`)
+	require.NoError(t, err)
+	require.NoError(t, testDao.AddCollectionField(ctx, person, dao.CollectionField{Name: "rating", Type: "Int"}))
+	_, err = testDao.PatchRecord(ctx, 1, map[string]any{"rating": 5}, nil, person.Id)
+	require.NoError(t, err)
+
+	// there is nothing for the existing records to refer to
+	err = testDao.AddCollectionField(ctx, person, dao.CollectionField{
+		Name:    "friend",
+		Type:    "Person",
+		Ref:     person.Id,
+		NonNull: true,
+	})
+	require.ErrorIs(t, err, dao.ErrNonNullReference)
+	found, err := testDao.FindCollectionById(ctx, person.Id)
+	require.NoError(t, err)
+	require.NotContains(t, found.Fields, "friend")
+	require.NotContains(t, columns(t, testDao.RecordDb, "Person"), "friend")
+}
+
+func TestDropCollectionField(t *testing.T) {
+	ctx := context.Background()
+	testDao := util.NewMemoryDao(t)
+	person := newPeopleCollection(t, testDao)
+	_, err := testDao.SetRecord(ctx, map[string]any{"name": "ada", "nickname": "countess", "emails": []any{"ada@example.com"}}, nil, person.Id)
+	require.NoError(t, err)
+
+	require.NoError(t, testDao.DropCollectionField(ctx, person, "nickname"))
+	require.NoError(t, testDao.DropCollectionField(ctx, person, "emails"))
+	require.Equal(t, map[string]bool{"id": false, "name": true}, columns(t, testDao.RecordDb, "Person"))
+	require.Empty(t, columns(t, testDao.RecordDb, "Person_emails"))
+	found, err := testDao.FindCollectionById(ctx, person.Id)
+	require.NoError(t, err)
+	require.Equal(t, person.Fields, found.Fields)
+	require.Len(t, found.Fields, 1)
+
+	record, err := testDao.GetRecord(ctx, 1, []dao.Selection{{FieldName: "name"}}, person.Id)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"name": "ada"}`, string(record))
+
+	require.Error(t, testDao.DropCollectionField(ctx, person, "nickname"))
+}
+
+func TestRetypeCollectionField(t *testing.T) {
+	ctx := context.Background()
+	testDao := util.NewMemoryDao(t)
+	person := newPeopleCollection(t, testDao)
+	_, err := testDao.SetRecord(ctx, map[string]any{"name": "ada", "nickname": "countess"}, nil, person.Id)
+	require.NoError(t, err)
+
+	// the values of the old type are dropped
+	require.NoError(t, testDao.RetypeCollectionField(ctx, person, dao.CollectionField{Name: "nickname", Type: "Int", NonNull: true}))
+	require.True(t, columns(t, testDao.RecordDb, "Person")["nickname"])
+	record, err := testDao.GetRecord(ctx, 1, []dao.Selection{{FieldName: "nickname"}}, person.Id)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"nickname": 0}`, string(record))
+
+	// a column becomes a list table
+	require.NoError(t, testDao.RetypeCollectionField(ctx, person, dao.CollectionField{Name: "nickname", Type: "String", IsList: true}))
+	require.NotContains(t, columns(t, testDao.RecordDb, "Person"), "nickname")
+	require.Contains(t, columns(t, testDao.RecordDb, "Person_nickname"), "value")
+	found, err := testDao.FindCollectionById(ctx, person.Id)
+	require.NoError(t, err)
+	require.Equal(t, dao.CollectionField{Name: "nickname", Type: "String", IsList: true}, found.Fields["nickname"])
+}
+
+func TestUpdateCollectionFieldNullability(t *testing.T) {
+	ctx := context.Background()
+	testDao := util.NewMemoryDao(t)
+	person := newPeopleCollection(t, testDao)
+	_, err := testDao.SetRecord(ctx, map[string]any{"name": "ada"}, nil, person.Id)
+	require.NoError(t, err)
+
+	// relaxed without dropping the values
+	require.NoError(t, testDao.UpdateCollectionField(ctx, person, dao.CollectionField{Name: "name", Type: "String"}))
+	require.False(t, columns(t, testDao.RecordDb, "Person")["name"])
+	_, err = testDao.SetRecord(ctx, map[string]any{"nickname": "grace"}, nil, person.Id)
+	require.NoError(t, err)
+
+	person, err = testDao.FindCollectionById(ctx, person.Id)
+	require.NoError(t, err)
+	require.False(t, person.Fields["name"].NonNull)
+	err = testDao.UpdateCollectionField(ctx, person, dao.CollectionField{Name: "name", Type: "String", NonNull: true})
+	require.ErrorIs(t, err, dao.ErrNullValues)
+	found, err := testDao.FindCollectionById(ctx, person.Id)
+	require.NoError(t, err)
+	require.False(t, found.Fields["name"].NonNull)
+
+	_, err = testDao.PatchRecord(ctx, 2, map[string]any{"name": "grace"}, nil, person.Id)
+	require.NoError(t, err)
+	require.NoError(t, testDao.UpdateCollectionField(ctx, person, dao.CollectionField{Name: "name", Type: "String", NonNull: true}))
+	require.True(t, columns(t, testDao.RecordDb, "Person")["name"])
+	found, err = testDao.FindCollectionById(ctx, person.Id)
+	require.NoError(t, err)
+	require.True(t, found.Fields["name"].NonNull)
+	for id, name := range map[int]string{1: "ada", 2: "grace"} {
+		record, err := testDao.GetRecord(ctx, id, []dao.Selection{{FieldName: "name"}}, person.Id)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"name": "`+name+`"}`, string(record))
+	}
+}
+
+func TestCascadeCollections(t *testing.T) {
+	person := &dao.Collection{Id: 1, Name: "Person"}
+	post := &dao.Collection{Id: 2, Name: "Post", Fields: map[string]dao.CollectionField{
+		"author": {Name: "author", Type: "Person", Ref: 1, OnDelete: dao.OnDeleteCascade},
+	}}
+	comment := &dao.Collection{Id: 3, Name: "Comment", Fields: map[string]dao.CollectionField{
+		"post":     {Name: "post", Type: "Post", Ref: 2, OnDelete: dao.OnDeleteCascade},
+		"replyTo":  {Name: "replyTo", Type: "Comment", Ref: 3, OnDelete: dao.OnDeleteCascade},
+		"mentions": {Name: "mentions", Type: "Person", Ref: 1, IsList: true, OnDelete: dao.OnDeleteSetNull},
+	}}
+	badge := &dao.Collection{Id: 4, Name: "Badge", Fields: map[string]dao.CollectionField{
+		"comment": {Name: "comment", Type: "Comment", Ref: 3, OnDelete: dao.OnDeleteSetNull},
+	}}
+	review := &dao.Collection{Id: 5, Name: "Review", Fields: map[string]dao.CollectionField{
+		"post":     {Name: "post", Type: "Post", Ref: 2, OnDelete: dao.OnDeleteRestrict},
+		"comments": {Name: "comments", Type: "Comment", Ref: 3, IsList: true, InverseOf: "review", OnDelete: dao.OnDeleteCascade},
+	}}
+	collections := []*dao.Collection{person, post, comment, badge, review}
+
+	require.ElementsMatch(t, []*dao.Collection{post, comment, badge}, dao.CascadeCollections(collections, person.Id))
+	require.ElementsMatch(t, []*dao.Collection{comment, badge}, dao.CascadeCollections(collections, post.Id))
+	// inverse fields are not stored, and the collection itself is left out
+	require.Equal(t, []*dao.Collection{badge}, dao.CascadeCollections(collections, comment.Id))
+	require.Empty(t, dao.CascadeCollections(collections, review.Id))
+}
@@ -12,6 +12,7 @@ type Dao interface {
 type daoImpl struct {
 	schemaDb *sql.DB
 	recordDb *sql.DB
+	changes  *changeHooks
 }
 
 var _ Dao = (*daoImpl)(nil)
@@ -20,5 +21,6 @@ func NewDao(schemaDb *sql.DB, recordDb *sql.DB) Dao {
 	return &daoImpl{
 		schemaDb,
 		recordDb,
+		newChangeHooks(),
 	}
 }
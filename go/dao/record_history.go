@@ -0,0 +1,330 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/sashankg/hold/p2p"
+)
+
+// HistoryField is the field of a record with its versions, like
+// ListRecordHistory, unless its collection has a field of that name. The
+// Params of its selection only have First.
+const HistoryField = "history"
+
+// Fields of a record version in ListRecordHistory.
+const (
+	HistoryVersion   = "version"
+	HistoryOperation = "operation"
+	HistoryPeer      = "peer"
+	HistoryChangedAt = "changedAt"
+	HistoryNode      = "node"
+)
+
+// HistoryTimeLayout is the layout of the changedAt time of versions. Times
+// in this layout sort in time order.
+const HistoryTimeLayout = "2006-01-02T15:04:05.000Z"
+
+// historyTable is the log of every write to every collection. Names
+// starting with __ are reserved in GraphQL, so it cannot be the table of a
+// collection. data is the record after the write with every stored field.
+// The versions of a record are purged when it is deleted, so that a deleted
+// record cannot be read as of an earlier time.
+const historyTable = "__history"
+
+const historyTableDefinition = `CREATE TABLE IF NOT EXISTS ` + historyTable + ` (
+	version INTEGER PRIMARY KEY,
+	collection_id INTEGER NOT NULL,
+	record_id INTEGER NOT NULL,
+	operation TEXT NOT NULL,
+	peer TEXT,
+	changed_at TEXT NOT NULL,
+	data TEXT
+);
+CREATE INDEX IF NOT EXISTS ` + historyTable + `_record ON ` + historyTable + ` (collection_id, record_id, version)`
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// createHistoryTable creates the history table the first time it is used,
+// as the record database has no migrations.
+func createHistoryTable(ctx context.Context, db execer) error {
+	_, err := db.ExecContext(ctx, historyTableDefinition)
+	return err
+}
+
+// StoredSelection selects the id and every stored field of a record, with
+// references and lists of references as ids.
+func StoredSelection(collection *Collection) []Selection {
+	names := []string{}
+	for name, field := range collection.Fields {
+		if field.IsStored() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	selection := []Selection{{FieldName: IdField}}
+	for _, name := range names {
+		selection = append(selection, Selection{FieldName: name})
+	}
+	return selection
+}
+
+// writeHistory adds a version for every change of a write, by the peer of
+// ctx, before the write commits. A delete purges every version of the
+// deleted record instead.
+func (o *daoImpl) writeHistory(ctx context.Context, recordTx *sql.Tx, changes []Change) error {
+	if err := createHistoryTable(ctx, recordTx); err != nil {
+		return err
+	}
+	var peer *string
+	if id, ok := p2p.PeerFromContext(ctx); ok {
+		peer = nullableString(id.String())
+	}
+	for _, change := range changes {
+		if change.Operation == ChangeDelete {
+			_, err := sq.Delete(historyTable).
+				Where(sq.Eq{"collection_id": change.CollectionId, "record_id": change.Id}).
+				RunWith(recordTx).
+				ExecContext(ctx)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		collection, err := o.FindCollectionById(ctx, change.CollectionId)
+		if err != nil {
+			return err
+		}
+		record, err := o.readRecord(ctx, recordTx, change.Id, StoredSelection(collection), change.CollectionId)
+		// patched by a delete and then deleted further down the cascade
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = sq.Insert(historyTable).
+			Columns("collection_id", "record_id", "operation", "peer", "changed_at", "data").
+			Values(
+				change.CollectionId,
+				change.Id,
+				change.Operation,
+				peer,
+				sq.Expr(`strftime('%Y-%m-%dT%H:%M:%fZ', 'now')`),
+				string(record),
+			).
+			RunWith(recordTx).
+			ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRecordAsOf implements RecordDao.
+func (o *daoImpl) GetRecordAsOf(
+	ctx context.Context,
+	id int,
+	asOf time.Time,
+	selection []Selection,
+	collectionId int,
+) ([]byte, error) {
+	if err := createHistoryTable(ctx, o.recordDb); err != nil {
+		return nil, err
+	}
+	collection, err := o.FindCollectionById(ctx, collectionId)
+	if err != nil {
+		return nil, err
+	}
+	alias := tableAlias(0)
+	object, err := o.buildVersionJsonObject(ctx, collection, alias, selection, 0)
+	if err != nil {
+		return nil, err
+	}
+	version := sq.Select("record_id AS "+IdField, "data").
+		From(historyTable).
+		Where(sq.Eq{"collection_id": collectionId, "record_id": id}).
+		Where(sq.LtOrEq{"changed_at": asOf.UTC().Format(HistoryTimeLayout)}).
+		OrderBy("version DESC").
+		Limit(1)
+	var json []byte
+	err = sq.Select().
+		Column(object).
+		FromSelect(version, alias).
+		Where(alias + `.data IS NOT NULL`).
+		RunWith(o.recordDb).
+		QueryRowContext(ctx).
+		Scan(&json)
+	return json, err
+}
+
+// ListRecordHistory implements RecordDao.
+func (o *daoImpl) ListRecordHistory(
+	ctx context.Context,
+	id int,
+	first int,
+	selection []Selection, /*version selection*/
+	collectionId int,
+) ([]byte, error) {
+	if err := createHistoryTable(ctx, o.recordDb); err != nil {
+		return nil, err
+	}
+	collection, err := o.FindCollectionById(ctx, collectionId)
+	if err != nil {
+		return nil, err
+	}
+	historyQuery, err := o.buildHistoryQuery(ctx, collection, sq.Expr(`?`, id), first, selection, 0)
+	if err != nil {
+		return nil, err
+	}
+	var json []byte
+	err = sq.Select().
+		Column(historyQuery).
+		RunWith(o.recordDb).
+		QueryRowContext(ctx).
+		Scan(&json)
+	return json, err
+}
+
+// buildHistoryQuery aggregates up to first versions of the record with id,
+// newest first, into a JSON array.
+func (o *daoImpl) buildHistoryQuery(
+	ctx context.Context,
+	collection *Collection,
+	id sq.Sqlizer,
+	first int,
+	selection []Selection, /*version selection*/
+	depth int,
+) (sq.Sqlizer, error) {
+	if first <= 0 {
+		first = DefaultPageSize
+	}
+	if first > MaxPageSize {
+		first = MaxPageSize
+	}
+	alias := versionAlias(depth)
+	objectArgs := sq.Expr(``)
+	for i, s := range selection {
+		if i > 0 {
+			objectArgs = sq.ConcatExpr(objectArgs, `, `)
+		}
+		objectArgs = sq.ConcatExpr(objectArgs, sq.Expr(`?, `, s.Key()))
+		switch s.FieldName {
+		case HistoryVersion:
+			objectArgs = sq.ConcatExpr(objectArgs, alias+`.version`)
+		case HistoryOperation:
+			objectArgs = sq.ConcatExpr(objectArgs, alias+`.operation`)
+		case HistoryPeer:
+			objectArgs = sq.ConcatExpr(objectArgs, alias+`.peer`)
+		case HistoryChangedAt:
+			objectArgs = sq.ConcatExpr(objectArgs, alias+`.changed_at`)
+		case HistoryNode:
+			node, err := o.buildVersionJsonObject(ctx, collection, alias, s.Subselections, depth)
+			if err != nil {
+				return nil, err
+			}
+			objectArgs = sq.ConcatExpr(
+				objectArgs,
+				`CASE WHEN `+alias+`.data IS NULL THEN NULL ELSE json(`, node, `) END`,
+			)
+		default:
+			return nil, errors.New("invalid version field " + s.FieldName)
+		}
+	}
+	versions := sq.Select("version", "operation", "peer", "changed_at", "record_id AS "+IdField, "data").
+		From(historyTable).
+		Where(sq.Eq{"collection_id": collection.Id}).
+		Where(sq.Expr(`record_id = ?`, id)).
+		OrderBy("version DESC").
+		Limit(uint64(first))
+	return sq.ConcatExpr(
+		`json((SELECT json_group_array(json(json_object(`,
+		objectArgs,
+		`))) FROM (`,
+		versions,
+		`) AS `+alias+`))`,
+	), nil
+}
+
+// historyFirst is the number of versions a selection of HistoryField asks
+// for, which is 0 for the default.
+func historyFirst(selection Selection) int {
+	if selection.Params == nil {
+		return 0
+	}
+	return selection.Params.First
+}
+
+func versionAlias(depth int) string {
+	return `v` + strconv.Itoa(depth)
+}
+
+// buildVersionJsonObject is buildJsonObject for a version of a record, where
+// the row aliased by alias has the id and the data of the version. Object
+// fields and inverse fields are read from the current records they refer to.
+func (o *daoImpl) buildVersionJsonObject(
+	ctx context.Context,
+	collection *Collection,
+	alias string,
+	selection []Selection,
+	depth int,
+) (sq.Sqlizer, error) {
+	objectArgs := sq.Expr(``)
+	for i, s := range selection {
+		if i > 0 {
+			objectArgs = sq.ConcatExpr(objectArgs, `, `)
+		}
+		objectArgs = sq.ConcatExpr(objectArgs, sq.Expr(`?, `, s.Key()))
+		value := sq.Expr(`json_extract(`+alias+`.data, ?)`, `$.`+s.FieldName)
+		field, isField := collection.Fields[s.FieldName]
+		switch {
+		case s.FieldName == IdField:
+			objectArgs = sq.ConcatExpr(objectArgs, alias+`.`+IdField)
+		case s.FieldName == TypenameField:
+			objectArgs = sq.ConcatExpr(objectArgs, sq.Expr(`?`, collection.Name))
+		case s.FieldName == HistoryField && !isField:
+			historyQuery, err := o.buildHistoryQuery(ctx, collection, sq.Expr(alias+`.`+IdField), historyFirst(s), s.Subselections, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			objectArgs = sq.ConcatExpr(objectArgs, historyQuery)
+		case !field.IsStored():
+			inverseQuery, err := o.buildInverseFieldQuery(ctx, field, alias, s, depth)
+			if err != nil {
+				return nil, err
+			}
+			objectArgs = sq.ConcatExpr(objectArgs, inverseQuery)
+		case field.IsList && field.Ref > 0 && len(s.Subselections) > 0:
+			elementAlias := elementAlias(depth)
+			recordQuery, err := o.buildRecordQuery(ctx, sq.Expr(elementAlias+`.value`), s.Subselections, field.Ref, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			objectArgs = sq.ConcatExpr(
+				objectArgs,
+				`json((SELECT json_group_array(json((`, recordQuery, `))) FROM `,
+				sq.Expr(`json_each(`+alias+`.data, ?)`, `$.`+s.FieldName),
+				` AS `+elementAlias+`))`,
+			)
+		case field.IsList:
+			objectArgs = sq.ConcatExpr(objectArgs, `json(`, value, `)`)
+		case field.Ref > 0 && len(s.Subselections) > 0:
+			recordQuery, err := o.buildRecordQuery(ctx, value, s.Subselections, field.Ref, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			objectArgs = sq.ConcatExpr(objectArgs, `json((`, recordQuery, `))`)
+		default:
+			objectArgs = sq.ConcatExpr(objectArgs, value)
+		}
+	}
+	return sq.ConcatExpr(`json_object(`, objectArgs, `)`), nil
+}
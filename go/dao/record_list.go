@@ -0,0 +1,422 @@
+package dao
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+const (
+	ConnectionEdges    = "edges"
+	ConnectionPageInfo = "pageInfo"
+	EdgeCursor         = "cursor"
+	EdgeNode           = "node"
+)
+
+type ListParams struct {
+	Where   *Filter
+	OrderBy []Order
+	First   int
+	After   string
+}
+
+// Filter is a tree of conditions. Conditions and nested filters are all
+// required to match, except for Or where any one of them has to.
+type Filter struct {
+	Conditions []Condition
+	And        []Filter
+	Or         []Filter
+}
+
+type Condition struct {
+	Field    string
+	Operator string
+	Value    any
+}
+
+const (
+	OperatorEq     = "eq"
+	OperatorNe     = "ne"
+	OperatorGt     = "gt"
+	OperatorGte    = "gte"
+	OperatorLt     = "lt"
+	OperatorLte    = "lte"
+	OperatorIn     = "in"
+	OperatorLike   = "like"
+	OperatorIsNull = "isNull"
+)
+
+type Order struct {
+	Field      string
+	Descending bool
+}
+
+// ListRecords implements RecordDao.
+//
+// The whole connection is produced by one query: a page CTE selects up to
+// First+1 rows after the cursor, and the outer select aggregates them into
+// edges and pageInfo with json_group_array.
+func (o *daoImpl) ListRecords(
+	ctx context.Context,
+	params ListParams,
+	selection []Selection,
+	collectionId int,
+) ([]byte, error) {
+	collection, err := o.FindCollectionById(ctx, collectionId)
+	if err != nil {
+		return nil, err
+	}
+	// the history of the records can be selected
+	if err := createHistoryTable(ctx, o.recordDb); err != nil {
+		return nil, err
+	}
+	listQuery, err := o.buildListQuery(ctx, collection, params, selection)
+	if err != nil {
+		return nil, err
+	}
+	var json []byte
+	err = listQuery.RunWith(o.recordDb).QueryRowContext(ctx).Scan(&json)
+	return json, err
+}
+
+func (o *daoImpl) buildListQuery(
+	ctx context.Context,
+	collection *Collection,
+	params ListParams,
+	selection []Selection,
+) (sq.SelectBuilder, error) {
+	alias := tableAlias(0)
+	pageSize := params.First
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+
+	// id is always the last sort key so that cursors are unique
+	orderBy := []Order{}
+	for _, order := range params.OrderBy {
+		if order.Field == IdField {
+			continue
+		}
+		if err := checkScalarField(collection, order.Field); err != nil {
+			return sq.SelectBuilder{}, err
+		}
+		orderBy = append(orderBy, order)
+	}
+	idDescending := len(params.OrderBy) > 0 &&
+		params.OrderBy[len(params.OrderBy)-1].Field == IdField &&
+		params.OrderBy[len(params.OrderBy)-1].Descending
+	orderBy = append(orderBy, Order{Field: IdField, Descending: idDescending})
+
+	orderClauses := make([]string, len(orderBy))
+	cursorColumns := make([]string, len(orderBy))
+	for i, order := range orderBy {
+		cursorColumns[i] = alias + `.` + order.Field
+		orderClauses[i] = cursorColumns[i]
+		if order.Descending {
+			orderClauses[i] += ` DESC`
+		}
+	}
+	orderSql := strings.Join(orderClauses, `, `)
+
+	where := sq.And{}
+	if params.Where != nil {
+		filter, err := params.Where.toSql(collection, alias)
+		if err != nil {
+			return sq.SelectBuilder{}, err
+		}
+		where = append(where, filter)
+	}
+	if params.After != "" {
+		cursor, err := decodeCursor(params.After, len(orderBy))
+		if err != nil {
+			return sq.SelectBuilder{}, err
+		}
+		where = append(where, afterCursor(orderBy, cursorColumns, cursor))
+	}
+
+	page := sq.Select().
+		Column(sq.Alias(sq.Expr(`row_number() OVER (ORDER BY `+orderSql+`)`), `rn`)).
+		Column(sq.Alias(sq.Expr(`hex(json_array(`+strings.Join(cursorColumns, `, `)+`))`), `cursor`))
+	page, err := o.addNodeColumns(ctx, page, collection, alias, selection)
+	if err != nil {
+		return sq.SelectBuilder{}, err
+	}
+	page = page.
+		From(collection.Name + ` AS ` + alias).
+		Where(where).
+		OrderBy(orderSql).
+		Limit(uint64(pageSize + 1))
+	return buildConnectionQuery(page, selection, pageSize, params.After != "")
+}
+
+// addNodeColumns adds a column to the page for every aliased node selection
+// of a connection, with the records aliased by alias.
+func (o *daoImpl) addNodeColumns(
+	ctx context.Context,
+	page sq.SelectBuilder,
+	collection *Collection,
+	alias string,
+	selection []Selection,
+) (sq.SelectBuilder, error) {
+	nodeColumns := 0
+	for _, s := range selection {
+		if s.FieldName != ConnectionEdges {
+			continue
+		}
+		for _, e := range s.Subselections {
+			if e.FieldName == EdgeNode {
+				node, err := o.buildJsonObject(ctx, collection, alias, e.Subselections, 0)
+				if err != nil {
+					return sq.SelectBuilder{}, err
+				}
+				page = page.Column(sq.Alias(node, nodeColumn(nodeColumns)))
+				nodeColumns++
+			}
+		}
+	}
+	return page, nil
+}
+
+// buildConnectionQuery aggregates a page of up to pageSize+1 rows into a
+// connection. The page has the columns rn, cursor and those of
+// addNodeColumns, as well as a column for each of edgeColumns, which are
+// edge fields of their own.
+func buildConnectionQuery(
+	page sq.SelectBuilder,
+	selection []Selection,
+	pageSize int,
+	hasCursor bool,
+	edgeColumns ...string,
+) (sq.SelectBuilder, error) {
+	limit := strconv.Itoa(pageSize)
+	nodeColumns := 0
+	connectionArgs := sq.Expr(``)
+	for i, s := range selection {
+		if i > 0 {
+			connectionArgs = sq.ConcatExpr(connectionArgs, `, `)
+		}
+		connectionArgs = sq.ConcatExpr(connectionArgs, sq.Expr(`?, `, s.Key()))
+		switch s.FieldName {
+		case ConnectionEdges:
+			edgeArgs := sq.Expr(``)
+			for j, e := range s.Subselections {
+				if j > 0 {
+					edgeArgs = sq.ConcatExpr(edgeArgs, `, `)
+				}
+				edgeArgs = sq.ConcatExpr(edgeArgs, sq.Expr(`?, `, e.Key()))
+				switch e.FieldName {
+				case EdgeCursor:
+					edgeArgs = sq.ConcatExpr(edgeArgs, `cursor`)
+				case EdgeNode:
+					edgeArgs = sq.ConcatExpr(edgeArgs, `json(`+nodeColumn(nodeColumns)+`)`)
+					nodeColumns++
+				default:
+					if slices.Contains(edgeColumns, e.FieldName) {
+						edgeArgs = sq.ConcatExpr(edgeArgs, e.FieldName)
+						continue
+					}
+					return sq.SelectBuilder{}, fmt.Errorf("invalid edge field: %s", e.FieldName)
+				}
+			}
+			connectionArgs = sq.ConcatExpr(
+				connectionArgs,
+				`json((SELECT json_group_array(json_object(`,
+				edgeArgs,
+				`)) FROM (SELECT * FROM page WHERE rn <= `+limit+` ORDER BY rn)))`,
+			)
+		case ConnectionPageInfo:
+			pageInfoArgs, err := buildPageInfoArgs(s.Subselections, limit, hasCursor)
+			if err != nil {
+				return sq.SelectBuilder{}, err
+			}
+			connectionArgs = sq.ConcatExpr(connectionArgs, `json_object(`, pageInfoArgs, `)`)
+		default:
+			return sq.SelectBuilder{}, fmt.Errorf("invalid connection field: %s", s.FieldName)
+		}
+	}
+
+	return sq.Select().
+		Column(sq.ConcatExpr(`json_object(`, connectionArgs, `)`)).
+		PrefixExpr(sq.ConcatExpr(`WITH page AS (`, page, `)`)), nil
+}
+
+func nodeColumn(i int) string {
+	return `node` + strconv.Itoa(i)
+}
+
+func buildPageInfoArgs(selection []Selection, limit string, hasCursor bool) (sq.Sqlizer, error) {
+	pageInfoArgs := sq.Expr(``)
+	for i, s := range selection {
+		if i > 0 {
+			pageInfoArgs = sq.ConcatExpr(pageInfoArgs, `, `)
+		}
+		pageInfoArgs = sq.ConcatExpr(pageInfoArgs, sq.Expr(`?, `, s.Key()))
+		switch s.FieldName {
+		case "hasNextPage":
+			pageInfoArgs = sq.ConcatExpr(
+				pageInfoArgs,
+				`json(iif((SELECT count(*) FROM page) > `+limit+`, 'true', 'false'))`,
+			)
+		case "hasPreviousPage":
+			pageInfoArgs = sq.ConcatExpr(pageInfoArgs, `json('`+strconv.FormatBool(hasCursor)+`')`)
+		case "startCursor":
+			pageInfoArgs = sq.ConcatExpr(
+				pageInfoArgs,
+				`(SELECT cursor FROM page ORDER BY rn LIMIT 1)`,
+			)
+		case "endCursor":
+			pageInfoArgs = sq.ConcatExpr(
+				pageInfoArgs,
+				`(SELECT cursor FROM page WHERE rn <= `+limit+` ORDER BY rn DESC LIMIT 1)`,
+			)
+		default:
+			return nil, fmt.Errorf("invalid pageInfo field: %s", s.FieldName)
+		}
+	}
+	return pageInfoArgs, nil
+}
+
+// afterCursor expands a keyset comparison over mixed sort directions:
+// (a > x) OR (a = x AND b > y) OR ... SQLite sorts NULL as the smallest
+// value, so NULLs come first ascending and last descending.
+func afterCursor(orderBy []Order, columns []string, cursor []any) sq.Sqlizer {
+	after := sq.Or{}
+	for i, order := range orderBy {
+		term := sq.And{}
+		for j := 0; j < i; j++ {
+			term = append(term, sq.Eq{columns[j]: cursor[j]})
+		}
+		column, value := columns[i], cursor[i]
+		switch {
+		case value == nil && !order.Descending:
+			term = append(term, sq.NotEq{column: nil})
+		case value == nil && order.Descending:
+			continue
+		case !order.Descending:
+			term = append(term, sq.Gt{column: value})
+		default:
+			term = append(term, sq.Or{sq.Lt{column: value}, sq.Eq{column: nil}})
+		}
+		after = append(after, term)
+	}
+	return after
+}
+
+func decodeCursor(cursor string, length int) ([]any, error) {
+	invalidCursor := fmt.Errorf("invalid cursor: %s", cursor)
+	raw, err := hex.DecodeString(cursor)
+	if err != nil {
+		return nil, invalidCursor
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	values := []any{}
+	if err := decoder.Decode(&values); err != nil || len(values) != length {
+		return nil, invalidCursor
+	}
+	for i, value := range values {
+		if number, ok := value.(json.Number); ok {
+			if intValue, err := number.Int64(); err == nil {
+				values[i] = intValue
+			} else if floatValue, err := number.Float64(); err == nil {
+				values[i] = floatValue
+			} else {
+				return nil, invalidCursor
+			}
+		}
+	}
+	return values, nil
+}
+
+func (f *Filter) toSql(collection *Collection, alias string) (sq.Sqlizer, error) {
+	and := sq.And{}
+	for _, condition := range f.Conditions {
+		if condition.Field != IdField {
+			if err := checkScalarField(collection, condition.Field); err != nil {
+				return nil, err
+			}
+		}
+		column := alias + `.` + condition.Field
+		var expr sq.Sqlizer
+		switch condition.Operator {
+		case OperatorEq:
+			expr = sq.Eq{column: condition.Value}
+		case OperatorNe:
+			expr = sq.NotEq{column: condition.Value}
+		case OperatorGt:
+			expr = sq.Gt{column: condition.Value}
+		case OperatorGte:
+			expr = sq.GtOrEq{column: condition.Value}
+		case OperatorLt:
+			expr = sq.Lt{column: condition.Value}
+		case OperatorLte:
+			expr = sq.LtOrEq{column: condition.Value}
+		case OperatorIn:
+			values, ok := condition.Value.([]any)
+			if !ok {
+				return nil, fmt.Errorf("in operator needs a list for field %s", condition.Field)
+			}
+			expr = sq.Eq{column: values}
+		case OperatorLike:
+			expr = sq.Like{column: condition.Value}
+		case OperatorIsNull:
+			isNull, ok := condition.Value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("isNull operator needs a boolean for field %s", condition.Field)
+			}
+			if isNull {
+				expr = sq.Eq{column: nil}
+			} else {
+				expr = sq.NotEq{column: nil}
+			}
+		default:
+			return nil, fmt.Errorf("invalid operator %s for field %s", condition.Operator, condition.Field)
+		}
+		and = append(and, expr)
+	}
+	for _, filter := range f.And {
+		expr, err := filter.toSql(collection, alias)
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, expr)
+	}
+	if len(f.Or) > 0 {
+		or := sq.Or{}
+		for _, filter := range f.Or {
+			expr, err := filter.toSql(collection, alias)
+			if err != nil {
+				return nil, err
+			}
+			or = append(or, expr)
+		}
+		and = append(and, or)
+	}
+	return and, nil
+}
+
+func checkScalarField(collection *Collection, fieldName string) error {
+	field, ok := collection.Fields[fieldName]
+	if !ok {
+		return fmt.Errorf("invalid field %s for collection %s", fieldName, collection.Name)
+	}
+	if field.IsList {
+		return fmt.Errorf("list field %s cannot be filtered or sorted", fieldName)
+	}
+	return nil
+}
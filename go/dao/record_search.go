@@ -0,0 +1,292 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Fields of the edges of SearchRecords, besides those of ListRecords.
+const (
+	// EdgeRank is the bm25 rank of the record, lower is better.
+	EdgeRank = "rank"
+	// EdgeSnippet is an excerpt of the searchable field that matches best,
+	// as HTML: the text is escaped, and the matches are between SnippetStart
+	// and SnippetEnd.
+	EdgeSnippet = "snippet"
+)
+
+const (
+	SnippetStart    = "<mark>"
+	SnippetEnd      = "</mark>"
+	snippetEllipsis = "…"
+	snippetTokens   = 16
+)
+
+// snippetStartMarker and snippetEndMarker are the markers snippet() puts
+// around matches before the text is escaped, which are control characters
+// so that text cannot pass for them once escaped.
+const (
+	snippetStartMarker = "\x01"
+	snippetEndMarker   = "\x02"
+)
+
+// snippetReplacements are the replacements of escapeSnippet, & first so that
+// the entities of the others are not escaped again.
+var snippetReplacements = [][2]string{
+	{"&", "&amp;"},
+	{"<", "&lt;"},
+	{">", "&gt;"},
+	{`"`, "&quot;"},
+	{"'", "&#39;"},
+	{snippetStartMarker, SnippetStart},
+	{snippetEndMarker, SnippetEnd},
+}
+
+// escapeSnippet escapes the text of a snippet with the markers around its
+// matches, and replaces the markers with SnippetStart and SnippetEnd.
+func escapeSnippet(snippet sq.Sqlizer) sq.Sqlizer {
+	for _, replacement := range snippetReplacements {
+		snippet = sq.ConcatExpr(`replace(`, snippet, sq.Expr(`, ?, ?)`, replacement[0], replacement[1]))
+	}
+	return snippet
+}
+
+// ErrSearchUnavailable is returned when a collection gets searchable fields
+// but SQLite was built without FTS5, which go-sqlite3 only includes with the
+// sqlite_fts5 build tag.
+var ErrSearchUnavailable = errors.New("full-text search needs SQLite with FTS5, build with -tags sqlite_fts5")
+
+// ErrInvalidSearchQuery is returned by SearchRecords for queries that are not
+// valid FTS5 queries.
+var ErrInvalidSearchQuery = errors.New("invalid search query")
+
+type SearchParams struct {
+	// Query is an FTS5 query, such as `hello wor*` or `title: "hello world"`.
+	Query string
+	First int
+	After string
+}
+
+// searchTableName is the name of the FTS5 table of a collection. Names
+// starting with __ are reserved in GraphQL, so it cannot be the table of a
+// collection or of a list field.
+func searchTableName(collection *Collection) string {
+	return `__search_` + collection.Name
+}
+
+// SearchableFields returns the names of the searchable fields of a
+// collection in order, which are the columns of its search table.
+func SearchableFields(collection *Collection) []string {
+	names := []string{}
+	for name, field := range collection.Fields {
+		if field.Searchable && field.IsStored() && !field.IsList {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// createSearchTable creates the search table of a collection with searchable
+// fields, along with the triggers that keep it in sync with the records, and
+// indexes the records that are already there.
+//
+// The search table keeps a copy of the fields rather than reading them from
+// the collection table, because REPLACE does not fire delete triggers and
+// would leave stale entries for the replaced records. Every insert removes
+// the entry with the same id first instead.
+func createSearchTable(ctx context.Context, db execer, collection *Collection) error {
+	fields := SearchableFields(collection)
+	if len(fields) == 0 {
+		return nil
+	}
+	table := searchTableName(collection)
+	columns := strings.Join(fields, `, `)
+	newValues := `new.` + strings.Join(fields, `, new.`)
+	deleteOld := `DELETE FROM ` + table + ` WHERE rowid = old.` + IdField + `; `
+	insertNew := `INSERT INTO ` + table + ` (rowid, ` + columns + `) VALUES (new.` + IdField + `, ` + newValues + `); `
+	statements := []string{
+		`CREATE VIRTUAL TABLE ` + table + ` USING fts5(` + columns + `)`,
+		`CREATE TRIGGER ` + table + `_insert AFTER INSERT ON ` + collection.Name + ` BEGIN ` +
+			`DELETE FROM ` + table + ` WHERE rowid = new.` + IdField + `; ` + insertNew + `END`,
+		`CREATE TRIGGER ` + table + `_update AFTER UPDATE ON ` + collection.Name + ` BEGIN ` +
+			deleteOld + insertNew + `END`,
+		`CREATE TRIGGER ` + table + `_delete AFTER DELETE ON ` + collection.Name + ` BEGIN ` +
+			deleteOld + `END`,
+		`INSERT INTO ` + table + ` (rowid, ` + columns + `) SELECT ` + IdField + `, ` + columns + ` FROM ` + collection.Name,
+	}
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			if strings.Contains(err.Error(), "no such module: fts5") {
+				return ErrSearchUnavailable
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildSearchTable recreates the search table of a collection after its
+// searchable fields changed, or drops it if there are none left.
+func rebuildSearchTable(ctx context.Context, recordTx *sql.Tx, collection *Collection) error {
+	table := searchTableName(collection)
+	for _, statement := range []string{
+		`DROP TRIGGER IF EXISTS ` + table + `_insert`,
+		`DROP TRIGGER IF EXISTS ` + table + `_update`,
+		`DROP TRIGGER IF EXISTS ` + table + `_delete`,
+		`DROP TABLE IF EXISTS ` + table,
+	} {
+		if _, err := recordTx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return createSearchTable(ctx, recordTx, collection)
+}
+
+// withField returns a copy of collection with field added or replaced.
+func withField(collection *Collection, field CollectionField) *Collection {
+	updated := *collection
+	updated.Fields = map[string]CollectionField{field.Name: field}
+	for name, f := range collection.Fields {
+		if name != field.Name {
+			updated.Fields[name] = f
+		}
+	}
+	return &updated
+}
+
+// withoutField returns a copy of collection without the field.
+func withoutField(collection *Collection, fieldName string) *Collection {
+	updated := *collection
+	updated.Fields = map[string]CollectionField{}
+	for name, f := range collection.Fields {
+		if name != fieldName {
+			updated.Fields[name] = f
+		}
+	}
+	return &updated
+}
+
+// SearchRecords implements RecordDao.
+//
+// The records are ranked with bm25 like the rank column of FTS5, and the
+// connection is built like that of ListRecords, with the rank and id of the
+// records in the cursors.
+func (o *daoImpl) SearchRecords(
+	ctx context.Context,
+	params SearchParams,
+	selection []Selection, /*connection selection*/
+	collectionId int,
+) ([]byte, error) {
+	collection, err := o.FindCollectionById(ctx, collectionId)
+	if err != nil {
+		return nil, err
+	}
+	if len(SearchableFields(collection)) == 0 {
+		return nil, fmt.Errorf("collection %s has no searchable fields", collection.Name)
+	}
+	// the history of the records can be selected
+	if err := createHistoryTable(ctx, o.recordDb); err != nil {
+		return nil, err
+	}
+	table := searchTableName(collection)
+	// the query is checked on its own, so that its errors are not mistaken
+	// for those of the records
+	var matched int
+	err = sq.Select(`1`).
+		From(table).
+		Where(table+` MATCH ?`, params.Query).
+		Limit(1).
+		RunWith(o.recordDb).
+		QueryRowContext(ctx).
+		Scan(&matched)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSearchQuery, err)
+	}
+
+	searchQuery, err := o.buildSearchQuery(ctx, collection, params, selection)
+	if err != nil {
+		return nil, err
+	}
+	var json []byte
+	err = searchQuery.RunWith(o.recordDb).QueryRowContext(ctx).Scan(&json)
+	return json, err
+}
+
+func (o *daoImpl) buildSearchQuery(
+	ctx context.Context,
+	collection *Collection,
+	params SearchParams,
+	selection []Selection,
+) (sq.SelectBuilder, error) {
+	alias := tableAlias(0)
+	table := searchTableName(collection)
+	pageSize := params.First
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+
+	orderBy := []Order{{Field: EdgeRank}, {Field: IdField}}
+	cursorColumns := []string{table + `.rank`, alias + `.` + IdField}
+	orderSql := strings.Join(cursorColumns, `, `)
+	where := sq.And{sq.Expr(table+` MATCH ?`, params.Query)}
+	if params.After != "" {
+		cursor, err := decodeCursor(params.After, len(orderBy))
+		if err != nil {
+			return sq.SelectBuilder{}, err
+		}
+		// ranks are in cursors as text, as JSON would round them
+		rank, ok := cursor[0].(string)
+		if !ok {
+			return sq.SelectBuilder{}, fmt.Errorf("invalid cursor: %s", params.After)
+		}
+		cursor[0], err = strconv.ParseFloat(rank, 64)
+		if err != nil {
+			return sq.SelectBuilder{}, fmt.Errorf("invalid cursor: %s", params.After)
+		}
+		where = append(where, afterCursor(orderBy, cursorColumns, cursor))
+	}
+
+	// auxiliary functions like snippet cannot be used next to window
+	// functions, so the matches are a subquery of the page. Its columns have
+	// names that fields cannot have.
+	matches := sq.Select(alias + `.*`).
+		Column(sq.Alias(sq.Expr(table+`.rank`), `__rank`)).
+		Column(sq.Alias(
+			escapeSnippet(sq.Expr(
+				`snippet(`+table+`, -1, ?, ?, ?, ?)`,
+				snippetStartMarker, snippetEndMarker, snippetEllipsis, snippetTokens,
+			)),
+			`__snippet`,
+		)).
+		From(table).
+		Join(collection.Name + ` AS ` + alias + ` ON ` + alias + `.` + IdField + ` = ` + table + `.rowid`).
+		Where(where).
+		OrderBy(orderSql).
+		Limit(uint64(pageSize + 1))
+	rankColumn := alias + `.__rank`
+	idColumn := alias + `.` + IdField
+	page := sq.Select().
+		Column(sq.Alias(sq.Expr(`row_number() OVER (ORDER BY `+rankColumn+`, `+idColumn+`)`), `rn`)).
+		Column(sq.Alias(sq.Expr(`hex(json_array(printf('%.17g', `+rankColumn+`), `+idColumn+`))`), `cursor`)).
+		Column(sq.Alias(sq.Expr(rankColumn), EdgeRank)).
+		Column(sq.Alias(sq.Expr(alias+`.__snippet`), EdgeSnippet))
+	page, err := o.addNodeColumns(ctx, page, collection, alias, selection)
+	if err != nil {
+		return sq.SelectBuilder{}, err
+	}
+	page = page.
+		FromSelect(matches, alias).
+		OrderBy(rankColumn + `, ` + idColumn)
+	return buildConnectionQuery(page, selection, pageSize, params.After != "", EdgeRank, EdgeSnippet)
+}
@@ -0,0 +1,683 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// SetRecord implements RecordDao. A record with the same id is replaced
+// if values contains one, otherwise a new record is inserted. ErrInvalidValue
+// is returned for values that do not fit their field.
+func (o *daoImpl) SetRecord(
+	ctx context.Context,
+	values map[string]any,
+	selection []Selection,
+	collectionId int,
+) ([]byte, error) {
+	collection, err := o.FindCollectionById(ctx, collectionId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRecordValues(collection, values); err != nil {
+		return nil, err
+	}
+	for _, field := range collection.Fields {
+		if field.NonNull && !field.IsList && values[field.Name] == nil {
+			return nil, fmt.Errorf("non-null field %s of collection %s needs a value", field.Name, collection.Name)
+		}
+	}
+
+	recordTx, err := o.recordDb.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer recordTx.Rollback()
+
+	if err := o.checkReferences(ctx, recordTx, collection, values); err != nil {
+		return nil, err
+	}
+	columnValues, listValues := splitListValues(collection, values)
+	var result sql.Result
+	if len(columnValues) == 0 {
+		result, err = recordTx.ExecContext(ctx, `INSERT INTO `+collection.Name+` DEFAULT VALUES`)
+	} else {
+		columns, columnValues := sortedColumns(columnValues)
+		result, err = sq.Replace(collection.Name).
+			Columns(columns...).
+			Values(columnValues...).
+			RunWith(recordTx).
+			ExecContext(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	// a replaced record does not keep any of its old list elements
+	for _, field := range collection.Fields {
+		if !field.IsList || !field.IsStored() {
+			continue
+		}
+		if err := writeListValues(ctx, recordTx, collection, field, int(id), listValues[field.Name]); err != nil {
+			return nil, err
+		}
+	}
+
+	json, err := o.readRecord(ctx, recordTx, int(id), selection, collectionId)
+	if err != nil {
+		return nil, err
+	}
+	changes := []Change{{CollectionId: collectionId, Id: int(id), Operation: ChangeSet}}
+	if err := o.writeHistory(ctx, recordTx, changes); err != nil {
+		return nil, err
+	}
+	if err := o.changes.write(ctx, recordTx, changes); err != nil {
+		return nil, err
+	}
+	if err := recordTx.Commit(); err != nil {
+		return nil, err
+	}
+	o.changes.fire(ctx, changes)
+	return json, nil
+}
+
+// PatchRecord implements RecordDao. Only the columns in values are
+// updated; sql.ErrNoRows is returned if there is no record with the id, and
+// ErrInvalidValue for values that do not fit their field.
+func (o *daoImpl) PatchRecord(
+	ctx context.Context,
+	id int,
+	values map[string]any,
+	selection []Selection,
+	collectionId int,
+) ([]byte, error) {
+	collection, err := o.FindCollectionById(ctx, collectionId)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := values[IdField]; ok {
+		return nil, fmt.Errorf("id of a record cannot be patched")
+	}
+	if err := checkRecordValues(collection, values); err != nil {
+		return nil, err
+	}
+
+	recordTx, err := o.recordDb.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer recordTx.Rollback()
+
+	if err := o.checkReferences(ctx, recordTx, collection, values); err != nil {
+		return nil, err
+	}
+	columnValues, listValues := splitListValues(collection, values)
+	if len(columnValues) > 0 {
+		result, err := sq.Update(collection.Name).
+			SetMap(columnValues).
+			Where(sq.Eq{IdField: id}).
+			RunWith(recordTx).
+			ExecContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if rowsAffected == 0 {
+			return nil, sql.ErrNoRows
+		}
+	}
+	for fieldName, items := range listValues {
+		field := collection.Fields[fieldName]
+		if err := writeListValues(ctx, recordTx, collection, field, id, items); err != nil {
+			return nil, err
+		}
+	}
+
+	json, err := o.readRecord(ctx, recordTx, id, selection, collectionId)
+	if err != nil {
+		return nil, err
+	}
+	changes := []Change{{CollectionId: collectionId, Id: id, Operation: ChangePatch}}
+	if err := o.writeHistory(ctx, recordTx, changes); err != nil {
+		return nil, err
+	}
+	if err := o.changes.write(ctx, recordTx, changes); err != nil {
+		return nil, err
+	}
+	if err := recordTx.Commit(); err != nil {
+		return nil, err
+	}
+	o.changes.fire(ctx, changes)
+	return json, nil
+}
+
+// ErrDeleteRestricted is returned by DeleteRecord when a record is still
+// referred to by a field with the OnDeleteRestrict action.
+var ErrDeleteRestricted = errors.New("record is still referenced")
+
+// DeleteRecord implements RecordDao. The record is read with selection
+// before it is deleted, then the references to it are handled by the
+// OnDeleteAction of each field that refers to its collection, in the same
+// transaction. sql.ErrNoRows is returned if there is no record with the id.
+func (o *daoImpl) DeleteRecord(
+	ctx context.Context,
+	id int,
+	selection []Selection,
+	collectionId int,
+) ([]byte, error) {
+	collections, err := o.ListCollections(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recordTx, err := o.recordDb.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer recordTx.Rollback()
+
+	json, err := o.readRecord(ctx, recordTx, id, selection, collectionId)
+	if err != nil {
+		return nil, err
+	}
+	// the whole cascade is known before any restricted reference is checked,
+	// so that records deleted anyway never hold up the delete
+	deleted := map[int]map[int]bool{}
+	changes := []Change{}
+	if err := collectDeletes(ctx, recordTx, collections, collectionId, []int{id}, deleted, &changes); err != nil {
+		return nil, err
+	}
+	if err := checkRestricted(ctx, recordTx, collections, deleted, changes); err != nil {
+		return nil, err
+	}
+	if err := deleteRecords(ctx, recordTx, collections, deleted, &changes); err != nil {
+		return nil, err
+	}
+	if err := o.writeHistory(ctx, recordTx, changes); err != nil {
+		return nil, err
+	}
+	if err := o.changes.write(ctx, recordTx, changes); err != nil {
+		return nil, err
+	}
+	if err := recordTx.Commit(); err != nil {
+		return nil, err
+	}
+	o.changes.fire(ctx, changes)
+	return json, nil
+}
+
+// CascadeCollections returns the other collections whose records a delete
+// of a record of the collection with collectionId can delete or patch, by
+// the OnDeleteCascade and OnDeleteSetNull actions of the fields that refer
+// to it, and so on down the cascade.
+func CascadeCollections(collections []*Collection, collectionId int) []*Collection {
+	reached := map[int]bool{collectionId: true}
+	cascaded := map[int]bool{collectionId: true}
+	cascading := []int{collectionId}
+	result := []*Collection{}
+	for len(cascading) > 0 {
+		target := cascading[0]
+		cascading = cascading[1:]
+		for _, c := range collections {
+			for _, field := range c.Fields {
+				if field.Ref != target || !field.IsStored() {
+					continue
+				}
+				action := field.OnDeleteAction()
+				if action != OnDeleteCascade && action != OnDeleteSetNull {
+					continue
+				}
+				if !reached[c.Id] {
+					reached[c.Id] = true
+					result = append(result, c)
+				}
+				// patched records do not cascade any further
+				if action == OnDeleteCascade && !cascaded[c.Id] {
+					cascaded[c.Id] = true
+					cascading = append(cascading, c.Id)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// collectDeletes adds records of a collection, and every record that
+// cascades from them, to deleted by collection id and to changes. Records
+// already in deleted are skipped, so that cascades through cyclic references
+// end.
+func collectDeletes(
+	ctx context.Context,
+	recordTx *sql.Tx,
+	collections []*Collection,
+	collectionId int,
+	ids []int,
+	deleted map[int]map[int]bool,
+	changes *[]Change,
+) error {
+	if deleted[collectionId] == nil {
+		deleted[collectionId] = map[int]bool{}
+	}
+	newIds := []int{}
+	for _, id := range ids {
+		if !deleted[collectionId][id] {
+			deleted[collectionId][id] = true
+			newIds = append(newIds, id)
+			*changes = append(*changes, Change{CollectionId: collectionId, Id: id, Operation: ChangeDelete})
+		}
+	}
+	if len(newIds) == 0 {
+		return nil
+	}
+	for _, c := range collections {
+		for _, field := range c.Fields {
+			if field.Ref != collectionId || !field.IsStored() || field.OnDeleteAction() != OnDeleteCascade {
+				continue
+			}
+			referencing, err := referencingIds(ctx, recordTx, c, field, newIds)
+			if err != nil {
+				return err
+			}
+			if err := collectDeletes(ctx, recordTx, collections, c.Id, referencing, deleted, changes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkRestricted returns ErrDeleteRestricted if a record that is not deleted
+// refers to a deleted record by a field with the OnDeleteRestrict action.
+func checkRestricted(
+	ctx context.Context,
+	recordTx *sql.Tx,
+	collections []*Collection,
+	deleted map[int]map[int]bool,
+	changes []Change,
+) error {
+	for _, target := range collections {
+		ids := deletedIds(changes, target.Id)
+		if len(ids) == 0 {
+			continue
+		}
+		for _, c := range collections {
+			for _, field := range c.Fields {
+				if field.Ref != target.Id || !field.IsStored() || field.OnDeleteAction() != OnDeleteRestrict {
+					continue
+				}
+				referencing, err := referencingIds(ctx, recordTx, c, field, ids)
+				if err != nil {
+					return err
+				}
+				for _, id := range referencing {
+					if !deleted[c.Id][id] {
+						return fmt.Errorf(
+							"%w by field %s of %s %d",
+							ErrDeleteRestricted,
+							field.Name,
+							c.Name,
+							id,
+						)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// deleteRecords deletes the records collected by collectDeletes along with
+// their list elements, after setting the references to them to null for the
+// fields with the OnDeleteSetNull action. Every patched record that is not
+// deleted is added to changes.
+func deleteRecords(
+	ctx context.Context,
+	recordTx *sql.Tx,
+	collections []*Collection,
+	deleted map[int]map[int]bool,
+	changes *[]Change,
+) error {
+	deletes := *changes
+	for _, collection := range collections {
+		ids := deletedIds(deletes, collection.Id)
+		if len(ids) == 0 {
+			continue
+		}
+		for _, c := range collections {
+			for _, field := range c.Fields {
+				if field.Ref != collection.Id || !field.IsStored() || field.OnDeleteAction() != OnDeleteSetNull {
+					continue
+				}
+				if err := setNull(ctx, recordTx, c, field, ids, deleted, changes); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, field := range collection.Fields {
+			if !field.IsList || !field.IsStored() {
+				continue
+			}
+			_, err := sq.Delete(listTableName(collection.Name, field.Name)).
+				Where(sq.Eq{"owner": ids}).
+				RunWith(recordTx).
+				ExecContext(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := sq.Delete(collection.Name).
+			Where(sq.Eq{IdField: ids}).
+			RunWith(recordTx).
+			ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setNull removes the references of one field of collection to the records
+// with ids.
+func setNull(
+	ctx context.Context,
+	recordTx *sql.Tx,
+	collection *Collection,
+	field CollectionField,
+	ids []int,
+	deleted map[int]map[int]bool,
+	changes *[]Change,
+) error {
+	referencing, err := referencingIds(ctx, recordTx, collection, field, ids)
+	if err != nil || len(referencing) == 0 {
+		return err
+	}
+	for _, id := range referencing {
+		if !deleted[collection.Id][id] {
+			*changes = append(*changes, Change{CollectionId: collection.Id, Id: id, Operation: ChangePatch})
+		}
+	}
+	if field.IsList {
+		_, err = sq.Delete(listTableName(collection.Name, field.Name)).
+			Where(sq.Eq{"value": ids}).
+			RunWith(recordTx).
+			ExecContext(ctx)
+	} else {
+		_, err = sq.Update(collection.Name).
+			Set(field.Name, nil).
+			Where(sq.Eq{field.Name: ids}).
+			RunWith(recordTx).
+			ExecContext(ctx)
+	}
+	return err
+}
+
+// referencingIds returns the ids of the records of collection that refer to
+// any of ids by field.
+func referencingIds(
+	ctx context.Context,
+	recordTx *sql.Tx,
+	collection *Collection,
+	field CollectionField,
+	ids []int,
+) ([]int, error) {
+	table := collection.Name
+	column := field.Name
+	owner := IdField
+	if field.IsList {
+		table = listTableName(collection.Name, field.Name)
+		column = "value"
+		owner = "owner"
+	}
+	return selectIds(ctx, recordTx, sq.Select(`DISTINCT `+owner).
+		From(table).
+		Where(sq.Eq{column: ids}))
+}
+
+// deletedIds returns the ids of the records of a collection deleted by
+// changes, in order.
+func deletedIds(changes []Change, collectionId int) []int {
+	ids := []int{}
+	for _, change := range changes {
+		if change.CollectionId == collectionId && change.Operation == ChangeDelete {
+			ids = append(ids, change.Id)
+		}
+	}
+	return ids
+}
+
+func selectIds(ctx context.Context, recordTx *sql.Tx, query sq.SelectBuilder) ([]int, error) {
+	rows, err := query.RunWith(recordTx).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// readRecord reads a record back inside of the transaction that wrote it.
+func (o *daoImpl) readRecord(
+	ctx context.Context,
+	recordTx *sql.Tx,
+	id int,
+	selection []Selection,
+	collectionId int,
+) ([]byte, error) {
+	// the history of the record can be selected
+	if err := createHistoryTable(ctx, recordTx); err != nil {
+		return nil, err
+	}
+	recordQuery, err := o.buildRecordQuery(ctx, sq.Expr(`?`, id), selection, collectionId, 0)
+	if err != nil {
+		return nil, err
+	}
+	var json []byte
+	err = recordQuery.RunWith(recordTx).QueryRowContext(ctx).Scan(&json)
+	return json, err
+}
+
+func checkRecordValues(collection *Collection, values map[string]any) error {
+	for fieldName := range values {
+		if fieldName == IdField {
+			continue
+		}
+		field, ok := collection.Fields[fieldName]
+		if !ok {
+			return fmt.Errorf("invalid field %s for collection %s", fieldName, collection.Name)
+		}
+		if !field.IsStored() {
+			return fmt.Errorf("field %s of collection %s is an inverse and cannot be written", fieldName, collection.Name)
+		}
+		if _, ok := values[fieldName].([]any); ok != field.IsList {
+			return fmt.Errorf("%w for field %s of collection %s", ErrInvalidValue, fieldName, collection.Name)
+		}
+		if field.NonNull && values[fieldName] == nil {
+			return fmt.Errorf("non-null field %s of collection %s cannot be null", fieldName, collection.Name)
+		}
+		items := []any{values[fieldName]}
+		if field.IsList {
+			items = values[fieldName].([]any)
+		}
+		for _, item := range items {
+			if (item != nil || field.IsList) && !isValidValue(field, item) {
+				return fmt.Errorf("%w for field %s of collection %s: %v is not %s", ErrInvalidValue, fieldName, collection.Name, item, field.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// ErrInvalidValue is returned when a value does not have the type of its
+// field, or refers to a record that does not exist.
+var ErrInvalidValue = errors.New("invalid value")
+
+// isValidValue reports whether a value that is not null can be stored in
+// a field. Numbers decoded from JSON are float64, so whole ones are integers,
+// and booleans are read back as 0 and 1.
+func isValidValue(field CollectionField, value any) bool {
+	switch field.Type {
+	case "String", BlobType:
+		_, ok := value.(string)
+		return ok
+	case "Boolean":
+		if _, ok := value.(bool); ok {
+			return true
+		}
+		number, ok := integerValue(value)
+		return ok && (number == 0 || number == 1)
+	case "Float":
+		if _, ok := value.(float64); ok {
+			return true
+		}
+	case "ID":
+		if _, ok := value.(string); ok {
+			return true
+		}
+	}
+	// Int and references are stored as integers
+	_, ok := integerValue(value)
+	return ok
+}
+
+func integerValue(value any) (int64, bool) {
+	switch value := value.(type) {
+	case int:
+		return int64(value), true
+	case int64:
+		return value, true
+	case float64:
+		if value == math.Trunc(value) {
+			return int64(value), true
+		}
+	}
+	return 0, false
+}
+
+// checkReferences checks that the values of reference fields refer to
+// records that exist, in the transaction of the write.
+func (o *daoImpl) checkReferences(
+	ctx context.Context,
+	recordTx *sql.Tx,
+	collection *Collection,
+	values map[string]any,
+) error {
+	for fieldName, value := range values {
+		field, ok := collection.Fields[fieldName]
+		if !ok || field.Ref == 0 || value == nil {
+			continue
+		}
+		items := []any{value}
+		if field.IsList {
+			items = value.([]any)
+		}
+		ids := map[int64]bool{}
+		for _, item := range items {
+			id, _ := integerValue(item)
+			ids[id] = true
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		refCollection, err := o.FindCollectionById(ctx, field.Ref)
+		if err != nil {
+			return err
+		}
+		idList := make([]int64, 0, len(ids))
+		for id := range ids {
+			idList = append(idList, id)
+		}
+		var count int
+		err = sq.Select("count(*)").
+			From(refCollection.Name).
+			Where(sq.Eq{IdField: idList}).
+			RunWith(recordTx).
+			QueryRowContext(ctx).
+			Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count != len(ids) {
+			return fmt.Errorf(
+				"%w for field %s of collection %s: no %s record has the id",
+				ErrInvalidValue,
+				fieldName,
+				collection.Name,
+				refCollection.Name,
+			)
+		}
+	}
+	return nil
+}
+
+func splitListValues(
+	collection *Collection,
+	values map[string]any,
+) (map[string]any, map[string][]any) {
+	columnValues := map[string]any{}
+	listValues := map[string][]any{}
+	for fieldName, value := range values {
+		if collection.Fields[fieldName].IsList {
+			listValues[fieldName] = value.([]any)
+		} else {
+			columnValues[fieldName] = value
+		}
+	}
+	return columnValues, listValues
+}
+
+// writeListValues replaces all the elements of a list field for one record.
+func writeListValues(
+	ctx context.Context,
+	recordTx *sql.Tx,
+	collection *Collection,
+	field CollectionField,
+	id int,
+	items []any,
+) error {
+	table := listTableName(collection.Name, field.Name)
+	_, err := sq.Delete(table).
+		Where(sq.Eq{"owner": id}).
+		RunWith(recordTx).
+		ExecContext(ctx)
+	if err != nil || len(items) == 0 {
+		return err
+	}
+	insertQuery := sq.Insert(table).Columns("owner", "position", "value")
+	for position, item := range items {
+		insertQuery = insertQuery.Values(id, position, item)
+	}
+	_, err = insertQuery.RunWith(recordTx).ExecContext(ctx)
+	return err
+}
+
+func sortedColumns(values map[string]any) ([]string, []any) {
+	columns := make([]string, 0, len(values))
+	for column := range values {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	columnValues := make([]any, len(columns))
+	for i, column := range columns {
+		columnValues[i] = values[column]
+	}
+	return columns, columnValues
+}
@@ -0,0 +1,70 @@
+package dao_test
+
+import (
+	"context"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sashankg/hold/dao"
+	"github.com/sashankg/hold/testing/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteRecordValues(t *testing.T) {
+	ctx := context.Background()
+	testDao := util.NewMemoryDao(t)
+	person := &dao.Collection{
+		Name:    "Person",
+		Version: "1",
+		Fields:  map[string]dao.CollectionField{"name": {Name: "name", Type: "String"}},
+	}
+	require.NoError(t, testDao.AddCollections(ctx, []*dao.Collection{person}))
+	post := &dao.Collection{
+		Name:    "Post",
+		Version: "1",
+		Fields: map[string]dao.CollectionField{
+			"title":     {Name: "title", Type: "String"},
+			"views":     {Name: "views", Type: "Int"},
+			"published": {Name: "published", Type: "Boolean"},
+			"author":    {Name: "author", Type: "Person", Ref: person.Id},
+			"readers":   {Name: "readers", Type: "Person", Ref: person.Id, IsList: true},
+		},
+	}
+	require.NoError(t, testDao.AddCollections(ctx, []*dao.Collection{post}))
+	_, err := testDao.SetRecord(ctx, map[string]any{"name": "ada"}, nil, person.Id)
+	require.NoError(t, err)
+
+	// values decoded from JSON are accepted as well
+	_, err = testDao.SetRecord(ctx, map[string]any{
+		"title":     "hello",
+		"views":     float64(10),
+		"published": float64(1),
+		"author":    1,
+		"readers":   []any{int64(1), float64(1)},
+	}, nil, post.Id)
+	require.NoError(t, err)
+
+	for name, values := range map[string]map[string]any{
+		"string for int":          {"views": "ten"},
+		"fraction for int":        {"views": 1.5},
+		"int for string":          {"title": 10},
+		"int for boolean":         {"published": 2},
+		"missing author":          {"author": 2},
+		"missing reader":          {"readers": []any{1, 2}},
+		"null reader":             {"readers": []any{nil}},
+		"string for a reference":  {"author": "ada"},
+		"list for a single value": {"author": []any{1}},
+	} {
+		_, err := testDao.SetRecord(ctx, values, nil, post.Id)
+		require.ErrorIs(t, err, dao.ErrInvalidValue, name)
+		_, err = testDao.PatchRecord(ctx, 1, values, nil, post.Id)
+		require.ErrorIs(t, err, dao.ErrInvalidValue, name)
+	}
+
+	record, err := testDao.GetRecord(ctx, 1, []dao.Selection{
+		{FieldName: "views"},
+		{FieldName: "author", Subselections: []dao.Selection{{FieldName: "name"}}},
+	}, post.Id)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"views": 10, "author": {"name": "ada"}}`, string(record))
+}
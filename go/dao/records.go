@@ -2,10 +2,23 @@ package dao
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	sq "github.com/Masterminds/squirrel"
 )
 
+const (
+	IdField = "id"
+	// TypenameField is the GraphQL meta field with the name of the type of a
+	// record, which is the name of its collection.
+	TypenameField = "__typename"
+	// BlobType is the type of fields that hold the digest of a blob.
+	BlobType = "Blob"
+)
+
 type RecordDao interface {
 	GetRecord(
 		ctx context.Context,
@@ -13,11 +26,84 @@ type RecordDao interface {
 		selection []Selection,
 		collectionId int,
 	) ([]byte, error)
+	ListRecords(
+		ctx context.Context,
+		params ListParams,
+		selection []Selection, /*connection selection*/
+		collectionId int,
+	) ([]byte, error)
+	// SearchRecords lists the records whose searchable fields match an FTS5
+	// query, best match first.
+	SearchRecords(
+		ctx context.Context,
+		params SearchParams,
+		selection []Selection, /*connection selection*/
+		collectionId int,
+	) ([]byte, error)
+	SetRecord(
+		ctx context.Context,
+		values map[string]any,
+		selection []Selection,
+		collectionId int,
+	) ([]byte, error)
+	PatchRecord(
+		ctx context.Context,
+		id int,
+		values map[string]any,
+		selection []Selection,
+		collectionId int,
+	) ([]byte, error)
+	DeleteRecord(
+		ctx context.Context,
+		id int,
+		selection []Selection,
+		collectionId int,
+	) ([]byte, error)
+	// GetRecordAsOf reads a record as it was at asOf, from its history.
+	// Object and inverse fields are read from the current records.
+	GetRecordAsOf(
+		ctx context.Context,
+		id int,
+		asOf time.Time,
+		selection []Selection,
+		collectionId int,
+	) ([]byte, error)
+	// ListRecordHistory lists the versions of a record, newest first, with
+	// the peer that wrote each of them. DeleteRecord purges the versions of
+	// every record it deletes.
+	ListRecordHistory(
+		ctx context.Context,
+		id int,
+		first int,
+		selection []Selection, /*version selection*/
+		collectionId int,
+	) ([]byte, error)
+	// ReferencedBlobs returns the digests in every Blob field of every
+	// collection, and of every version in the history.
+	ReferencedBlobs(ctx context.Context) (map[string]bool, error)
+	// OnChange adds a listener for the writes of SetRecord, PatchRecord and
+	// DeleteRecord, until the returned function is called.
+	OnChange(listener ChangeListener) (remove func())
+	// OnWrite adds a hook to the writes of SetRecord, PatchRecord and
+	// DeleteRecord, until the returned function is called.
+	OnWrite(hook WriteHook) (remove func())
 }
 
 type Selection struct {
+	// Alias is the key of the field in the result, if it is not FieldName.
+	Alias         string
 	FieldName     string
 	Subselections []Selection
+	// Params filter, sort and limit the records of an inverse field.
+	Params *ListParams
+}
+
+// Key returns the key of the selected field in the result.
+func (s Selection) Key() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	return s.FieldName
 }
 
 type Record struct {
@@ -33,9 +119,16 @@ func (o *daoImpl) GetRecord(
 	selection []Selection,
 	collectionId int,
 ) ([]byte, error) {
-	recordQuery := o.buildRecordQuery(ctx, sq.Expr(`?`, id), selection, collectionId)
+	// the history of the record can be selected
+	if err := createHistoryTable(ctx, o.recordDb); err != nil {
+		return nil, err
+	}
+	recordQuery, err := o.buildRecordQuery(ctx, sq.Expr(`?`, id), selection, collectionId, 0)
+	if err != nil {
+		return nil, err
+	}
 	var json []byte
-	err := recordQuery.RunWith(o.recordDb).QueryRowContext(ctx).Scan(&json)
+	err = recordQuery.RunWith(o.recordDb).QueryRowContext(ctx).Scan(&json)
 	return json, err
 }
 
@@ -44,36 +137,240 @@ func (o *daoImpl) buildRecordQuery(
 	id sq.Sqlizer,
 	selection []Selection,
 	collectionId int,
-) sq.SelectBuilder {
+	depth int,
+) (sq.SelectBuilder, error) {
 	collection, err := o.FindCollectionById(ctx, collectionId)
 	if err != nil {
-		// should have already validated
-		panic(err)
+		return sq.SelectBuilder{}, err
+	}
+	alias := tableAlias(depth)
+	object, err := o.buildJsonObject(ctx, collection, alias, selection, depth)
+	if err != nil {
+		return sq.SelectBuilder{}, err
 	}
-	builder := sq.Select().From(collection.Name).Where(sq.Expr(`id = ?`, id))
+	return sq.Select().
+		Column(object).
+		From(collection.Name + ` AS ` + alias).
+		Where(sq.Expr(alias+`.id = ?`, id)), nil
+}
+
+// buildJsonObject builds a json_object expression for the selected fields of
+// the row aliased by alias. Object fields are resolved with a correlated
+// subquery against the referenced collection.
+func (o *daoImpl) buildJsonObject(
+	ctx context.Context,
+	collection *Collection,
+	alias string,
+	selection []Selection,
+	depth int,
+) (sq.Sqlizer, error) {
 	objectArgs := sq.Expr(``)
 	for i, s := range selection {
 		if i > 0 {
 			objectArgs = sq.ConcatExpr(objectArgs, `, `)
 		}
-		objectArgs = sq.ConcatExpr(objectArgs, sq.Expr(`?, `, s.FieldName))
-		if len(s.Subselections) > 0 {
-
-			objectArgs = sq.ConcatExpr(
-				objectArgs,
-				`(`,
-				o.buildRecordQuery(
-					ctx,
-					sq.Expr(s.FieldName),
-					s.Subselections,
-					collection.Fields[s.FieldName].Ref,
-				),
-				`)`,
-			)
-		} else {
-			objectArgs = sq.ConcatExpr(objectArgs, s.FieldName)
+		objectArgs = sq.ConcatExpr(objectArgs, sq.Expr(`?, `, s.Key()))
+		column := alias + `.` + s.FieldName
+		field, isField := collection.Fields[s.FieldName]
+		switch {
+		case s.FieldName == TypenameField:
+			objectArgs = sq.ConcatExpr(objectArgs, sq.Expr(`?`, collection.Name))
+		case s.FieldName == HistoryField && !isField:
+			historyQuery, err := o.buildHistoryQuery(ctx, collection, sq.Expr(alias+`.`+IdField), historyFirst(s), s.Subselections, depth)
+			if err != nil {
+				return nil, err
+			}
+			objectArgs = sq.ConcatExpr(objectArgs, historyQuery)
+		case !field.IsStored():
+			inverseQuery, err := o.buildInverseFieldQuery(ctx, field, alias, s, depth)
+			if err != nil {
+				return nil, err
+			}
+			objectArgs = sq.ConcatExpr(objectArgs, inverseQuery)
+		case field.IsList:
+			listQuery, err := o.buildListFieldQuery(ctx, collection, field, alias, s.Subselections, depth)
+			if err != nil {
+				return nil, err
+			}
+			objectArgs = sq.ConcatExpr(objectArgs, listQuery)
+		case field.Ref > 0 && len(s.Subselections) > 0:
+			recordQuery, err := o.buildRecordQuery(ctx, sq.Expr(column), s.Subselections, field.Ref, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			// json() keeps the subquery result from being embedded as a string
+			objectArgs = sq.ConcatExpr(objectArgs, `json((`, recordQuery, `))`)
+		default:
+			objectArgs = sq.ConcatExpr(objectArgs, column)
+		}
+	}
+	return sq.ConcatExpr(`json_object(`, objectArgs, `)`), nil
+}
+
+// buildListFieldQuery aggregates the elements of a list field in position
+// order. Elements of object lists are record ids that are resolved the same
+// way as a single object field.
+func (o *daoImpl) buildListFieldQuery(
+	ctx context.Context,
+	collection *Collection,
+	field CollectionField,
+	alias string,
+	subselections []Selection,
+	depth int,
+) (sq.Sqlizer, error) {
+	elementAlias := elementAlias(depth)
+	var element sq.Sqlizer = sq.Expr(elementAlias + `.value`)
+	if field.Ref > 0 && len(subselections) > 0 {
+		recordQuery, err := o.buildRecordQuery(ctx, sq.Expr(elementAlias+`.value`), subselections, field.Ref, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		element = sq.ConcatExpr(`json((`, recordQuery, `))`)
+	}
+	return sq.ConcatExpr(
+		`json((SELECT json_group_array(`,
+		element,
+		`) FROM (SELECT value FROM `+listTableName(collection.Name, field.Name)+
+			` WHERE owner = `+alias+`.id ORDER BY position) AS `+elementAlias+`))`,
+	), nil
+}
+
+// buildInverseFieldQuery aggregates the records of the Ref collection whose
+// InverseOf field points at the row aliased by alias. The records are
+// filtered, sorted and limited by the params of the selection like a page of
+// ListRecords, but without cursors.
+func (o *daoImpl) buildInverseFieldQuery(
+	ctx context.Context,
+	field CollectionField,
+	alias string,
+	selection Selection,
+	depth int,
+) (sq.Sqlizer, error) {
+	refCollection, err := o.FindCollectionById(ctx, field.Ref)
+	if err != nil {
+		return nil, err
+	}
+	params := ListParams{}
+	if selection.Params != nil {
+		params = *selection.Params
+	}
+	if params.After != "" {
+		return nil, fmt.Errorf("inverse field %s cannot be paginated with a cursor", field.Name)
+	}
+	refAlias := tableAlias(depth + 1)
+	where := sq.And{sq.Expr(refAlias + `.` + field.InverseOf + ` = ` + alias + `.id`)}
+	if params.Where != nil {
+		filter, err := params.Where.toSql(refCollection, refAlias)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, filter)
+	}
+	orderClauses := []string{}
+	for _, order := range params.OrderBy {
+		if err := checkScalarField(refCollection, order.Field); err != nil {
+			return nil, err
+		}
+		orderClause := refAlias + `.` + order.Field
+		if order.Descending {
+			orderClause += ` DESC`
+		}
+		orderClauses = append(orderClauses, orderClause)
+	}
+	orderClauses = append(orderClauses, refAlias+`.`+IdField)
+	pageSize := params.First
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+
+	object, err := o.buildJsonObject(ctx, refCollection, refAlias, selection.Subselections, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	records := sq.Select(`*`).
+		From(refCollection.Name + ` AS ` + refAlias).
+		Where(where).
+		OrderBy(strings.Join(orderClauses, `, `)).
+		Limit(uint64(pageSize))
+	return sq.ConcatExpr(
+		`json((SELECT json_group_array(json(`,
+		object,
+		`)) FROM (`,
+		records,
+		`) AS `+refAlias+`))`,
+	), nil
+}
+
+func tableAlias(depth int) string {
+	return `t` + strconv.Itoa(depth)
+}
+
+func elementAlias(depth int) string {
+	return `e` + strconv.Itoa(depth)
+}
+
+// ReferencedBlobs implements RecordDao.
+func (o *daoImpl) ReferencedBlobs(ctx context.Context) (map[string]bool, error) {
+	collections, err := o.ListCollections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := createHistoryTable(ctx, o.recordDb); err != nil {
+		return nil, err
+	}
+	digests := map[string]bool{}
+	for _, collection := range collections {
+		for _, field := range collection.Fields {
+			if field.Type != BlobType || !field.IsStored() {
+				continue
+			}
+			query := sq.Select(`DISTINCT ` + field.Name).
+				From(collection.Name).
+				Where(sq.NotEq{field.Name: nil})
+			// versions that GetRecordAsOf can still read refer to blobs too
+			path := `$.` + field.Name
+			versions := sq.Select().
+				Column(sq.Expr(`DISTINCT json_extract(data, ?)`, path)).
+				From(historyTable).
+				Where(sq.Eq{"collection_id": collection.Id}).
+				Where(`json_extract(data, ?) IS NOT NULL`, path)
+			if field.IsList {
+				query = sq.Select(`DISTINCT value`).
+					From(listTableName(collection.Name, field.Name)).
+					Where(sq.NotEq{"value": nil})
+				versions = sq.Select(`DISTINCT element.value`).
+					From(historyTable).
+					JoinClause(`JOIN json_each(`+historyTable+`.data, ?) AS element`, path).
+					Where(sq.Eq{"collection_id": collection.Id}).
+					Where(`element.value IS NOT NULL`)
+			}
+			if err := o.collectDigests(ctx, query, digests); err != nil {
+				return nil, err
+			}
+			if err := o.collectDigests(ctx, versions, digests); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return digests, nil
+}
+
+// collectDigests adds the digests selected by query to digests.
+func (o *daoImpl) collectDigests(ctx context.Context, query sq.SelectBuilder, digests map[string]bool) error {
+	rows, err := query.RunWith(o.recordDb).QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var digest string
+		if err := rows.Scan(&digest); err != nil {
+			return err
 		}
+		digests[digest] = true
 	}
-	jsonObject := sq.ConcatExpr(`json_object(`, objectArgs, `)`)
-	return builder.Column(jsonObject)
+	return rows.Err()
 }
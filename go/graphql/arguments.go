@@ -0,0 +1,439 @@
+package graphql
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/sashankg/hold/dao"
+)
+
+const (
+	cWhereArg   = "where"
+	cOrderByArg = "orderBy"
+	cFirstArg   = "first"
+	cAfterArg   = "after"
+	cIdArg      = "id"
+	cInputArg   = "input"
+	cAsOfArg    = "asOf"
+	cQueryArg   = "query"
+
+	cAndFilter = "and"
+	cOrFilter  = "or"
+)
+
+// getListParams translates the arguments of a listX root field into
+// dao.ListParams, checking field names against the collection.
+func getListParams(field *ast.Field, collection *dao.Collection) (*dao.ListParams, error) {
+	params := &dao.ListParams{}
+	for _, arg := range field.Arguments {
+		switch arg.Name.Value {
+		case cWhereArg:
+			where, err := getFilter(arg.Value, collection)
+			if err != nil {
+				return nil, err
+			}
+			params.Where = where
+		case cOrderByArg:
+			orderBy, err := getOrderBy(arg.Value, collection)
+			if err != nil {
+				return nil, err
+			}
+			params.OrderBy = orderBy
+		case cFirstArg:
+			first, err := getFirst(arg)
+			if err != nil {
+				return nil, err
+			}
+			params.First = first
+		case cAfterArg:
+			after, err := getAfter(arg)
+			if err != nil {
+				return nil, err
+			}
+			params.After = after
+		default:
+			return nil, NewInvalidSchemaError("invalid argument: "+arg.Name.Value, arg.Loc)
+		}
+	}
+	return params, nil
+}
+
+// getSearchParams translates the arguments of a searchX root field into
+// dao.SearchParams.
+func getSearchParams(field *ast.Field) (*dao.SearchParams, error) {
+	params := &dao.SearchParams{}
+	hasQuery := false
+	for _, arg := range field.Arguments {
+		switch arg.Name.Value {
+		case cQueryArg:
+			value, ok := arg.Value.(*ast.StringValue)
+			if !ok {
+				return nil, NewInvalidSchemaError("query arg needs to be a string", arg.Loc)
+			}
+			params.Query = value.Value
+			hasQuery = true
+		case cFirstArg:
+			first, err := getFirst(arg)
+			if err != nil {
+				return nil, err
+			}
+			params.First = first
+		case cAfterArg:
+			after, err := getAfter(arg)
+			if err != nil {
+				return nil, err
+			}
+			params.After = after
+		default:
+			return nil, NewInvalidSchemaError("invalid argument: "+arg.Name.Value, arg.Loc)
+		}
+	}
+	if !hasQuery {
+		return nil, NewInvalidSchemaError("no query arg", field.Loc)
+	}
+	return params, nil
+}
+
+func getFirst(arg *ast.Argument) (int, error) {
+	value, ok := arg.Value.(*ast.IntValue)
+	if !ok {
+		return 0, NewInvalidSchemaError("first arg needs to be int", arg.Loc)
+	}
+	first, err := strconv.Atoi(value.Value)
+	if err != nil || first < 0 {
+		return 0, NewInvalidSchemaError("first arg needs to be a non-negative int", arg.Loc)
+	}
+	return first, nil
+}
+
+func getAfter(arg *ast.Argument) (string, error) {
+	value, ok := arg.Value.(*ast.StringValue)
+	if !ok {
+		return "", NewInvalidSchemaError("after arg needs to be a cursor string", arg.Loc)
+	}
+	return value.Value, nil
+}
+
+// getAsOf reads the asOf argument of a findX root field, which is an
+// RFC 3339 time, or returns nil if there is none.
+func getAsOf(field *ast.Field) (*time.Time, error) {
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != cAsOfArg {
+			continue
+		}
+		value, ok := arg.Value.(*ast.StringValue)
+		if !ok {
+			return nil, NewInvalidSchemaError("asOf arg needs to be a time string", arg.Loc)
+		}
+		asOf, err := time.Parse(time.RFC3339Nano, value.Value)
+		if err != nil {
+			return nil, NewInvalidSchemaError("asOf arg needs to be an RFC 3339 time", arg.Loc)
+		}
+		return &asOf, nil
+	}
+	return nil, nil
+}
+
+// getHistoryFirst reads the first argument of a historyX root field, which
+// is 0 if there is none.
+func getHistoryFirst(field *ast.Field) (int, error) {
+	first := 0
+	for _, arg := range field.Arguments {
+		switch arg.Name.Value {
+		case cIdArg:
+		case cFirstArg:
+			var err error
+			first, err = getFirst(arg)
+			if err != nil {
+				return 0, err
+			}
+		default:
+			return 0, NewInvalidSchemaError("invalid argument: "+arg.Name.Value, arg.Loc)
+		}
+	}
+	return first, nil
+}
+
+// getFilter reads a where object of the form
+//
+//	{ title: { eq: "hello" }, or: [{ views: { gt: 10 } }, { views: { isNull: true } }] }
+func getFilter(value ast.Value, collection *dao.Collection) (*dao.Filter, error) {
+	object, ok := value.(*ast.ObjectValue)
+	if !ok {
+		return nil, NewInvalidSchemaError("where arg needs to be an object", value.GetLoc())
+	}
+	filter := &dao.Filter{}
+	for _, objectField := range object.Fields {
+		// a null filter is the same as leaving it out
+		if _, isNull := objectField.Value.(*nullValue); isNull {
+			continue
+		}
+		switch objectField.Name.Value {
+		case cAndFilter, cOrFilter:
+			list, ok := objectField.Value.(*ast.ListValue)
+			if !ok {
+				return nil, NewInvalidSchemaError(
+					objectField.Name.Value+" filter needs to be a list",
+					objectField.Loc,
+				)
+			}
+			for _, item := range list.Values {
+				nested, err := getFilter(item, collection)
+				if err != nil {
+					return nil, err
+				}
+				if objectField.Name.Value == cAndFilter {
+					filter.And = append(filter.And, *nested)
+				} else {
+					filter.Or = append(filter.Or, *nested)
+				}
+			}
+		default:
+			conditions, err := getConditions(objectField, collection)
+			if err != nil {
+				return nil, err
+			}
+			filter.Conditions = append(filter.Conditions, conditions...)
+		}
+	}
+	return filter, nil
+}
+
+func getConditions(objectField *ast.ObjectField, collection *dao.Collection) ([]dao.Condition, error) {
+	fieldName := objectField.Name.Value
+	if err := checkFilterableField(fieldName, collection, objectField.Loc); err != nil {
+		return nil, err
+	}
+	operators, ok := objectField.Value.(*ast.ObjectValue)
+	if !ok {
+		return nil, NewInvalidSchemaError("filter on "+fieldName+" needs to be an object", objectField.Loc)
+	}
+	conditions := []dao.Condition{}
+	for _, operator := range operators.Fields {
+		switch operator.Name.Value {
+		case dao.OperatorEq,
+			dao.OperatorNe,
+			dao.OperatorGt,
+			dao.OperatorGte,
+			dao.OperatorLt,
+			dao.OperatorLte,
+			dao.OperatorLike,
+			dao.OperatorIsNull:
+		case dao.OperatorIn:
+			if _, ok := operator.Value.(*ast.ListValue); !ok {
+				return nil, NewInvalidSchemaError("in operator needs a list", operator.Loc)
+			}
+		default:
+			return nil, NewInvalidSchemaError("invalid operator: "+operator.Name.Value, operator.Loc)
+		}
+		value, err := valueToGo(operator.Value)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, dao.Condition{
+			Field:    fieldName,
+			Operator: operator.Name.Value,
+			Value:    value,
+		})
+	}
+	return conditions, nil
+}
+
+// getOrderBy accepts either { title: ASC } or [{ title: ASC }, { id: DESC }].
+func getOrderBy(value ast.Value, collection *dao.Collection) ([]dao.Order, error) {
+	switch value := value.(type) {
+	case *ast.ListValue:
+		orderBy := []dao.Order{}
+		for _, item := range value.Values {
+			orders, err := getOrderBy(item, collection)
+			if err != nil {
+				return nil, err
+			}
+			orderBy = append(orderBy, orders...)
+		}
+		return orderBy, nil
+	case *ast.ObjectValue:
+		orderBy := []dao.Order{}
+		for _, objectField := range value.Fields {
+			if err := checkFilterableField(objectField.Name.Value, collection, objectField.Loc); err != nil {
+				return nil, err
+			}
+			// directions from variables are strings, as JSON has no enums
+			var direction string
+			switch value := objectField.Value.(type) {
+			case *ast.EnumValue:
+				direction = value.Value
+			case *ast.StringValue:
+				direction = value.Value
+			}
+			if direction != "ASC" && direction != "DESC" {
+				return nil, NewInvalidSchemaError("order direction needs to be ASC or DESC", objectField.Loc)
+			}
+			orderBy = append(orderBy, dao.Order{
+				Field:      objectField.Name.Value,
+				Descending: direction == "DESC",
+			})
+		}
+		return orderBy, nil
+	}
+	return nil, NewInvalidSchemaError("orderBy arg needs to be an object or list", value.GetLoc())
+}
+
+// getInverseParams reads the arguments of an inverse field, which are the
+// arguments of a listX root field of its collection without a cursor.
+func getInverseParams(field *ast.Field, refCollection *dao.Collection) (*dao.ListParams, error) {
+	for _, arg := range field.Arguments {
+		if arg.Name.Value == cAfterArg {
+			return nil, NewInvalidSchemaError("after arg is not supported on inverse fields", arg.Loc)
+		}
+	}
+	return getListParams(field, refCollection)
+}
+
+func checkFilterableField(fieldName string, collection *dao.Collection, loc *ast.Location) error {
+	if fieldName == dao.IdField {
+		return nil
+	}
+	field, ok := collection.Fields[fieldName]
+	if !ok {
+		return NewInvalidSchemaError("invalid field: "+fieldName, loc)
+	}
+	if field.IsList {
+		return NewInvalidSchemaError("list field cannot be filtered or sorted: "+fieldName, loc)
+	}
+	return nil
+}
+
+// getRecordInput reads the input object of a setX or patchX root field and
+// checks every value against the type of its collection field. Object fields
+// take the id of the referenced record.
+func getRecordInput(field *ast.Field, collection *dao.Collection) (map[string]any, error) {
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != cInputArg {
+			continue
+		}
+		object, ok := arg.Value.(*ast.ObjectValue)
+		if !ok {
+			return nil, NewInvalidSchemaError("input arg needs to be an object", arg.Loc)
+		}
+		values := map[string]any{}
+		for _, objectField := range object.Fields {
+			fieldName := objectField.Name.Value
+			value, err := valueToGo(objectField.Value)
+			if err != nil {
+				return nil, err
+			}
+			if fieldName == dao.IdField {
+				if _, ok := value.(int64); !ok {
+					return nil, NewInvalidSchemaError("id needs to be int", objectField.Loc)
+				}
+				values[fieldName] = value
+				continue
+			}
+			collectionField, ok := collection.Fields[fieldName]
+			if !ok {
+				return nil, NewInvalidSchemaError("invalid field: "+fieldName, objectField.Loc)
+			}
+			if !collectionField.IsStored() {
+				return nil, NewInvalidSchemaError("inverse field cannot be written: "+fieldName, objectField.Loc)
+			}
+			if value == nil && !collectionField.IsList {
+				if collectionField.NonNull {
+					return nil, NewInvalidSchemaError("non-null field cannot be null: "+fieldName, objectField.Loc)
+				}
+				values[fieldName] = nil
+				continue
+			}
+			if collectionField.IsList {
+				items, ok := value.([]any)
+				if !ok {
+					return nil, NewInvalidSchemaError("list field needs a list value: "+fieldName, objectField.Loc)
+				}
+				for _, item := range items {
+					if !isValidInputValue(collectionField, item) {
+						return nil, NewInvalidSchemaError(
+							"invalid value in "+fieldName+" of type ["+collectionField.Type+"]",
+							objectField.Loc,
+						)
+					}
+				}
+				values[fieldName] = items
+				continue
+			}
+			if !isValidInputValue(collectionField, value) {
+				return nil, NewInvalidSchemaError(
+					"invalid value for "+fieldName+" of type "+collectionField.Type,
+					objectField.Loc,
+				)
+			}
+			values[fieldName] = value
+		}
+		if rootFieldOperation(field) == cSetOperation {
+			for _, collectionField := range collection.Fields {
+				if _, ok := values[collectionField.Name]; !ok && collectionField.NonNull && !collectionField.IsList {
+					return nil, NewInvalidSchemaError("missing value for non-null field: "+collectionField.Name, object.Loc)
+				}
+			}
+		}
+		return values, nil
+	}
+	return nil, NewInvalidSchemaError("no input arg", field.Loc)
+}
+
+func isValidInputValue(field dao.CollectionField, value any) bool {
+	switch field.Type {
+	case "Int":
+		_, ok := value.(int64)
+		return ok
+	case "Float":
+		switch value.(type) {
+		case int64, float64:
+			return true
+		}
+		return false
+	case "String", dao.BlobType:
+		_, ok := value.(string)
+		return ok
+	case "Boolean":
+		_, ok := value.(bool)
+		return ok
+	case "ID":
+		switch value.(type) {
+		case int64, string:
+			return true
+		}
+		return false
+	}
+	// object fields reference a record id
+	_, ok := value.(int64)
+	return ok
+}
+
+func valueToGo(value ast.Value) (any, error) {
+	switch value := value.(type) {
+	case *ast.IntValue:
+		return strconv.ParseInt(value.Value, 10, 64)
+	case *ast.FloatValue:
+		return strconv.ParseFloat(value.Value, 64)
+	case *ast.StringValue:
+		return value.Value, nil
+	case *ast.BooleanValue:
+		return value.Value, nil
+	case *ast.EnumValue:
+		return value.Value, nil
+	case *nullValue:
+		return nil, nil
+	case *ast.ListValue:
+		values := make([]any, len(value.Values))
+		for i, item := range value.Values {
+			goValue, err := valueToGo(item)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = goValue
+		}
+		return values, nil
+	}
+	return nil, NewInvalidSchemaError("unsupported value", value.GetLoc())
+}
@@ -0,0 +1,135 @@
+package graphql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/sashankg/hold/blobs"
+	"github.com/sashankg/hold/dao"
+)
+
+// Blob fields are stored as the digest of a blob, and resolve to an object
+// with these fields.
+const (
+	cBlobDigestField   = "digest"
+	cBlobSizeField     = "size"
+	cBlobMimeTypeField = "mimeType"
+	cBlobUrlField      = "url"
+)
+
+// blobUrlPrefix is where handlers.BlobHandler serves blobs.
+const blobUrlPrefix = "/blob/"
+
+var blobFields = map[string]bool{
+	cBlobDigestField:   true,
+	cBlobSizeField:     true,
+	cBlobMimeTypeField: true,
+	cBlobUrlField:      true,
+}
+
+// validateBlobSelections checks the selection set of a Blob field.
+func validateBlobSelections(sel *ast.Field) error {
+	if sel.SelectionSet == nil {
+		return NewInvalidSchemaError("need a selection set for blob fields: "+sel.Name.Value, sel.Loc)
+	}
+	for _, blobSel := range sel.SelectionSet.Selections {
+		blobSel, ok := blobSel.(*ast.Field)
+		if !ok {
+			continue
+		}
+		if !blobFields[blobSel.Name.Value] || blobSel.SelectionSet != nil {
+			return NewInvalidSchemaError("invalid blob field: "+blobSel.Name.Value, blobSel.Loc)
+		}
+	}
+	return nil
+}
+
+func getBlobSelection(selectionSet *ast.SelectionSet) []dao.Selection {
+	selection := []dao.Selection{}
+	for _, s := range selectionSet.Selections {
+		if s, ok := s.(*ast.Field); ok {
+			selection = append(selection, dao.Selection{
+				Alias:     aliasValue(s),
+				FieldName: s.Name.Value,
+			})
+		}
+	}
+	return selection
+}
+
+// checkBlobValues checks that the blobs written to Blob fields exist.
+func checkBlobValues(
+	ctx context.Context,
+	store blobs.Store,
+	collection *dao.Collection,
+	values map[string]any,
+	loc *ast.Location,
+) error {
+	for fieldName, value := range values {
+		if collection.Fields[fieldName].Type != dao.BlobType || value == nil {
+			continue
+		}
+		digests, ok := value.([]any)
+		if !ok {
+			digests = []any{value}
+		}
+		for _, digest := range digests {
+			digest, _ := digest.(string)
+			_, err := store.Stat(ctx, digest)
+			if errors.Is(err, sql.ErrNoRows) || errors.Is(err, blobs.ErrInvalidDigest) {
+				return NewInvalidSchemaError("blob not found for "+fieldName+": "+digest, loc)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// completeBlob replaces the digest in a Blob field with the selected fields
+// of the blob. It returns null if the blob does not exist.
+func (c *nullCompleter) completeBlob(
+	raw json.RawMessage,
+	selection []dao.Selection,
+	path []any,
+) (json.RawMessage, error) {
+	var digest string
+	if err := json.Unmarshal(raw, &digest); err != nil {
+		return nil, err
+	}
+	blob, err := c.blobs.Stat(c.ctx, digest)
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, blobs.ErrInvalidDigest) {
+		c.errors = append(c.errors, &Error{
+			Message:    "blob not found: " + digest,
+			Path:       path,
+			Extensions: ErrorExtensions{Code: ErrorCodeNotFound},
+		})
+		return jsonNull, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	for _, s := range selection {
+		var value any
+		switch s.FieldName {
+		case cBlobDigestField:
+			value = blob.Digest
+		case cBlobSizeField:
+			value = blob.Size
+		case cBlobMimeTypeField:
+			value = blob.MimeType
+		case cBlobUrlField:
+			value = blobUrlPrefix + blob.Digest
+		}
+		fields[s.Key()], err = json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return writeObject(selection, fields)
+}
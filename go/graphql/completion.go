@@ -0,0 +1,261 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/sashankg/hold/blobs"
+	"github.com/sashankg/hold/dao"
+)
+
+var jsonNull = json.RawMessage(`null`)
+
+// nullCompleter applies the null propagation rules of the GraphQL spec to
+// records that come back from the dao: a non-null field that is null is a
+// field error, and makes its parent object null instead.
+//
+// Blob fields are completed here as well, because the blobs are not in the
+// database of the records.
+type nullCompleter struct {
+	*collectionCache
+	blobs  blobs.Store
+	errors Errors
+}
+
+func newNullCompleter(collections *collectionCache, blobStore blobs.Store) *nullCompleter {
+	return &nullCompleter{
+		collectionCache: collections,
+		blobs:           blobStore,
+	}
+}
+
+func (c *nullCompleter) completeRootField(
+	field *ast.Field,
+	selection []dao.Selection,
+	collection *dao.Collection,
+	raw json.RawMessage,
+) (json.RawMessage, error) {
+	path := []any{responseKey(field)}
+	switch operation := rootFieldOperation(field); {
+	case isConnectionOperation(operation):
+		return c.completeConnection(raw, selection, collection, path)
+	case operation == cHistoryOperation:
+		return c.completeHistory(raw, selection, collection, path)
+	default:
+		return c.completeObject(raw, selection, collection, path)
+	}
+}
+
+// completeHistory completes the node of every version in a historyX result
+// or a history field.
+func (c *nullCompleter) completeHistory(
+	raw json.RawMessage,
+	selection []dao.Selection,
+	collection *dao.Collection,
+	path []any,
+) (json.RawMessage, error) {
+	var versions []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &versions); err != nil {
+		return nil, err
+	}
+	completedVersions := make([]json.RawMessage, len(versions))
+	for i, version := range versions {
+		for _, s := range selection {
+			if s.FieldName != dao.HistoryNode {
+				continue
+			}
+			node, err := c.completeObject(version[s.Key()], s.Subselections, collection, appendPath(path, i, s.Key()))
+			if err != nil {
+				return nil, err
+			}
+			version[s.Key()] = node
+		}
+		completedVersion, err := writeObject(selection, version)
+		if err != nil {
+			return nil, err
+		}
+		completedVersions[i] = completedVersion
+	}
+	return json.Marshal(completedVersions)
+}
+
+// completeConnection completes the node of every edge in a listX or searchX
+// result.
+func (c *nullCompleter) completeConnection(
+	raw json.RawMessage,
+	selection []dao.Selection,
+	collection *dao.Collection,
+	path []any,
+) (json.RawMessage, error) {
+	if isJsonNull(raw) {
+		return raw, nil
+	}
+	var connection map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &connection); err != nil {
+		return nil, err
+	}
+	for _, s := range selection {
+		if s.FieldName != dao.ConnectionEdges {
+			continue
+		}
+		var edges []map[string]json.RawMessage
+		if err := json.Unmarshal(connection[s.Key()], &edges); err != nil {
+			return nil, err
+		}
+		for i, edge := range edges {
+			for _, e := range s.Subselections {
+				if e.FieldName != dao.EdgeNode {
+					continue
+				}
+				node, err := c.completeObject(
+					edge[e.Key()],
+					e.Subselections,
+					collection,
+					appendPath(path, s.Key(), i, e.Key()),
+				)
+				if err != nil {
+					return nil, err
+				}
+				edge[e.Key()] = node
+			}
+		}
+		completedEdges := make([]json.RawMessage, len(edges))
+		for i, edge := range edges {
+			completedEdge, err := writeObject(s.Subselections, edge)
+			if err != nil {
+				return nil, err
+			}
+			completedEdges[i] = completedEdge
+		}
+		edgesJson, err := json.Marshal(completedEdges)
+		if err != nil {
+			return nil, err
+		}
+		connection[s.Key()] = edgesJson
+	}
+	return writeObject(selection, connection)
+}
+
+// completeObject returns the object with its fields in selection order, or
+// null if one of its non-null fields is null.
+func (c *nullCompleter) completeObject(
+	raw json.RawMessage,
+	selection []dao.Selection,
+	collection *dao.Collection,
+	path []any,
+) (json.RawMessage, error) {
+	if isJsonNull(raw) {
+		return jsonNull, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	for _, s := range selection {
+		value := fields[s.Key()]
+		fieldPath := appendPath(path, s.Key())
+		if isHistoryField(collection, s.FieldName) {
+			history, err := c.completeHistory(value, s.Subselections, collection, fieldPath)
+			if err != nil {
+				return nil, err
+			}
+			fields[s.Key()] = history
+			continue
+		}
+		field, nonNull := collection.Fields[s.FieldName], s.FieldName == dao.IdField || s.FieldName == dao.TypenameField
+		nonNull = nonNull || (field.NonNull && !field.IsList)
+
+		if isJsonNull(value) {
+			if nonNull {
+				c.errors = append(c.errors, &Error{
+					Message:    "non-null field " + s.FieldName + " of " + collection.Name + " is null",
+					Path:       fieldPath,
+					Extensions: ErrorExtensions{Code: ErrorCodeInternal},
+				})
+				return jsonNull, nil
+			}
+			fields[s.Key()] = jsonNull
+			continue
+		}
+		if len(s.Subselections) == 0 {
+			continue
+		}
+
+		complete := func(item json.RawMessage, itemPath []any) (json.RawMessage, error) {
+			if field.Type == dao.BlobType {
+				return c.completeBlob(item, s.Subselections, itemPath)
+			}
+			refCollection, err := c.get(field.Ref)
+			if err != nil {
+				return nil, err
+			}
+			return c.completeObject(item, s.Subselections, refCollection, itemPath)
+		}
+		var err error
+		if field.IsList {
+			var items []json.RawMessage
+			if err := json.Unmarshal(value, &items); err != nil {
+				return nil, err
+			}
+			for i, item := range items {
+				if isJsonNull(item) {
+					continue
+				}
+				items[i], err = complete(item, appendPath(fieldPath, i))
+				if err != nil {
+					return nil, err
+				}
+			}
+			value, err = json.Marshal(items)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			value, err = complete(value, fieldPath)
+			if err != nil {
+				return nil, err
+			}
+			// the error was already reported for the nested field
+			if nonNull && isJsonNull(value) {
+				return jsonNull, nil
+			}
+		}
+		fields[s.Key()] = value
+	}
+	return writeObject(selection, fields)
+}
+
+// writeObject encodes fields in selection order, which encoding/json would
+// otherwise sort by key.
+func writeObject(selection []dao.Selection, fields map[string]json.RawMessage) (json.RawMessage, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, s := range selection {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(s.Key())
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		value := fields[s.Key()]
+		if len(value) == 0 {
+			value = jsonNull
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func isJsonNull(raw json.RawMessage) bool {
+	trimmed := bytes.TrimSpace(raw)
+	return len(trimmed) == 0 || bytes.Equal(trimmed, jsonNull)
+}
+
+func appendPath(path []any, elems ...any) []any {
+	return append(path[:len(path):len(path)], elems...)
+}
@@ -0,0 +1,99 @@
+package graphql
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/location"
+)
+
+// Codes in the extensions of an Error.
+const (
+	ErrorCodeParseFailed      = "GRAPHQL_PARSE_FAILED"
+	ErrorCodeValidationFailed = "GRAPHQL_VALIDATION_FAILED"
+	ErrorCodeBadUserInput     = "BAD_USER_INPUT"
+	ErrorCodeNotFound         = "NOT_FOUND"
+	ErrorCodeForbidden        = "FORBIDDEN"
+	ErrorCodeUnauthenticated  = "UNAUTHENTICATED"
+	ErrorCodeInternal         = "INTERNAL_SERVER_ERROR"
+)
+
+// Error is an entry of the errors list of a GraphQL response. Errors in a
+// field have the path of the field, and the rest of the response is still
+// returned with null in place of the field.
+type Error struct {
+	Message    string                    `json:"message"`
+	Locations  []location.SourceLocation `json:"locations,omitempty"`
+	Path       []any                     `json:"path,omitempty"`
+	Extensions ErrorExtensions           `json:"extensions"`
+}
+
+type ErrorExtensions struct {
+	Code string `json:"code"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errors are returned by Resolve together with the partial data.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// FormatError turns an error from parsing, preparing or validating a
+// document into an Error. Errors that are not caused by the request are
+// internal errors, and their message is not exposed.
+func FormatError(err error) *Error {
+	var responseErr *Error
+	if errors.As(err, &responseErr) {
+		return responseErr
+	}
+	var schemaErr *InvalidSchemaError
+	if errors.As(err, &schemaErr) {
+		return &Error{
+			Message:    schemaErr.reason,
+			Locations:  schemaErr.Locations(),
+			Extensions: ErrorExtensions{Code: ErrorCodeValidationFailed},
+		}
+	}
+	var syntaxErr *gqlerrors.Error
+	if errors.As(err, &syntaxErr) {
+		// the rest of the message highlights the location in the source
+		message, _, _ := strings.Cut(syntaxErr.Message, "\n")
+		return &Error{
+			Message:    message,
+			Locations:  syntaxErr.Locations,
+			Extensions: ErrorExtensions{Code: ErrorCodeParseFailed},
+		}
+	}
+	return &Error{
+		Message:    "internal server error",
+		Extensions: ErrorExtensions{Code: ErrorCodeInternal},
+	}
+}
+
+// NewForbiddenError is returned when the peer of a request lacks a
+// permission on a collection in the document.
+func NewForbiddenError(reason string, loc *ast.Location) *Error {
+	return &Error{
+		Message:    reason,
+		Locations:  sourceLocations(loc),
+		Extensions: ErrorExtensions{Code: ErrorCodeForbidden},
+	}
+}
+
+func sourceLocations(loc *ast.Location) []location.SourceLocation {
+	if loc == nil {
+		return nil
+	}
+	return []location.SourceLocation{location.GetLocation(loc.Source, loc.Start)}
+}
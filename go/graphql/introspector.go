@@ -0,0 +1,94 @@
+package graphql
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	gql "github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/sashankg/hold/dao"
+)
+
+// Introspector answers __schema, __type and __typename queries with a schema
+// generated from the registered collections.
+type Introspector interface {
+	SchemaListener
+	// Introspect executes a document prepared by PrepareOperation.
+	Introspect(ctx context.Context, doc *ast.Document) *gql.Result
+}
+
+// SchemaListener is notified by Registrar after it changes the collections.
+type SchemaListener interface {
+	SchemaChanged(ctx context.Context) error
+}
+
+type introspectorImpl struct {
+	dao    dao.CollectionDao
+	mutex  sync.RWMutex
+	schema *gql.Schema
+}
+
+var _ Introspector = (*introspectorImpl)(nil)
+
+func NewIntrospector(ctx context.Context, dao dao.CollectionDao) (*introspectorImpl, error) {
+	introspector := &introspectorImpl{
+		dao: dao,
+	}
+	if err := introspector.SchemaChanged(ctx); err != nil {
+		return nil, err
+	}
+	return introspector, nil
+}
+
+// SchemaChanged implements SchemaListener.
+func (i *introspectorImpl) SchemaChanged(ctx context.Context) error {
+	schema, err := loadSchema(ctx, i.dao)
+	if err != nil {
+		return err
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.schema = schema
+	return nil
+}
+
+// Introspect implements Introspector.
+func (i *introspectorImpl) Introspect(ctx context.Context, doc *ast.Document) *gql.Result {
+	i.mutex.RLock()
+	schema := i.schema
+	i.mutex.RUnlock()
+	if schema == nil {
+		return &gql.Result{
+			Errors: []gqlerrors.FormattedError{
+				gqlerrors.NewFormattedError("no collections have been registered"),
+			},
+		}
+	}
+	validation := gql.ValidateDocument(schema, doc, nil)
+	if !validation.IsValid {
+		return &gql.Result{Errors: validation.Errors}
+	}
+	return gql.Execute(gql.ExecuteParams{
+		Schema:  *schema,
+		AST:     doc,
+		Context: ctx,
+	})
+}
+
+// IsIntrospectionQuery reports whether every root field of the document is
+// an introspection field like __schema or __type.
+func IsIntrospectionQuery(doc *ast.Document) bool {
+	hasRootFields := false
+	err := iterateRootFields(doc, func(field *ast.Field) error {
+		hasRootFields = true
+		if !strings.HasPrefix(field.Name.Value, "__") {
+			return errNotIntrospection
+		}
+		return nil
+	})
+	return hasRootFields && err == nil
+}
+
+var errNotIntrospection = NewInvalidSchemaError("not an introspection query", nil)
@@ -0,0 +1,141 @@
+package graphql_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/sashankg/hold/graphql"
+	"github.com/sashankg/hold/testing/util"
+	"github.com/stretchr/testify/require"
+)
+
+func introspect(t *testing.T, introspector graphql.Introspector, query string) string {
+	doc, err := parseGraphql(query)
+	require.NoError(t, err)
+	require.True(t, graphql.IsIntrospectionQuery(doc))
+	result := introspector.Introspect(context.Background(), doc)
+	require.Empty(t, result.Errors)
+	data, err := json.Marshal(result.Data)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestIntrospect(t *testing.T) {
+	testDao := util.NewMemoryDao(t)
+	introspector, err := graphql.NewIntrospector(context.Background(), testDao)
+	require.NoError(t, err)
+	registrar := graphql.NewRegistrar(testDao, introspector)
+
+	doc, err := parseGraphql(`
+		type Post {
+			title: String!
+			author: Person
+			tags: [String]
+		}
+		type Person {
+			name: String @deprecated(reason: "use handle")
+		}
+	`)
+	require.NoError(t, err)
+	_, err = registrar.RegisterSchema(context.Background(), doc, graphql.RegisterOptions{})
+	require.NoError(t, err)
+
+	require.JSONEq(t, `{
+		"__type": {
+			"name": "Post",
+			"fields": [
+				{"name": "author", "type": {"kind": "OBJECT", "name": "Person", "ofType": null}},
+				{"name": "history", "type": {"kind": "NON_NULL", "name": null, "ofType": {"name": null}}},
+				{"name": "id", "type": {"kind": "NON_NULL", "name": null, "ofType": {"name": "Int"}}},
+				{"name": "tags", "type": {"kind": "LIST", "name": null, "ofType": {"name": "String"}}},
+				{"name": "title", "type": {"kind": "NON_NULL", "name": null, "ofType": {"name": "String"}}}
+			]
+		}
+	}`, introspect(t, introspector, `
+		{
+			__type(name: "Post") {
+				name
+				fields {
+					name
+					type {
+						kind
+						name
+						ofType {
+							name
+						}
+					}
+				}
+			}
+		}
+	`))
+
+	require.JSONEq(t, `{
+		"__type": {
+			"fields": [
+				{"name": "history", "isDeprecated": false, "deprecationReason": null},
+				{"name": "id", "isDeprecated": false, "deprecationReason": null},
+				{"name": "name", "isDeprecated": true, "deprecationReason": "use handle"}
+			]
+		}
+	}`, introspect(t, introspector, `
+		{
+			__type(name: "Person") {
+				fields(includeDeprecated: true) {
+					name
+					isDeprecated
+					deprecationReason
+				}
+			}
+		}
+	`))
+
+	require.JSONEq(t, `{
+		"__type": {
+			"fields": [
+				{"name": "findPerson", "type": {"name": "Person"}},
+				{"name": "findPost", "type": {"name": "Post"}},
+				{"name": "historyPerson", "type": {"name": null}},
+				{"name": "historyPost", "type": {"name": null}},
+				{"name": "listPerson", "type": {"name": null}},
+				{"name": "listPost", "type": {"name": null}}
+			]
+		}
+	}`, introspect(t, introspector, `
+		{
+			__type(name: "Query") {
+				fields {
+					name
+					type {
+						name
+					}
+				}
+			}
+		}
+	`))
+
+	// registering a new collection rebuilds the schema
+	doc, err = parseGraphql(`
+		type Comment {
+			body: String
+		}
+	`)
+	require.NoError(t, err)
+	_, err = registrar.RegisterSchema(context.Background(), doc, graphql.RegisterOptions{})
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"__schema": {"mutationType": {"name": "Mutation"}},
+		"__type": {"name": "CommentInput"}
+	}`, introspect(t, introspector, `
+		{
+			__schema {
+				mutationType {
+					name
+				}
+			}
+			__type(name: "CommentInput") {
+				name
+			}
+		}
+	`))
+}
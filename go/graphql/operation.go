@@ -0,0 +1,508 @@
+package graphql
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/printer"
+)
+
+const (
+	cSkipDirective    = "skip"
+	cIncludeDirective = "include"
+	cIfArg            = "if"
+)
+
+// nullValue is an explicit null. The parser has no null literal, so it only
+// comes from variables that are null and from null fields of input objects
+// in variables.
+type nullValue struct {
+	Loc *ast.Location
+}
+
+func (v *nullValue) GetKind() string {
+	return "NullValue"
+}
+
+func (v *nullValue) GetLoc() *ast.Location {
+	return v.Loc
+}
+
+func (v *nullValue) GetValue() interface{} {
+	return nil
+}
+
+// PrepareOperation returns a document with just the operation that should be
+// executed, in the form that Validator and Resolver expect: fragments are
+// inlined, variables are replaced with their values, fields skipped with
+// @skip or @include are left out and fields with the same response key are
+// merged.
+//
+// operationName may be empty if the document has a single operation.
+func PrepareOperation(
+	doc *ast.Document,
+	operationName string,
+	variables map[string]any,
+) (*ast.Document, error) {
+	var operation *ast.OperationDefinition
+	operationCount := 0
+	fragments := map[string]*ast.FragmentDefinition{}
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.OperationDefinition:
+			operationCount++
+			if operationName == "" || (def.Name != nil && def.Name.Value == operationName) {
+				operation = def
+			}
+		case *ast.FragmentDefinition:
+			fragments[def.Name.Value] = def
+		}
+	}
+	if operationName == "" && operationCount > 1 {
+		return nil, NewInvalidSchemaError("operationName is required for a document with several operations", nil)
+	}
+	if operation == nil {
+		return nil, NewInvalidSchemaError("no operation named "+operationName, nil)
+	}
+
+	values, err := getVariableValues(operation.VariableDefinitions, variables)
+	if err != nil {
+		return nil, err
+	}
+	p := &operationPreparer{
+		fragments: fragments,
+		variables: values,
+		declared:  map[string]bool{},
+		spreading: map[string]bool{},
+	}
+	for _, definition := range operation.VariableDefinitions {
+		p.declared[definition.Variable.Name.Value] = true
+	}
+	selectionSet, err := p.prepareSelectionSet(operation.SelectionSet)
+	if err != nil {
+		return nil, err
+	}
+	directives, err := p.substituteDirectives(operation.Directives)
+	if err != nil {
+		return nil, err
+	}
+	return ast.NewDocument(&ast.Document{
+		Loc: doc.Loc,
+		Definitions: []ast.Node{
+			ast.NewOperationDefinition(&ast.OperationDefinition{
+				Loc:          operation.Loc,
+				Operation:    operation.Operation,
+				Name:         operation.Name,
+				Directives:   directives,
+				SelectionSet: selectionSet,
+			}),
+		},
+	}), nil
+}
+
+// responseKey returns the key of a field in the response, which is its alias
+// if it has one.
+func responseKey(field *ast.Field) string {
+	if field.Alias != nil {
+		return field.Alias.Value
+	}
+	return field.Name.Value
+}
+
+func aliasValue(field *ast.Field) string {
+	if field.Alias != nil && field.Alias.Value != field.Name.Value {
+		return field.Alias.Value
+	}
+	return ""
+}
+
+type operationPreparer struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]ast.Value
+	declared  map[string]bool
+	// fragments that are being spread, to catch cycles
+	spreading map[string]bool
+}
+
+func (p *operationPreparer) prepareSelectionSet(selectionSet *ast.SelectionSet) (*ast.SelectionSet, error) {
+	if selectionSet == nil {
+		return nil, nil
+	}
+	fields, err := p.collectFields(selectionSet)
+	if err != nil {
+		return nil, err
+	}
+	fields, err = mergeFields(fields)
+	if err != nil {
+		return nil, err
+	}
+	selections := make([]ast.Selection, len(fields))
+	for i, field := range fields {
+		field.SelectionSet, err = p.prepareSelectionSet(field.SelectionSet)
+		if err != nil {
+			return nil, err
+		}
+		selections[i] = field
+	}
+	return ast.NewSelectionSet(&ast.SelectionSet{
+		Loc:        selectionSet.Loc,
+		Selections: selections,
+	}), nil
+}
+
+// collectFields flattens fragments into the fields they select. The fields
+// are copies with their variables substituted.
+func (p *operationPreparer) collectFields(selectionSet *ast.SelectionSet) ([]*ast.Field, error) {
+	fields := []*ast.Field{}
+	for _, selection := range selectionSet.Selections {
+		include, err := p.shouldInclude(selectionDirectives(selection))
+		if err != nil {
+			return nil, err
+		}
+		if !include {
+			continue
+		}
+		switch selection := selection.(type) {
+		case *ast.Field:
+			arguments, err := p.substituteArguments(selection.Arguments)
+			if err != nil {
+				return nil, err
+			}
+			directives, err := p.substituteDirectives(selection.Directives)
+			if err != nil {
+				return nil, err
+			}
+			field := *selection
+			field.Arguments = arguments
+			field.Directives = directives
+			fields = append(fields, &field)
+		case *ast.InlineFragment:
+			inlined, err := p.collectFields(selection.SelectionSet)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, inlined...)
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+			fragment, ok := p.fragments[name]
+			if !ok {
+				return nil, NewInvalidSchemaError("unknown fragment: "+name, selection.Loc)
+			}
+			if p.spreading[name] {
+				return nil, NewInvalidSchemaError("fragment spreads itself: "+name, selection.Loc)
+			}
+			p.spreading[name] = true
+			inlined, err := p.collectFields(fragment.SelectionSet)
+			delete(p.spreading, name)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, inlined...)
+		}
+	}
+	return fields, nil
+}
+
+// mergeFields merges fields with the same response key into the first of
+// them. They need to select the same field with the same arguments.
+func mergeFields(fields []*ast.Field) ([]*ast.Field, error) {
+	merged := []*ast.Field{}
+	byKey := map[string]*ast.Field{}
+	for _, field := range fields {
+		key := responseKey(field)
+		first, ok := byKey[key]
+		if !ok {
+			byKey[key] = field
+			merged = append(merged, field)
+			continue
+		}
+		if first.Name.Value != field.Name.Value || !sameArguments(first.Arguments, field.Arguments) {
+			return nil, NewInvalidSchemaError(
+				"fields "+first.Name.Value+" and "+field.Name.Value+" conflict because both are selected as "+key,
+				field.Loc,
+			)
+		}
+		if field.SelectionSet == nil {
+			continue
+		}
+		if first.SelectionSet == nil {
+			first.SelectionSet = field.SelectionSet
+			continue
+		}
+		first.SelectionSet = ast.NewSelectionSet(&ast.SelectionSet{
+			Loc: first.SelectionSet.Loc,
+			Selections: append(
+				append([]ast.Selection{}, first.SelectionSet.Selections...),
+				field.SelectionSet.Selections...,
+			),
+		})
+	}
+	return merged, nil
+}
+
+func sameArguments(a []*ast.Argument, b []*ast.Argument) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	printed := map[string]any{}
+	for _, arg := range a {
+		printed[arg.Name.Value] = printer.Print(arg.Value)
+	}
+	for _, arg := range b {
+		if value, ok := printed[arg.Name.Value]; !ok || value != printer.Print(arg.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func selectionDirectives(selection ast.Selection) []*ast.Directive {
+	switch selection := selection.(type) {
+	case *ast.Field:
+		return selection.Directives
+	case *ast.InlineFragment:
+		return selection.Directives
+	case *ast.FragmentSpread:
+		return selection.Directives
+	}
+	return nil
+}
+
+func (p *operationPreparer) shouldInclude(directives []*ast.Directive) (bool, error) {
+	for _, directive := range directives {
+		name := directive.Name.Value
+		if name != cSkipDirective && name != cIncludeDirective {
+			continue
+		}
+		arguments, err := p.substituteArguments(directive.Arguments)
+		if err != nil {
+			return false, err
+		}
+		if len(arguments) != 1 || arguments[0].Name.Value != cIfArg {
+			return false, NewInvalidSchemaError("@"+name+" takes one argument named 'if'", directive.Loc)
+		}
+		value, ok := arguments[0].Value.(*ast.BooleanValue)
+		if !ok {
+			return false, NewInvalidSchemaError("'if' argument of @"+name+" needs to be boolean", directive.Loc)
+		}
+		if value.Value == (name == cSkipDirective) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (p *operationPreparer) substituteDirectives(directives []*ast.Directive) ([]*ast.Directive, error) {
+	substituted := make([]*ast.Directive, 0, len(directives))
+	for _, directive := range directives {
+		if directive.Name.Value == cSkipDirective || directive.Name.Value == cIncludeDirective {
+			continue
+		}
+		arguments, err := p.substituteArguments(directive.Arguments)
+		if err != nil {
+			return nil, err
+		}
+		copied := *directive
+		copied.Arguments = arguments
+		substituted = append(substituted, &copied)
+	}
+	return substituted, nil
+}
+
+// substituteArguments replaces variables in the arguments with their values.
+// Arguments whose variable has no value or is null are left out, as no
+// argument tells null apart from leaving it out.
+func (p *operationPreparer) substituteArguments(arguments []*ast.Argument) ([]*ast.Argument, error) {
+	substituted := make([]*ast.Argument, 0, len(arguments))
+	for _, arg := range arguments {
+		value, err := p.substituteValue(arg.Value)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		if _, isNull := value.(*nullValue); isNull {
+			continue
+		}
+		copied := *arg
+		copied.Value = value
+		substituted = append(substituted, &copied)
+	}
+	return substituted, nil
+}
+
+func (p *operationPreparer) substituteValue(value ast.Value) (ast.Value, error) {
+	switch value := value.(type) {
+	case *ast.Variable:
+		if !p.declared[value.Name.Value] {
+			return nil, NewInvalidSchemaError("undeclared variable $"+value.Name.Value, value.Loc)
+		}
+		// a variable without a value is the same as leaving the value out
+		return p.variables[value.Name.Value], nil
+	case *ast.ListValue:
+		values := make([]ast.Value, 0, len(value.Values))
+		for _, item := range value.Values {
+			substituted, err := p.substituteValue(item)
+			if err != nil {
+				return nil, err
+			}
+			if _, isNull := substituted.(*nullValue); isNull || substituted == nil {
+				return nil, NewInvalidSchemaError("null is not supported in lists", item.GetLoc())
+			}
+			values = append(values, substituted)
+		}
+		return ast.NewListValue(&ast.ListValue{Loc: value.Loc, Values: values}), nil
+	case *ast.ObjectValue:
+		fields := make([]*ast.ObjectField, 0, len(value.Fields))
+		for _, field := range value.Fields {
+			substituted, err := p.substituteValue(field.Value)
+			if err != nil {
+				return nil, err
+			}
+			if substituted == nil {
+				continue
+			}
+			fields = append(fields, ast.NewObjectField(&ast.ObjectField{
+				Loc:   field.Loc,
+				Name:  field.Name,
+				Value: substituted,
+			}))
+		}
+		return ast.NewObjectValue(&ast.ObjectValue{Loc: value.Loc, Fields: fields}), nil
+	}
+	return value, nil
+}
+
+// getVariableValues turns the JSON values of the declared variables into
+// literals. Variables without a value take their default value, or are left
+// out if they have none. Variables that are null stay null.
+func getVariableValues(
+	definitions []*ast.VariableDefinition,
+	variables map[string]any,
+) (map[string]ast.Value, error) {
+	values := map[string]ast.Value{}
+	for _, definition := range definitions {
+		name := definition.Variable.Name.Value
+		_, isNonNull := definition.Type.(*ast.NonNull)
+		variable, ok := variables[name]
+		if !ok {
+			if isNonNull && definition.DefaultValue == nil {
+				return nil, NewInvalidSchemaError("missing value for variable $"+name, definition.Loc)
+			}
+			if definition.DefaultValue != nil {
+				values[name] = definition.DefaultValue
+			}
+			continue
+		}
+		if variable == nil && isNonNull {
+			return nil, NewInvalidSchemaError("null value for non-null variable $"+name, definition.Loc)
+		}
+		value, err := jsonToValue(variable, definition.Type, definition.Loc)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// jsonToValue converts a decoded JSON value into a literal of the given type.
+// Nested values of input objects are converted without a type, so numbers
+// become Int literals if they are whole.
+func jsonToValue(value any, valueType ast.Type, loc *ast.Location) (ast.Value, error) {
+	typeName := ""
+	var itemType ast.Type
+	switch t := valueType.(type) {
+	case *ast.NonNull:
+		return jsonToValue(value, t.Type, loc)
+	case *ast.List:
+		itemType = t.Type
+	case *ast.Named:
+		typeName = t.Name.Value
+	}
+
+	switch value := value.(type) {
+	case nil:
+		return &nullValue{Loc: loc}, nil
+	case bool:
+		return ast.NewBooleanValue(&ast.BooleanValue{Loc: loc, Value: value}), nil
+	case string:
+		_, isScalar := scalarTypes[typeName]
+		if typeName != "" && !isScalar {
+			return ast.NewEnumValue(&ast.EnumValue{Loc: loc, Value: value}), nil
+		}
+		return ast.NewStringValue(&ast.StringValue{Loc: loc, Value: value}), nil
+	case int:
+		return jsonToValue(float64(value), valueType, loc)
+	case int64:
+		return jsonToValue(float64(value), valueType, loc)
+	case float64:
+		if typeName == "Float" || value != math.Trunc(value) {
+			return ast.NewFloatValue(&ast.FloatValue{
+				Loc:   loc,
+				Value: strconv.FormatFloat(value, 'f', -1, 64),
+			}), nil
+		}
+		return ast.NewIntValue(&ast.IntValue{
+			Loc:   loc,
+			Value: strconv.FormatFloat(value, 'f', -1, 64),
+		}), nil
+	case []any:
+		values := make([]ast.Value, len(value))
+		for i, item := range value {
+			if item == nil {
+				return nil, NewInvalidSchemaError("null is not supported in lists", loc)
+			}
+			itemValue, err := jsonToValue(item, itemType, loc)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = itemValue
+		}
+		return ast.NewListValue(&ast.ListValue{Loc: loc, Values: values}), nil
+	case map[string]any:
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fields := []*ast.ObjectField{}
+		for _, name := range names {
+			fieldValue, err := jsonToValue(value[name], nil, loc)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, ast.NewObjectField(&ast.ObjectField{
+				Loc:   loc,
+				Name:  ast.NewName(&ast.Name{Loc: loc, Value: name}),
+				Value: fieldValue,
+			}))
+		}
+		return ast.NewObjectValue(&ast.ObjectValue{Loc: loc, Fields: fields}), nil
+	}
+	return nil, NewInvalidSchemaError("unsupported variable value", loc)
+}
+
+// IsMutation reports whether a document prepared by PrepareOperation is a
+// mutation.
+func IsMutation(doc *ast.Document) bool {
+	return isOperationType(doc, ast.OperationTypeMutation)
+}
+
+// IsSubscription reports whether a document prepared by PrepareOperation is
+// a subscription, which is resolved by Resolver.Subscribe.
+func IsSubscription(doc *ast.Document) bool {
+	return isOperationType(doc, ast.OperationTypeSubscription)
+}
+
+func isOperationType(doc *ast.Document, operationType string) bool {
+	for _, def := range doc.Definitions {
+		if def, ok := def.(*ast.OperationDefinition); ok && def.Operation == operationType {
+			return true
+		}
+	}
+	return false
+}
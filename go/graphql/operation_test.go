@@ -0,0 +1,105 @@
+package graphql_test
+
+import (
+	"testing"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/printer"
+	"github.com/sashankg/hold/graphql"
+	"github.com/stretchr/testify/require"
+)
+
+func prepare(t *testing.T, query string, operationName string, variables map[string]any) (*ast.Document, error) {
+	doc, err := parseGraphql(query)
+	require.NoError(t, err)
+	return graphql.PrepareOperation(doc, operationName, variables)
+}
+
+func TestPrepareOperation(t *testing.T) {
+	doc, err := prepare(t, `
+		query Posts($where: PostWhere, $skipViews: Boolean = true, $first: Int) {
+			listPost(where: $where, first: $first) {
+				edges {
+					node {
+						...Post
+						views @skip(if: $skipViews)
+					}
+				}
+			}
+		}
+		query Other {
+			findPerson(id: 1) {
+				name
+			}
+		}
+		fragment Post on Post {
+			title
+			author {
+				name
+			}
+			author {
+				id
+			}
+		}
+	`, "Posts", map[string]any{
+		"where": map[string]any{"views": map[string]any{"gt": float64(10)}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, `query Posts {
+  listPost(where: {views: {gt: 10}}) {
+    edges {
+      node {
+        title
+        author {
+          name
+          id
+        }
+      }
+    }
+  }
+}
+`, printer.Print(doc))
+}
+
+func TestPrepareOperationErrors(t *testing.T) {
+	query := `
+		query A($id: Int!) {
+			findPost(id: $id) {
+				title
+			}
+		}
+		query B {
+			findPost(id: $id) {
+				...Loop
+			}
+		}
+		query C {
+			findPost(id: 1) {
+				title
+				title: views
+			}
+		}
+		fragment Loop on Post {
+			author {
+				...Loop
+			}
+		}
+	`
+	for name, tc := range map[string]struct {
+		operationName string
+		variables     map[string]any
+	}{
+		"no operationName":    {"", nil},
+		"unknown operation":   {"D", nil},
+		"missing variable":    {"A", nil},
+		"undeclared variable": {"B", nil},
+		"conflicting fields":  {"C", nil},
+	} {
+		_, err := prepare(t, query, tc.operationName, tc.variables)
+		var schemaErr *graphql.InvalidSchemaError
+		require.ErrorAs(t, err, &schemaErr, name)
+	}
+
+	_, err := prepare(t, query, "A", map[string]any{"id": float64(2)})
+	require.NoError(t, err)
+}
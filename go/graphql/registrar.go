@@ -2,26 +2,46 @@ package graphql
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/kinds"
 	"github.com/sashankg/hold/dao"
 )
 
 const (
-	cPlaceholderObjectType = "Object"
+	cPlaceholderObjectType    = "Object"
+	cDefaultDeprecationReason = "No longer supported"
+	cInitialCollectionVersion = "1"
 )
 
 type Registrar interface {
-	RegisterSchema(context.Context, *ast.Document) error
+	RegisterSchema(context.Context, *ast.Document, RegisterOptions) ([]*dao.Collection, error)
+}
+
+// RegisterOptions control which changes RegisterSchema may apply to
+// collections that are already registered.
+type RegisterOptions struct {
+	// AllowDestructive allows dropping and retyping fields, which deletes the
+	// data stored in them.
+	AllowDestructive bool
 }
 
 type registrarImpl struct {
-	dao dao.CollectionDao
+	dao       dao.CollectionDao
+	listeners []SchemaListener
 }
 
-func NewRegistrar(dao dao.CollectionDao) Registrar {
+// NewRegistrar returns a Registrar that notifies listeners every time it
+// registers a schema.
+func NewRegistrar(dao dao.CollectionDao, listeners ...SchemaListener) Registrar {
 	return &registrarImpl{
 		dao,
+		listeners,
 	}
 }
 
@@ -30,65 +50,573 @@ type objectFieldSpec struct {
 	namespace string
 }
 
+const (
+	SchemaChangeAddField         = "addField"
+	SchemaChangeDeprecateField   = "deprecateField"
+	SchemaChangeDropField        = "dropField"
+	SchemaChangeRetypeField      = "retypeField"
+	SchemaChangeNullabilityField = "nullabilityField"
+	SchemaChangeOnDeleteField    = "onDeleteField"
+	SchemaChangeSearchableField  = "searchableField"
+)
+
+type SchemaChange struct {
+	Kind       string `json:"kind"`
+	Collection string `json:"collection"`
+	Field      string `json:"field"`
+}
+
+func (c SchemaChange) IsDestructive() bool {
+	return c.Kind == SchemaChangeDropField || c.Kind == SchemaChangeRetypeField
+}
+
+func (c SchemaChange) String() string {
+	return c.Kind + " " + c.Collection + "." + c.Field
+}
+
+// collectionPlan is what RegisterSchema will do for one object definition.
+type collectionPlan struct {
+	collection   *dao.Collection
+	isNew        bool
+	objectFields []objectFieldSpec
+	changes      []SchemaChange
+	// fields to add or retype on an existing collection
+	fields map[string]objectFieldSpec
+}
+
 // RegisterSchema implements Registrar.
-func (r *registrarImpl) RegisterSchema(ctx context.Context, doc *ast.Document) error {
-	collections := []*dao.Collection{}
-	objectFields := [][]objectFieldSpec{}
+//
+// New object types become new collections. For types that are already
+// registered the fields are diffed: added fields and changed deprecations, on
+// delete actions or searchable directives are applied right away, while
+// dropped and retyped fields are only applied if options.AllowDestructive is
+// set. The version of every changed collection is bumped.
+func (r *registrarImpl) RegisterSchema(
+	ctx context.Context,
+	doc *ast.Document,
+	options RegisterOptions,
+) ([]*dao.Collection, error) {
+	plans := []*collectionPlan{}
 	for _, def := range doc.Definitions {
 		switch def := def.(type) {
 		case *ast.ObjectDefinition:
-			namespace, err := getNamespace(def.Directives)
+			plan, err := r.planCollection(ctx, def)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			collection := &dao.Collection{
-				Name:   def.Name.Value,
-				Domain: namespace,
-				Fields: map[string]dao.CollectionField{},
+			plans = append(plans, plan)
+		}
+	}
+
+	if err := r.checkInverseFields(ctx, plans); err != nil {
+		return nil, err
+	}
+
+	destructiveChanges := []SchemaChange{}
+	for _, plan := range plans {
+		if plan.isNew {
+			continue
+		}
+		if err := r.diffCollection(ctx, plan, plans); err != nil {
+			return nil, err
+		}
+		for _, change := range plan.changes {
+			if change.IsDestructive() {
+				destructiveChanges = append(destructiveChanges, change)
 			}
-			i := len(collections)
-			objectFields = append(objectFields, []objectFieldSpec{})
-			for _, fieldDef := range def.Fields {
-				field, isScalar := getScalarCollectionField(fieldDef.Name.Value, fieldDef.Type, false, true)
-				if isScalar {
-					collection.Fields[fieldDef.Name.Value] = field
-				} else {
-					fieldNamespace, err := getNamespace(fieldDef.Directives)
-					if err != nil {
-						return err
-					}
-					objectFields[i] = append(objectFields[i], objectFieldSpec{
-						CollectionField: field,
-						namespace:       fieldNamespace,
-					})
+		}
+	}
+	if len(destructiveChanges) > 0 && !options.AllowDestructive {
+		return nil, &DestructiveChangeError{Changes: destructiveChanges}
+	}
+
+	newCollections := []*dao.Collection{}
+	for _, plan := range plans {
+		if plan.isNew {
+			newCollections = append(newCollections, plan.collection)
+		}
+	}
+	if len(newCollections) > 0 {
+		if err := r.dao.AddCollections(ctx, newCollections); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, plan := range plans {
+		if plan.isNew {
+			for _, spec := range plan.objectFields {
+				if err := r.addField(ctx, plan.collection, spec); err != nil {
+					return nil, err
 				}
 			}
-			collections = append(collections, collection)
+			continue
+		}
+		if err := r.applyChanges(ctx, plan); err != nil {
+			return nil, err
 		}
 	}
-	err := r.dao.AddCollections(ctx, collections)
-	if err != nil {
-		return err
+
+	collections := make([]*dao.Collection, len(plans))
+	for i, plan := range plans {
+		collection, err := r.dao.FindCollectionById(ctx, plan.collection.Id)
+		if err != nil {
+			return nil, err
+		}
+		collections[i] = collection
+	}
+	for _, listener := range r.listeners {
+		if err := listener.SchemaChanged(ctx); err != nil {
+			return nil, err
+		}
 	}
+	return collections, nil
+}
 
-	for i, fields := range objectFields {
-		for _, spec := range fields {
-			refCollection, err := r.dao.FindCollectionBySpec(
-				ctx,
-				dao.CollectionSpec{Name: spec.CollectionField.Type, Namespace: spec.namespace},
+func (r *registrarImpl) planCollection(
+	ctx context.Context,
+	def *ast.ObjectDefinition,
+) (*collectionPlan, error) {
+	if isScalarType(def.Name.Value) {
+		return nil, NewInvalidSchemaError("type name is reserved: "+def.Name.Value, def.Loc)
+	}
+	namespace, err := getNamespace(def.Directives)
+	if err != nil {
+		return nil, err
+	}
+	collection := &dao.Collection{
+		Name:    def.Name.Value,
+		Domain:  namespace,
+		Version: cInitialCollectionVersion,
+		Fields:  map[string]dao.CollectionField{},
+	}
+	plan := &collectionPlan{
+		collection: collection,
+		fields:     map[string]objectFieldSpec{},
+	}
+	for _, fieldDef := range def.Fields {
+		field, isScalar := getScalarCollectionField(fieldDef.Name.Value, fieldDef.Type, false, true)
+		field.DeprecationReason, err = getDeprecationReason(fieldDef.Directives)
+		if err != nil {
+			return nil, err
+		}
+		field.InverseOf, err = getInverseOf(fieldDef.Directives)
+		if err != nil {
+			return nil, err
+		}
+		if field.InverseOf != "" && (isScalar || !field.IsList) {
+			return nil, NewInvalidSchemaError(
+				"inverse field should be a list of an object type: "+fieldDef.Name.Value,
+				fieldDef.Loc,
+			)
+		}
+		field.OnDelete, err = getOnDelete(fieldDef.Directives)
+		if err != nil {
+			return nil, err
+		}
+		if field.OnDelete != "" && (isScalar || field.InverseOf != "") {
+			return nil, NewInvalidSchemaError(
+				"onDelete directive is only allowed on object fields: "+fieldDef.Name.Value,
+				fieldDef.Loc,
+			)
+		}
+		if field.OnDelete == dao.OnDeleteSetNull && field.NonNull {
+			return nil, NewInvalidSchemaError(
+				"non-null field cannot be set to null on delete: "+fieldDef.Name.Value,
+				fieldDef.Loc,
+			)
+		}
+		field.Searchable, err = getSearchable(fieldDef.Directives)
+		if err != nil {
+			return nil, err
+		}
+		if field.Searchable && (field.Type != "String" || field.IsList) {
+			return nil, NewInvalidSchemaError(
+				"searchable directive is only allowed on String fields: "+fieldDef.Name.Value,
+				fieldDef.Loc,
 			)
+		}
+		// the hidden columns of FTS5 tables
+		if field.Searchable && (field.Name == "rank" || field.Name == "rowid") {
+			return nil, NewInvalidSchemaError(
+				"searchable field cannot be named rank or rowid: "+fieldDef.Name.Value,
+				fieldDef.Loc,
+			)
+		}
+		if isScalar {
+			collection.Fields[fieldDef.Name.Value] = field
+		} else {
+			fieldNamespace, err := getNamespace(fieldDef.Directives)
+			if err != nil {
+				return nil, err
+			}
+			plan.objectFields = append(plan.objectFields, objectFieldSpec{
+				CollectionField: field,
+				namespace:       fieldNamespace,
+			})
+		}
+	}
+
+	existing, err := r.dao.FindCollectionBySpec(
+		ctx,
+		dao.CollectionSpec{Name: collection.Name, Namespace: namespace},
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		plan.isNew = true
+		return plan, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the existing collection is updated in place, the definition is kept in
+	// fields to be diffed against it
+	for name, field := range collection.Fields {
+		plan.fields[name] = objectFieldSpec{CollectionField: field}
+	}
+	for _, spec := range plan.objectFields {
+		plan.fields[spec.Name] = spec
+	}
+	plan.collection = existing
+	plan.objectFields = nil
+	return plan, nil
+}
+
+// checkInverseFields checks that every inverse field in the plans is the
+// inverse of a single reference back to the collection that declares it.
+// The referencing field is looked up in the plans first, as the document may
+// define or change it, and then in the registered collections.
+func (r *registrarImpl) checkInverseFields(ctx context.Context, plans []*collectionPlan) error {
+	for _, plan := range plans {
+		for _, spec := range plan.definedFields() {
+			if spec.InverseOf == "" {
+				continue
+			}
+			refFields, err := r.findDefinedFields(ctx, spec, plans)
 			if err != nil {
 				return err
 			}
-			field := spec.CollectionField
-			field.Ref = refCollection.Id
-			r.dao.AddCollectionField(ctx, collections[i], field)
+			refField, ok := refFields[spec.InverseOf]
+			if !ok || refField.IsList || !refField.IsStored() || refField.Type != plan.collection.Name {
+				return NewInvalidSchemaError(
+					"inverse field "+plan.collection.Name+"."+spec.Name+" should be the inverse of a "+
+						plan.collection.Name+" field of "+spec.Type+": "+spec.InverseOf,
+					nil,
+				)
+			}
 		}
 	}
+	return nil
+}
+
+// definedFields returns the fields of the object definition of the plan.
+func (p *collectionPlan) definedFields() map[string]objectFieldSpec {
+	if !p.isNew {
+		return p.fields
+	}
+	fields := map[string]objectFieldSpec{}
+	for name, field := range p.collection.Fields {
+		fields[name] = objectFieldSpec{CollectionField: field}
+	}
+	for _, spec := range p.objectFields {
+		fields[spec.Name] = spec
+	}
+	return fields
+}
 
+// findDefinedFields returns the fields of the collection an object field
+// refers to, as defined by the plans or else as registered.
+func (r *registrarImpl) findDefinedFields(
+	ctx context.Context,
+	spec objectFieldSpec,
+	plans []*collectionPlan,
+) (map[string]objectFieldSpec, error) {
+	for _, plan := range plans {
+		if plan.collection.Name == spec.Type && plan.collection.Domain == spec.namespace {
+			return plan.definedFields(), nil
+		}
+	}
+	refCollection, err := r.dao.FindCollectionBySpec(
+		ctx,
+		dao.CollectionSpec{Name: spec.Type, Namespace: spec.namespace},
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, NewInvalidSchemaError("unknown type: "+spec.Type, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]objectFieldSpec{}
+	for name, field := range refCollection.Fields {
+		fields[name] = objectFieldSpec{CollectionField: field}
+	}
+	return fields, nil
+}
+
+// diffCollection fills in the changes needed to turn the existing collection
+// of the plan into its definition.
+func (r *registrarImpl) diffCollection(
+	ctx context.Context,
+	plan *collectionPlan,
+	plans []*collectionPlan,
+) error {
+	existing := plan.collection
+	names := make([]string, 0, len(plan.fields))
+	for name := range plan.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		spec := plan.fields[name]
+		if spec.Ref == 0 && !isScalarType(spec.Type) {
+			ref, err := r.findRef(ctx, spec, plans)
+			if err != nil {
+				return err
+			}
+			spec.Ref = ref
+			plan.fields[name] = spec
+		}
+		change := SchemaChange{Collection: existing.Name, Field: name}
+		have, ok := existing.Fields[name]
+		switch {
+		case !ok:
+			change.Kind = SchemaChangeAddField
+		case have.Type != spec.Type || have.IsList != spec.IsList || have.Ref != spec.Ref ||
+			have.InverseOf != spec.InverseOf:
+			change.Kind = SchemaChangeRetypeField
+		case have.NonNull != spec.NonNull:
+			change.Kind = SchemaChangeNullabilityField
+		case have.DeprecationReason != spec.DeprecationReason:
+			change.Kind = SchemaChangeDeprecateField
+		case have.OnDelete != spec.OnDelete:
+			change.Kind = SchemaChangeOnDeleteField
+		case have.Searchable != spec.Searchable:
+			change.Kind = SchemaChangeSearchableField
+		default:
+			continue
+		}
+		plan.changes = append(plan.changes, change)
+	}
+
+	dropped := []string{}
+	for name := range existing.Fields {
+		if _, ok := plan.fields[name]; !ok {
+			dropped = append(dropped, name)
+		}
+	}
+	sort.Strings(dropped)
+	for _, name := range dropped {
+		plan.changes = append(plan.changes, SchemaChange{
+			Kind:       SchemaChangeDropField,
+			Collection: existing.Name,
+			Field:      name,
+		})
+	}
 	return nil
 }
 
+// findRef returns the id of the collection an object field refers to, or 0
+// if that collection is only about to be created by the same document.
+func (r *registrarImpl) findRef(
+	ctx context.Context,
+	spec objectFieldSpec,
+	plans []*collectionPlan,
+) (int, error) {
+	refCollection, err := r.dao.FindCollectionBySpec(
+		ctx,
+		dao.CollectionSpec{Name: spec.Type, Namespace: spec.namespace},
+	)
+	if err == nil {
+		return refCollection.Id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	for _, plan := range plans {
+		if plan.isNew && plan.collection.Name == spec.Type && plan.collection.Domain == spec.namespace {
+			return 0, nil
+		}
+	}
+	return 0, NewInvalidSchemaError("unknown type: "+spec.Type, nil)
+}
+
+func (r *registrarImpl) addField(
+	ctx context.Context,
+	collection *dao.Collection,
+	spec objectFieldSpec,
+) error {
+	field, err := r.resolveField(ctx, spec)
+	if err != nil {
+		return err
+	}
+	if err := r.dao.AddCollectionField(ctx, collection, field); err != nil {
+		return fieldChangeError(err)
+	}
+	collection.Fields[field.Name] = field
+	return nil
+}
+
+// fieldChangeError reports the changes the collection's records do not allow
+// as invalid schemas.
+func fieldChangeError(err error) error {
+	if errors.Is(err, dao.ErrNonNullReference) || errors.Is(err, dao.ErrNullValues) {
+		return NewInvalidSchemaError(err.Error(), nil)
+	}
+	return err
+}
+
+// resolveField returns the field of spec with the id of the collection it
+// refers to.
+func (r *registrarImpl) resolveField(ctx context.Context, spec objectFieldSpec) (dao.CollectionField, error) {
+	field := spec.CollectionField
+	if !isScalarType(field.Type) {
+		refCollection, err := r.dao.FindCollectionBySpec(
+			ctx,
+			dao.CollectionSpec{Name: field.Type, Namespace: spec.namespace},
+		)
+		if err != nil {
+			return dao.CollectionField{}, err
+		}
+		field.Ref = refCollection.Id
+	}
+	return field, nil
+}
+
+func (r *registrarImpl) applyChanges(ctx context.Context, plan *collectionPlan) error {
+	if len(plan.changes) == 0 {
+		return nil
+	}
+	collection := plan.collection
+	for _, change := range plan.changes {
+		switch change.Kind {
+		case SchemaChangeDropField:
+			if err := r.dao.DropCollectionField(ctx, collection, change.Field); err != nil {
+				return err
+			}
+		case SchemaChangeRetypeField:
+			field, err := r.resolveField(ctx, plan.fields[change.Field])
+			if err != nil {
+				return err
+			}
+			if err := r.dao.RetypeCollectionField(ctx, collection, field); err != nil {
+				return fieldChangeError(err)
+			}
+		case SchemaChangeAddField:
+			if err := r.addField(ctx, collection, plan.fields[change.Field]); err != nil {
+				return err
+			}
+		case SchemaChangeDeprecateField, SchemaChangeOnDeleteField, SchemaChangeSearchableField,
+			SchemaChangeNullabilityField:
+			field := collection.Fields[change.Field]
+			field.NonNull = plan.fields[change.Field].NonNull
+			field.DeprecationReason = plan.fields[change.Field].DeprecationReason
+			field.OnDelete = plan.fields[change.Field].OnDelete
+			field.Searchable = plan.fields[change.Field].Searchable
+			if err := r.dao.UpdateCollectionField(ctx, collection, field); err != nil {
+				return fieldChangeError(err)
+			}
+			collection.Fields[change.Field] = field
+		}
+	}
+	version, err := strconv.Atoi(collection.Version)
+	if err != nil {
+		// collections registered before versioning have no version
+		version = 0
+	}
+	collection.Version = strconv.Itoa(version + 1)
+	return r.dao.SetCollectionVersion(ctx, collection)
+}
+
+func getDeprecationReason(directives []*ast.Directive) (string, error) {
+	for _, directive := range directives {
+		if directive.Name.Value != "deprecated" {
+			continue
+		}
+		if len(directive.Arguments) == 0 {
+			return cDefaultDeprecationReason, nil
+		}
+		if len(directive.Arguments) > 1 || directive.Arguments[0].Name.Value != "reason" ||
+			directive.Arguments[0].Value.GetKind() != kinds.StringValue {
+			return "", NewInvalidSchemaError(
+				"deprecated directive should take one string argument named 'reason'",
+				directive.Loc,
+			)
+		}
+		return directive.Arguments[0].Value.GetValue().(string), nil
+	}
+	return "", nil
+}
+
+func getInverseOf(directives []*ast.Directive) (string, error) {
+	for _, directive := range directives {
+		if directive.Name.Value != "inverse" {
+			continue
+		}
+		if len(directive.Arguments) != 1 || directive.Arguments[0].Name.Value != "of" ||
+			directive.Arguments[0].Value.GetKind() != kinds.StringValue {
+			return "", NewInvalidSchemaError(
+				"inverse directive should take one string argument named 'of'",
+				directive.Loc,
+			)
+		}
+		return directive.Arguments[0].Value.GetValue().(string), nil
+	}
+	return "", nil
+}
+
+func getOnDelete(directives []*ast.Directive) (string, error) {
+	for _, directive := range directives {
+		if directive.Name.Value != "onDelete" {
+			continue
+		}
+		if len(directive.Arguments) == 1 && directive.Arguments[0].Name.Value == "action" &&
+			directive.Arguments[0].Value.GetKind() == kinds.EnumValue {
+			switch action := directive.Arguments[0].Value.GetValue().(string); action {
+			case dao.OnDeleteCascade, dao.OnDeleteRestrict, dao.OnDeleteSetNull:
+				return action, nil
+			}
+		}
+		return "", NewInvalidSchemaError(
+			"onDelete directive should take one argument named 'action' that is CASCADE, RESTRICT or SET_NULL",
+			directive.Loc,
+		)
+	}
+	return "", nil
+}
+
+func getSearchable(directives []*ast.Directive) (bool, error) {
+	for _, directive := range directives {
+		if directive.Name.Value != "searchable" {
+			continue
+		}
+		if len(directive.Arguments) > 0 {
+			return false, NewInvalidSchemaError("searchable directive takes no arguments", directive.Loc)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func isScalarType(typeName string) bool {
+	switch typeName {
+	case "Int", "Float", "String", "Boolean", "ID", dao.BlobType:
+		return true
+	}
+	return false
+}
+
+// DestructiveChangeError is returned by RegisterSchema when the document
+// drops or retypes fields without RegisterOptions.AllowDestructive.
+type DestructiveChangeError struct {
+	Changes []SchemaChange
+}
+
+func (e *DestructiveChangeError) Error() string {
+	changes := make([]string, len(e.Changes))
+	for i, change := range e.Changes {
+		changes[i] = change.String()
+	}
+	return "destructive schema changes need to be allowed: " + strings.Join(changes, ", ")
+}
+
 func getScalarCollectionField(
 	fieldName string,
 	fieldType ast.Type,
@@ -107,21 +635,26 @@ func getScalarCollectionField(
 		case "Boolean":
 			fallthrough
 		case "ID":
+			fallthrough
+		case dao.BlobType:
 			return dao.CollectionField{
-				Name:   fieldName,
-				Type:   fieldType.Name.Value,
-				IsList: isList,
+				Name:    fieldName,
+				Type:    fieldType.Name.Value,
+				IsList:  isList,
+				NonNull: !isNullable,
 			}, true
 		}
 		return dao.CollectionField{
-			Name:   fieldName,
-			Type:   fieldType.Name.Value,
-			IsList: isList,
+			Name:    fieldName,
+			Type:    fieldType.Name.Value,
+			IsList:  isList,
+			NonNull: !isNullable,
 		}, false
 	case *ast.List:
 		return getScalarCollectionField(fieldName, fieldType.Type, true, isNullable)
 	case *ast.NonNull:
-		return getScalarCollectionField(fieldName, fieldType.Type, isList, false)
+		// inside of a list, non-null applies to the elements and not the field
+		return getScalarCollectionField(fieldName, fieldType.Type, isList, isList && isNullable)
 	}
 	panic("invalid field definition type")
 }
@@ -31,10 +31,9 @@ func TestRegisterSchema(t *testing.T) {
 		`,
 	})
 	require.NoError(t, err)
-	require.NoError(
-		t,
-		registrar.RegisterSchema(context.Background(), ast),
-	)
+	collections, err := registrar.RegisterSchema(context.Background(), ast, graphql.RegisterOptions{})
+	require.NoError(t, err)
+	require.Len(t, collections, 2)
 
 	postCollection, err := testDao.FindCollectionBySpec(context.Background(), dao.CollectionSpec{
 		Name: "Post",
@@ -49,9 +48,10 @@ func TestRegisterSchema(t *testing.T) {
 	require.Greater(t, personCollection.Id, 0)
 
 	require.Equal(t, postCollection, &dao.Collection{
-		Name:   "Post",
-		Domain: "",
-		Id:     postCollection.Id,
+		Name:    "Post",
+		Domain:  "",
+		Version: "1",
+		Id:      postCollection.Id,
 		Fields: map[string]dao.CollectionField{
 			"title": {
 				Name: "title",
@@ -70,9 +70,10 @@ func TestRegisterSchema(t *testing.T) {
 	})
 
 	require.Equal(t, personCollection, &dao.Collection{
-		Name:   "Person",
-		Domain: "",
-		Id:     personCollection.Id,
+		Name:    "Person",
+		Domain:  "",
+		Version: "1",
+		Id:      personCollection.Id,
 		Fields: map[string]dao.CollectionField{
 			"name": {
 				Name: "name",
@@ -100,5 +101,321 @@ func TestRegisterSchema(t *testing.T) {
 	testField("post", "body", "TEXT")
 	testField("post", "author", "INTEGER")
 	testField("person", "name", "TEXT")
-	testField("person", "friends", "INTEGER")
+	testField("person_friends", "owner", "INTEGER")
+	testField("person_friends", "position", "INTEGER")
+	testField("person_friends", "value", "INTEGER")
+
+	var friendsColumns int
+	require.NoError(t, testDao.RecordDb.QueryRow(`
+		SELECT count(*) FROM pragma_table_info('person') WHERE name = 'friends'
+		`).
+		Scan(&friendsColumns))
+	require.Equal(t, 0, friendsColumns)
+}
+
+func TestRegisterSchemaEvolution(t *testing.T) {
+	testDao := util.NewMemoryDao(t)
+	registrar := graphql.NewRegistrar(testDao)
+	register := func(source string, options graphql.RegisterOptions) ([]*dao.Collection, error) {
+		doc, err := parser.Parse(parser.ParseParams{Source: source})
+		require.NoError(t, err)
+		return registrar.RegisterSchema(context.Background(), doc, options)
+	}
+
+	_, err := register(`
+		type Post {
+			title: String
+			body: String
+		}
+	`, graphql.RegisterOptions{})
+	require.NoError(t, err)
+
+	// registering the same schema again changes nothing
+	collections, err := register(`
+		type Post {
+			title: String
+			body: String
+		}
+	`, graphql.RegisterOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "1", collections[0].Version)
+
+	// additive changes are applied automatically
+	collections, err = register(`
+		type Post {
+			title: String
+			body: String @deprecated(reason: "use content")
+			content: String
+			author: Person
+		}
+		type Person {
+			name: String
+		}
+	`, graphql.RegisterOptions{})
+	require.NoError(t, err)
+	require.Len(t, collections, 2)
+	post := collections[0]
+	require.Equal(t, "2", post.Version)
+	require.Equal(t, "use content", post.Fields["body"].DeprecationReason)
+	require.Equal(t, "String", post.Fields["content"].Type)
+	require.Equal(t, collections[1].Id, post.Fields["author"].Ref)
+
+	// destructive changes need to be allowed
+	destructive := `
+		type Post {
+			title: Int
+			content: String
+			author: Person
+		}
+		type Person {
+			name: String
+		}
+	`
+	_, err = register(destructive, graphql.RegisterOptions{})
+	var destructiveErr *graphql.DestructiveChangeError
+	require.ErrorAs(t, err, &destructiveErr)
+	require.Equal(t, []graphql.SchemaChange{
+		{Kind: graphql.SchemaChangeRetypeField, Collection: "Post", Field: "title"},
+		{Kind: graphql.SchemaChangeDropField, Collection: "Post", Field: "body"},
+	}, destructiveErr.Changes)
+
+	collections, err = register(destructive, graphql.RegisterOptions{AllowDestructive: true})
+	require.NoError(t, err)
+	post = collections[0]
+	require.Equal(t, "3", post.Version)
+	require.Equal(t, "Int", post.Fields["title"].Type)
+	require.NotContains(t, post.Fields, "body")
+
+	var columns int
+	require.NoError(t, testDao.RecordDb.QueryRow(`
+		SELECT count(*) FROM pragma_table_info('post') WHERE name = 'body'
+		`).
+		Scan(&columns))
+	require.Equal(t, 0, columns)
+	var columnType string
+	require.NoError(t, testDao.RecordDb.QueryRow(`
+		SELECT type FROM pragma_table_info('post') WHERE name = 'title'
+		`).
+		Scan(&columnType))
+	require.Equal(t, "INTEGER", columnType)
+
+	// a column left by a change whose metadata failed to commit is replaced
+	_, err = testDao.RecordDb.Exec(`ALTER TABLE Post ADD COLUMN summary TEXT`)
+	require.NoError(t, err)
+	collections, err = register(`
+		type Post {
+			title: Int
+			content: String
+			author: Person
+			summary: Int
+		}
+		type Person {
+			name: String
+		}
+	`, graphql.RegisterOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "Int", collections[0].Fields["summary"].Type)
+}
+
+func TestRegisterSchemaInverseFields(t *testing.T) {
+	for _, source := range []string{
+		`type Post { author: Person } type Person { posts: Post @inverse(of: "author") }`,
+		`type Post { author: Person } type Person { posts: [Post] @inverse(of: "writer") }`,
+		`type Post { authors: [Person] } type Person { posts: [Post] @inverse(of: "authors") }`,
+		`type Post { title: String } type Person { posts: [Post] @inverse(of: "title") }`,
+		`type Post { author: Person } type Person { posts: [Post] @inverse }`,
+	} {
+		doc, err := parser.Parse(parser.ParseParams{Source: source})
+		require.NoError(t, err)
+		_, err = graphql.NewRegistrar(util.NewMemoryDao(t)).
+			RegisterSchema(context.Background(), doc, graphql.RegisterOptions{})
+		var schemaErr *graphql.InvalidSchemaError
+		require.ErrorAs(t, err, &schemaErr, source)
+	}
+
+	// the referencing field can already be registered
+	testDao := util.NewMemoryDao(t)
+	registrar := graphql.NewRegistrar(testDao)
+	doc, err := parser.Parse(parser.ParseParams{Source: `
+		type Post { author: Person }
+		type Person { name: String }
+	`})
+	require.NoError(t, err)
+	_, err = registrar.RegisterSchema(context.Background(), doc, graphql.RegisterOptions{})
+	require.NoError(t, err)
+	doc, err = parser.Parse(parser.ParseParams{Source: `
+		type Person { name: String, posts: [Post] @inverse(of: "author") }
+	`})
+	require.NoError(t, err)
+	collections, err := registrar.RegisterSchema(context.Background(), doc, graphql.RegisterOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "author", collections[0].Fields["posts"].InverseOf)
+	require.Equal(t, "2", collections[0].Version)
+}
+
+func TestRegisterSchemaNonNullReference(t *testing.T) {
+	testDao := util.NewMemoryDao(t)
+	registrar := graphql.NewRegistrar(testDao)
+	register := func(source string) error {
+		doc, err := parser.Parse(parser.ParseParams{Source: source})
+		require.NoError(t, err)
+		_, err = registrar.RegisterSchema(context.Background(), doc, graphql.RegisterOptions{})
+		return err
+	}
+	require.NoError(t, register(`type Post { title: String } type Person { name: String }`))
+	// there is no record to refer to for the posts that are already there
+	_, err := testDao.RecordDb.Exec(`INSERT INTO Post (title) VALUES ('hello')`)
+	require.NoError(t, err)
+	err = register(`type Post { title: String, author: Person! } type Person { name: String }`)
+	var schemaErr *graphql.InvalidSchemaError
+	require.ErrorAs(t, err, &schemaErr)
+
+	require.NoError(t, register(`type Post { title: String, author: Person } type Person { name: String }`))
+	require.NoError(t, register(`type Person { name: String, mentor: Person! }`))
+}
+
+func TestRegisterSchemaNullability(t *testing.T) {
+	testDao := util.NewMemoryDao(t)
+	registrar := graphql.NewRegistrar(testDao)
+	register := func(source string) ([]*dao.Collection, error) {
+		doc, err := parser.Parse(parser.ParseParams{Source: source})
+		require.NoError(t, err)
+		return registrar.RegisterSchema(context.Background(), doc, graphql.RegisterOptions{})
+	}
+	titles := func() []any {
+		rows, err := testDao.RecordDb.Query(`SELECT title FROM Post ORDER BY id`)
+		require.NoError(t, err)
+		defer rows.Close()
+		titles := []any{}
+		for rows.Next() {
+			var title any
+			require.NoError(t, rows.Scan(&title))
+			titles = append(titles, title)
+		}
+		return titles
+	}
+
+	_, err := register(`type Post { title: String!, body: String }`)
+	require.NoError(t, err)
+	_, err = testDao.RecordDb.Exec(`INSERT INTO Post (title) VALUES ('hello'), ('world')`)
+	require.NoError(t, err)
+
+	// relaxing a field keeps its values, and is not destructive
+	collections, err := register(`type Post { title: String, body: String }`)
+	require.NoError(t, err)
+	require.False(t, collections[0].Fields["title"].NonNull)
+	require.Equal(t, "2", collections[0].Version)
+	require.Equal(t, []any{"hello", "world"}, titles())
+	_, err = testDao.RecordDb.Exec(`INSERT INTO Post (title) VALUES (NULL)`)
+	require.NoError(t, err)
+
+	// tightening it fails while it holds nulls
+	_, err = register(`type Post { title: String!, body: String }`)
+	var schemaErr *graphql.InvalidSchemaError
+	require.ErrorAs(t, err, &schemaErr)
+	require.Equal(t, []any{"hello", "world", nil}, titles())
+
+	_, err = testDao.RecordDb.Exec(`DELETE FROM Post WHERE title IS NULL`)
+	require.NoError(t, err)
+	collections, err = register(`type Post { title: String!, body: String }`)
+	require.NoError(t, err)
+	require.True(t, collections[0].Fields["title"].NonNull)
+	require.Equal(t, []any{"hello", "world"}, titles())
+	_, err = testDao.RecordDb.Exec(`INSERT INTO Post (title) VALUES (NULL)`)
+	require.Error(t, err)
+}
+
+func TestRegisterSchemaSearchable(t *testing.T) {
+	for _, source := range []string{
+		`type Post { views: Int @searchable }`,
+		`type Post { tags: [String] @searchable }`,
+		`type Post { author: Person @searchable } type Person { name: String }`,
+		`type Post { rank: String @searchable }`,
+		`type Post { title: String @searchable(language: "en") }`,
+	} {
+		doc, err := parser.Parse(parser.ParseParams{Source: source})
+		require.NoError(t, err)
+		_, err = graphql.NewRegistrar(util.NewMemoryDao(t)).
+			RegisterSchema(context.Background(), doc, graphql.RegisterOptions{})
+		var schemaErr *graphql.InvalidSchemaError
+		require.ErrorAs(t, err, &schemaErr, source)
+	}
+
+	util.SkipWithoutSearch(t)
+	testDao := util.NewMemoryDao(t)
+	registrar := graphql.NewRegistrar(testDao)
+	register := func(source string, options graphql.RegisterOptions) ([]*dao.Collection, error) {
+		doc, err := parser.Parse(parser.ParseParams{Source: source})
+		require.NoError(t, err)
+		return registrar.RegisterSchema(context.Background(), doc, options)
+	}
+	matches := func(query string) []int {
+		rows, err := testDao.RecordDb.Query(`SELECT rowid FROM __search_Post WHERE __search_Post MATCH ? ORDER BY rowid`, query)
+		require.NoError(t, err)
+		defer rows.Close()
+		ids := []int{}
+		for rows.Next() {
+			var id int
+			require.NoError(t, rows.Scan(&id))
+			ids = append(ids, id)
+		}
+		return ids
+	}
+
+	_, err := register(`
+		type Post {
+			title: String @searchable
+			body: String
+		}
+	`, graphql.RegisterOptions{})
+	require.NoError(t, err)
+	_, err = testDao.RecordDb.Exec(`INSERT INTO Post (id, title, body) VALUES (1, 'hello', 'world'), (2, 'world', 'hello')`)
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, matches("hello"))
+
+	// the existing records are indexed
+	collections, err := register(`
+		type Post {
+			title: String @searchable
+			body: String @searchable
+		}
+	`, graphql.RegisterOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "2", collections[0].Version)
+	require.True(t, collections[0].Fields["body"].Searchable)
+	require.Equal(t, []int{1, 2}, matches("hello"))
+	require.Equal(t, []int{2}, matches("body: hello"))
+
+	// a searchable field can be dropped
+	collections, err = register(`
+		type Post {
+			body: String @searchable
+		}
+	`, graphql.RegisterOptions{AllowDestructive: true})
+	require.NoError(t, err)
+	require.NotContains(t, collections[0].Fields, "title")
+	require.Equal(t, []int{2}, matches("hello"))
+
+	// and made non-null
+	_, err = register(`
+		type Post {
+			body: String! @searchable
+		}
+	`, graphql.RegisterOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []int{2}, matches("hello"))
+	_, err = testDao.RecordDb.Exec(`INSERT INTO Post (id, body) VALUES (3, 'hello again')`)
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 3}, matches("hello"))
+
+	// without searchable fields there is no search table
+	_, err = register(`
+		type Post {
+			body: String
+		}
+	`, graphql.RegisterOptions{})
+	require.NoError(t, err)
+	var tables int
+	require.NoError(t, testDao.RecordDb.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name GLOB '__search*'`).Scan(&tables))
+	require.Equal(t, 0, tables)
 }
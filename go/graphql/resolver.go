@@ -2,62 +2,247 @@ package graphql
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 
 	"github.com/graphql-go/graphql/language/ast"
+	"github.com/sashankg/hold/blobs"
 	"github.com/sashankg/hold/dao"
 )
 
 type Resolver interface {
 	Resolve(context.Context, *ast.Document) ([]byte, error)
+	Subscribe(context.Context, *ast.Document) (<-chan SubscriptionResult, error)
 }
 
 type resolverImpl struct {
-	dao dao.Dao
+	dao   dao.Dao
+	blobs blobs.Store
 }
 
 var _ Resolver = (*resolverImpl)(nil)
 
-func NewResolver(dao dao.Dao) *resolverImpl {
+func NewResolver(dao dao.Dao, blobStore blobs.Store) *resolverImpl {
 	return &resolverImpl{
-		dao: dao,
+		dao:   dao,
+		blobs: blobStore,
 	}
 }
 
 // Resolve implements Resolver.
+//
+// A root field that fails, or a non-null field that resolves to null, does
+// not fail the whole document: the data is still returned, together with
+// Errors describing which fields are null and why.
 func (r *resolverImpl) Resolve(
 	ctx context.Context,
 	doc *ast.Document,
 ) ([]byte, error) {
 	result := map[string]JsonValue{}
+	dataIsNull := false
+	completer := newNullCompleter(newCollectionCache(ctx, r.dao), r.blobs)
 	err := iterateRootFields(doc, func(field *ast.Field) error {
-		collectionSpec, schemaErr := rootFieldToCollectionSpec(field)
-		if schemaErr != nil {
-			return schemaErr
-		}
-		collectionId, err := r.dao.GetCollectionId(ctx, *collectionSpec)
-		if err != nil {
-			return fmt.Errorf("collection not found for root field %s", field.Name.Value)
-		}
-		recordId, err := getRecordId(field)
+		json, err := r.resolveRootField(ctx, field, completer)
 		if err != nil {
-			return err
+			completer.errors = append(completer.errors, newFieldError(err, field))
+			// connections and histories are non-null, so the null bubbles
+			// up to data
+			operation := rootFieldOperation(field)
+			dataIsNull = dataIsNull || isConnectionOperation(operation) || operation == cHistoryOperation
+			json = jsonNull
 		}
-		json, err := r.dao.GetRecord(
-			ctx,
-			recordId,
-			getDaoSelection(field.SelectionSet),
-			collectionId,
-		)
-		result[field.Name.Value] = JsonValue(json)
+		result[responseKey(field)] = JsonValue(json)
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
-	return json.Marshal(result)
+	data := []byte(jsonNull)
+	if !dataIsNull {
+		data, err = json.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(completer.errors) > 0 {
+		return data, completer.errors
+	}
+	return data, nil
+}
+
+func (r *resolverImpl) resolveRootField(
+	ctx context.Context,
+	field *ast.Field,
+	completer *nullCompleter,
+) ([]byte, error) {
+	collectionSpec, schemaErr := rootFieldToCollectionSpec(field)
+	if schemaErr != nil {
+		return nil, schemaErr
+	}
+	collection, err := r.dao.FindCollectionBySpec(ctx, *collectionSpec)
+	if err != nil {
+		return nil, fmt.Errorf("collection not found for root field %s: %w", field.Name.Value, err)
+	}
+	var selection []dao.Selection
+	switch operation := rootFieldOperation(field); {
+	case isConnectionOperation(operation):
+		selection, err = getConnectionSelection(completer.collectionCache, field.SelectionSet, collection)
+	case operation == cHistoryOperation:
+		selection, err = getVersionSelection(completer.collectionCache, field.SelectionSet, collection)
+	default:
+		selection, err = getDaoSelection(completer.collectionCache, field.SelectionSet, collection)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var json []byte
+	switch rootFieldOperation(field) {
+	case cListOperation:
+		json, err = r.resolveList(ctx, field, selection, collection)
+	case cSearchOperation:
+		json, err = r.resolveSearch(ctx, field, selection, collection)
+	case cSetOperation, cPatchOperation:
+		json, err = r.resolveWrite(ctx, field, selection, collection)
+	case cDeleteOperation:
+		json, err = r.resolveDelete(ctx, field, selection, collection)
+	case cHistoryOperation:
+		json, err = r.resolveHistory(ctx, field, selection, collection)
+	default:
+		json, err = r.resolveFind(ctx, field, selection, collection)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return completer.completeRootField(field, selection, collection, json)
+}
+
+// newFieldError describes why a root field resolved to null.
+func newFieldError(err error, field *ast.Field) *Error {
+	fieldErr := &Error{
+		Message:    "internal server error",
+		Locations:  sourceLocations(field.Loc),
+		Path:       []any{responseKey(field)},
+		Extensions: ErrorExtensions{Code: ErrorCodeInternal},
+	}
+	var schemaErr *InvalidSchemaError
+	switch {
+	case errors.As(err, &schemaErr):
+		fieldErr.Message = schemaErr.reason
+		fieldErr.Locations = schemaErr.Locations()
+		fieldErr.Extensions.Code = ErrorCodeBadUserInput
+	case errors.Is(err, dao.ErrDeleteRestricted), errors.Is(err, dao.ErrInvalidSearchQuery),
+		errors.Is(err, dao.ErrInvalidValue):
+		fieldErr.Message = err.Error()
+		fieldErr.Extensions.Code = ErrorCodeBadUserInput
+	case errors.Is(err, sql.ErrNoRows):
+		fieldErr.Message = "record not found"
+		fieldErr.Extensions.Code = ErrorCodeNotFound
+	}
+	return fieldErr
+}
+
+func (r *resolverImpl) resolveFind(
+	ctx context.Context,
+	field *ast.Field,
+	selection []dao.Selection,
+	collection *dao.Collection,
+) ([]byte, error) {
+	recordId, err := getRecordId(field)
+	if err != nil {
+		return nil, NewInvalidSchemaError(err.Error(), field.Loc)
+	}
+	asOf, err := getAsOf(field)
+	if err != nil {
+		return nil, err
+	}
+	if asOf != nil {
+		return r.dao.GetRecordAsOf(ctx, recordId, *asOf, selection, collection.Id)
+	}
+	return r.dao.GetRecord(ctx, recordId, selection, collection.Id)
+}
+
+func (r *resolverImpl) resolveList(
+	ctx context.Context,
+	field *ast.Field,
+	selection []dao.Selection,
+	collection *dao.Collection,
+) ([]byte, error) {
+	params, err := getListParams(field, collection)
+	if err != nil {
+		return nil, err
+	}
+	return r.dao.ListRecords(ctx, *params, selection, collection.Id)
+}
+
+func (r *resolverImpl) resolveSearch(
+	ctx context.Context,
+	field *ast.Field,
+	selection []dao.Selection,
+	collection *dao.Collection,
+) ([]byte, error) {
+	params, err := getSearchParams(field)
+	if err != nil {
+		return nil, err
+	}
+	return r.dao.SearchRecords(ctx, *params, selection, collection.Id)
+}
+
+func (r *resolverImpl) resolveWrite(
+	ctx context.Context,
+	field *ast.Field,
+	selection []dao.Selection,
+	collection *dao.Collection,
+) ([]byte, error) {
+	values, err := getRecordInput(field, collection)
+	if err != nil {
+		return nil, err
+	}
+	// the blobs stay until the write that refers to them commits
+	defer r.blobs.Refer()()
+	if err := checkBlobValues(ctx, r.blobs, collection, values, field.Loc); err != nil {
+		return nil, err
+	}
+	if rootFieldOperation(field) == cSetOperation {
+		return r.dao.SetRecord(ctx, values, selection, collection.Id)
+	}
+	recordId, err := getRecordId(field)
+	if err != nil {
+		return nil, NewInvalidSchemaError(err.Error(), field.Loc)
+	}
+	return r.dao.PatchRecord(ctx, recordId, values, selection, collection.Id)
+}
+
+func (r *resolverImpl) resolveDelete(
+	ctx context.Context,
+	field *ast.Field,
+	selection []dao.Selection,
+	collection *dao.Collection,
+) ([]byte, error) {
+	recordId, err := getRecordId(field)
+	if err != nil {
+		return nil, NewInvalidSchemaError(err.Error(), field.Loc)
+	}
+	return r.dao.DeleteRecord(ctx, recordId, selection, collection.Id)
+}
+
+func (r *resolverImpl) resolveHistory(
+	ctx context.Context,
+	field *ast.Field,
+	selection []dao.Selection,
+	collection *dao.Collection,
+) ([]byte, error) {
+	recordId, err := getRecordId(field)
+	if err != nil {
+		return nil, NewInvalidSchemaError(err.Error(), field.Loc)
+	}
+	first, err := getHistoryFirst(field)
+	if err != nil {
+		return nil, err
+	}
+	return r.dao.ListRecordHistory(ctx, recordId, first, selection, collection.Id)
 }
 
 type JsonValue []byte
@@ -68,7 +253,7 @@ func (v JsonValue) MarshalJSON() ([]byte, error) {
 
 func getRecordId(field *ast.Field) (int, error) {
 	for _, arg := range field.Arguments {
-		if arg.Name.Value == "id" {
+		if arg.Name.Value == cIdArg {
 			if value, ok := arg.Value.(*ast.IntValue); ok {
 				return strconv.Atoi(value.GetValue().(string))
 			}
@@ -78,18 +263,133 @@ func getRecordId(field *ast.Field) (int, error) {
 	return 0, fmt.Errorf("no id arg")
 }
 
-func getDaoSelection(selectionSet *ast.SelectionSet) []dao.Selection {
+// getDaoSelection translates the selection set of a record of collection.
+// The arguments of inverse fields are checked against the collection they
+// read from.
+func getDaoSelection(
+	collections *collectionCache,
+	selectionSet *ast.SelectionSet,
+	collection *dao.Collection,
+) ([]dao.Selection, error) {
 	if selectionSet == nil {
-		return nil
+		return nil, nil
+	}
+	selection := []dao.Selection{}
+	for _, s := range selectionSet.Selections {
+		s, ok := s.(*ast.Field)
+		if !ok {
+			continue
+		}
+		daoSelection := dao.Selection{
+			Alias:     aliasValue(s),
+			FieldName: s.Name.Value,
+		}
+		if isHistoryField(collection, s.Name.Value) {
+			first, err := getHistoryFirst(s)
+			if err != nil {
+				return nil, err
+			}
+			daoSelection.Params = &dao.ListParams{First: first}
+			daoSelection.Subselections, err = getVersionSelection(collections, s.SelectionSet, collection)
+			if err != nil {
+				return nil, err
+			}
+			selection = append(selection, daoSelection)
+			continue
+		}
+		field := collection.Fields[s.Name.Value]
+		if field.Type == dao.BlobType && s.SelectionSet != nil {
+			// the blob fields are filled in by the completer
+			daoSelection.Subselections = getBlobSelection(s.SelectionSet)
+		}
+		if field.Ref > 0 && s.SelectionSet != nil {
+			refCollection, err := collections.get(field.Ref)
+			if err != nil {
+				return nil, err
+			}
+			if !field.IsStored() {
+				daoSelection.Params, err = getInverseParams(s, refCollection)
+				if err != nil {
+					return nil, err
+				}
+			}
+			daoSelection.Subselections, err = getDaoSelection(collections, s.SelectionSet, refCollection)
+			if err != nil {
+				return nil, err
+			}
+		}
+		selection = append(selection, daoSelection)
+	}
+	return selection, nil
+}
+
+// getConnectionSelection translates the selection set of a listX or searchX
+// root field, where the nodes are records of collection.
+func getConnectionSelection(
+	collections *collectionCache,
+	selectionSet *ast.SelectionSet,
+	collection *dao.Collection,
+) ([]dao.Selection, error) {
+	selection := []dao.Selection{}
+	for _, s := range selectionSet.Selections {
+		s, ok := s.(*ast.Field)
+		if !ok {
+			continue
+		}
+		daoSelection := dao.Selection{
+			Alias:     aliasValue(s),
+			FieldName: s.Name.Value,
+		}
+		if s.SelectionSet != nil {
+			for _, sub := range s.SelectionSet.Selections {
+				sub, ok := sub.(*ast.Field)
+				if !ok {
+					continue
+				}
+				subselection := dao.Selection{
+					Alias:     aliasValue(sub),
+					FieldName: sub.Name.Value,
+				}
+				if s.Name.Value == dao.ConnectionEdges && sub.Name.Value == dao.EdgeNode {
+					var err error
+					subselection.Subselections, err = getDaoSelection(collections, sub.SelectionSet, collection)
+					if err != nil {
+						return nil, err
+					}
+				}
+				daoSelection.Subselections = append(daoSelection.Subselections, subselection)
+			}
+		}
+		selection = append(selection, daoSelection)
 	}
+	return selection, nil
+}
+
+// getVersionSelection translates the selection set of a historyX root field
+// or a history field, where the nodes are records of collection.
+func getVersionSelection(
+	collections *collectionCache,
+	selectionSet *ast.SelectionSet,
+	collection *dao.Collection,
+) ([]dao.Selection, error) {
 	selection := []dao.Selection{}
 	for _, s := range selectionSet.Selections {
-		if s, ok := s.(*ast.Field); ok {
-			selection = append(selection, dao.Selection{
-				FieldName:     s.Name.Value,
-				Subselections: getDaoSelection(s.GetSelectionSet()),
-			})
+		s, ok := s.(*ast.Field)
+		if !ok {
+			continue
+		}
+		daoSelection := dao.Selection{
+			Alias:     aliasValue(s),
+			FieldName: s.Name.Value,
+		}
+		if s.Name.Value == dao.HistoryNode {
+			var err error
+			daoSelection.Subselections, err = getDaoSelection(collections, s.SelectionSet, collection)
+			if err != nil {
+				return nil, err
+			}
 		}
+		selection = append(selection, daoSelection)
 	}
-	return selection
+	return selection, nil
 }
@@ -0,0 +1,1056 @@
+package graphql_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sashankg/hold/blobs"
+	"github.com/sashankg/hold/dao"
+	"github.com/sashankg/hold/graphql"
+	"github.com/sashankg/hold/p2p"
+	"github.com/sashankg/hold/testing/util"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestResolver(t *testing.T) graphql.Resolver {
+	testDao := util.NewMemoryDao(t)
+	doc, err := parseGraphql(`
+		type Post {
+			title: String
+			views: Int
+			author: Person
+			tags: [String]
+		}
+		type Person {
+			name: String
+			friends: [Person]
+			posts: [Post] @inverse(of: "author")
+		}
+	`)
+	require.NoError(t, err)
+	_, err = graphql.NewRegistrar(testDao).RegisterSchema(context.Background(), doc, graphql.RegisterOptions{})
+	require.NoError(t, err)
+
+	_, err = testDao.RecordDb.Exec(`
+		INSERT INTO Person (id, name) VALUES (1, 'ada'), (2, 'grace');
+		INSERT INTO Post (id, title, views, author) VALUES
+			(1, 'first', 10, 1),
+			(2, 'second', 30, 2),
+			(3, 'third', 20, 1),
+			(4, 'fourth', 0, 2);
+		INSERT INTO Post_tags (owner, position, value) VALUES
+			(1, 1, 'news'),
+			(1, 0, 'go');
+		INSERT INTO Person_friends (owner, position, value) VALUES
+			(1, 0, 2);
+	`)
+	require.NoError(t, err)
+	return newResolver(t, testDao)
+}
+
+func newResolver(t *testing.T, testDao dao.Dao) graphql.Resolver {
+	blobStore, err := blobs.NewStore(util.NewMemoryDao(t).SchemaDb, t.TempDir(), &blobs.Sha256Hasher{})
+	require.NoError(t, err)
+	return graphql.NewResolver(testDao, blobStore)
+}
+
+func resolve(t *testing.T, resolver graphql.Resolver, query string) string {
+	doc, err := parseGraphql(query)
+	require.NoError(t, err)
+	result, err := resolver.Resolve(context.Background(), doc)
+	require.NoError(t, err)
+	return string(result)
+}
+
+func TestResolveFind(t *testing.T) {
+	resolver := newTestResolver(t)
+
+	require.JSONEq(t, `{
+		"findPost": {"id": 2, "title": "second", "author": {"name": "grace"}}
+	}`, resolve(t, resolver, `
+		query {
+			findPost(id: 2) {
+				id
+				title
+				author {
+					name
+				}
+			}
+		}
+	`))
+}
+
+func TestResolveTypename(t *testing.T) {
+	resolver := newTestResolver(t)
+
+	require.JSONEq(t, `{
+		"findPerson": {
+			"__typename": "Person",
+			"friends": [{"kind": "Person"}],
+			"posts": [{"__typename": "Post", "author": {"__typename": "Person"}}]
+		},
+		"listPost": {"edges": [{"node": {"__typename": "Post", "title": "first"}}]}
+	}`, resolve(t, resolver, `
+		query {
+			findPerson(id: 1) {
+				__typename
+				friends {
+					kind: __typename
+				}
+				posts(first: 1) {
+					__typename
+					author {
+						__typename
+					}
+				}
+			}
+			listPost(first: 1) {
+				edges {
+					node {
+						__typename
+						title
+					}
+				}
+			}
+		}
+	`))
+}
+
+func TestResolveList(t *testing.T) {
+	resolver := newTestResolver(t)
+
+	firstPage := resolve(t, resolver, `
+		query {
+			listPost(where: { views: { gt: 0 } }, orderBy: { views: DESC }, first: 2) {
+				edges {
+					node {
+						title
+						author {
+							name
+						}
+					}
+				}
+				pageInfo {
+					hasNextPage
+					endCursor
+				}
+			}
+		}
+	`)
+	var connection struct {
+		ListPost struct {
+			Edges []struct {
+				Node struct {
+					Title string
+				}
+			}
+			PageInfo struct {
+				HasNextPage bool
+				EndCursor   string
+			}
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(firstPage), &connection))
+	require.Len(t, connection.ListPost.Edges, 2)
+	require.Equal(t, "second", connection.ListPost.Edges[0].Node.Title)
+	require.Equal(t, "third", connection.ListPost.Edges[1].Node.Title)
+	require.True(t, connection.ListPost.PageInfo.HasNextPage)
+	require.NotEmpty(t, connection.ListPost.PageInfo.EndCursor)
+
+	require.JSONEq(t, `{
+		"listPost": {
+			"edges": [{"node": {"title": "first", "author": {"name": "ada"}}}],
+			"pageInfo": {"hasNextPage": false}
+		}
+	}`, resolve(t, resolver, `
+		query {
+			listPost(
+				where: { views: { gt: 0 } },
+				orderBy: { views: DESC },
+				first: 2,
+				after: "`+connection.ListPost.PageInfo.EndCursor+`"
+			) {
+				edges {
+					node {
+						title
+						author {
+							name
+						}
+					}
+				}
+				pageInfo {
+					hasNextPage
+				}
+			}
+		}
+	`))
+}
+
+func TestResolveListFilters(t *testing.T) {
+	resolver := newTestResolver(t)
+
+	require.JSONEq(t, `{
+		"listPost": {
+			"edges": [
+				{"node": {"id": 1}},
+				{"node": {"id": 4}}
+			]
+		}
+	}`, resolve(t, resolver, `
+		query {
+			listPost(where: { or: [{ title: { like: "f%" } }, { views: { in: [0, 10] } }] }) {
+				edges {
+					node {
+						id
+					}
+				}
+			}
+		}
+	`))
+}
+
+func TestResolveSet(t *testing.T) {
+	resolver := newTestResolver(t)
+
+	require.JSONEq(t, `{
+		"setPost": {"id": 5, "title": "fifth", "views": 1, "author": {"name": "ada"}}
+	}`, resolve(t, resolver, `
+		mutation {
+			setPost(input: { title: "fifth", views: 1, author: 1 }) {
+				id
+				title
+				views
+				author {
+					name
+				}
+			}
+		}
+	`))
+
+	require.JSONEq(t, `{
+		"setPost": {"id": 2, "title": "replaced", "views": null}
+	}`, resolve(t, resolver, `
+		mutation {
+			setPost(input: { id: 2, title: "replaced" }) {
+				id
+				title
+				views
+			}
+		}
+	`))
+}
+
+func TestResolvePatch(t *testing.T) {
+	resolver := newTestResolver(t)
+
+	require.JSONEq(t, `{
+		"patchPost": {"id": 3, "title": "third", "views": 21}
+	}`, resolve(t, resolver, `
+		mutation {
+			patchPost(id: 3, input: { views: 21 }) {
+				id
+				title
+				views
+			}
+		}
+	`))
+
+	doc, err := parseGraphql(`
+		mutation {
+			patchPost(id: 100, input: { views: 21 }) {
+				id
+			}
+		}
+	`)
+	require.NoError(t, err)
+	_, err = resolver.Resolve(context.Background(), doc)
+	require.Error(t, err)
+}
+
+func TestResolvePatchNull(t *testing.T) {
+	resolver := newTestResolver(t)
+	patch := func(variables map[string]any) (string, error) {
+		doc, err := parseGraphql(`
+			mutation Patch($title: String, $where: PostWhere) {
+				patchPost(id: 3, input: { title: $title, views: 21 }) {
+					title
+					views
+				}
+				listPost(where: $where) {
+					edges {
+						node {
+							id
+						}
+					}
+				}
+			}
+		`)
+		require.NoError(t, err)
+		doc, err = graphql.PrepareOperation(doc, "Patch", variables)
+		if err != nil {
+			return "", err
+		}
+		result, err := resolver.Resolve(context.Background(), doc)
+		return string(result), err
+	}
+
+	// a variable without a value leaves the field as it is
+	result, err := patch(map[string]any{"where": map[string]any{"id": map[string]any{"eq": float64(3)}}})
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"patchPost": {"title": "third", "views": 21},
+		"listPost": {"edges": [{"node": {"id": 3}}]}
+	}`, result)
+
+	// a null variable sets it to null, and a null filter is left out
+	result, err = patch(map[string]any{
+		"title": nil,
+		"where": map[string]any{"id": map[string]any{"eq": float64(3)}, "title": nil},
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"patchPost": {"title": null, "views": 21},
+		"listPost": {"edges": [{"node": {"id": 3}}]}
+	}`, result)
+}
+
+func TestResolveListFields(t *testing.T) {
+	resolver := newTestResolver(t)
+
+	require.JSONEq(t, `{
+		"findPost": {"tags": ["go", "news"], "author": {"name": "ada", "friends": [{"name": "grace"}]}}
+	}`, resolve(t, resolver, `
+		query {
+			findPost(id: 1) {
+				tags
+				author {
+					name
+					friends {
+						name
+					}
+				}
+			}
+		}
+	`))
+
+	require.JSONEq(t, `{
+		"setPerson": {"id": 3, "name": "linus", "friends": [{"name": "grace"}, {"name": "ada"}]}
+	}`, resolve(t, resolver, `
+		mutation {
+			setPerson(input: { name: "linus", friends: [2, 1] }) {
+				id
+				name
+				friends {
+					name
+				}
+			}
+		}
+	`))
+
+	require.JSONEq(t, `{
+		"patchPost": {"title": "first", "tags": ["rust"]}
+	}`, resolve(t, resolver, `
+		mutation {
+			patchPost(id: 1, input: { tags: ["rust"] }) {
+				title
+				tags
+			}
+		}
+	`))
+
+	require.JSONEq(t, `{
+		"setPost": {"id": 1, "tags": []}
+	}`, resolve(t, resolver, `
+		mutation {
+			setPost(input: { id: 1, title: "first" }) {
+				id
+				tags
+			}
+		}
+	`))
+}
+
+func TestResolveNonNull(t *testing.T) {
+	testDao := util.NewMemoryDao(t)
+	doc, err := parseGraphql(`
+		type Book {
+			title: String!
+			author: Author!
+			editor: Author
+		}
+		type Author {
+			name: String!
+		}
+	`)
+	require.NoError(t, err)
+	_, err = graphql.NewRegistrar(testDao).RegisterSchema(context.Background(), doc, graphql.RegisterOptions{})
+	require.NoError(t, err)
+	resolver := newResolver(t, testDao)
+
+	_, err = testDao.RecordDb.Exec(`INSERT INTO Book (id, title) VALUES (1, NULL)`)
+	require.Error(t, err)
+	_, err = testDao.RecordDb.Exec(`
+		INSERT INTO Author (id, name) VALUES (1, 'ursula');
+		INSERT INTO Book (id, title, author, editor) VALUES (1, 'earthsea', 1, 99), (2, 'dispossessed', 99, 1);
+	`)
+	require.NoError(t, err)
+
+	// a nullable field resolves to null without an error
+	require.JSONEq(t, `{
+		"findBook": {"title": "earthsea", "editor": null}
+	}`, resolve(t, resolver, `
+		query {
+			findBook(id: 1) {
+				title
+				editor {
+					name
+				}
+			}
+		}
+	`))
+
+	// a null in a non-null field makes its parent null
+	doc, err = parseGraphql(`
+		query {
+			findBook(id: 2) {
+				title
+				author {
+					name
+				}
+			}
+		}
+	`)
+	require.NoError(t, err)
+	result, err := resolver.Resolve(context.Background(), doc)
+	var fieldErrors graphql.Errors
+	require.ErrorAs(t, err, &fieldErrors)
+	require.JSONEq(t, `{"findBook": null}`, string(result))
+	require.Len(t, fieldErrors, 1)
+	require.Equal(t, []any{"findBook", "author"}, fieldErrors[0].Path)
+
+	doc, err = parseGraphql(`
+		mutation {
+			setBook(input: { author: 1 }) {
+				title
+			}
+		}
+	`)
+	require.NoError(t, err)
+	result, err = resolver.Resolve(context.Background(), doc)
+	require.ErrorAs(t, err, &fieldErrors)
+	require.JSONEq(t, `{"setBook": null}`, string(result))
+	require.Equal(t, graphql.ErrorCodeBadUserInput, fieldErrors[0].Extensions.Code)
+
+	// a null input field is a value, so it cannot be given to a non-null field
+	doc, err = parseGraphql(`
+		mutation Patch($input: BookInput) {
+			patchBook(id: 1, input: $input) {
+				title
+			}
+		}
+	`)
+	require.NoError(t, err)
+	doc, err = graphql.PrepareOperation(doc, "Patch", map[string]any{"input": map[string]any{"title": nil}})
+	require.NoError(t, err)
+	result, err = resolver.Resolve(context.Background(), doc)
+	require.ErrorAs(t, err, &fieldErrors)
+	require.JSONEq(t, `{"patchBook": null}`, string(result))
+	require.Equal(t, graphql.ErrorCodeBadUserInput, fieldErrors[0].Extensions.Code)
+	require.JSONEq(t, `{
+		"findBook": {"title": "earthsea"}
+	}`, resolve(t, resolver, `
+		query {
+			findBook(id: 1) {
+				title
+			}
+		}
+	`))
+}
+
+func TestResolveErrors(t *testing.T) {
+	resolver := newTestResolver(t)
+
+	doc, err := parseGraphql(`
+		query {
+			missing: findPost(id: 99) {
+				title
+			}
+			findPost(id: 1) {
+				title
+			}
+		}
+	`)
+	require.NoError(t, err)
+	result, err := resolver.Resolve(context.Background(), doc)
+	var resolveErrors graphql.Errors
+	require.ErrorAs(t, err, &resolveErrors)
+	require.JSONEq(t, `{"missing": null, "findPost": {"title": "first"}}`, string(result))
+	errorsJson, err := json.Marshal(resolveErrors)
+	require.NoError(t, err)
+	require.JSONEq(t, `[{
+		"message": "record not found",
+		"locations": [{"line": 3, "column": 4}],
+		"path": ["missing"],
+		"extensions": {"code": "NOT_FOUND"}
+	}]`, string(errorsJson))
+
+	// a failing connection makes all of data null
+	doc, err = parseGraphql(`
+		query {
+			listPost(after: "not a cursor") {
+				edges {
+					cursor
+				}
+			}
+			findPost(id: 1) {
+				title
+			}
+		}
+	`)
+	require.NoError(t, err)
+	result, err = resolver.Resolve(context.Background(), doc)
+	require.ErrorAs(t, err, &resolveErrors)
+	require.Equal(t, "null", string(result))
+	require.Equal(t, []any{"listPost"}, resolveErrors[0].Path)
+
+	// a reference to a record that does not exist
+	doc, err = parseGraphql(`
+		mutation {
+			patchPost(id: 1, input: { author: 99 }) {
+				title
+			}
+		}
+	`)
+	require.NoError(t, err)
+	result, err = resolver.Resolve(context.Background(), doc)
+	require.ErrorAs(t, err, &resolveErrors)
+	require.JSONEq(t, `{"patchPost": null}`, string(result))
+	require.Equal(t, graphql.ErrorCodeBadUserInput, resolveErrors[0].Extensions.Code)
+}
+
+func TestResolveAliasesAndFragments(t *testing.T) {
+	resolver := newTestResolver(t)
+
+	doc, err := parseGraphql(`
+		query Other {
+			findPerson(id: 1) {
+				name
+			}
+		}
+		query Posts($id: Int!, $first: Int = 1, $order: [PostOrderBy!], $withAuthor: Boolean!) {
+			first: findPost(id: $id) {
+				...PostFields
+			}
+			second: findPost(id: 2) {
+				heading: title
+				... on Post {
+					author @include(if: $withAuthor) {
+						name
+					}
+				}
+			}
+			listPost(first: $first, orderBy: $order) {
+				edges {
+					node {
+						...PostFields
+					}
+				}
+				top: edges {
+					post: node {
+						views
+					}
+				}
+			}
+		}
+		fragment PostFields on Post {
+			title
+			views
+			title
+		}
+	`)
+	require.NoError(t, err)
+	doc, err = graphql.PrepareOperation(doc, "Posts", map[string]any{
+		"id":         float64(1),
+		"order":      []any{map[string]any{"views": "DESC"}},
+		"withAuthor": false,
+	})
+	require.NoError(t, err)
+	result, err := resolver.Resolve(context.Background(), doc)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"first": {"title": "first", "views": 10},
+		"second": {"heading": "second"},
+		"listPost": {
+			"edges": [{"node": {"title": "second", "views": 30}}],
+			"top": [{"post": {"views": 30}}]
+		}
+	}`, string(result))
+}
+
+func TestResolveInverseFields(t *testing.T) {
+	resolver := newTestResolver(t)
+
+	require.JSONEq(t, `{
+		"findPerson": {
+			"name": "ada",
+			"posts": [{"title": "first"}, {"title": "third"}],
+			"top": [{"title": "third", "author": {"name": "ada"}}]
+		},
+		"listPerson": {
+			"edges": [
+				{"node": {"posts": [{"id": 2}, {"id": 4}]}}
+			]
+		}
+	}`, resolve(t, resolver, `
+		query {
+			findPerson(id: 1) {
+				name
+				posts {
+					title
+				}
+				top: posts(where: { views: { gt: 0 } }, orderBy: { views: DESC }, first: 1) {
+					title
+					author {
+						name
+					}
+				}
+			}
+			listPerson(where: { name: { eq: "grace" } }) {
+				edges {
+					node {
+						posts {
+							id
+						}
+					}
+				}
+			}
+		}
+	`))
+
+	doc, err := parseGraphql(`
+		mutation {
+			setPerson(input: { name: "linus", posts: [1] }) {
+				id
+			}
+		}
+	`)
+	require.NoError(t, err)
+	_, err = resolver.Resolve(context.Background(), doc)
+	var resolveErrors graphql.Errors
+	require.ErrorAs(t, err, &resolveErrors)
+	require.Equal(t, graphql.ErrorCodeBadUserInput, resolveErrors[0].Extensions.Code)
+}
+
+func TestResolveDelete(t *testing.T) {
+	testDao := util.NewMemoryDao(t)
+	doc, err := parseGraphql(`
+		type Author {
+			name: String
+		}
+		type Book {
+			title: String
+			author: Author! @onDelete(action: CASCADE)
+			editor: Author
+			reviewers: [Author]
+		}
+		type Review {
+			book: Book! @onDelete(action: RESTRICT)
+			critic: Author @onDelete(action: CASCADE)
+		}
+	`)
+	require.NoError(t, err)
+	_, err = graphql.NewRegistrar(testDao).RegisterSchema(context.Background(), doc, graphql.RegisterOptions{})
+	require.NoError(t, err)
+	resolver := newResolver(t, testDao)
+	_, err = testDao.RecordDb.Exec(`
+		INSERT INTO Author (id, name) VALUES (1, 'ursula'), (2, 'octavia'), (3, 'ted');
+		INSERT INTO Book (id, title, author, editor) VALUES (1, 'earthsea', 1, 2), (2, 'kindred', 2, 3);
+		INSERT INTO Book_reviewers (owner, position, value) VALUES (2, 0, 3), (2, 1, 2), (1, 0, 2);
+		INSERT INTO Review (id, book) VALUES (1, 2);
+	`)
+	require.NoError(t, err)
+
+	// references set to null, and removed from lists
+	require.JSONEq(t, `{
+		"deleteAuthor": {"id": 3, "name": "ted"}
+	}`, resolve(t, resolver, `
+		mutation {
+			deleteAuthor(id: 3) {
+				id
+				name
+			}
+		}
+	`))
+	require.JSONEq(t, `{
+		"findBook": {"editor": null, "reviewers": [{"name": "octavia"}]}
+	}`, resolve(t, resolver, `
+		query {
+			findBook(id: 2) {
+				editor {
+					name
+				}
+				reviewers {
+					name
+				}
+			}
+		}
+	`))
+
+	// the book of octavia is still reviewed
+	doc, err = parseGraphql(`
+		mutation {
+			deleteAuthor(id: 2) {
+				id
+			}
+		}
+	`)
+	require.NoError(t, err)
+	result, err := resolver.Resolve(context.Background(), doc)
+	var resolveErrors graphql.Errors
+	require.ErrorAs(t, err, &resolveErrors)
+	require.JSONEq(t, `{"deleteAuthor": null}`, string(result))
+	require.Equal(t, graphql.ErrorCodeBadUserInput, resolveErrors[0].Extensions.Code)
+
+	// deleting ursula deletes her book
+	resolve(t, resolver, `
+		mutation {
+			deleteAuthor(id: 1) {
+				id
+			}
+		}
+	`)
+	var count int
+	require.NoError(t, testDao.RecordDb.QueryRow(`SELECT COUNT(*) FROM Book`).Scan(&count))
+	require.Equal(t, 1, count)
+	require.NoError(t, testDao.RecordDb.QueryRow(`SELECT COUNT(*) FROM Book_reviewers`).Scan(&count))
+	require.Equal(t, 1, count)
+
+	doc, err = parseGraphql(`
+		mutation {
+			deleteAuthor(id: 1) {
+				id
+			}
+		}
+	`)
+	require.NoError(t, err)
+	_, err = resolver.Resolve(context.Background(), doc)
+	require.ErrorAs(t, err, &resolveErrors)
+	require.Equal(t, graphql.ErrorCodeNotFound, resolveErrors[0].Extensions.Code)
+
+	// a review that restricts the delete of a book does not when the same
+	// cascade deletes it
+	_, err = testDao.RecordDb.Exec(`
+		INSERT INTO Author (id, name) VALUES (4, 'nk');
+		INSERT INTO Book (id, title, author) VALUES (3, 'broken earth', 4);
+		INSERT INTO Review (id, book, critic) VALUES (2, 3, 4);
+	`)
+	require.NoError(t, err)
+	resolve(t, resolver, `
+		mutation {
+			deleteAuthor(id: 4) {
+				id
+			}
+		}
+	`)
+	require.NoError(t, testDao.RecordDb.QueryRow(`SELECT COUNT(*) FROM Review`).Scan(&count))
+	require.Equal(t, 1, count)
+}
+
+func TestResolveHistory(t *testing.T) {
+	resolver := newTestResolver(t)
+	ctx := context.Background()
+
+	resolve(t, resolver, `
+		mutation {
+			setPost(input: {title: "draft", author: 1, tags: ["go"]}) {
+				id
+			}
+		}
+	`)
+	time.Sleep(10 * time.Millisecond)
+	drafted := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	// patched by a peer
+	doc, err := parseGraphql(`
+		mutation {
+			patchPost(id: 5, input: {title: "published", author: 2}) {
+				id
+			}
+		}
+	`)
+	require.NoError(t, err)
+	editor := peer.ID("editor")
+	_, err = resolver.Resolve(p2p.WithPeer(ctx, editor), doc)
+	require.NoError(t, err)
+
+	require.JSONEq(t, `{
+		"historyPost": [
+			{"version": 2, "operation": "PATCH", "peer": "`+editor.String()+`", "node": {
+				"id": 5, "title": "published", "tags": ["go"], "author": {"name": "grace"}
+			}},
+			{"version": 1, "operation": "SET", "peer": null, "node": {
+				"id": 5, "title": "draft", "tags": ["go"], "author": {"name": "ada"}
+			}}
+		]
+	}`, resolve(t, resolver, `
+		query {
+			historyPost(id: 5) {
+				version
+				operation
+				peer
+				node {
+					id
+					title
+					tags
+					author {
+						name
+					}
+				}
+			}
+		}
+	`))
+	require.JSONEq(t, `{
+		"historyPost": [{"operation": "PATCH"}]
+	}`, resolve(t, resolver, `
+		query {
+			historyPost(id: 5, first: 1) {
+				operation
+			}
+		}
+	`))
+
+	require.JSONEq(t, `{
+		"findPost": {"title": "draft", "author": {"name": "ada"}}
+	}`, resolve(t, resolver, `
+		query {
+			findPost(id: 5, asOf: "`+drafted.Format(time.RFC3339Nano)+`") {
+				title
+				author {
+					name
+				}
+			}
+		}
+	`))
+
+	// the history of a record can also be selected from the record itself
+	require.JSONEq(t, `{
+		"findPost": {"title": "published", "history": [
+			{"operation": "PATCH", "node": {"title": "published"}}
+		]}
+	}`, resolve(t, resolver, `
+		query {
+			findPost(id: 5) {
+				title
+				history(first: 1) {
+					operation
+					node {
+						title
+					}
+				}
+			}
+		}
+	`))
+
+	// deleting the record purges its history
+	resolve(t, resolver, `
+		mutation {
+			deletePost(id: 5) {
+				id
+			}
+		}
+	`)
+	require.JSONEq(t, `{"historyPost": []}`, resolve(t, resolver, `
+		query {
+			historyPost(id: 5) {
+				version
+			}
+		}
+	`))
+
+	// before the record was set, while it existed, and after it was deleted
+	for _, asOf := range []time.Time{drafted.Add(-time.Hour), drafted, time.Now()} {
+		doc, err = parseGraphql(`
+			query {
+				findPost(id: 5, asOf: "` + asOf.Format(time.RFC3339Nano) + `") {
+					title
+				}
+			}
+		`)
+		require.NoError(t, err)
+		result, err := resolver.Resolve(ctx, doc)
+		var resolveErrors graphql.Errors
+		require.ErrorAs(t, err, &resolveErrors)
+		require.JSONEq(t, `{"findPost": null}`, string(result))
+		require.Equal(t, graphql.ErrorCodeNotFound, resolveErrors[0].Extensions.Code)
+	}
+}
+
+func TestResolveSearch(t *testing.T) {
+	util.SkipWithoutSearch(t)
+	testDao := util.NewMemoryDao(t)
+	doc, err := parseGraphql(`
+		type Note {
+			title: String @searchable
+			body: String @searchable
+			views: Int
+		}
+	`)
+	require.NoError(t, err)
+	_, err = graphql.NewRegistrar(testDao).RegisterSchema(context.Background(), doc, graphql.RegisterOptions{})
+	require.NoError(t, err)
+	resolver := newResolver(t, testDao)
+	resolve(t, resolver, `
+		mutation {
+			a: setNote(input: {title: "groceries", body: "buy milk and eggs"}) { id }
+			b: setNote(input: {title: "milk", body: "oat milk is milk too"}) { id }
+			c: setNote(input: {title: "travel", body: "pack the bags"}) { id }
+		}
+	`)
+	search := func(args string) map[string]any {
+		var result map[string]any
+		require.NoError(t, json.Unmarshal([]byte(resolve(t, resolver, `
+			query {
+				searchNote(`+args+`) {
+					edges {
+						cursor
+						rank
+						snippet
+						node {
+							id
+							title
+						}
+					}
+					pageInfo {
+						hasNextPage
+						endCursor
+					}
+				}
+			}
+		`)), &result))
+		return result["searchNote"].(map[string]any)
+	}
+	ids := func(connection map[string]any) []float64 {
+		ids := []float64{}
+		for _, edge := range connection["edges"].([]any) {
+			ids = append(ids, edge.(map[string]any)["node"].(map[string]any)["id"].(float64))
+		}
+		return ids
+	}
+
+	// the note about milk ranks first
+	first := search(`query: "milk", first: 1`)
+	edge := first["edges"].([]any)[0].(map[string]any)
+	require.Equal(t, []float64{2}, ids(first))
+	require.Less(t, edge["rank"], 0.0)
+	require.Contains(t, edge["snippet"], "<mark>milk</mark>")
+	pageInfo := first["pageInfo"].(map[string]any)
+	require.Equal(t, true, pageInfo["hasNextPage"])
+	next := search(`query: "milk", after: "` + pageInfo["endCursor"].(string) + `"`)
+	require.Equal(t, []float64{1}, ids(next))
+	require.Equal(t, false, next["pageInfo"].(map[string]any)["hasNextPage"])
+
+	// the index follows patches, replacements and deletes
+	resolve(t, resolver, `
+		mutation {
+			patchNote(id: 3, input: {body: "pack milk"}) { id }
+		}
+	`)
+	resolve(t, resolver, `
+		mutation {
+			setNote(input: {id: 1, title: "groceries", body: "buy bread"}) { id }
+		}
+	`)
+	resolve(t, resolver, `
+		mutation {
+			deleteNote(id: 2) { id }
+		}
+	`)
+	require.Equal(t, []float64{3}, ids(search(`query: "milk"`)))
+	require.Equal(t, []float64{1}, ids(search(`query: "title: groc*"`)))
+
+	// the text of snippets is escaped HTML
+	resolve(t, resolver, `
+		mutation {
+			setNote(input: {title: "xss", body: "<script>alert('tea & milk')</script>"}) { id }
+		}
+	`)
+	edge = search(`query: "alert"`)["edges"].([]any)[0].(map[string]any)
+	require.Equal(t, "&lt;script&gt;<mark>alert</mark>(&#39;tea &amp; milk&#39;)&lt;/script&gt;", edge["snippet"])
+
+	doc, err = parseGraphql(`
+		query {
+			searchNote(query: "\"milk") {
+				edges {
+					cursor
+				}
+			}
+		}
+	`)
+	require.NoError(t, err)
+	result, err := resolver.Resolve(context.Background(), doc)
+	var resolveErrors graphql.Errors
+	require.ErrorAs(t, err, &resolveErrors)
+	require.Equal(t, `null`, string(result))
+	require.Equal(t, graphql.ErrorCodeBadUserInput, resolveErrors[0].Extensions.Code)
+}
+
+func TestResolveBlobs(t *testing.T) {
+	testDao := util.NewMemoryDao(t)
+	doc, err := parseGraphql(`
+		type Post {
+			photo: Blob
+			attachments: [Blob]
+		}
+	`)
+	require.NoError(t, err)
+	_, err = graphql.NewRegistrar(testDao).RegisterSchema(context.Background(), doc, graphql.RegisterOptions{})
+	require.NoError(t, err)
+	blobStore, err := blobs.NewStore(testDao.SchemaDb, t.TempDir(), &blobs.Sha256Hasher{})
+	require.NoError(t, err)
+	resolver := graphql.NewResolver(testDao, blobStore)
+	blob, err := blobStore.Put(context.Background(), strings.NewReader("hello world"), "")
+	require.NoError(t, err)
+
+	require.JSONEq(t, `{
+		"setPost": {
+			"photo": {
+				"digest": "`+blob.Digest+`",
+				"size": 11,
+				"type": "text/plain; charset=utf-8",
+				"url": "/blob/`+blob.Digest+`"
+			},
+			"attachments": [{"size": 11}]
+		}
+	}`, resolve(t, resolver, `
+		mutation {
+			setPost(input: { photo: "`+blob.Digest+`", attachments: ["`+blob.Digest+`"] }) {
+				photo {
+					digest
+					size
+					type: mimeType
+					url
+				}
+				attachments {
+					size
+				}
+			}
+		}
+	`))
+
+	doc, err = parseGraphql(`
+		mutation {
+			setPost(input: { photo: "` + strings.Repeat("0", 64) + `" }) {
+				id
+			}
+		}
+	`)
+	require.NoError(t, err)
+	_, err = resolver.Resolve(context.Background(), doc)
+	var resolveErrors graphql.Errors
+	require.ErrorAs(t, err, &resolveErrors)
+	require.Equal(t, graphql.ErrorCodeBadUserInput, resolveErrors[0].Extensions.Code)
+}
@@ -0,0 +1,529 @@
+package graphql
+
+import (
+	"context"
+	"sort"
+
+	gql "github.com/graphql-go/graphql"
+	"github.com/sashankg/hold/core"
+	"github.com/sashankg/hold/dao"
+)
+
+// collectionSchema describes the registered collections as GraphQL types and
+// root fields. It is only used to answer introspection queries, records are
+// still resolved by Resolver.
+type collectionSchema struct {
+	collections   []*dao.Collection
+	objects       map[int]*gql.Object
+	versions      map[int]*gql.Object
+	filters       map[string]*gql.InputObject
+	wheres        map[int]*gql.InputObject
+	orderBys      map[int]*gql.InputObject
+	types         []gql.Type
+	queries       gql.Fields
+	mutations     gql.Fields
+	subscriptions gql.Fields
+}
+
+var (
+	_ core.QueryResolver        = (*collectionSchema)(nil)
+	_ core.MutationResolver     = (*collectionSchema)(nil)
+	_ core.SubscriptionResolver = (*collectionSchema)(nil)
+	_ core.TypeSource           = (*collectionSchema)(nil)
+)
+
+var sortDirectionEnum = gql.NewEnum(gql.EnumConfig{
+	Name: "SortDirection",
+	Values: gql.EnumValueConfigMap{
+		"ASC":  &gql.EnumValueConfig{Value: "ASC"},
+		"DESC": &gql.EnumValueConfig{Value: "DESC"},
+	},
+})
+
+var changeOperationEnum = gql.NewEnum(gql.EnumConfig{
+	Name: "ChangeOperation",
+	Values: gql.EnumValueConfigMap{
+		string(dao.ChangeSet):    &gql.EnumValueConfig{Value: string(dao.ChangeSet)},
+		string(dao.ChangePatch):  &gql.EnumValueConfig{Value: string(dao.ChangePatch)},
+		string(dao.ChangeDelete): &gql.EnumValueConfig{Value: string(dao.ChangeDelete)},
+	},
+})
+
+var pageInfoObject = gql.NewObject(gql.ObjectConfig{
+	Name: "PageInfo",
+	Fields: gql.Fields{
+		"hasNextPage":     &gql.Field{Type: gql.NewNonNull(gql.Boolean)},
+		"hasPreviousPage": &gql.Field{Type: gql.NewNonNull(gql.Boolean)},
+		"startCursor":     &gql.Field{Type: gql.String},
+		"endCursor":       &gql.Field{Type: gql.String},
+	},
+})
+
+var blobObject = gql.NewObject(gql.ObjectConfig{
+	Name: dao.BlobType,
+	Fields: gql.Fields{
+		cBlobDigestField:   &gql.Field{Type: gql.NewNonNull(gql.String)},
+		cBlobSizeField:     &gql.Field{Type: gql.NewNonNull(gql.Int)},
+		cBlobMimeTypeField: &gql.Field{Type: gql.NewNonNull(gql.String)},
+		cBlobUrlField:      &gql.Field{Type: gql.NewNonNull(gql.String)},
+	},
+})
+
+var scalarTypes = map[string]*gql.Scalar{
+	"Int":     gql.Int,
+	"Float":   gql.Float,
+	"String":  gql.String,
+	"Boolean": gql.Boolean,
+	"ID":      gql.ID,
+}
+
+func newCollectionSchema(collections []*dao.Collection) *collectionSchema {
+	s := &collectionSchema{
+		objects:       map[int]*gql.Object{},
+		versions:      map[int]*gql.Object{},
+		filters:       map[string]*gql.InputObject{},
+		wheres:        map[int]*gql.InputObject{},
+		orderBys:      map[int]*gql.InputObject{},
+		queries:       gql.Fields{},
+		mutations:     gql.Fields{},
+		subscriptions: gql.Fields{},
+	}
+	// root fields are named after the collection only, so a collection in a
+	// namespace is left out if another collection already has its name
+	names := map[string]bool{}
+	for _, collection := range collections {
+		if names[collection.Name] {
+			continue
+		}
+		names[collection.Name] = true
+		s.collections = append(s.collections, collection)
+	}
+	for _, collection := range s.collections {
+		s.objects[collection.Id] = s.newObject(collection)
+	}
+	for _, collection := range s.collections {
+		s.addRootFields(collection)
+	}
+	return s
+}
+
+// QueryFields implements core.QueryResolver.
+func (s *collectionSchema) QueryFields() gql.Fields {
+	return s.queries
+}
+
+// MutationFields implements core.MutationResolver.
+func (s *collectionSchema) MutationFields() gql.Fields {
+	return s.mutations
+}
+
+// SubscriptionFields implements core.SubscriptionResolver.
+func (s *collectionSchema) SubscriptionFields() gql.Fields {
+	return s.subscriptions
+}
+
+// Types implements core.TypeSource.
+func (s *collectionSchema) Types() []gql.Type {
+	return s.types
+}
+
+func (s *collectionSchema) newObject(collection *dao.Collection) *gql.Object {
+	object := gql.NewObject(gql.ObjectConfig{
+		Name: collection.Name,
+		// fields are a thunk because collections can reference each other
+		Fields: gql.FieldsThunk(func() gql.Fields {
+			fields := gql.Fields{
+				dao.IdField: &gql.Field{Type: gql.NewNonNull(gql.Int)},
+			}
+			if isHistoryField(collection, dao.HistoryField) {
+				fields[dao.HistoryField] = &gql.Field{
+					Type: gql.NewNonNull(gql.NewList(gql.NewNonNull(s.versions[collection.Id]))),
+					Args: gql.FieldConfigArgument{
+						cFirstArg: &gql.ArgumentConfig{Type: gql.Int},
+					},
+				}
+			}
+			for _, field := range sortedFields(collection) {
+				fieldType := s.outputType(field)
+				if fieldType == nil {
+					continue
+				}
+				fields[field.Name] = &gql.Field{
+					Type:              fieldType,
+					DeprecationReason: field.DeprecationReason,
+				}
+				if !field.IsStored() {
+					fields[field.Name].Args = s.inverseArgs(field)
+				}
+			}
+			return fields
+		}),
+	})
+	s.types = append(s.types, object)
+	return object
+}
+
+func (s *collectionSchema) addRootFields(collection *dao.Collection) {
+	object := s.objects[collection.Id]
+	edge := gql.NewObject(gql.ObjectConfig{
+		Name: collection.Name + "Edge",
+		Fields: gql.Fields{
+			dao.EdgeCursor: &gql.Field{Type: gql.NewNonNull(gql.String)},
+			dao.EdgeNode:   &gql.Field{Type: object},
+		},
+	})
+	connection := gql.NewObject(gql.ObjectConfig{
+		Name: collection.Name + "Connection",
+		Fields: gql.Fields{
+			dao.ConnectionEdges:    &gql.Field{Type: gql.NewNonNull(gql.NewList(gql.NewNonNull(edge)))},
+			dao.ConnectionPageInfo: &gql.Field{Type: gql.NewNonNull(pageInfoObject)},
+		},
+	})
+	change := gql.NewObject(gql.ObjectConfig{
+		Name: collection.Name + "Change",
+		Fields: gql.Fields{
+			cChangeOperationField: &gql.Field{Type: gql.NewNonNull(changeOperationEnum)},
+			cChangeIdField:        &gql.Field{Type: gql.NewNonNull(gql.Int)},
+			cChangeNodeField:      &gql.Field{Type: object},
+		},
+	})
+	version := gql.NewObject(gql.ObjectConfig{
+		Name: collection.Name + "Version",
+		Fields: gql.Fields{
+			dao.HistoryVersion:   &gql.Field{Type: gql.NewNonNull(gql.Int)},
+			dao.HistoryOperation: &gql.Field{Type: gql.NewNonNull(changeOperationEnum)},
+			dao.HistoryPeer:      &gql.Field{Type: gql.String},
+			dao.HistoryChangedAt: &gql.Field{Type: gql.NewNonNull(gql.String)},
+			dao.HistoryNode:      &gql.Field{Type: object},
+		},
+	})
+	s.types = append(s.types, edge, connection, change, version)
+	s.versions[collection.Id] = version
+
+	s.queries[cFindOperation+collection.Name] = &gql.Field{
+		Type: object,
+		Args: gql.FieldConfigArgument{
+			cIdArg:   &gql.ArgumentConfig{Type: gql.NewNonNull(gql.Int)},
+			cAsOfArg: &gql.ArgumentConfig{Type: gql.String},
+		},
+	}
+	s.queries[cListOperation+collection.Name] = &gql.Field{
+		Type: gql.NewNonNull(connection),
+		Args: gql.FieldConfigArgument{
+			cWhereArg:   &gql.ArgumentConfig{Type: s.whereInput(collection)},
+			cOrderByArg: &gql.ArgumentConfig{Type: gql.NewList(gql.NewNonNull(s.orderByInput(collection)))},
+			cFirstArg:   &gql.ArgumentConfig{Type: gql.Int},
+			cAfterArg:   &gql.ArgumentConfig{Type: gql.String},
+		},
+	}
+
+	s.queries[cHistoryOperation+collection.Name] = &gql.Field{
+		Type: gql.NewNonNull(gql.NewList(gql.NewNonNull(version))),
+		Args: gql.FieldConfigArgument{
+			cIdArg:    &gql.ArgumentConfig{Type: gql.NewNonNull(gql.Int)},
+			cFirstArg: &gql.ArgumentConfig{Type: gql.Int},
+		},
+	}
+
+	if len(dao.SearchableFields(collection)) > 0 {
+		s.addSearchField(collection)
+	}
+
+	s.mutations[cSetOperation+collection.Name] = &gql.Field{
+		Type: object,
+		Args: gql.FieldConfigArgument{
+			cInputArg: &gql.ArgumentConfig{Type: gql.NewNonNull(s.recordInput(collection, true))},
+		},
+	}
+	s.mutations[cDeleteOperation+collection.Name] = &gql.Field{
+		Type: object,
+		Args: gql.FieldConfigArgument{
+			cIdArg: &gql.ArgumentConfig{Type: gql.NewNonNull(gql.Int)},
+		},
+	}
+	s.subscriptions[cWatchOperation+collection.Name] = &gql.Field{
+		Type: object,
+		Args: gql.FieldConfigArgument{
+			cIdArg: &gql.ArgumentConfig{Type: gql.NewNonNull(gql.Int)},
+		},
+	}
+	s.subscriptions[cChangesOperation+collection.Name] = &gql.Field{
+		Type: gql.NewNonNull(change),
+	}
+	if hasStoredFields(collection) {
+		s.mutations[cPatchOperation+collection.Name] = &gql.Field{
+			Type: object,
+			Args: gql.FieldConfigArgument{
+				cIdArg:    &gql.ArgumentConfig{Type: gql.NewNonNull(gql.Int)},
+				cInputArg: &gql.ArgumentConfig{Type: gql.NewNonNull(s.recordInput(collection, false))},
+			},
+		}
+	}
+}
+
+// addSearchField adds searchX, whose edges have the rank of their record and
+// a snippet of it next to the fields of listX edges.
+func (s *collectionSchema) addSearchField(collection *dao.Collection) {
+	edge := gql.NewObject(gql.ObjectConfig{
+		Name: collection.Name + "SearchEdge",
+		Fields: gql.Fields{
+			dao.EdgeCursor:  &gql.Field{Type: gql.NewNonNull(gql.String)},
+			dao.EdgeNode:    &gql.Field{Type: s.objects[collection.Id]},
+			dao.EdgeRank:    &gql.Field{Type: gql.NewNonNull(gql.Float)},
+			dao.EdgeSnippet: &gql.Field{Type: gql.String},
+		},
+	})
+	connection := gql.NewObject(gql.ObjectConfig{
+		Name: collection.Name + "SearchConnection",
+		Fields: gql.Fields{
+			dao.ConnectionEdges:    &gql.Field{Type: gql.NewNonNull(gql.NewList(gql.NewNonNull(edge)))},
+			dao.ConnectionPageInfo: &gql.Field{Type: gql.NewNonNull(pageInfoObject)},
+		},
+	})
+	s.types = append(s.types, edge, connection)
+	s.queries[cSearchOperation+collection.Name] = &gql.Field{
+		Type: gql.NewNonNull(connection),
+		Args: gql.FieldConfigArgument{
+			cQueryArg: &gql.ArgumentConfig{Type: gql.NewNonNull(gql.String)},
+			cFirstArg: &gql.ArgumentConfig{Type: gql.Int},
+			cAfterArg: &gql.ArgumentConfig{Type: gql.String},
+		},
+	}
+}
+
+// inverseArgs are the arguments of an inverse field, which are those of the
+// listX field of the referenced collection without a cursor.
+func (s *collectionSchema) inverseArgs(field dao.CollectionField) gql.FieldConfigArgument {
+	for _, collection := range s.collections {
+		if collection.Id != field.Ref {
+			continue
+		}
+		return gql.FieldConfigArgument{
+			cWhereArg:   &gql.ArgumentConfig{Type: s.whereInput(collection)},
+			cOrderByArg: &gql.ArgumentConfig{Type: gql.NewList(gql.NewNonNull(s.orderByInput(collection)))},
+			cFirstArg:   &gql.ArgumentConfig{Type: gql.Int},
+		}
+	}
+	return nil
+}
+
+// recordInput is the input of setX, or of patchX where every field is
+// optional and the id is an argument of its own.
+func (s *collectionSchema) recordInput(collection *dao.Collection, isSet bool) *gql.InputObject {
+	name := collection.Name + "PatchInput"
+	fields := gql.InputObjectConfigFieldMap{}
+	if isSet {
+		name = collection.Name + "Input"
+		fields[dao.IdField] = &gql.InputObjectFieldConfig{Type: gql.Int}
+	}
+	for _, field := range sortedFields(collection) {
+		scalar := s.inputType(field)
+		if scalar == nil || !field.IsStored() {
+			continue
+		}
+		var fieldType gql.Input = scalar
+		if field.IsList {
+			fieldType = gql.NewList(scalar)
+		} else if isSet && field.NonNull {
+			fieldType = gql.NewNonNull(scalar)
+		}
+		fields[field.Name] = &gql.InputObjectFieldConfig{Type: fieldType}
+	}
+	input := gql.NewInputObject(gql.InputObjectConfig{Name: name, Fields: fields})
+	s.types = append(s.types, input)
+	return input
+}
+
+func (s *collectionSchema) whereInput(collection *dao.Collection) *gql.InputObject {
+	if where, ok := s.wheres[collection.Id]; ok {
+		return where
+	}
+	var where *gql.InputObject
+	where = gql.NewInputObject(gql.InputObjectConfig{
+		Name: collection.Name + "Where",
+		Fields: gql.InputObjectConfigFieldMapThunk(func() gql.InputObjectConfigFieldMap {
+			fields := gql.InputObjectConfigFieldMap{
+				cAndFilter:  &gql.InputObjectFieldConfig{Type: gql.NewList(gql.NewNonNull(where))},
+				cOrFilter:   &gql.InputObjectFieldConfig{Type: gql.NewList(gql.NewNonNull(where))},
+				dao.IdField: &gql.InputObjectFieldConfig{Type: s.filterInput(gql.Int)},
+			}
+			for _, field := range sortedFields(collection) {
+				fieldType := s.inputType(field)
+				if field.IsList || fieldType == nil {
+					continue
+				}
+				fields[field.Name] = &gql.InputObjectFieldConfig{Type: s.filterInput(fieldType)}
+			}
+			return fields
+		}),
+	})
+	s.wheres[collection.Id] = where
+	s.types = append(s.types, where)
+	return where
+}
+
+func (s *collectionSchema) orderByInput(collection *dao.Collection) *gql.InputObject {
+	if orderBy, ok := s.orderBys[collection.Id]; ok {
+		return orderBy
+	}
+	fields := gql.InputObjectConfigFieldMap{
+		dao.IdField: &gql.InputObjectFieldConfig{Type: sortDirectionEnum},
+	}
+	for _, field := range sortedFields(collection) {
+		if !field.IsList {
+			fields[field.Name] = &gql.InputObjectFieldConfig{Type: sortDirectionEnum}
+		}
+	}
+	orderBy := gql.NewInputObject(gql.InputObjectConfig{
+		Name:   collection.Name + "OrderBy",
+		Fields: fields,
+	})
+	s.orderBys[collection.Id] = orderBy
+	s.types = append(s.types, orderBy)
+	return orderBy
+}
+
+// filterInput returns the operators that can be applied to a scalar in a
+// where argument, for example IntFilter.
+func (s *collectionSchema) filterInput(scalar *gql.Scalar) *gql.InputObject {
+	if filter, ok := s.filters[scalar.Name()]; ok {
+		return filter
+	}
+	filter := gql.NewInputObject(gql.InputObjectConfig{
+		Name: scalar.Name() + "Filter",
+		Fields: gql.InputObjectConfigFieldMap{
+			dao.OperatorEq:     &gql.InputObjectFieldConfig{Type: scalar},
+			dao.OperatorNe:     &gql.InputObjectFieldConfig{Type: scalar},
+			dao.OperatorGt:     &gql.InputObjectFieldConfig{Type: scalar},
+			dao.OperatorGte:    &gql.InputObjectFieldConfig{Type: scalar},
+			dao.OperatorLt:     &gql.InputObjectFieldConfig{Type: scalar},
+			dao.OperatorLte:    &gql.InputObjectFieldConfig{Type: scalar},
+			dao.OperatorIn:     &gql.InputObjectFieldConfig{Type: gql.NewList(gql.NewNonNull(scalar))},
+			dao.OperatorLike:   &gql.InputObjectFieldConfig{Type: gql.String},
+			dao.OperatorIsNull: &gql.InputObjectFieldConfig{Type: gql.Boolean},
+		},
+	})
+	s.filters[scalar.Name()] = filter
+	s.types = append(s.types, filter)
+	return filter
+}
+
+func (s *collectionSchema) outputType(field dao.CollectionField) gql.Output {
+	var fieldType gql.Output
+	if scalar, ok := scalarTypes[field.Type]; ok {
+		fieldType = scalar
+	} else if field.Type == dao.BlobType {
+		fieldType = blobObject
+	} else if object, ok := s.objects[field.Ref]; ok {
+		fieldType = object
+	} else {
+		return nil
+	}
+	if field.IsList {
+		return gql.NewList(fieldType)
+	}
+	if field.NonNull {
+		return gql.NewNonNull(fieldType)
+	}
+	return fieldType
+}
+
+// inputType returns the scalar that is written to a field; object fields
+// take the id of the referenced record and blob fields the digest.
+func (s *collectionSchema) inputType(field dao.CollectionField) *gql.Scalar {
+	if scalar, ok := scalarTypes[field.Type]; ok {
+		return scalar
+	}
+	if field.Type == dao.BlobType {
+		return gql.String
+	}
+	if _, ok := s.objects[field.Ref]; ok {
+		return gql.Int
+	}
+	return nil
+}
+
+// hasStoredFields reports whether patchX has any fields to write.
+func hasStoredFields(collection *dao.Collection) bool {
+	for _, field := range collection.Fields {
+		if field.IsStored() {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedFields(collection *dao.Collection) []dao.CollectionField {
+	fields := make([]dao.CollectionField, 0, len(collection.Fields))
+	for _, field := range collection.Fields {
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+	return fields
+}
+
+// buildSchema puts the fields and types of every source together into one
+// schema. It returns nil if there are no query fields, because a schema
+// cannot have an empty query type.
+func buildSchema(
+	queries []core.QueryResolver,
+	mutations []core.MutationResolver,
+	subscriptions []core.SubscriptionResolver,
+	types []core.TypeSource,
+) (*gql.Schema, error) {
+	queryFields := gql.Fields{}
+	for _, source := range queries {
+		for name, field := range source.QueryFields() {
+			queryFields[name] = field
+		}
+	}
+	if len(queryFields) == 0 {
+		return nil, nil
+	}
+	mutationFields := gql.Fields{}
+	for _, source := range mutations {
+		for name, field := range source.MutationFields() {
+			mutationFields[name] = field
+		}
+	}
+	config := gql.SchemaConfig{
+		Query: gql.NewObject(gql.ObjectConfig{Name: "Query", Fields: queryFields}),
+	}
+	if len(mutationFields) > 0 {
+		config.Mutation = gql.NewObject(gql.ObjectConfig{Name: "Mutation", Fields: mutationFields})
+	}
+	subscriptionFields := gql.Fields{}
+	for _, source := range subscriptions {
+		for name, field := range source.SubscriptionFields() {
+			subscriptionFields[name] = field
+		}
+	}
+	if len(subscriptionFields) > 0 {
+		config.Subscription = gql.NewObject(gql.ObjectConfig{Name: "Subscription", Fields: subscriptionFields})
+	}
+	for _, source := range types {
+		config.Types = append(config.Types, source.Types()...)
+	}
+	schema, err := gql.NewSchema(config)
+	if err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// loadSchema builds the schema of all the collections in the dao.
+func loadSchema(ctx context.Context, collectionDao dao.CollectionDao) (*gql.Schema, error) {
+	collections, err := collectionDao.ListCollections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	source := newCollectionSchema(collections)
+	return buildSchema(
+		[]core.QueryResolver{source},
+		[]core.MutationResolver{source},
+		[]core.SubscriptionResolver{source},
+		[]core.TypeSource{source},
+	)
+}
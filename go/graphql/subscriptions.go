@@ -0,0 +1,231 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/sashankg/hold/dao"
+)
+
+const (
+	cChangeOperationField = "operation"
+	cChangeIdField        = "id"
+	cChangeNodeField      = "node"
+
+	// cSubscriptionBuffer is how many changes a subscription can fall behind
+	// before it is ended.
+	cSubscriptionBuffer = 256
+)
+
+// SubscriptionResult is one response of a subscription. Data and Err mean the
+// same as the results of Resolve.
+type SubscriptionResult struct {
+	Data []byte
+	Err  error
+}
+
+var errSubscriptionOverflow = &Error{
+	Message:    "subscription fell behind the changes",
+	Extensions: ErrorExtensions{Code: ErrorCodeInternal},
+}
+
+// Subscribe implements Resolver.
+//
+// watchX(id:) resolves like findX(id:) right away and then after every
+// change to the record, with null and a NOT_FOUND error once it is deleted.
+// changesX resolves to { operation id node } for every change to a record of
+// the collection, where node is null for deletes. The channel is closed when
+// ctx is done, or after a result with a request error.
+func (r *resolverImpl) Subscribe(
+	ctx context.Context,
+	doc *ast.Document,
+) (<-chan SubscriptionResult, error) {
+	var field *ast.Field
+	iterateRootFields(doc, func(rootField *ast.Field) error {
+		field = rootField
+		return nil
+	})
+	if field == nil {
+		return nil, NewInvalidSchemaError("no subscription field", nil)
+	}
+	collectionSpec, schemaErr := rootFieldToCollectionSpec(field)
+	if schemaErr != nil {
+		return nil, schemaErr
+	}
+	collection, err := r.dao.FindCollectionBySpec(ctx, *collectionSpec)
+	if err != nil {
+		return nil, err
+	}
+	operation := rootFieldOperation(field)
+	watchId := 0
+	if operation == cWatchOperation {
+		watchId, err = getRecordId(field)
+		if err != nil {
+			return nil, NewInvalidSchemaError(err.Error(), field.Loc)
+		}
+	}
+
+	changes := make(chan dao.Change, cSubscriptionBuffer)
+	overflow := make(chan struct{})
+	var overflowOnce sync.Once
+	remove := r.dao.OnChange(func(written []dao.Change) {
+		for _, change := range written {
+			if change.CollectionId != collection.Id || (operation == cWatchOperation && change.Id != watchId) {
+				continue
+			}
+			select {
+			case changes <- change:
+			default:
+				overflowOnce.Do(func() { close(overflow) })
+			}
+		}
+	})
+
+	results := make(chan SubscriptionResult)
+	send := func(data []byte, err error) bool {
+		select {
+		case results <- SubscriptionResult{Data: data, Err: err}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	go func() {
+		defer close(results)
+		defer remove()
+		if operation == cWatchOperation && !send(r.resolveWatch(ctx, field, collectionSpec, watchId)) {
+			return
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-overflow:
+				send(nil, errSubscriptionOverflow)
+				return
+			case change := <-changes:
+				var data []byte
+				var err error
+				if operation == cWatchOperation {
+					// the record is read once for changes that piled up
+					for len(changes) > 0 {
+						<-changes
+					}
+					data, err = r.resolveWatch(ctx, field, collectionSpec, watchId)
+				} else {
+					data, err = r.resolveChange(ctx, field, collectionSpec, change)
+				}
+				if !send(data, err) {
+					return
+				}
+			}
+		}
+	}()
+	return results, nil
+}
+
+// resolveWatch resolves a watchX field as the findX field with its
+// selection set.
+func (r *resolverImpl) resolveWatch(
+	ctx context.Context,
+	field *ast.Field,
+	collectionSpec *dao.CollectionSpec,
+	id int,
+) ([]byte, error) {
+	return r.Resolve(ctx, findDocument(field, collectionSpec, responseKey(field), id, field.SelectionSet))
+}
+
+// resolveChange resolves a changesX field for one change. The node is
+// resolved as a findX field, and its errors are moved under the changesX
+// field.
+func (r *resolverImpl) resolveChange(
+	ctx context.Context,
+	field *ast.Field,
+	collectionSpec *dao.CollectionSpec,
+	change dao.Change,
+) ([]byte, error) {
+	result := map[string]JsonValue{}
+	var fieldErrors Errors
+	for _, sel := range field.SelectionSet.Selections {
+		sel, ok := sel.(*ast.Field)
+		if !ok {
+			continue
+		}
+		var value any
+		switch sel.Name.Value {
+		case cChangeOperationField:
+			value = change.Operation
+		case cChangeIdField:
+			value = change.Id
+		case cChangeNodeField:
+			if change.Operation == dao.ChangeDelete {
+				result[responseKey(sel)] = JsonValue(jsonNull)
+				continue
+			}
+			nodeKey := responseKey(sel)
+			data, err := r.Resolve(ctx, findDocument(field, collectionSpec, nodeKey, change.Id, sel.SelectionSet))
+			if err != nil && !errors.As(err, &fieldErrors) {
+				return nil, err
+			}
+			nodeResult := map[string]json.RawMessage{}
+			if err := json.Unmarshal(data, &nodeResult); err != nil {
+				return nil, err
+			}
+			result[nodeKey] = JsonValue(nodeResult[nodeKey])
+			for _, fieldError := range fieldErrors {
+				fieldError.Path = append([]any{responseKey(field)}, fieldError.Path...)
+			}
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		result[responseKey(sel)] = JsonValue(encoded)
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(map[string]JsonValue{responseKey(field): JsonValue(encoded)})
+	if err != nil {
+		return nil, err
+	}
+	if len(fieldErrors) > 0 {
+		return data, fieldErrors
+	}
+	return data, nil
+}
+
+// findDocument is a query with the findX field of a collection in place of a
+// subscription field, under the key of alias.
+func findDocument(
+	field *ast.Field,
+	collectionSpec *dao.CollectionSpec,
+	alias string,
+	id int,
+	selectionSet *ast.SelectionSet,
+) *ast.Document {
+	findField := ast.NewField(&ast.Field{
+		Loc:   field.Loc,
+		Alias: ast.NewName(&ast.Name{Value: alias}),
+		Name:  ast.NewName(&ast.Name{Value: cFindOperation + collectionSpec.Name}),
+		Arguments: []*ast.Argument{ast.NewArgument(&ast.Argument{
+			Name:  ast.NewName(&ast.Name{Value: cIdArg}),
+			Value: ast.NewIntValue(&ast.IntValue{Value: strconv.Itoa(id)}),
+		})},
+		// keeps the namespace
+		Directives:   field.Directives,
+		SelectionSet: selectionSet,
+	})
+	return ast.NewDocument(&ast.Document{
+		Definitions: []ast.Node{ast.NewOperationDefinition(&ast.OperationDefinition{
+			Operation:    ast.OperationTypeQuery,
+			SelectionSet: ast.NewSelectionSet(&ast.SelectionSet{Selections: []ast.Selection{findField}}),
+		})},
+	})
+}
@@ -0,0 +1,99 @@
+package graphql_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sashankg/hold/graphql"
+	"github.com/stretchr/testify/require"
+)
+
+func subscribe(t *testing.T, ctx context.Context, resolver graphql.Resolver, query string) <-chan graphql.SubscriptionResult {
+	doc, err := parseGraphql(query)
+	require.NoError(t, err)
+	results, err := resolver.Subscribe(ctx, doc)
+	require.NoError(t, err)
+	return results
+}
+
+func nextResult(t *testing.T, results <-chan graphql.SubscriptionResult) graphql.SubscriptionResult {
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(time.Second):
+		require.FailNow(t, "no subscription result")
+		return graphql.SubscriptionResult{}
+	}
+}
+
+func TestSubscribeWatch(t *testing.T) {
+	resolver := newTestResolver(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	results := subscribe(t, ctx, resolver, `
+		subscription {
+			post: watchPost(id: 1) {
+				title
+				author {
+					name
+				}
+			}
+		}
+	`)
+
+	result := nextResult(t, results)
+	require.NoError(t, result.Err)
+	require.JSONEq(t, `{"post": {"title": "first", "author": {"name": "ada"}}}`, string(result.Data))
+
+	// other records do not resolve the subscription again
+	resolve(t, resolver, `mutation { patchPost(id: 2, input: { title: "other" }) { id } }`)
+	resolve(t, resolver, `mutation { patchPost(id: 1, input: { title: "changed" }) { id } }`)
+	result = nextResult(t, results)
+	require.NoError(t, result.Err)
+	require.JSONEq(t, `{"post": {"title": "changed", "author": {"name": "ada"}}}`, string(result.Data))
+
+	resolve(t, resolver, `mutation { deletePost(id: 1) { id } }`)
+	result = nextResult(t, results)
+	require.JSONEq(t, `{"post": null}`, string(result.Data))
+	var fieldErrors graphql.Errors
+	require.ErrorAs(t, result.Err, &fieldErrors)
+	require.Equal(t, graphql.ErrorCodeNotFound, fieldErrors[0].Extensions.Code)
+
+	cancel()
+	_, ok := <-results
+	require.False(t, ok)
+}
+
+func TestSubscribeChanges(t *testing.T) {
+	resolver := newTestResolver(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	people := subscribe(t, ctx, resolver, `
+		subscription {
+			changesPerson {
+				operation
+				id
+				person: node {
+					name
+				}
+			}
+		}
+	`)
+	posts := subscribe(t, ctx, resolver, `subscription { changesPost { operation id } }`)
+
+	resolve(t, resolver, `mutation { setPerson(input: { id: 3, name: "barbara" }) { id } }`)
+	result := nextResult(t, people)
+	require.NoError(t, result.Err)
+	require.JSONEq(t, `{"changesPerson": {"operation": "SET", "id": 3, "person": {"name": "barbara"}}}`, string(result.Data))
+
+	// the posts of a deleted person are patched to have no author
+	resolve(t, resolver, `mutation { deletePerson(id: 2) { id } }`)
+	result = nextResult(t, people)
+	require.NoError(t, result.Err)
+	require.JSONEq(t, `{"changesPerson": {"operation": "DELETE", "id": 2, "person": null}}`, string(result.Data))
+	for _, id := range []string{"2", "4"} {
+		result = nextResult(t, posts)
+		require.NoError(t, result.Err)
+		require.JSONEq(t, `{"changesPost": {"operation": "PATCH", "id": `+id+`}}`, string(result.Data))
+	}
+}
@@ -1,6 +1,7 @@
 package graphql
 
 import (
+	"context"
 	"regexp"
 
 	"github.com/graphql-go/graphql/language/ast"
@@ -25,17 +26,32 @@ func iterateRootFields(doc *ast.Document, yield func(*ast.Field) error) error {
 	return nil
 }
 
+const (
+	cFindOperation   = "find"
+	cListOperation   = "list"
+	cPatchOperation  = "patch"
+	cSetOperation    = "set"
+	cDeleteOperation = "delete"
+	cWatchOperation  = "watch"
+	// cChangesOperation is a feed of the changes to every record of a
+	// collection.
+	cChangesOperation = "changes"
+	// cHistoryOperation lists the past versions of a record.
+	cHistoryOperation = "history"
+	// cSearchOperation is a connection of the records that match a full-text
+	// query.
+	cSearchOperation = "search"
+)
+
+var rootFieldMatcher = regexp.MustCompile("^(find|list|patch|set|delete|watch|changes|history|search)([A-Z][a-zA-Z]*)$")
+
 func rootFieldToCollectionSpec(
 	def *ast.Field,
 ) (*dao.CollectionSpec, error) {
-	matcher, err := regexp.Compile("^(?:find|list|patch|set)([A-Z][a-zA-Z]*)$")
-	if err != nil {
-		panic(err)
-	}
-	matches := matcher.FindStringSubmatch(def.Name.Value)
-	if len(matches) != 2 {
+	matches := rootFieldMatcher.FindStringSubmatch(def.Name.Value)
+	if len(matches) != 3 {
 		return nil, NewInvalidSchemaError(
-			"invalid operation name: "+def.Name.Value+" should match "+matcher.String(),
+			"invalid operation name: "+def.Name.Value+" should match "+rootFieldMatcher.String(),
 			def.Loc,
 		)
 	}
@@ -43,7 +59,35 @@ func rootFieldToCollectionSpec(
 	if schemaErr != nil {
 		return nil, schemaErr
 	}
-	return &dao.CollectionSpec{Namespace: namespace, Name: matches[1]}, nil
+	return &dao.CollectionSpec{Namespace: namespace, Name: matches[2]}, nil
+}
+
+// isMutationOperation reports whether a root field operation writes records.
+func isMutationOperation(operation string) bool {
+	return operation == cSetOperation || operation == cPatchOperation || operation == cDeleteOperation
+}
+
+// isSubscriptionOperation reports whether a root field operation resolves
+// again after every change.
+func isSubscriptionOperation(operation string) bool {
+	return operation == cWatchOperation || operation == cChangesOperation
+}
+
+// isConnectionOperation reports whether a root field operation resolves to a
+// connection of records.
+func isConnectionOperation(operation string) bool {
+	return operation == cListOperation || operation == cSearchOperation
+}
+
+// rootFieldOperation returns the find/list/patch/set/delete/watch/changes/
+// history/search prefix of a root field, or an empty string if the field name
+// is not a valid root field.
+func rootFieldOperation(def *ast.Field) string {
+	matches := rootFieldMatcher.FindStringSubmatch(def.Name.Value)
+	if len(matches) != 3 {
+		return ""
+	}
+	return matches[1]
 }
 
 func getNamespace(directives []*ast.Directive) (string, error) {
@@ -78,3 +122,38 @@ func mergeCollectionFields(
 	}
 	return collectionA
 }
+
+// collectionCache looks up each collection that a document refers to once.
+type collectionCache struct {
+	ctx         context.Context
+	dao         dao.CollectionDao
+	collections map[int]*dao.Collection
+}
+
+func newCollectionCache(ctx context.Context, collectionDao dao.CollectionDao) *collectionCache {
+	return &collectionCache{
+		ctx:         ctx,
+		dao:         collectionDao,
+		collections: map[int]*dao.Collection{},
+	}
+}
+
+func (c *collectionCache) get(id int) (*dao.Collection, error) {
+	if collection, ok := c.collections[id]; ok {
+		return collection, nil
+	}
+	collection, err := c.dao.FindCollectionById(c.ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.collections[id] = collection
+	return collection, nil
+}
+
+// isHistoryField reports whether a field of a record of collection is the
+// list of its versions, which a field of the collection with the same name
+// takes the place of.
+func isHistoryField(collection *dao.Collection, fieldName string) bool {
+	_, isField := collection.Fields[fieldName]
+	return fieldName == dao.HistoryField && !isField
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 
 	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/location"
+	"github.com/sashankg/hold/acl"
 	"github.com/sashankg/hold/dao"
 )
 
@@ -17,12 +20,14 @@ type Validator interface {
 }
 
 type validatorImpl struct {
-	dao dao.CollectionDao
+	dao        dao.CollectionDao
+	authorizer acl.Authorizer
 }
 
-func NewValidator(dao dao.CollectionDao) Validator {
+func NewValidator(dao dao.CollectionDao, authorizer acl.Authorizer) Validator {
 	return &validatorImpl{
-		dao: dao,
+		dao:        dao,
+		authorizer: authorizer,
 	}
 }
 
@@ -35,6 +40,13 @@ func (h *validatorImpl) ValidateRootSelections(
 	ctx context.Context,
 	doc *ast.Document,
 ) error {
+	for _, def := range doc.Definitions {
+		if def, ok := def.(*ast.OperationDefinition); ok {
+			if err := validateOperationType(def); err != nil {
+				return err
+			}
+		}
+	}
 	return iterateRootFields(doc, func(field *ast.Field) error {
 		collectionSpec, schemaErr := rootFieldToCollectionSpec(field)
 		if schemaErr != nil {
@@ -47,11 +59,245 @@ func (h *validatorImpl) ValidateRootSelections(
 				err,
 			)
 		}
-		h.validateNestedSelections(ctx, field.SelectionSet, collection)
-		return nil
+		permission := acl.Read
+		if isMutationOperation(rootFieldOperation(field)) {
+			permission = acl.Write
+		}
+		if err := h.authorize(ctx, collection, permission, field.Loc); err != nil {
+			return err
+		}
+		switch rootFieldOperation(field) {
+		case cFindOperation:
+			if _, err := getAsOf(field); err != nil {
+				return err
+			}
+		case cListOperation:
+			if _, err := getListParams(field, collection); err != nil {
+				return err
+			}
+			if field.SelectionSet == nil {
+				return NewInvalidSchemaError("need a selection set for list fields", field.Loc)
+			}
+			return h.validateConnectionSelections(ctx, field.SelectionSet, collection)
+		case cSearchOperation:
+			if _, err := getSearchParams(field); err != nil {
+				return err
+			}
+			if len(dao.SearchableFields(collection)) == 0 {
+				return NewInvalidSchemaError("collection has no searchable fields: "+collection.Name, field.Loc)
+			}
+			if field.SelectionSet == nil {
+				return NewInvalidSchemaError("need a selection set for search fields", field.Loc)
+			}
+			return h.validateConnectionSelections(ctx, field.SelectionSet, collection, dao.EdgeRank, dao.EdgeSnippet)
+		case cChangesOperation:
+			if len(field.Arguments) > 0 {
+				return NewInvalidSchemaError(field.Name.Value+" takes no arguments", field.Loc)
+			}
+			if field.SelectionSet == nil {
+				return NewInvalidSchemaError("need a selection set for "+field.Name.Value, field.Loc)
+			}
+			return h.validateNodeSelections(
+				ctx, field.SelectionSet, collection, "change",
+				cChangeOperationField, cChangeIdField,
+			)
+		case cHistoryOperation:
+			if _, err := getRecordId(field); err != nil {
+				return NewInvalidSchemaError(err.Error(), field.Loc)
+			}
+			if _, err := getHistoryFirst(field); err != nil {
+				return err
+			}
+			if field.SelectionSet == nil {
+				return NewInvalidSchemaError("need a selection set for "+field.Name.Value, field.Loc)
+			}
+			return h.validateNodeSelections(
+				ctx, field.SelectionSet, collection, "version",
+				dao.HistoryVersion, dao.HistoryOperation, dao.HistoryPeer, dao.HistoryChangedAt,
+			)
+		case cDeleteOperation, cWatchOperation:
+			if _, err := getRecordId(field); err != nil {
+				return NewInvalidSchemaError(err.Error(), field.Loc)
+			}
+			if rootFieldOperation(field) == cDeleteOperation {
+				if err := h.authorizeCascade(ctx, collection, field.Loc); err != nil {
+					return err
+				}
+			}
+			if field.SelectionSet == nil {
+				return NewInvalidSchemaError("need a selection set for "+field.Name.Value, field.Loc)
+			}
+		case cPatchOperation:
+			if _, err := getRecordId(field); err != nil {
+				return NewInvalidSchemaError(err.Error(), field.Loc)
+			}
+			fallthrough
+		case cSetOperation:
+			if _, err := getRecordInput(field, collection); err != nil {
+				return err
+			}
+			if field.SelectionSet == nil {
+				return NewInvalidSchemaError("need a selection set for "+field.Name.Value, field.Loc)
+			}
+		}
+		return h.validateNestedSelections(ctx, field.SelectionSet, collection)
 	})
 }
 
+// validateOperationType checks that setX, patchX and deleteX are only used in
+// mutations, watchX and changesX only in subscriptions, and findX, listX,
+// historyX and searchX only in queries. A subscription has a single root field.
+func validateOperationType(def *ast.OperationDefinition) error {
+	for _, sel := range def.SelectionSet.Selections {
+		field, ok := sel.(*ast.Field)
+		if !ok {
+			continue
+		}
+		operationType := ast.OperationTypeQuery
+		switch operation := rootFieldOperation(field); {
+		case isMutationOperation(operation):
+			operationType = ast.OperationTypeMutation
+		case isSubscriptionOperation(operation):
+			operationType = ast.OperationTypeSubscription
+		}
+		if operationType != def.Operation {
+			return NewInvalidSchemaError(
+				field.Name.Value+" is not allowed in a "+def.Operation,
+				field.Loc,
+			)
+		}
+	}
+	if def.Operation == ast.OperationTypeSubscription && len(def.SelectionSet.Selections) != 1 {
+		return NewInvalidSchemaError("a subscription needs exactly one root field", def.Loc)
+	}
+	return nil
+}
+
+var pageInfoFields = map[string]bool{
+	"hasNextPage":     true,
+	"hasPreviousPage": true,
+	"startCursor":     true,
+	"endCursor":       true,
+}
+
+// validateConnectionSelections validates the selection set of a listX field,
+// which has the shape { edges { cursor node { ... } } pageInfo { ... } }.
+// The edges of searchX have the scalar edgeFields as well.
+func (h *validatorImpl) validateConnectionSelections(
+	ctx context.Context,
+	selections *ast.SelectionSet,
+	collection *dao.Collection,
+	edgeFields ...string,
+) error {
+	for _, sel := range selections.Selections {
+		sel, ok := sel.(*ast.Field)
+		if !ok {
+			continue
+		}
+		switch sel.Name.Value {
+		case dao.ConnectionEdges:
+			if sel.SelectionSet == nil {
+				return NewInvalidSchemaError("need a selection set for edges", sel.Loc)
+			}
+			for _, edgeSel := range sel.SelectionSet.Selections {
+				edgeSel, ok := edgeSel.(*ast.Field)
+				if !ok {
+					continue
+				}
+				switch {
+				case edgeSel.Name.Value == dao.EdgeCursor || slices.Contains(edgeFields, edgeSel.Name.Value):
+					if edgeSel.SelectionSet != nil {
+						return NewInvalidSchemaError("field not object type: "+edgeSel.Name.Value, edgeSel.Loc)
+					}
+				case edgeSel.Name.Value == dao.EdgeNode:
+					if edgeSel.SelectionSet == nil {
+						return NewInvalidSchemaError("need a selection set for node", edgeSel.Loc)
+					}
+					if err := h.validateNestedSelections(ctx, edgeSel.SelectionSet, collection); err != nil {
+						return err
+					}
+				default:
+					return NewInvalidSchemaError("invalid edge field: "+edgeSel.Name.Value, edgeSel.Loc)
+				}
+			}
+		case dao.ConnectionPageInfo:
+			if sel.SelectionSet == nil {
+				return NewInvalidSchemaError("need a selection set for pageInfo", sel.Loc)
+			}
+			for _, pageInfoSel := range sel.SelectionSet.Selections {
+				pageInfoSel, ok := pageInfoSel.(*ast.Field)
+				if !ok {
+					continue
+				}
+				if !pageInfoFields[pageInfoSel.Name.Value] || pageInfoSel.SelectionSet != nil {
+					return NewInvalidSchemaError("invalid pageInfo field: "+pageInfoSel.Name.Value, pageInfoSel.Loc)
+				}
+			}
+		default:
+			return NewInvalidSchemaError("invalid connection field: "+sel.Name.Value, sel.Loc)
+		}
+	}
+	return nil
+}
+
+// validateHistorySelections validates the history field of a record of
+// collection, which takes the first argument of historyX.
+func (h *validatorImpl) validateHistorySelections(
+	ctx context.Context,
+	field *ast.Field,
+	collection *dao.Collection,
+) error {
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != cFirstArg {
+			return NewInvalidSchemaError("invalid argument: "+arg.Name.Value, arg.Loc)
+		}
+	}
+	if _, err := getHistoryFirst(field); err != nil {
+		return err
+	}
+	if field.SelectionSet == nil {
+		return NewInvalidSchemaError("need a selection set for "+field.Name.Value, field.Loc)
+	}
+	return h.validateNodeSelections(
+		ctx, field.SelectionSet, collection, "version",
+		dao.HistoryVersion, dao.HistoryOperation, dao.HistoryPeer, dao.HistoryChangedAt,
+	)
+}
+
+// validateNodeSelections validates the selection set of an object that holds
+// a record of collection in its node field next to scalar fields, like a
+// change of changesX { operation id node { ... } } or a version of historyX.
+func (h *validatorImpl) validateNodeSelections(
+	ctx context.Context,
+	selections *ast.SelectionSet,
+	collection *dao.Collection,
+	kind string,
+	scalarFields ...string,
+) error {
+	for _, sel := range selections.Selections {
+		sel, ok := sel.(*ast.Field)
+		if !ok {
+			continue
+		}
+		switch {
+		case slices.Contains(scalarFields, sel.Name.Value):
+			if sel.SelectionSet != nil {
+				return NewInvalidSchemaError("field not object type: "+sel.Name.Value, sel.Loc)
+			}
+		case sel.Name.Value == cChangeNodeField:
+			if sel.SelectionSet == nil {
+				return NewInvalidSchemaError("need a selection set for node", sel.Loc)
+			}
+			if err := h.validateNestedSelections(ctx, sel.SelectionSet, collection); err != nil {
+				return err
+			}
+		default:
+			return NewInvalidSchemaError("invalid "+kind+" field: "+sel.Name.Value, sel.Loc)
+		}
+	}
+	return nil
+}
+
 func (h *validatorImpl) validateNestedSelections(
 	ctx context.Context,
 	selections *ast.SelectionSet,
@@ -60,11 +306,29 @@ func (h *validatorImpl) validateNestedSelections(
 	for _, sel := range selections.Selections {
 		switch sel := sel.(type) {
 		case *ast.Field:
+			if sel.Name.Value == dao.IdField || sel.Name.Value == dao.TypenameField {
+				if sel.SelectionSet != nil {
+					return NewInvalidSchemaError("field not object type: "+sel.Name.Value, sel.Loc)
+				}
+				continue
+			}
+			if isHistoryField(collectionMap, sel.Name.Value) {
+				if err := h.validateHistorySelections(ctx, sel, collectionMap); err != nil {
+					return err
+				}
+				continue
+			}
 			field, ok := collectionMap.Fields[sel.Name.Value]
 			if !ok {
 				// not a real field
 				return NewInvalidSchemaError("invalid field: "+sel.Name.Value, sel.Loc)
 			}
+			if field.Type == dao.BlobType {
+				if err := validateBlobSelections(sel); err != nil {
+					return err
+				}
+				continue
+			}
 			if field.Ref == 0 && sel.SelectionSet != nil {
 				// not a reference field
 				return NewInvalidSchemaError("field not object type: "+sel.Name.Value, sel.Loc)
@@ -73,6 +337,9 @@ func (h *validatorImpl) validateNestedSelections(
 				// not a reference field
 				return NewInvalidSchemaError("need a selection set for object fields: "+sel.Name.Value, sel.Loc)
 			}
+			if field.IsStored() && len(sel.Arguments) > 0 {
+				return NewInvalidSchemaError("arguments are only allowed on inverse fields: "+sel.Name.Value, sel.Loc)
+			}
 			if sel.SelectionSet == nil {
 				continue
 			}
@@ -80,6 +347,14 @@ func (h *validatorImpl) validateNestedSelections(
 			if err != nil {
 				return NewInvalidSchemaError("invalid collection reference: "+sel.Name.Value, sel.Loc)
 			}
+			if err := h.authorize(ctx, nestedCollection, acl.Read, sel.Loc); err != nil {
+				return err
+			}
+			if !field.IsStored() {
+				if _, err := getInverseParams(sel, nestedCollection); err != nil {
+					return err
+				}
+			}
 			if err := h.validateNestedSelections(ctx, sel.SelectionSet, nestedCollection); err != nil {
 				return err
 			}
@@ -88,6 +363,33 @@ func (h *validatorImpl) validateNestedSelections(
 	return nil
 }
 
+func (h *validatorImpl) authorize(
+	ctx context.Context,
+	collection *dao.Collection,
+	permission acl.Permission,
+	loc *ast.Location,
+) error {
+	if h.authorizer.Allowed(ctx, collection.Domain, collection.Name, permission) {
+		return nil
+	}
+	return NewForbiddenError(fmt.Sprintf("no %s access to %s", permission, collection.Name), loc)
+}
+
+// authorizeCascade checks Write on every other collection that deleting a
+// record of collection can delete or patch records of.
+func (h *validatorImpl) authorizeCascade(ctx context.Context, collection *dao.Collection, loc *ast.Location) error {
+	collections, err := h.dao.ListCollections(ctx)
+	if err != nil {
+		return err
+	}
+	for _, cascade := range dao.CascadeCollections(collections, collection.Id) {
+		if err := h.authorize(ctx, cascade, acl.Write, loc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type InvalidSchemaError struct {
 	reason   string
 	location *ast.Location
@@ -98,5 +400,14 @@ func NewInvalidSchemaError(reason string, location *ast.Location) *InvalidSchema
 }
 
 func (e *InvalidSchemaError) Error() string {
-	return fmt.Sprintf("invalid schema: %s at %d", e.reason, e.location.Start)
+	locations := e.Locations()
+	if len(locations) == 0 {
+		return fmt.Sprintf("invalid schema: %s", e.reason)
+	}
+	return fmt.Sprintf("invalid schema: %s at %d:%d", e.reason, locations[0].Line, locations[0].Column)
+}
+
+// Locations returns the line and column of the error in the source document.
+func (e *InvalidSchemaError) Locations() []location.SourceLocation {
+	return sourceLocations(e.location)
 }
@@ -6,8 +6,11 @@ import (
 
 	"github.com/graphql-go/graphql/language/ast"
 	"github.com/graphql-go/graphql/language/parser"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sashankg/hold/acl"
 	"github.com/sashankg/hold/dao"
 	"github.com/sashankg/hold/graphql"
+	"github.com/sashankg/hold/p2p"
 	"github.com/sashankg/hold/testing/mocks"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
@@ -17,9 +20,11 @@ func TestValidateRootSelections(t *testing.T) {
 	doc, err := parseGraphql(`
 		query {
 			findPost {
+				__typename
 				title
 				body
 				author {
+					__typename
 					name
 					friends {
 						name
@@ -71,14 +76,211 @@ func TestValidateRootSelections(t *testing.T) {
 			},
 		}, nil).Times(2)
 
-	validator := graphql.NewValidator(mockDao)
+	validator := graphql.NewValidator(mockDao, openAcl(t))
 
 	err = validator.ValidateRootSelections(context.Background(), doc)
 	assert.NoError(t, err)
 }
 
+func openAcl(t *testing.T) acl.Authorizer {
+	authorizer, err := acl.NewAcl(acl.Policy{Open: true}, "")
+	assert.NoError(t, err)
+	return authorizer
+}
+
 func parseGraphql(query string) (*ast.Document, error) {
 	return parser.Parse(parser.ParseParams{
 		Source: query,
 	})
 }
+
+func TestValidateMutations(t *testing.T) {
+	postCollection := &dao.Collection{
+		Name: "Post",
+		Fields: map[string]dao.CollectionField{
+			"title": {
+				Name: "title",
+				Type: "String",
+			},
+			"views": {
+				Name: "views",
+				Type: "Int",
+			},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		valid bool
+	}{
+		{
+			name:  "set",
+			query: `mutation { setPost(input: { title: "hello", views: 1 }) { id } }`,
+			valid: true,
+		},
+		{
+			name:  "patch",
+			query: `mutation { patchPost(id: 1, input: { views: 2 }) { views } }`,
+			valid: true,
+		},
+		{
+			name:  "patch without id",
+			query: `mutation { patchPost(input: { views: 2 }) { views } }`,
+		},
+		{
+			name:  "wrong value type",
+			query: `mutation { setPost(input: { views: "many" }) { id } }`,
+		},
+		{
+			name:  "unknown field",
+			query: `mutation { setPost(input: { likes: 1 }) { id } }`,
+		},
+		{
+			name:  "set in query",
+			query: `query { setPost(input: { views: 1 }) { id } }`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc, err := parseGraphql(test.query)
+			assert.NoError(t, err)
+
+			ctrl := gomock.NewController(t)
+			mockDao := mocks.NewMockDao(ctrl)
+			mockDao.EXPECT().
+				FindCollectionBySpec(gomock.Any(), gomock.Eq(dao.CollectionSpec{Name: "Post"})).
+				Return(postCollection, nil).
+				AnyTimes()
+
+			err = graphql.NewValidator(mockDao, openAcl(t)).ValidateRootSelections(context.Background(), doc)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateAccess(t *testing.T) {
+	const reader = "QmNpBvAKWrjigDHP4Mn3LpqCmin5F2K9TiVFoFGTC6ayV3"
+	const writer = "QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"
+	authorizer, err := acl.NewAcl(acl.Policy{
+		Roles: map[string]acl.Role{
+			"reader": {Namespaces: map[string][]acl.Permission{"": {acl.Read}}},
+			"writer": {Namespaces: map[string][]acl.Permission{"": {acl.Read, acl.Write}}},
+		},
+		Peers: map[string][]string{reader: {"reader"}, writer: {"writer"}},
+	}, "")
+	assert.NoError(t, err)
+	readerId, err := peer.Decode(reader)
+	assert.NoError(t, err)
+	writerId, err := peer.Decode(writer)
+	assert.NoError(t, err)
+
+	postCollection := &dao.Collection{
+		Id:   1,
+		Name: "Post",
+		Fields: map[string]dao.CollectionField{
+			"title":  {Name: "title", Type: "String"},
+			"secret": {Name: "secret", Type: "Secret", Ref: 2},
+		},
+	}
+	secretCollection := &dao.Collection{
+		Id:     2,
+		Name:   "Secret",
+		Domain: "private",
+		Fields: map[string]dao.CollectionField{
+			"value": {Name: "value", Type: "String"},
+		},
+	}
+	commentCollection := &dao.Collection{
+		Id:   3,
+		Name: "Comment",
+		Fields: map[string]dao.CollectionField{
+			"body": {Name: "body", Type: "String"},
+		},
+	}
+	// deleting a comment cascades to a collection the writer cannot write
+	auditCollection := &dao.Collection{
+		Id:     4,
+		Name:   "Audit",
+		Domain: "private",
+		Fields: map[string]dao.CollectionField{
+			"comment": {Name: "comment", Type: "Comment", Ref: 3, OnDelete: dao.OnDeleteSetNull},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		query string
+		valid bool
+	}{
+		{
+			name:  "read",
+			ctx:   p2p.WithPeer(context.Background(), readerId),
+			query: `query { findPost(id: 1) { title } }`,
+			valid: true,
+		},
+		{
+			name:  "write",
+			ctx:   p2p.WithPeer(context.Background(), readerId),
+			query: `mutation { setPost(input: { title: "hello" }) { id } }`,
+		},
+		{
+			name:  "read other namespace",
+			ctx:   p2p.WithPeer(context.Background(), readerId),
+			query: `query { findPost(id: 1) { secret { value } } }`,
+		},
+		{
+			name:  "delete",
+			ctx:   p2p.WithPeer(context.Background(), writerId),
+			query: `mutation { deletePost(id: 1) { id } }`,
+			valid: true,
+		},
+		{
+			name:  "delete cascading to other namespace",
+			ctx:   p2p.WithPeer(context.Background(), writerId),
+			query: `mutation { deleteComment(id: 1) { id } }`,
+		},
+		{
+			name:  "unknown peer",
+			ctx:   context.Background(),
+			query: `query { findPost(id: 1) { title } }`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc, err := parseGraphql(test.query)
+			assert.NoError(t, err)
+
+			ctrl := gomock.NewController(t)
+			mockDao := mocks.NewMockDao(ctrl)
+			mockDao.EXPECT().
+				FindCollectionBySpec(gomock.Any(), gomock.Eq(dao.CollectionSpec{Name: "Post"})).
+				Return(postCollection, nil).
+				AnyTimes()
+			mockDao.EXPECT().
+				FindCollectionBySpec(gomock.Any(), gomock.Eq(dao.CollectionSpec{Name: "Comment"})).
+				Return(commentCollection, nil).
+				AnyTimes()
+			mockDao.EXPECT().
+				FindCollectionById(gomock.Any(), gomock.Eq(2)).
+				Return(secretCollection, nil).
+				AnyTimes()
+			mockDao.EXPECT().
+				ListCollections(gomock.Any()).
+				Return([]*dao.Collection{postCollection, secretCollection, commentCollection, auditCollection}, nil).
+				AnyTimes()
+
+			err = graphql.NewValidator(mockDao, authorizer).ValidateRootSelections(test.ctx, doc)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, graphql.ErrorCodeForbidden, graphql.FormatError(err).Extensions.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/sashankg/hold/acl"
+	"github.com/sashankg/hold/blobs"
+	"github.com/sashankg/hold/util"
+)
+
+// BlobHandler serves the content of blobs at /blob/<digest>. Blobs never
+// change, so the digest is the ETag and responses can be cached forever.
+//
+// Blobs are not in a namespace, so they can be read by peers that can read
+// every namespace.
+type BlobHandler struct {
+	store      blobs.Store
+	authorizer acl.Authorizer
+}
+
+func NewBlobHandler(store blobs.Store, authorizer acl.Authorizer) *BlobHandler {
+	return &BlobHandler{
+		store,
+		authorizer,
+	}
+}
+
+var _ http.Handler = &BlobHandler{}
+
+func (h *BlobHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizer.Allowed(r.Context(), acl.AnyNamespace, acl.AnyCollection, acl.Read) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	digest := strings.TrimPrefix(r.URL.Path, h.Route())
+	file, blob, err := h.store.Open(r.Context(), digest)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, blobs.ErrInvalidDigest) {
+			http.NotFound(w, r)
+			return
+		}
+		util.InternalServerError(w, err)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", blob.MimeType)
+	w.Header().Set("ETag", `"`+blob.Digest+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	// ServeContent handles Range, If-Range and If-None-Match
+	http.ServeContent(w, r, "", blob.CreatedAt, file)
+}
+
+func (h *BlobHandler) Route() string {
+	return "/blob/"
+}
@@ -0,0 +1,58 @@
+package handlers_test
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sashankg/hold/acl"
+	"github.com/sashankg/hold/blobs"
+	"github.com/sashankg/hold/handlers"
+	"github.com/sashankg/hold/testing/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobHandler(t *testing.T) {
+	store, err := blobs.NewStore(util.NewMemoryDao(t).SchemaDb, t.TempDir(), &blobs.Sha256Hasher{})
+	require.NoError(t, err)
+	blob, err := store.Put(context.Background(), strings.NewReader("hello world"), "text/plain")
+	require.NoError(t, err)
+	handler := handlers.NewBlobHandler(store, openAcl(t))
+
+	req := httptest.NewRequest("GET", "/blob/"+blob.Digest, nil)
+	req.Header.Set("Range", "bytes=6-")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	body, err := io.ReadAll(resp.Result().Body)
+	require.NoError(t, err)
+	require.Equal(t, 206, resp.Code)
+	require.Equal(t, "world", string(body))
+	require.Equal(t, "text/plain", resp.Header().Get("Content-Type"))
+	etag := resp.Header().Get("ETag")
+	require.Equal(t, `"`+blob.Digest+`"`, etag)
+
+	req = httptest.NewRequest("GET", "/blob/"+blob.Digest, nil)
+	req.Header.Set("If-None-Match", etag)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	require.Equal(t, 304, resp.Code)
+
+	for _, path := range []string{"/blob/" + strings.Repeat("0", 64), "/blob/../schema.db"} {
+		resp = httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest("GET", path, nil))
+		require.Equal(t, 404, resp.Code, path)
+	}
+
+	// blobs are only served to peers that can read every namespace
+	authorizer, err := acl.NewAcl(acl.Policy{
+		Roles: map[string]acl.Role{"public": {Namespaces: map[string][]acl.Permission{"": {acl.Read}}}},
+		Peers: map[string][]string{acl.Everyone: {"public"}},
+	}, "")
+	require.NoError(t, err)
+	resp = httptest.NewRecorder()
+	handlers.NewBlobHandler(store, authorizer).ServeHTTP(resp, httptest.NewRequest("GET", "/blob/"+blob.Digest, nil))
+	require.Equal(t, 403, resp.Code)
+}
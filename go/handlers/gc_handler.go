@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/sashankg/hold/acl"
+	"github.com/sashankg/hold/blobs"
+	"github.com/sashankg/hold/util"
+)
+
+// GcHandler runs the blob garbage collector on POST, and responds with what
+// it deleted. With ?dryRun=true it only reports what would be deleted.
+type GcHandler struct {
+	collector  *blobs.GarbageCollector
+	authorizer acl.Authorizer
+}
+
+func NewGcHandler(collector *blobs.GarbageCollector, authorizer acl.Authorizer) *GcHandler {
+	return &GcHandler{
+		collector,
+		authorizer,
+	}
+}
+
+var _ http.Handler = &GcHandler{}
+
+func (h *GcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizer.Allowed(r.Context(), "", "", acl.Admin) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	report, err := h.collector.Collect(r.Context(), r.URL.Query().Get("dryRun") == "true")
+	if err != nil {
+		util.InternalServerError(w, err)
+		return
+	}
+	writeJson(w, http.StatusOK, report)
+}
+
+func (h *GcHandler) Route() string {
+	return "/admin/gc"
+}
@@ -1,56 +1,207 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 
+	"github.com/graphql-go/graphql/language/ast"
 	gql_parser "github.com/graphql-go/graphql/language/parser"
 	gql_handler "github.com/graphql-go/handler"
+	"github.com/sashankg/hold/acl"
 	"github.com/sashankg/hold/graphql"
+	"github.com/sashankg/hold/util"
 )
 
 type GraphqlHandler struct {
-	validator graphql.Validator
-	resolver  graphql.Resolver
+	validator    graphql.Validator
+	resolver     graphql.Resolver
+	introspector graphql.Introspector
+	authorizer   acl.Authorizer
+	tokens       *acl.Tokens
 }
 
-func NewGraphqlHandler(validator graphql.Validator, resolver graphql.Resolver) *GraphqlHandler {
+func NewGraphqlHandler(
+	validator graphql.Validator,
+	resolver graphql.Resolver,
+	introspector graphql.Introspector,
+	authorizer acl.Authorizer,
+	tokens *acl.Tokens,
+) *GraphqlHandler {
 	return &GraphqlHandler{
 		validator,
 		resolver,
+		introspector,
+		authorizer,
+		tokens,
 	}
 }
 
 var _ http.Handler = &GraphqlHandler{}
 
+// Media types of GraphQL responses. Clients that accept
+// application/graphql-response+json get 4xx status codes for requests that
+// could not be executed, while application/json responses are always 200 so
+// that older clients read the errors from the body.
+const (
+	cGraphqlResponseMediaType = "application/graphql-response+json"
+	cJsonMediaType            = "application/json"
+)
+
+type graphqlResponse struct {
+	Data   graphql.JsonValue `json:"data,omitempty"`
+	Errors graphql.Errors    `json:"errors,omitempty"`
+}
+
+// graphqlRequest is the body of a GraphQL request. Token is only read from
+// stream messages, HTTP requests send it in the Authorization header.
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	OperationName string         `json:"operationName,omitempty"`
+	Token         string         `json:"token,omitempty"`
+}
+
 func (h *GraphqlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	opts := gql_handler.NewRequestOptions(r)
+	mediaType := cJsonMediaType
+	if strings.Contains(r.Header.Get("Accept"), cGraphqlResponseMediaType) {
+		mediaType = cGraphqlResponseMediaType
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, statusCode, response := h.authenticate(r.Context(), r.Header.Get("Authorization"))
+	if response != nil {
+		writeGraphqlResponse(w, mediaType, statusCode, response)
+		return
+	}
 
-	doc, err := gql_parser.Parse(gql_parser.ParseParams{Source: opts.Query})
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+	opts := gql_handler.NewRequestOptions(r)
+	if opts.Query == "" {
+		writeGraphqlResponse(w, mediaType, http.StatusBadRequest, graphqlResponse{
+			Errors: graphql.Errors{graphql.FormatError(graphql.NewInvalidSchemaError("no query", nil))},
+		})
 		return
 	}
-	println("successfully parsed")
 
-	if err := h.validator.ValidateRootSelections(r.Context(), doc); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+	statusCode, response = h.execute(ctx, &graphqlRequest{
+		Query:         opts.Query,
+		Variables:     opts.Variables,
+		OperationName: opts.OperationName,
+	}, r.Method == http.MethodPost)
+	switch {
+	case statusCode == http.StatusMethodNotAllowed:
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
+	case mediaType == cJsonMediaType && (statusCode == http.StatusBadRequest || statusCode == http.StatusForbidden):
+		// application/json clients read the errors from the body
+		statusCode = http.StatusOK
 	}
-	println("successfully validated")
+	writeGraphqlResponse(w, mediaType, statusCode, response)
+}
+
+// authenticate adds the capabilities of a bearer token to the context, and
+// rejects peers without any access. The response is nil if the request can
+// go on.
+func (h *GraphqlHandler) authenticate(ctx context.Context, authorization string) (context.Context, int, any) {
+	ctx, err := h.tokens.Authenticate(ctx, authorization)
+	if err != nil {
+		return nil, http.StatusUnauthorized, graphqlResponse{
+			Errors: graphql.Errors{{
+				Message:    err.Error(),
+				Extensions: graphql.ErrorExtensions{Code: graphql.ErrorCodeUnauthenticated},
+			}},
+		}
+	}
+	// peers without a role cannot even introspect the schema
+	if !h.authorizer.Known(ctx) {
+		return nil, http.StatusForbidden, graphqlResponse{
+			Errors: graphql.Errors{graphql.NewForbiddenError("unknown peer", nil)},
+		}
+	}
+	return ctx, http.StatusOK, nil
+}
+
+// execute runs a request with a query through the validator and resolver.
+// The status code is the one of an application/graphql-response+json
+// response, and is 405 with no response for a mutation when mutations are
+// not allowed.
+func (h *GraphqlHandler) execute(ctx context.Context, request *graphqlRequest, allowMutations bool) (int, any) {
+	doc, err := parseRequest(request)
+	if err != nil {
+		return requestError(err)
+	}
+	if graphql.IsSubscription(doc) {
+		return requestError(graphql.NewInvalidSchemaError("subscriptions are only served over graphql-ws", nil))
+	}
+	if !allowMutations && graphql.IsMutation(doc) {
+		return http.StatusMethodNotAllowed, nil
+	}
+
+	if graphql.IsIntrospectionQuery(doc) {
+		result := h.introspector.Introspect(ctx, doc)
+		if result.Data == nil {
+			return http.StatusBadRequest, result
+		}
+		return http.StatusOK, result
+	}
+
+	if err := h.validator.ValidateRootSelections(ctx, doc); err != nil {
+		return requestError(err)
+	}
+
+	responseData, err := h.resolver.Resolve(ctx, doc)
+	var fieldErrors graphql.Errors
+	if err != nil && !errors.As(err, &fieldErrors) {
+		logger.Errorw("error resolving graphql request", "error", err)
+		return http.StatusInternalServerError, graphqlResponse{
+			Errors: graphql.Errors{graphql.FormatError(err)},
+		}
+	}
+	return http.StatusOK, graphqlResponse{
+		Data:   responseData,
+		Errors: fieldErrors,
+	}
+}
+
+func (h *GraphqlHandler) Route() string {
+	return "/graph"
+}
+
+// parseRequest parses the query of a request and prepares the operation to
+// run.
+func parseRequest(request *graphqlRequest) (*ast.Document, error) {
+	doc, err := gql_parser.Parse(gql_parser.ParseParams{Source: request.Query})
+	if err != nil {
+		return nil, err
+	}
+	return graphql.PrepareOperation(doc, request.OperationName, request.Variables)
+}
+
+// requestError is the response to a request that could not be executed.
+// There is no data in the response.
+func requestError(err error) (int, any) {
+	responseErr := graphql.FormatError(err)
+	statusCode := http.StatusBadRequest
+	if responseErr.Extensions.Code == graphql.ErrorCodeForbidden {
+		statusCode = http.StatusForbidden
+	}
+	return statusCode, graphqlResponse{
+		Errors: graphql.Errors{responseErr},
+	}
+}
 
-	responseData, err := h.resolver.Resolve(r.Context(), doc)
+func writeGraphqlResponse(w http.ResponseWriter, mediaType string, statusCode int, response any) {
+	body, err := json.Marshal(response)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		util.InternalServerError(w, err)
 		return
 	}
-	println("successfully resolved")
-	response, err := json.Marshal(map[string]graphql.JsonValue{
-		"data": responseData,
-	})
-	w.WriteHeader(http.StatusOK)
-	w.Write(response)
+	w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+	w.WriteHeader(statusCode)
+	w.Write(body)
 }
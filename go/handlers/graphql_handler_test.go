@@ -1,13 +1,21 @@
 package handlers_test
 
 import (
+	"crypto/rand"
 	"io"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/sashankg/hold/acl"
 	"github.com/sashankg/hold/graphql"
 	"github.com/sashankg/hold/handlers"
+	"github.com/sashankg/hold/p2p"
 	"github.com/sashankg/hold/testing/mocks"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
@@ -24,7 +32,7 @@ func TestGraphqlHandler(t *testing.T) {
 		Resolve(gomock.Any(), gomock.Any()).
 		Return(graphql.JsonValue(`"hello world"`), nil)
 
-	req := httptest.NewRequest("GET", "/", strings.NewReader(`
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`
 		query {
 			findPost {
 				title
@@ -40,10 +48,145 @@ func TestGraphqlHandler(t *testing.T) {
 	`))
 	req.Header.Set("Content-Type", "application/graphql")
 	resp := httptest.NewRecorder()
-	handlers.NewGraphqlHandler(mockValidator, mockResolver).ServeHTTP(resp, req)
+	handlers.NewGraphqlHandler(mockValidator, mockResolver, mocks.NewMockIntrospector(ctrl), openAcl(t), newTokens(t)).ServeHTTP(resp, req)
 
 	body, err := io.ReadAll(resp.Result().Body)
 	require.NoError(t, err)
 	require.Equal(t, `{"data":"hello world"}`, string(body))
 	require.Equal(t, 200, resp.Code)
 }
+
+func openAcl(t *testing.T) acl.Authorizer {
+	authorizer, err := acl.NewAcl(acl.Policy{Open: true}, "")
+	require.NoError(t, err)
+	return authorizer
+}
+
+func newTokens(t *testing.T) *acl.Tokens {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	tokens, err := acl.NewTokens(key, time.Now)
+	require.NoError(t, err)
+	return tokens
+}
+
+func TestGraphqlHandlerUnknownPeer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	authorizer, err := acl.NewAcl(acl.Policy{
+		Roles: map[string]acl.Role{"owner": {Namespaces: map[string][]acl.Permission{"*": {acl.Read, acl.Write}}}},
+		Peers: map[string][]string{"QmNpBvAKWrjigDHP4Mn3LpqCmin5F2K9TiVFoFGTC6ayV3": {"owner"}},
+	}, "")
+	require.NoError(t, err)
+	handler := handlers.NewGraphqlHandler(
+		mocks.NewMockValidator(ctrl),
+		mocks.NewMockResolver(ctrl),
+		mocks.NewMockIntrospector(ctrl),
+		authorizer,
+		newTokens(t),
+	)
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("{ __schema { types { name } } }"))
+	req.Header.Set("Content-Type", "application/graphql")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	body, err := io.ReadAll(resp.Result().Body)
+	require.NoError(t, err)
+	require.Equal(t, 403, resp.Code)
+	require.Contains(t, string(body), graphql.ErrorCodeForbidden)
+}
+
+func TestGraphqlHandlerDefaultAcl(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	self, err := peer.Decode("QmNpBvAKWrjigDHP4Mn3LpqCmin5F2K9TiVFoFGTC6ayV3")
+	require.NoError(t, err)
+	other, err := peer.Decode("QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC")
+	require.NoError(t, err)
+	authorizer, err := acl.NewAcl(acl.Policy{}, self)
+	require.NoError(t, err)
+	handler := handlers.NewGraphqlHandler(
+		mocks.NewMockValidator(ctrl),
+		mocks.NewMockResolver(ctrl),
+		mocks.NewMockIntrospector(ctrl),
+		authorizer,
+		newTokens(t),
+	)
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("{ __schema { types { name } } }"))
+	req = req.WithContext(p2p.WithPeer(req.Context(), other))
+	req.Header.Set("Content-Type", "application/graphql")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	body, err := io.ReadAll(resp.Result().Body)
+	require.NoError(t, err)
+	require.Equal(t, 403, resp.Code)
+	require.Contains(t, string(body), graphql.ErrorCodeForbidden)
+}
+
+func TestGraphqlHandlerRequestErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	handler := handlers.NewGraphqlHandler(
+		mocks.NewMockValidator(ctrl),
+		mocks.NewMockResolver(ctrl),
+		mocks.NewMockIntrospector(ctrl),
+		openAcl(t),
+		newTokens(t),
+	)
+
+	for accept, statusCode := range map[string]int{
+		"":                                  200,
+		"application/json":                  200,
+		"application/graphql-response+json": 400,
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("query {\n  findPost(id: 1) {"))
+		req.Header.Set("Content-Type", "application/graphql")
+		req.Header.Set("Accept", accept)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		body, err := io.ReadAll(resp.Result().Body)
+		require.NoError(t, err)
+		require.Equal(t, statusCode, resp.Code, accept)
+		require.JSONEq(t, `{"errors": [{
+			"message": "Syntax Error GraphQL (2:20) Expected Name, found EOF",
+			"locations": [{"line": 2, "column": 20}],
+			"extensions": {"code": "GRAPHQL_PARSE_FAILED"}
+		}]}`, string(body))
+	}
+
+	req := httptest.NewRequest("GET", "/?query="+url.QueryEscape(`mutation { setPost(input: {}) { id } }`), nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	require.Equal(t, 405, resp.Code)
+}
+
+func TestGraphqlHandlerPartialData(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockValidator := mocks.NewMockValidator(ctrl)
+	mockResolver := mocks.NewMockResolver(ctrl)
+
+	mockValidator.EXPECT().ValidateRootSelections(gomock.Any(), gomock.Any()).Return(nil)
+	mockResolver.EXPECT().
+		Resolve(gomock.Any(), gomock.Any()).
+		Return(graphql.JsonValue(`{"findPost":null}`), graphql.Errors{{
+			Message:    "record not found",
+			Path:       []any{"findPost"},
+			Extensions: graphql.ErrorExtensions{Code: graphql.ErrorCodeNotFound},
+		}})
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{ findPost(id: 1) { title } }`))
+	req.Header.Set("Content-Type", "application/graphql")
+	req.Header.Set("Accept", "application/graphql-response+json")
+	resp := httptest.NewRecorder()
+	handlers.NewGraphqlHandler(mockValidator, mockResolver, mocks.NewMockIntrospector(ctrl), openAcl(t), newTokens(t)).ServeHTTP(resp, req)
+
+	body, err := io.ReadAll(resp.Result().Body)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.Code)
+	require.Equal(t, "application/graphql-response+json; charset=utf-8", resp.Header().Get("Content-Type"))
+	require.JSONEq(t, `{
+		"data": {"findPost": null},
+		"errors": [{"message": "record not found", "path": ["findPost"], "extensions": {"code": "NOT_FOUND"}}]
+	}`, string(body))
+}
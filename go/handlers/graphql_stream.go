@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-msgio"
+	"github.com/sashankg/hold/graphql"
+	"github.com/sashankg/hold/p2p"
+)
+
+// GraphqlProtocol serves GraphQL over a libp2p stream without HTTP. Every
+// message is a uvarint length followed by a JSON body. The client writes
+// requests of the form {"query", "variables", "operationName", "token"}, and
+// the server answers each with a {"data", "errors"} response, in order, until
+// the client closes the stream.
+const GraphqlProtocol = "/hold/graphql/1.0.0"
+
+const cMaxGraphqlMessageSize = 4 << 20
+
+func (h *GraphqlHandler) ServeStream(s network.Stream) {
+	defer s.Close()
+	ctx := p2p.WithPeer(context.Background(), s.Conn().RemotePeer())
+	reader := msgio.NewVarintReaderSize(s, cMaxGraphqlMessageSize)
+	writer := msgio.NewVarintWriter(s)
+	for {
+		message, err := reader.ReadMsg()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			logger.Warnw("error reading graphql message", "error", err)
+			s.Reset()
+			return
+		}
+		response := h.serveMessage(ctx, message)
+		reader.ReleaseMsg(message)
+		body, err := json.Marshal(response)
+		if err != nil {
+			logger.Warnw("error writing graphql message", "error", err)
+			s.Reset()
+			return
+		}
+		if err := writer.WriteMsg(body); err != nil {
+			s.Reset()
+			return
+		}
+	}
+}
+
+func (h *GraphqlHandler) serveMessage(ctx context.Context, message []byte) any {
+	request := graphqlRequest{}
+	if err := json.Unmarshal(message, &request); err != nil {
+		_, response := requestError(graphql.NewInvalidSchemaError("invalid request: "+err.Error(), nil))
+		return response
+	}
+	authorization := ""
+	if request.Token != "" {
+		authorization = "Bearer " + request.Token
+	}
+	ctx, _, response := h.authenticate(ctx, authorization)
+	if response != nil {
+		return response
+	}
+	if request.Query == "" {
+		_, response := requestError(graphql.NewInvalidSchemaError("no query", nil))
+		return response
+	}
+	_, response = h.execute(ctx, &request, true)
+	return response
+}
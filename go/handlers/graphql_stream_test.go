@@ -0,0 +1,69 @@
+package handlers_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/libp2p/go-msgio"
+	"github.com/sashankg/hold/graphql"
+	"github.com/sashankg/hold/handlers"
+	"github.com/sashankg/hold/p2p"
+	"github.com/sashankg/hold/testing/mocks"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestGraphqlStream(t *testing.T) {
+	network := mocknet.New()
+	defer network.Close()
+	server, err := network.GenPeer()
+	require.NoError(t, err)
+	client, err := network.GenPeer()
+	require.NoError(t, err)
+	require.NoError(t, network.LinkAll())
+
+	ctrl := gomock.NewController(t)
+	mockValidator := mocks.NewMockValidator(ctrl)
+	mockResolver := mocks.NewMockResolver(ctrl)
+	mockValidator.EXPECT().ValidateRootSelections(gomock.Any(), gomock.Any()).Return(nil)
+	mockResolver.EXPECT().
+		Resolve(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, _ any) ([]byte, error) {
+			// the remote peer is in the context, as for gostream requests
+			remote, ok := p2p.PeerFromContext(ctx)
+			require.True(t, ok)
+			require.Equal(t, client.ID(), remote)
+			return []byte(`{"findPost":{"title":"hello"}}`), nil
+		})
+	handler := handlers.NewGraphqlHandler(mockValidator, mockResolver, mocks.NewMockIntrospector(ctrl), openAcl(t), newTokens(t))
+	server.SetStreamHandler(handlers.GraphqlProtocol, handler.ServeStream)
+
+	_, err = network.ConnectPeers(client.ID(), server.ID())
+	require.NoError(t, err)
+	stream, err := client.NewStream(context.Background(), server.ID(), handlers.GraphqlProtocol)
+	require.NoError(t, err)
+	defer stream.Close()
+	writer := msgio.NewVarintWriter(stream)
+	reader := msgio.NewVarintReader(stream)
+
+	require.NoError(t, writer.WriteMsg([]byte(`{
+		"query": "query Find($id: Int) { findPost(id: $id) { title } }",
+		"variables": {"id": 1},
+		"operationName": "Find"
+	}`)))
+	response, err := reader.ReadMsg()
+	require.NoError(t, err)
+	require.JSONEq(t, `{"data": {"findPost": {"title": "hello"}}}`, string(response))
+
+	// the stream stays open for more requests
+	require.NoError(t, writer.WriteMsg([]byte(`{"query": "{ findPost(id: 1) {"}`)))
+	response, err = reader.ReadMsg()
+	require.NoError(t, err)
+	require.Contains(t, string(response), graphql.ErrorCodeParseFailed)
+
+	require.NoError(t, writer.WriteMsg([]byte(`{"query": "{ findPost(id: 1) { title } }", "token": "nope"}`)))
+	response, err = reader.ReadMsg()
+	require.NoError(t, err)
+	require.Contains(t, string(response), graphql.ErrorCodeUnauthenticated)
+}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-msgio"
+	"github.com/sashankg/hold/graphql"
+	"github.com/sashankg/hold/p2p"
+)
+
+var logger = logging.Logger("hold")
+
+// GraphqlWsProtocol serves the graphql-transport-ws protocol over a libp2p
+// stream, with every message framed like GraphqlProtocol. The connection is
+// reset where a WebSocket would be closed with an error code.
+const GraphqlWsProtocol = "/hold/graphql-ws/1.0.0"
+
+const cGraphqlWsSubprotocol = "graphql-transport-ws"
+
+// Message types of graphql-transport-ws.
+const (
+	cWsConnectionInit = "connection_init"
+	cWsConnectionAck  = "connection_ack"
+	cWsPing           = "ping"
+	cWsPong           = "pong"
+	cWsSubscribe      = "subscribe"
+	cWsNext           = "next"
+	cWsError          = "error"
+	cWsComplete       = "complete"
+)
+
+// Close codes of graphql-transport-ws.
+const (
+	cWsBadRequest          = 4400
+	cWsUnauthorized        = 4401
+	cWsForbidden           = 4403
+	cWsSubscriberExists    = 4409
+	cWsTooManyInitRequests = 4429
+)
+
+type wsMessage struct {
+	Id      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// wsInitPayload is the payload of connection_init. The token is a bearer
+// token, like in the Authorization header of HTTP requests.
+type wsInitPayload struct {
+	Token string `json:"token"`
+}
+
+// messageConn is a connection that carries whole graphql-ws messages.
+type messageConn interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage(message []byte) error
+	// Close ends the connection, with one of the graphql-ws close codes or
+	// websocket.CloseNormalClosure.
+	Close(code int, reason string) error
+}
+
+type GraphqlWsHandler struct {
+	graphql  *GraphqlHandler
+	upgrader websocket.Upgrader
+}
+
+func NewGraphqlWsHandler(graphqlHandler *GraphqlHandler) *GraphqlWsHandler {
+	return &GraphqlWsHandler{
+		graphqlHandler,
+		websocket.Upgrader{
+			Subprotocols: []string{cGraphqlWsSubprotocol},
+			// requests come from peers over libp2p, not from browser pages
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+var _ http.Handler = &GraphqlWsHandler{}
+
+func (h *GraphqlWsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already wrote the error response
+		logger.Warnw("error upgrading graphql-ws connection", "error", err)
+		return
+	}
+	conn.SetReadLimit(cMaxGraphqlMessageSize)
+	h.serve(r.Context(), &wsConn{conn}, r.Header.Get("Authorization"))
+}
+
+func (h *GraphqlWsHandler) Route() string {
+	return "/graph/ws"
+}
+
+func (h *GraphqlWsHandler) ServeStream(s network.Stream) {
+	ctx := p2p.WithPeer(context.Background(), s.Conn().RemotePeer())
+	h.serve(ctx, &streamConn{
+		stream: s,
+		reader: msgio.NewVarintReaderSize(s, cMaxGraphqlMessageSize),
+		writer: msgio.NewVarintWriter(s),
+	}, "")
+}
+
+// serve runs a graphql-ws session until the client goes away. The
+// authorization of the connection request is used unless connection_init
+// has a token.
+func (h *GraphqlWsHandler) serve(ctx context.Context, conn messageConn, authorization string) {
+	ctx, cancel := context.WithCancel(ctx)
+	session := &graphqlWsSession{
+		handler:       h.graphql,
+		conn:          conn,
+		subscriptions: map[string]context.CancelFunc{},
+	}
+	defer func() {
+		cancel()
+		session.wait.Wait()
+	}()
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				logger.Warnw("error reading graphql-ws message", "error", err)
+			}
+			conn.Close(websocket.CloseNormalClosure, "")
+			return
+		}
+		message := wsMessage{}
+		if err := json.Unmarshal(data, &message); err != nil {
+			conn.Close(cWsBadRequest, "invalid message")
+			return
+		}
+		switch message.Type {
+		case cWsConnectionInit:
+			if session.ctx != nil {
+				conn.Close(cWsTooManyInitRequests, "too many initialisation requests")
+				return
+			}
+			payload := wsInitPayload{}
+			if len(message.Payload) > 0 && string(message.Payload) != "null" {
+				if err := json.Unmarshal(message.Payload, &payload); err != nil {
+					conn.Close(cWsBadRequest, "invalid connection_init payload")
+					return
+				}
+			}
+			if payload.Token != "" {
+				authorization = "Bearer " + payload.Token
+			}
+			authenticated, _, response := h.graphql.authenticate(ctx, authorization)
+			if response != nil {
+				conn.Close(cWsForbidden, "forbidden")
+				return
+			}
+			session.ctx = authenticated
+			if err := session.write(wsMessage{Type: cWsConnectionAck}); err != nil {
+				return
+			}
+		case cWsPing:
+			if err := session.write(wsMessage{Type: cWsPong}); err != nil {
+				return
+			}
+		case cWsPong:
+		case cWsSubscribe:
+			if session.ctx == nil {
+				conn.Close(cWsUnauthorized, "unauthorized")
+				return
+			}
+			request := graphqlRequest{}
+			if message.Id == "" || json.Unmarshal(message.Payload, &request) != nil {
+				conn.Close(cWsBadRequest, "invalid subscribe message")
+				return
+			}
+			if !session.start(message.Id, &request) {
+				conn.Close(cWsSubscriberExists, "subscriber for "+message.Id+" already exists")
+				return
+			}
+		case cWsComplete:
+			session.stop(message.Id)
+		default:
+			conn.Close(cWsBadRequest, "unknown message type "+message.Type)
+			return
+		}
+	}
+}
+
+// graphqlWsSession keeps the operations of a connection by id. ctx is set
+// once the connection is acknowledged.
+type graphqlWsSession struct {
+	handler       *GraphqlHandler
+	conn          messageConn
+	ctx           context.Context
+	writeLock     sync.Mutex
+	lock          sync.Mutex
+	subscriptions map[string]context.CancelFunc
+	wait          sync.WaitGroup
+}
+
+func (s *graphqlWsSession) write(message wsMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	return s.conn.WriteMessage(data)
+}
+
+func (s *graphqlWsSession) writePayload(id string, messageType string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return s.write(wsMessage{Id: id, Type: messageType, Payload: data})
+}
+
+// start runs an operation, and reports false if one with the id is already
+// running.
+func (s *graphqlWsSession) start(id string, request *graphqlRequest) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.subscriptions[id]; ok {
+		return false
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.subscriptions[id] = cancel
+	s.wait.Add(1)
+	go func() {
+		defer s.wait.Done()
+		s.run(ctx, id, request)
+		// completed by the server, unless the client already completed it
+		s.lock.Lock()
+		_, running := s.subscriptions[id]
+		delete(s.subscriptions, id)
+		s.lock.Unlock()
+		cancel()
+		if !running {
+			return
+		}
+		s.write(wsMessage{Id: id, Type: cWsComplete})
+	}()
+	return true
+}
+
+// stop cancels an operation that the client completed.
+func (s *graphqlWsSession) stop(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if cancel, ok := s.subscriptions[id]; ok {
+		delete(s.subscriptions, id)
+		cancel()
+	}
+}
+
+// run sends the results of an operation. Queries and mutations have one next
+// message, subscriptions one per result. Requests that cannot be executed
+// get an error message, which ends the operation without a complete.
+func (s *graphqlWsSession) run(ctx context.Context, id string, request *graphqlRequest) {
+	doc, err := parseRequest(request)
+	if err == nil && !graphql.IsSubscription(doc) {
+		statusCode, response := s.handler.execute(ctx, request, true)
+		if statusCode == http.StatusBadRequest || statusCode == http.StatusForbidden {
+			if response, ok := response.(graphqlResponse); ok {
+				s.error(id, response.Errors)
+				return
+			}
+		}
+		s.writePayload(id, cWsNext, response)
+		return
+	}
+	if err == nil {
+		err = s.handler.validator.ValidateRootSelections(ctx, doc)
+	}
+	var results <-chan graphql.SubscriptionResult
+	if err == nil {
+		results, err = s.handler.resolver.Subscribe(ctx, doc)
+	}
+	if err != nil {
+		s.error(id, graphql.Errors{graphql.FormatError(err)})
+		return
+	}
+	for result := range results {
+		var fieldErrors graphql.Errors
+		if result.Err != nil && !errors.As(result.Err, &fieldErrors) {
+			logger.Errorw("error resolving subscription", "error", result.Err)
+			s.error(id, graphql.Errors{graphql.FormatError(result.Err)})
+			return
+		}
+		if err := s.writePayload(id, cWsNext, graphqlResponse{
+			Data:   result.Data,
+			Errors: fieldErrors,
+		}); err != nil {
+			return
+		}
+	}
+}
+
+func (s *graphqlWsSession) error(id string, errs graphql.Errors) {
+	s.lock.Lock()
+	delete(s.subscriptions, id)
+	s.lock.Unlock()
+	s.writePayload(id, cWsError, errs)
+}
+
+type wsConn struct {
+	*websocket.Conn
+}
+
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	_, data, err := c.Conn.ReadMessage()
+	return data, err
+}
+
+func (c *wsConn) WriteMessage(message []byte) error {
+	return c.Conn.WriteMessage(websocket.TextMessage, message)
+}
+
+func (c *wsConn) Close(code int, reason string) error {
+	c.Conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(time.Second),
+	)
+	return c.Conn.Close()
+}
+
+type streamConn struct {
+	stream network.Stream
+	reader msgio.ReadCloser
+	writer msgio.WriteCloser
+}
+
+func (c *streamConn) ReadMessage() ([]byte, error) {
+	message, err := c.reader.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	// the message is unmarshalled before the next read
+	data := append([]byte(nil), message...)
+	c.reader.ReleaseMsg(message)
+	return data, nil
+}
+
+func (c *streamConn) WriteMessage(message []byte) error {
+	return c.writer.WriteMsg(message)
+}
+
+func (c *streamConn) Close(code int, reason string) error {
+	if code != websocket.CloseNormalClosure {
+		logger.Infow("closing graphql-ws stream", "code", code, "reason", reason)
+		return c.stream.Reset()
+	}
+	return c.stream.Close()
+}
@@ -0,0 +1,114 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/libp2p/go-msgio"
+	"github.com/sashankg/hold/graphql"
+	"github.com/sashankg/hold/handlers"
+	"github.com/sashankg/hold/testing/mocks"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestGraphqlWsStream(t *testing.T) {
+	network := mocknet.New()
+	defer network.Close()
+	server, err := network.GenPeer()
+	require.NoError(t, err)
+	client, err := network.GenPeer()
+	require.NoError(t, err)
+	require.NoError(t, network.LinkAll())
+
+	ctrl := gomock.NewController(t)
+	mockValidator := mocks.NewMockValidator(ctrl)
+	mockResolver := mocks.NewMockResolver(ctrl)
+	results := make(chan graphql.SubscriptionResult)
+	mockValidator.EXPECT().ValidateRootSelections(gomock.Any(), gomock.Any()).Return(nil)
+	mockResolver.EXPECT().Subscribe(gomock.Any(), gomock.Any()).Return(results, nil)
+	handler := handlers.NewGraphqlWsHandler(
+		handlers.NewGraphqlHandler(mockValidator, mockResolver, mocks.NewMockIntrospector(ctrl), openAcl(t), newTokens(t)),
+	)
+	server.SetStreamHandler(handlers.GraphqlWsProtocol, handler.ServeStream)
+
+	_, err = network.ConnectPeers(client.ID(), server.ID())
+	require.NoError(t, err)
+	stream, err := client.NewStream(context.Background(), server.ID(), handlers.GraphqlWsProtocol)
+	require.NoError(t, err)
+	defer stream.Close()
+	writer := msgio.NewVarintWriter(stream)
+	reader := msgio.NewVarintReader(stream)
+	read := func() string {
+		message, err := reader.ReadMsg()
+		require.NoError(t, err)
+		return string(message)
+	}
+
+	require.NoError(t, writer.WriteMsg([]byte(`{"type": "connection_init"}`)))
+	require.JSONEq(t, `{"type": "connection_ack"}`, read())
+	require.NoError(t, writer.WriteMsg([]byte(`{"type": "ping"}`)))
+	require.JSONEq(t, `{"type": "pong"}`, read())
+
+	require.NoError(t, writer.WriteMsg([]byte(`{
+		"id": "1",
+		"type": "subscribe",
+		"payload": {"query": "subscription { watchPost(id: 1) { title } }"}
+	}`)))
+	results <- graphql.SubscriptionResult{Data: []byte(`{"watchPost":{"title":"hello"}}`)}
+	require.JSONEq(t, `{
+		"id": "1",
+		"type": "next",
+		"payload": {"data": {"watchPost": {"title": "hello"}}}
+	}`, read())
+	results <- graphql.SubscriptionResult{Data: []byte(`{"watchPost":{"title":"bye"}}`)}
+	require.JSONEq(t, `{
+		"id": "1",
+		"type": "next",
+		"payload": {"data": {"watchPost": {"title": "bye"}}}
+	}`, read())
+	close(results)
+	require.JSONEq(t, `{"id": "1", "type": "complete"}`, read())
+
+	// parse errors end the operation with an error message
+	require.NoError(t, writer.WriteMsg([]byte(`{
+		"id": "2",
+		"type": "subscribe",
+		"payload": {"query": "subscription { watchPost(id: 1) {"}
+	}`)))
+	response := read()
+	require.Contains(t, response, `"type":"error"`)
+	require.Contains(t, response, graphql.ErrorCodeParseFailed)
+}
+
+func TestGraphqlWsUnauthorized(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	handler := handlers.NewGraphqlWsHandler(handlers.NewGraphqlHandler(
+		mocks.NewMockValidator(ctrl),
+		mocks.NewMockResolver(ctrl),
+		mocks.NewMockIntrospector(ctrl),
+		openAcl(t),
+		newTokens(t),
+	))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{"graphql-transport-ws"}}
+	conn, _, err := dialer.Dial(strings.Replace(server.URL, "http", "ws", 1)+handler.Route(), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, "graphql-transport-ws", conn.Subprotocol())
+
+	// subscribing before connection_init closes the connection
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{
+		"id": "1",
+		"type": "subscribe",
+		"payload": {"query": "subscription { watchPost(id: 1) { title } }"}
+	}`)))
+	_, _, err = conn.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, 4401), err)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	gql_parser "github.com/graphql-go/graphql/language/parser"
+	"github.com/sashankg/hold/acl"
+	"github.com/sashankg/hold/dao"
+	"github.com/sashankg/hold/graphql"
+	"github.com/sashankg/hold/util"
+)
+
+type SchemaHandler struct {
+	registrar  graphql.Registrar
+	dao        dao.CollectionDao
+	authorizer acl.Authorizer
+}
+
+func NewSchemaHandler(registrar graphql.Registrar, dao dao.CollectionDao, authorizer acl.Authorizer) *SchemaHandler {
+	return &SchemaHandler{
+		registrar,
+		dao,
+		authorizer,
+	}
+}
+
+var _ http.Handler = &SchemaHandler{}
+
+func (h *SchemaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizer.Allowed(r.Context(), "", "", acl.Admin) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		collections, err := h.dao.ListCollections(r.Context())
+		if err != nil {
+			util.InternalServerError(w, err)
+			return
+		}
+		writeJson(w, http.StatusOK, collections)
+	case http.MethodPost:
+		source, err := io.ReadAll(r.Body)
+		if err != nil {
+			util.InternalServerError(w, err)
+			return
+		}
+		doc, err := gql_parser.Parse(gql_parser.ParseParams{Source: string(source)})
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		options := graphql.RegisterOptions{
+			AllowDestructive: r.URL.Query().Get("allowDestructive") == "true",
+		}
+		collections, err := h.registrar.RegisterSchema(r.Context(), doc, options)
+		if err != nil {
+			var schemaErr *graphql.InvalidSchemaError
+			if errors.As(err, &schemaErr) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			var destructiveErr *graphql.DestructiveChangeError
+			if errors.As(err, &destructiveErr) {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			util.InternalServerError(w, err)
+			return
+		}
+		writeJson(w, http.StatusCreated, collections)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *SchemaHandler) Route() string {
+	return "/schema"
+}
+
+func writeJson(w http.ResponseWriter, statusCode int, value any) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		util.InternalServerError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
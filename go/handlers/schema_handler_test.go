@@ -0,0 +1,115 @@
+package handlers_test
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sashankg/hold/acl"
+	"github.com/sashankg/hold/dao"
+	"github.com/sashankg/hold/graphql"
+	"github.com/sashankg/hold/handlers"
+	"github.com/sashankg/hold/testing/mocks"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestSchemaHandlerRegister(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRegistrar := mocks.NewMockRegistrar(ctrl)
+	mockDao := mocks.NewMockDao(ctrl)
+
+	mockRegistrar.EXPECT().
+		RegisterSchema(gomock.Any(), gomock.Any(), gomock.Eq(graphql.RegisterOptions{})).
+		Return([]*dao.Collection{
+			{
+				Id:   1,
+				Name: "Post",
+				Fields: map[string]dao.CollectionField{
+					"title": {Name: "title", Type: "String"},
+				},
+			},
+		}, nil)
+
+	req := httptest.NewRequest("POST", "/schema", strings.NewReader(`
+		type Post {
+			title: String
+		}
+	`))
+	req.Header.Set("Content-Type", "application/graphql")
+	resp := httptest.NewRecorder()
+	handlers.NewSchemaHandler(mockRegistrar, mockDao, openAcl(t)).ServeHTTP(resp, req)
+
+	body, err := io.ReadAll(resp.Result().Body)
+	require.NoError(t, err)
+	require.Equal(t, 201, resp.Code)
+	require.JSONEq(t, `[{
+		"id": 1,
+		"name": "Post",
+		"domain": "",
+		"version": "",
+		"fields": {"title": {"name": "title", "type": "String"}}
+	}]`, string(body))
+}
+
+func TestSchemaHandlerRegisterInvalidDocument(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRegistrar := mocks.NewMockRegistrar(ctrl)
+	mockDao := mocks.NewMockDao(ctrl)
+
+	req := httptest.NewRequest("POST", "/schema", strings.NewReader(`type Post {`))
+	resp := httptest.NewRecorder()
+	handlers.NewSchemaHandler(mockRegistrar, mockDao, openAcl(t)).ServeHTTP(resp, req)
+
+	require.Equal(t, 400, resp.Code)
+}
+
+func TestSchemaHandlerList(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRegistrar := mocks.NewMockRegistrar(ctrl)
+	mockDao := mocks.NewMockDao(ctrl)
+
+	mockDao.EXPECT().
+		ListCollections(gomock.Any()).
+		Return([]*dao.Collection{
+			{
+				Id:     2,
+				Name:   "Person",
+				Domain: "social",
+				Fields: map[string]dao.CollectionField{
+					"friends": {Name: "friends", Type: "Person", Ref: 2, IsList: true},
+				},
+			},
+		}, nil)
+
+	req := httptest.NewRequest("GET", "/schema", nil)
+	resp := httptest.NewRecorder()
+	handlers.NewSchemaHandler(mockRegistrar, mockDao, openAcl(t)).ServeHTTP(resp, req)
+
+	body, err := io.ReadAll(resp.Result().Body)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.Code)
+	require.JSONEq(t, `[{
+		"id": 2,
+		"name": "Person",
+		"domain": "social",
+		"version": "",
+		"fields": {"friends": {"name": "friends", "type": "Person", "ref": 2, "isList": true}}
+	}]`, string(body))
+}
+
+func TestSchemaHandlerForbidden(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	authorizer, err := acl.NewAcl(acl.Policy{
+		Roles: map[string]acl.Role{"owner": {Namespaces: map[string][]acl.Permission{"*": {acl.Read, acl.Write}}}},
+		Peers: map[string][]string{acl.Everyone: {"owner"}},
+	}, "")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/schema", strings.NewReader(`type Post { title: String }`))
+	resp := httptest.NewRecorder()
+	handlers.NewSchemaHandler(mocks.NewMockRegistrar(ctrl), mocks.NewMockDao(ctrl), authorizer).ServeHTTP(resp, req)
+
+	require.Equal(t, 403, resp.Code)
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/sashankg/hold/acl"
+	"github.com/sashankg/hold/p2p"
+)
+
+// StatusHandler reports how the node is reachable, and whether the requesting
+// peer is connected directly or through a relay.
+type StatusHandler struct {
+	node       *p2p.Node
+	authorizer acl.Authorizer
+}
+
+func NewStatusHandler(node *p2p.Node, authorizer acl.Authorizer) *StatusHandler {
+	return &StatusHandler{
+		node,
+		authorizer,
+	}
+}
+
+var _ http.Handler = &StatusHandler{}
+
+func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizer.Allowed(r.Context(), "", "", acl.Admin) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	remote, _ := p2p.PeerFromContext(r.Context())
+	writeJson(w, http.StatusOK, h.node.Status(remote))
+}
+
+func (h *StatusHandler) Route() string {
+	return "/status"
+}
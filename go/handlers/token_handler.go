@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sashankg/hold/acl"
+	"github.com/sashankg/hold/p2p"
+	"github.com/sashankg/hold/util"
+)
+
+// TokenHandler issues capability tokens signed by the node on POST. A peer
+// can only issue capabilities its roles grant, so a token is never a way to
+// get more access or to extend one. Holders narrow a token further with
+// acl.Delegate.
+type TokenHandler struct {
+	tokens     *acl.Tokens
+	authorizer acl.Authorizer
+}
+
+func NewTokenHandler(tokens *acl.Tokens, authorizer acl.Authorizer) *TokenHandler {
+	return &TokenHandler{
+		tokens,
+		authorizer,
+	}
+}
+
+var _ http.Handler = &TokenHandler{}
+
+type tokenRequest struct {
+	// Audience is the peer id of the holder, and defaults to the requester.
+	Audience     string           `json:"audience"`
+	Capabilities []acl.Capability `json:"capabilities"`
+	// ExpiresIn is a duration like 720h.
+	ExpiresIn string `json:"expiresIn"`
+}
+
+type tokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (h *TokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	request := tokenRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	audience, _ := p2p.PeerFromContext(r.Context())
+	if request.Audience != "" {
+		var err error
+		audience, err = peer.Decode(request.Audience)
+		if err != nil {
+			http.Error(w, "invalid audience", http.StatusBadRequest)
+			return
+		}
+	}
+	if audience == "" {
+		http.Error(w, "no audience", http.StatusBadRequest)
+		return
+	}
+	expiresIn, err := time.ParseDuration(request.ExpiresIn)
+	if err != nil || expiresIn <= 0 {
+		http.Error(w, "invalid expiresIn", http.StatusBadRequest)
+		return
+	}
+	if len(request.Capabilities) == 0 {
+		http.Error(w, "no capabilities", http.StatusBadRequest)
+		return
+	}
+	for _, capability := range request.Capabilities {
+		for _, permission := range capability.Permissions {
+			if !h.authorizer.Allowed(r.Context(), capability.Namespace, capability.Collection, permission) {
+				http.Error(w, "cannot grant "+string(permission)+" on "+capability.Namespace, http.StatusForbidden)
+				return
+			}
+		}
+	}
+	expiresAt := time.Now().Add(expiresIn).Truncate(time.Second)
+	token, err := h.tokens.Issue(audience, request.Capabilities, expiresAt)
+	if err != nil {
+		util.InternalServerError(w, err)
+		return
+	}
+	writeJson(w, http.StatusOK, tokenResponse{Token: token, ExpiresAt: expiresAt})
+}
+
+func (h *TokenHandler) Route() string {
+	return "/tokens"
+}
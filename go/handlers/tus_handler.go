@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sashankg/hold/acl"
+	"github.com/sashankg/hold/blobs"
+	"github.com/sashankg/hold/util"
+)
+
+const (
+	cTusVersion    = "1.0.0"
+	cTusExtensions = "creation,termination,expiration"
+	// cTusChecksumMismatch is the status of the checksum extension for
+	// content that does not match its checksum.
+	cTusChecksumMismatch = 460
+	cOffsetMediaType     = "application/offset+octet-stream"
+)
+
+// TusHandler implements the tus resumable upload protocol, version 1.0.0
+// with the creation, termination and expiration extensions. Uploads are
+// created at /files/ and appended to at /files/<id>. A complete upload is
+// put into the blob store, and its digest is returned in the Blob-Digest
+// header.
+//
+// The metadata keys filetype and digest set the mime type of the blob and
+// the digest the content has to match.
+type TusHandler struct {
+	uploads    blobs.Uploads
+	maxSize    int64
+	authorizer acl.Authorizer
+}
+
+func NewTusHandler(uploads blobs.Uploads, maxSize int64, authorizer acl.Authorizer) *TusHandler {
+	return &TusHandler{
+		uploads,
+		maxSize,
+		authorizer,
+	}
+}
+
+var _ http.Handler = &TusHandler{}
+
+func (h *TusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", cTusVersion)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", cTusVersion)
+		w.Header().Set("Tus-Extension", cTusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.maxSize, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != cTusVersion {
+		w.Header().Set("Tus-Version", cTusVersion)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	if !h.authorizer.Allowed(r.Context(), "", "", acl.Upload) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, h.Route())
+	switch {
+	case r.Method == http.MethodPost && id == "":
+		h.create(w, r)
+	case r.Method == http.MethodHead && id != "":
+		h.head(w, r, id)
+	case r.Method == http.MethodPatch && id != "":
+		h.patch(w, r, id)
+	case r.Method == http.MethodDelete && id != "":
+		h.delete(w, r, id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *TusHandler) create(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if length > h.maxSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	rawMetadata := r.Header.Get("Upload-Metadata")
+	metadata, err := parseUploadMetadata(rawMetadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	upload, err := h.uploads.Create(r.Context(), blobs.UploadSpec{
+		Length:   length,
+		Metadata: rawMetadata,
+		MimeType: metadata["filetype"],
+		Digest:   metadata["digest"],
+	})
+	if err != nil {
+		if errors.Is(err, blobs.ErrInvalidDigest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		util.InternalServerError(w, err)
+		return
+	}
+	w.Header().Set("Location", h.Route()+upload.Id)
+	writeUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *TusHandler) head(w http.ResponseWriter, r *http.Request, id string) {
+	upload, err := h.uploads.Get(r.Context(), id)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	writeUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *TusHandler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != cOffsetMediaType {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	upload, err := h.uploads.Append(r.Context(), id, offset, r.Body)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	writeUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TusHandler) delete(w http.ResponseWriter, r *http.Request, id string) {
+	if err := h.uploads.Delete(r.Context(), id); err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TusHandler) Route() string {
+	return "/files/"
+}
+
+func writeUploadHeaders(w http.ResponseWriter, upload *blobs.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	if upload.IsFinal() {
+		w.Header().Set("Blob-Digest", upload.BlobDigest)
+	}
+}
+
+func writeUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, blobs.ErrOffsetMismatch):
+		w.WriteHeader(http.StatusConflict)
+	case errors.Is(err, blobs.ErrDigestMismatch):
+		http.Error(w, err.Error(), cTusChecksumMismatch)
+	default:
+		util.InternalServerError(w, err)
+	}
+}
+
+// parseUploadMetadata parses the comma separated pairs of a key and an
+// optional base64 value in the Upload-Metadata header.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("invalid Upload-Metadata")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("invalid Upload-Metadata value for " + key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
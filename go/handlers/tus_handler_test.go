@@ -0,0 +1,129 @@
+package handlers_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sashankg/hold/blobs"
+	"github.com/sashankg/hold/handlers"
+	"github.com/sashankg/hold/testing/util"
+	"github.com/stretchr/testify/require"
+)
+
+func newTusHandler(t *testing.T) (*handlers.TusHandler, blobs.Store) {
+	db := util.NewMemoryDao(t).SchemaDb
+	store, err := blobs.NewStore(db, t.TempDir(), &blobs.Sha256Hasher{})
+	require.NoError(t, err)
+	uploads, err := blobs.NewUploads(db, store, time.Hour)
+	require.NoError(t, err)
+	return handlers.NewTusHandler(uploads, 1024, openAcl(t)), store
+}
+
+func tusRequest(handler http.Handler, method string, path string, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for i := 0; i < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestTusHandler(t *testing.T) {
+	handler, store := newTusHandler(t)
+	content := "hello resumable world"
+	digest := sha256.Sum256([]byte(content))
+	metadata := "filetype " + base64.StdEncoding.EncodeToString([]byte("text/plain")) +
+		",digest " + base64.StdEncoding.EncodeToString([]byte(hex.EncodeToString(digest[:])))
+
+	resp := tusRequest(handler, "POST", "/files/", "",
+		"Upload-Length", strconv.Itoa(len(content)),
+		"Upload-Metadata", metadata,
+	)
+	require.Equal(t, 201, resp.Code)
+	location := resp.Header().Get("Location")
+	require.True(t, strings.HasPrefix(location, "/files/"))
+	require.NotEmpty(t, resp.Header().Get("Upload-Expires"))
+
+	resp = tusRequest(handler, "PATCH", location, content[:6],
+		"Content-Type", "application/offset+octet-stream",
+		"Upload-Offset", "0",
+	)
+	require.Equal(t, 204, resp.Code)
+	require.Equal(t, "6", resp.Header().Get("Upload-Offset"))
+
+	// the client lost the response and resends the first chunk
+	resp = tusRequest(handler, "PATCH", location, content[:6],
+		"Content-Type", "application/offset+octet-stream",
+		"Upload-Offset", "0",
+	)
+	require.Equal(t, 409, resp.Code)
+
+	resp = tusRequest(handler, "HEAD", location, "")
+	require.Equal(t, 200, resp.Code)
+	require.Equal(t, "6", resp.Header().Get("Upload-Offset"))
+	require.Equal(t, strconv.Itoa(len(content)), resp.Header().Get("Upload-Length"))
+	require.Equal(t, metadata, resp.Header().Get("Upload-Metadata"))
+	require.Equal(t, "no-store", resp.Header().Get("Cache-Control"))
+
+	resp = tusRequest(handler, "PATCH", location, content[6:],
+		"Content-Type", "application/offset+octet-stream",
+		"Upload-Offset", "6",
+	)
+	require.Equal(t, 204, resp.Code)
+	require.Equal(t, strconv.Itoa(len(content)), resp.Header().Get("Upload-Offset"))
+	require.Equal(t, hex.EncodeToString(digest[:]), resp.Header().Get("Blob-Digest"))
+
+	blob, err := store.Stat(context.Background(), hex.EncodeToString(digest[:]))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), blob.Size)
+	require.Equal(t, "text/plain", blob.MimeType)
+
+	resp = tusRequest(handler, "DELETE", location, "")
+	require.Equal(t, 204, resp.Code)
+	resp = tusRequest(handler, "HEAD", location, "")
+	require.Equal(t, 404, resp.Code)
+}
+
+func TestTusHandlerErrors(t *testing.T) {
+	handler, _ := newTusHandler(t)
+
+	resp := tusRequest(handler, "OPTIONS", "/files/", "", "Tus-Resumable", "")
+	require.Equal(t, 204, resp.Code)
+	require.Equal(t, "1.0.0", resp.Header().Get("Tus-Version"))
+	require.Equal(t, "1024", resp.Header().Get("Tus-Max-Size"))
+
+	resp = tusRequest(handler, "POST", "/files/", "", "Tus-Resumable", "0.2.2", "Upload-Length", "1")
+	require.Equal(t, 412, resp.Code)
+
+	resp = tusRequest(handler, "POST", "/files/", "", "Upload-Length", "2048")
+	require.Equal(t, 413, resp.Code)
+
+	resp = tusRequest(handler, "POST", "/files/", "",
+		"Upload-Length", "5",
+		"Upload-Metadata", "digest "+base64.StdEncoding.EncodeToString([]byte(strings.Repeat("0", 64))),
+	)
+	require.Equal(t, 201, resp.Code)
+	location := resp.Header().Get("Location")
+
+	resp = tusRequest(handler, "PATCH", location, "hello", "Upload-Offset", "0")
+	require.Equal(t, 415, resp.Code)
+
+	resp = tusRequest(handler, "PATCH", location, "hello",
+		"Content-Type", "application/offset+octet-stream",
+		"Upload-Offset", "0",
+	)
+	require.Equal(t, 460, resp.Code)
+	resp = tusRequest(handler, "HEAD", location, "")
+	require.Equal(t, 404, resp.Code)
+}
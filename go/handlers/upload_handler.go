@@ -1,60 +1,76 @@
 package handlers
 
 import (
-	"encoding/base64"
-	"hash"
-	"hash/fnv"
 	"io"
 	"net/http"
-	"os"
 
+	"github.com/sashankg/hold/acl"
+	"github.com/sashankg/hold/blobs"
 	"github.com/sashankg/hold/util"
 )
 
+// UploadHandler stores every file of a multipart upload in the blob store
+// and responds with their metadata, in the order of the parts.
 type UploadHandler struct {
-	hasher Hasher
+	store blobs.Store
+	// page is the path of the upload form served on GET.
+	page       string
+	authorizer acl.Authorizer
 }
 
 func NewUploadHandler(
-	hasher Hasher,
+	store blobs.Store,
+	page string,
+	authorizer acl.Authorizer,
 ) *UploadHandler {
 	return &UploadHandler{
-		hasher,
+		store,
+		page,
+		authorizer,
 	}
 }
 
 func (h *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		http.ServeFile(w, r, "../server/static/upload.html")
+		http.ServeFile(w, r, h.page)
 	case http.MethodPost:
+		if !h.authorizer.Allowed(r.Context(), "", "", acl.Upload) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 		reader, err := r.MultipartReader()
 		if err != nil {
-			util.InternalServerError(w, err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
 			return
 		}
+		uploaded := []*blobs.Blob{}
 		for {
 			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
 			if err != nil {
-				if err == io.EOF {
-					break
-				}
 				util.InternalServerError(w, err)
 				return
 			}
-			println(part.FileName(), part.FormName(), part.Header)
-			file, err := os.Create("uploads/" + part.FileName())
-			defer file.Close()
-
-			hash := h.hasher.New()
-
-			mw := io.MultiWriter(hash, file)
-
-			io.Copy(mw, part)
-
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(base64.StdEncoding.EncodeToString(hash.Sum(nil))))
+			if part.FileName() == "" {
+				continue
+			}
+			mimeType := part.Header.Get("Content-Type")
+			if mimeType == "application/octet-stream" {
+				// most clients send this when they do not know better
+				mimeType = ""
+			}
+			blob, err := h.store.Put(r.Context(), part, mimeType)
+			if err != nil {
+				util.InternalServerError(w, err)
+				return
+			}
+			uploaded = append(uploaded, blob)
 		}
+		writeJson(w, http.StatusOK, uploaded)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
@@ -63,13 +79,3 @@ func (h *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (h *UploadHandler) Route() string {
 	return "/upload"
 }
-
-type Hasher interface {
-	New() hash.Hash
-}
-
-type FnvHasher struct{}
-
-func (f *FnvHasher) New() hash.Hash {
-	return fnv.New128()
-}
@@ -0,0 +1,31 @@
+package p2p
+
+import (
+	"context"
+	"net"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+type peerKey struct{}
+
+func WithPeer(ctx context.Context, id peer.ID) context.Context {
+	return context.WithValue(ctx, peerKey{}, id)
+}
+
+// PeerFromContext returns the remote peer of a request that came over libp2p.
+func PeerFromContext(ctx context.Context) (peer.ID, bool) {
+	id, ok := ctx.Value(peerKey{}).(peer.ID)
+	return id, ok
+}
+
+// ConnContext adds the remote peer of a gostream connection to the context of
+// its requests, for use as http.Server.ConnContext.
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	// gostream addresses are the peer id
+	id, err := peer.Decode(conn.RemoteAddr().String())
+	if err != nil {
+		return ctx
+	}
+	return WithPeer(ctx, id)
+}
@@ -0,0 +1,184 @@
+package p2p
+
+import (
+	"sync"
+
+	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var logger = logging.Logger("hold")
+
+type ConnectionType string
+
+const (
+	ConnectionNone    ConnectionType = "none"
+	ConnectionDirect  ConnectionType = "direct"
+	ConnectionRelayed ConnectionType = "relayed"
+)
+
+type PeerStatus struct {
+	Id         peer.ID        `json:"id"`
+	Connection ConnectionType `json:"connection"`
+	Addrs      []string       `json:"addrs"`
+}
+
+type HolePunchStats struct {
+	Attempts  int `json:"attempts"`
+	Successes int `json:"successes"`
+	Failures  int `json:"failures"`
+}
+
+// NodeStatus describes how the node is reachable and how it is connected to
+// its peers. Peer is the peer that asked for the status, if it is connected.
+type NodeStatus struct {
+	Id           peer.ID        `json:"id"`
+	Reachability string         `json:"reachability"`
+	Addrs        []string       `json:"addrs"`
+	Relays       []RelayStatus  `json:"relays"`
+	HolePunches  HolePunchStats `json:"holePunches"`
+	Peer         *PeerStatus    `json:"peer,omitempty"`
+	Peers        []PeerStatus   `json:"peers"`
+}
+
+// Node ties the host to its relays, and keeps track of its reachability as
+// found by AutoNAT and of the outcome of hole punches.
+type Node struct {
+	relays *RelayManager
+
+	host         host.Host
+	subscription event.Subscription
+
+	mutex        sync.Mutex
+	reachability network.Reachability
+	holePunches  HolePunchStats
+}
+
+func NewNode(relays *RelayManager) *Node {
+	return &Node{
+		relays: relays,
+	}
+}
+
+// Options configures a host to advertise its relayed addresses, to run
+// AutoNAT for other peers, to map a port on the NAT and to upgrade relayed
+// connections to direct ones by hole punching.
+func (n *Node) Options() []libp2p.Option {
+	return []libp2p.Option{
+		libp2p.AddrsFactory(n.relays.AddrsFactory),
+		libp2p.EnableNATService(),
+		libp2p.NATPortMap(),
+		libp2p.EnableHolePunching(holepunch.WithTracer(n)),
+	}
+}
+
+// Start watches a host created with Options.
+func (n *Node) Start(h host.Host) error {
+	subscription, err := h.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		return err
+	}
+	n.host = h
+	n.subscription = subscription
+	h.Network().Notify(n.relays.Notifiee())
+	go func() {
+		for e := range subscription.Out() {
+			reachability := e.(event.EvtLocalReachabilityChanged).Reachability
+			logger.Infow("Reachability", "reachability", reachability)
+			n.mutex.Lock()
+			n.reachability = reachability
+			n.mutex.Unlock()
+		}
+	}()
+	return nil
+}
+
+func (n *Node) Close() error {
+	if n.subscription == nil {
+		return nil
+	}
+	return n.subscription.Close()
+}
+
+var _ holepunch.EventTracer = &Node{}
+
+func (n *Node) Trace(evt *holepunch.Event) {
+	if evt.Type != holepunch.EndHolePunchEvtT {
+		return
+	}
+	end := evt.Evt.(*holepunch.EndHolePunchEvt)
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.holePunches.Attempts++
+	if end.Success {
+		n.holePunches.Successes++
+		logger.Infow("Hole punched", "peer", evt.Remote)
+	} else {
+		n.holePunches.Failures++
+		logger.Infow("Hole punch failed", "peer", evt.Remote, "error", end.Error)
+	}
+}
+
+// Status reports the state of the node, and the connection to remote if it is
+// not empty.
+func (n *Node) Status(remote peer.ID) *NodeStatus {
+	n.mutex.Lock()
+	status := &NodeStatus{
+		Id:           n.host.ID(),
+		Reachability: n.reachability.String(),
+		Addrs:        addrStrings(n.host.Addrs()),
+		Relays:       n.relays.Status(),
+		HolePunches:  n.holePunches,
+		Peers:        []PeerStatus{},
+	}
+	n.mutex.Unlock()
+	for _, id := range n.host.Network().Peers() {
+		peerStatus := n.peerStatus(id)
+		status.Peers = append(status.Peers, *peerStatus)
+		if id == remote {
+			status.Peer = peerStatus
+		}
+	}
+	return status
+}
+
+func (n *Node) peerStatus(id peer.ID) *PeerStatus {
+	conns := n.host.Network().ConnsToPeer(id)
+	addrs := make([]ma.Multiaddr, len(conns))
+	for i, conn := range conns {
+		addrs[i] = conn.RemoteMultiaddr()
+	}
+	return &PeerStatus{
+		Id:         id,
+		Connection: GetConnectionType(conns),
+		Addrs:      addrStrings(addrs),
+	}
+}
+
+// GetConnectionType is direct if any of the connections to a peer is direct,
+// which is the case after a successful hole punch.
+func GetConnectionType(conns []network.Conn) ConnectionType {
+	connectionType := ConnectionNone
+	for _, conn := range conns {
+		if _, err := conn.RemoteMultiaddr().ValueForProtocol(ma.P_CIRCUIT); err == nil {
+			connectionType = ConnectionRelayed
+			continue
+		}
+		return ConnectionDirect
+	}
+	return connectionType
+}
+
+func addrStrings(addrs []ma.Multiaddr) []string {
+	strings := make([]string, len(addrs))
+	for i, addr := range addrs {
+		strings[i] = addr.String()
+	}
+	return strings
+}
@@ -0,0 +1,65 @@
+package p2p_test
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/sashankg/hold/p2p"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeStatus(t *testing.T) {
+	mn := mocknet.New()
+	defer mn.Close()
+	h, err := mn.GenPeer()
+	require.NoError(t, err)
+	direct, err := mn.GenPeer()
+	require.NoError(t, err)
+	// a peer that is only reachable through a relay
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	relayed, err := mn.AddPeer(key, ma.StringCast(
+		"/ip4/10.0.0.1/tcp/4001/p2p/QmNpBvAKWrjigDHP4Mn3LpqCmin5F2K9TiVFoFGTC6ayV3/p2p-circuit",
+	))
+	require.NoError(t, err)
+	require.NoError(t, mn.LinkAll())
+	_, err = mn.ConnectPeers(h.ID(), direct.ID())
+	require.NoError(t, err)
+	_, err = mn.ConnectPeers(h.ID(), relayed.ID())
+	require.NoError(t, err)
+
+	node := p2p.NewNode(p2p.NewRelayManager(nil, nil, p2p.DefaultRelayOptions()))
+	require.NoError(t, node.Start(h))
+	defer node.Close()
+
+	emitter, err := h.EventBus().Emitter(new(event.EvtLocalReachabilityChanged))
+	require.NoError(t, err)
+	require.NoError(t, emitter.Emit(event.EvtLocalReachabilityChanged{Reachability: network.ReachabilityPrivate}))
+	require.Eventually(t, func() bool {
+		return node.Status("").Reachability == "Private"
+	}, time.Second, 10*time.Millisecond)
+
+	status := node.Status(relayed.ID())
+	require.Equal(t, h.ID(), status.Id)
+	require.Len(t, status.Peers, 2)
+	require.Equal(t, relayed.ID(), status.Peer.Id)
+	require.Equal(t, p2p.ConnectionRelayed, status.Peer.Connection)
+
+	node.Trace(&holepunch.Event{
+		Remote: relayed.ID(),
+		Type:   holepunch.EndHolePunchEvtT,
+		Evt:    &holepunch.EndHolePunchEvt{Success: true},
+	})
+	status = node.Status(direct.ID())
+	require.Equal(t, p2p.ConnectionDirect, status.Peer.Connection)
+	require.Equal(t, p2p.HolePunchStats{Attempts: 1, Successes: 1}, status.HolePunches)
+	require.Nil(t, node.Status(peer.ID("unknown")).Peer)
+}
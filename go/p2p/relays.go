@@ -0,0 +1,248 @@
+package p2p
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ReserveFunc makes a reservation on a circuit relay.
+type ReserveFunc func(ctx context.Context, relay peer.AddrInfo) (*client.Reservation, error)
+
+// HostReserver reserves relay slots for a host. The host is looked up on every
+// call, so that the reserver can be created before the host.
+func HostReserver(h *host.Host) ReserveFunc {
+	return func(ctx context.Context, relay peer.AddrInfo) (*client.Reservation, error) {
+		return client.Reserve(ctx, *h, relay)
+	}
+}
+
+type RelayState string
+
+const (
+	RelayIdle     RelayState = "idle"
+	RelayReserved RelayState = "reserved"
+	RelayFailed   RelayState = "failed"
+)
+
+// RelayStatus is the reservation state of one relay. Reservations and
+// Failures count every attempt since the manager started.
+type RelayStatus struct {
+	Id           peer.ID    `json:"id"`
+	State        RelayState `json:"state"`
+	Expiration   time.Time  `json:"expiration,omitempty"`
+	RetryAt      time.Time  `json:"retryAt,omitempty"`
+	Reservations int        `json:"reservations"`
+	Failures     int        `json:"failures"`
+	LastError    string     `json:"lastError,omitempty"`
+}
+
+type RelayOptions struct {
+	// Reservations is the number of relays to hold a reservation on.
+	Reservations int
+	// RenewBefore is how long before expiry a reservation is renewed.
+	RenewBefore time.Duration
+	// Backoff is the delay before a failed relay is retried. It doubles on
+	// every consecutive failure up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Interval is how often Run checks the reservations.
+	Interval time.Duration
+	Now      func() time.Time
+}
+
+func DefaultRelayOptions() RelayOptions {
+	return RelayOptions{
+		Reservations: 1,
+		RenewBefore:  10 * time.Minute,
+		Backoff:      30 * time.Second,
+		MaxBackoff:   time.Hour,
+		Interval:     30 * time.Second,
+		Now:          time.Now,
+	}
+}
+
+// RelayManager keeps reservations on a number of relays from a list,
+// renewing them before they expire and failing over to the next relay in the
+// list when a relay stops accepting them.
+type RelayManager struct {
+	relays  []peer.AddrInfo
+	reserve ReserveFunc
+	options RelayOptions
+
+	mutex    sync.Mutex
+	statuses []RelayStatus
+	backoffs []time.Duration
+	wake     chan struct{}
+}
+
+func NewRelayManager(relays []peer.AddrInfo, reserve ReserveFunc, options RelayOptions) *RelayManager {
+	statuses := make([]RelayStatus, len(relays))
+	for i, relay := range relays {
+		statuses[i] = RelayStatus{Id: relay.ID, State: RelayIdle}
+	}
+	return &RelayManager{
+		relays:   relays,
+		reserve:  reserve,
+		options:  options,
+		statuses: statuses,
+		backoffs: make([]time.Duration, len(relays)),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Run refreshes the reservations every interval, and whenever a relay is
+// lost, until the context is done.
+func (m *RelayManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.options.Interval)
+	defer ticker.Stop()
+	for {
+		m.Refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.wake:
+		}
+	}
+}
+
+// Refresh renews the reservations that are about to expire, and reserves on
+// relays that are not failing until there are enough reservations.
+func (m *RelayManager) Refresh(ctx context.Context) {
+	now := m.options.Now()
+	active := 0
+	for i, status := range m.Status() {
+		if status.State != RelayReserved {
+			continue
+		}
+		if now.Before(status.Expiration.Add(-m.options.RenewBefore)) {
+			active++
+			continue
+		}
+		if m.tryReserve(ctx, i, now) {
+			active++
+		}
+	}
+	for i, status := range m.Status() {
+		if active >= m.options.Reservations {
+			return
+		}
+		if status.State == RelayReserved || now.Before(status.RetryAt) {
+			continue
+		}
+		if m.tryReserve(ctx, i, now) {
+			active++
+		}
+	}
+	if active < m.options.Reservations {
+		logger.Infow("Relay reservations", "active", active, "wanted", m.options.Reservations)
+	}
+}
+
+func (m *RelayManager) tryReserve(ctx context.Context, i int, now time.Time) bool {
+	relay := m.relays[i]
+	reservation, err := m.reserve(ctx, relay)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	status := &m.statuses[i]
+	if err != nil {
+		if m.backoffs[i] == 0 {
+			m.backoffs[i] = m.options.Backoff
+		} else {
+			m.backoffs[i] = min(2*m.backoffs[i], m.options.MaxBackoff)
+		}
+		status.State = RelayFailed
+		status.Expiration = time.Time{}
+		status.RetryAt = now.Add(m.backoffs[i])
+		status.Failures++
+		status.LastError = err.Error()
+		logger.Warnw("Relay reservation failed", "relay", relay.ID, "error", err)
+		return false
+	}
+	m.backoffs[i] = 0
+	status.State = RelayReserved
+	status.Expiration = reservation.Expiration
+	status.RetryAt = time.Time{}
+	status.Reservations++
+	status.LastError = ""
+	logger.Infow("Relay reserved", "relay", relay.ID, "until", reservation.Expiration)
+	return true
+}
+
+// Lost marks the reservation on a relay as gone, e.g. after the connection to
+// it closed, and wakes Run to fail over.
+func (m *RelayManager) Lost(id peer.ID) {
+	m.mutex.Lock()
+	lost := false
+	for i := range m.statuses {
+		if m.statuses[i].Id == id && m.statuses[i].State == RelayReserved {
+			m.statuses[i].State = RelayIdle
+			m.statuses[i].Expiration = time.Time{}
+			lost = true
+			logger.Warnw("Relay lost", "relay", id)
+		}
+	}
+	m.mutex.Unlock()
+	if !lost {
+		return
+	}
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Notifiee calls Lost when the last connection to a relay closes.
+func (m *RelayManager) Notifiee() network.Notifiee {
+	return &network.NotifyBundle{
+		DisconnectedF: func(n network.Network, conn network.Conn) {
+			if n.Connectedness(conn.RemotePeer()) != network.Connected {
+				m.Lost(conn.RemotePeer())
+			}
+		},
+	}
+}
+
+// Status returns a copy of the state of every relay, in the order of the list.
+func (m *RelayManager) Status() []RelayStatus {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	statuses := make([]RelayStatus, len(m.statuses))
+	copy(statuses, m.statuses)
+	return statuses
+}
+
+// Addrs returns the relayed addresses the host is reachable on, one for every
+// address of a relay it holds a reservation on.
+func (m *RelayManager) Addrs() []ma.Multiaddr {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	addrs := []ma.Multiaddr{}
+	for i, status := range m.statuses {
+		if status.State != RelayReserved {
+			continue
+		}
+		circuit, err := ma.NewMultiaddr("/p2p/" + status.Id.String() + "/p2p-circuit")
+		if err != nil {
+			continue
+		}
+		for _, addr := range m.relays[i].Addrs {
+			addrs = append(addrs, addr.Encapsulate(circuit))
+		}
+	}
+	return addrs
+}
+
+// AddrsFactory advertises the relayed addresses alongside the host's own, for
+// use with libp2p.AddrsFactory.
+func (m *RelayManager) AddrsFactory(addrs []ma.Multiaddr) []ma.Multiaddr {
+	return append(addrs, m.Addrs()...)
+}
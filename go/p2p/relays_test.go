@@ -0,0 +1,90 @@
+package p2p_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/sashankg/hold/p2p"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	relayA = "/ip4/10.0.0.1/tcp/4001/p2p/QmNpBvAKWrjigDHP4Mn3LpqCmin5F2K9TiVFoFGTC6ayV3"
+	relayB = "/ip4/10.0.0.2/tcp/4001/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"
+)
+
+func TestRelayManager(t *testing.T) {
+	relays := []peer.AddrInfo{}
+	for _, addr := range []string{relayA, relayB} {
+		info, err := peer.AddrInfoFromString(addr)
+		require.NoError(t, err)
+		relays = append(relays, *info)
+	}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	down := map[peer.ID]bool{}
+	calls := []peer.ID{}
+	reserve := func(ctx context.Context, relay peer.AddrInfo) (*client.Reservation, error) {
+		calls = append(calls, relay.ID)
+		if down[relay.ID] {
+			return nil, errors.New("relay is down")
+		}
+		return &client.Reservation{Expiration: now.Add(time.Hour)}, nil
+	}
+	options := p2p.DefaultRelayOptions()
+	options.Now = func() time.Time { return now }
+	manager := p2p.NewRelayManager(relays, reserve, options)
+	ctx := context.Background()
+
+	// reserves on the first relay only
+	manager.Refresh(ctx)
+	require.Equal(t, []peer.ID{relays[0].ID}, calls)
+	require.Equal(t, []ma.Multiaddr{
+		ma.StringCast("/ip4/10.0.0.1/tcp/4001/p2p/QmNpBvAKWrjigDHP4Mn3LpqCmin5F2K9TiVFoFGTC6ayV3/p2p-circuit"),
+	}, manager.Addrs())
+
+	// does nothing until the reservation is about to expire
+	now = now.Add(45 * time.Minute)
+	manager.Refresh(ctx)
+	require.Len(t, calls, 1)
+
+	// renews before expiry
+	now = now.Add(10 * time.Minute)
+	manager.Refresh(ctx)
+	require.Len(t, calls, 2)
+	status := manager.Status()
+	require.Equal(t, p2p.RelayReserved, status[0].State)
+	require.Equal(t, 2, status[0].Reservations)
+	require.Equal(t, now.Add(time.Hour), status[0].Expiration)
+
+	// fails over when renewal fails
+	down[relays[0].ID] = true
+	now = now.Add(55 * time.Minute)
+	manager.Refresh(ctx)
+	require.Equal(t, []peer.ID{relays[0].ID, relays[1].ID}, calls[2:])
+	status = manager.Status()
+	require.Equal(t, p2p.RelayFailed, status[0].State)
+	require.Equal(t, 1, status[0].Failures)
+	require.Equal(t, "relay is down", status[0].LastError)
+	require.Equal(t, p2p.RelayReserved, status[1].State)
+	require.Equal(t, []ma.Multiaddr{
+		ma.StringCast("/ip4/10.0.0.2/tcp/4001/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit"),
+	}, manager.Addrs())
+
+	// a lost relay is replaced by the first one once its backoff is over
+	down[relays[0].ID] = false
+	manager.Lost(relays[1].ID)
+	require.Empty(t, manager.Addrs())
+	manager.Refresh(ctx)
+	require.Equal(t, relays[1].ID, calls[len(calls)-1])
+	now = now.Add(options.Backoff)
+	manager.Lost(relays[1].ID)
+	manager.Refresh(ctx)
+	require.Equal(t, relays[0].ID, calls[len(calls)-1])
+	require.Equal(t, p2p.RelayReserved, manager.Status()[0].State)
+}
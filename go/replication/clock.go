@@ -0,0 +1,85 @@
+package replication
+
+import (
+	"sync"
+	"time"
+)
+
+// Timestamp is a reading of a hybrid logical clock: milliseconds of wall
+// time in the upper bits and a logical counter in the lower 16 bits, so that
+// timestamps compare as integers.
+type Timestamp uint64
+
+const cLogicalBits = 16
+
+// Time is the wall time part of the timestamp.
+func (t Timestamp) Time() time.Time {
+	return time.UnixMilli(int64(t >> cLogicalBits))
+}
+
+// Clock is a hybrid logical clock. Its timestamps follow the wall time, but
+// never go backwards and are always after every timestamp it was updated
+// with, even if the clocks of other nodes are ahead.
+type Clock struct {
+	mutex sync.Mutex
+	last  Timestamp
+	now   func() time.Time
+}
+
+func NewClock(now func() time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns a timestamp after every one returned or seen so far.
+func (c *Clock) Now() Timestamp {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	wall := Timestamp(c.now().UnixMilli()) << cLogicalBits
+	if wall > c.last {
+		c.last = wall
+	} else {
+		c.last++
+	}
+	return c.last
+}
+
+// Update moves the clock past a timestamp from another node.
+func (c *Clock) Update(remote Timestamp) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if remote > c.last {
+		c.last = remote
+	}
+}
+
+// Version is when and where a record was last written. Versions are ordered
+// by timestamp, and by origin for writes at the same timestamp, so every
+// node picks the same last writer.
+type Version struct {
+	Timestamp Timestamp `json:"timestamp"`
+	Origin    string    `json:"origin"`
+}
+
+func (v Version) After(other Version) bool {
+	if v.Timestamp != other.Timestamp {
+		return v.Timestamp > other.Timestamp
+	}
+	return v.Origin > other.Origin
+}
+
+// VersionVector is the latest timestamp seen from every origin. A node with
+// a vector has every write of an origin up to its timestamp, or a later
+// write of the same record.
+type VersionVector map[string]Timestamp
+
+// Covers reports whether the write of a version was already seen.
+func (v VersionVector) Covers(version Version) bool {
+	return version.Timestamp <= v[version.Origin]
+}
+
+// Add moves the timestamp of the origin of version forward.
+func (v VersionVector) Add(version Version) {
+	if !v.Covers(version) {
+		v[version.Origin] = version.Timestamp
+	}
+}
@@ -0,0 +1,45 @@
+package replication_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sashankg/hold/replication"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClock(t *testing.T) {
+	now := time.UnixMilli(1000)
+	clock := replication.NewClock(func() time.Time { return now })
+
+	first := clock.Now()
+	require.Equal(t, now, first.Time())
+	// the wall time did not move
+	second := clock.Now()
+	require.Greater(t, second, first)
+	require.Equal(t, now, second.Time())
+
+	// a remote clock that is ahead
+	remote := replication.Timestamp(5000) << 16
+	clock.Update(remote)
+	require.Greater(t, clock.Now(), remote)
+
+	// the wall time went backwards
+	now = time.UnixMilli(500)
+	require.Greater(t, clock.Now(), remote)
+}
+
+func TestVersionVector(t *testing.T) {
+	vector := replication.VersionVector{}
+	older := replication.Version{Timestamp: 1, Origin: "a"}
+	newer := replication.Version{Timestamp: 2, Origin: "a"}
+	require.False(t, vector.Covers(older))
+	vector.Add(newer)
+	vector.Add(older)
+	require.True(t, vector.Covers(older))
+	require.Equal(t, replication.Timestamp(2), vector["a"])
+
+	// ties between origins are broken by origin
+	require.True(t, replication.Version{Timestamp: 2, Origin: "b"}.After(newer))
+	require.False(t, newer.After(replication.Version{Timestamp: 2, Origin: "b"}))
+}
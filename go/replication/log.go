@@ -0,0 +1,243 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// recordKey identifies a record on every node, by the node that created it
+// and its id there. Local ids differ between nodes, and are reused once the
+// record with the highest id is deleted.
+type recordKey struct {
+	Origin string `json:"origin"`
+	Id     int    `json:"id"`
+}
+
+// logEntry is the latest write of a record, which is all the change log
+// keeps of it.
+type logEntry struct {
+	CollectionId int
+	Key          recordKey
+	// RecordId is the local id of the record, and 0 once it is deleted.
+	RecordId int
+	Deleted  bool
+	Version  Version
+}
+
+const logTable = "__replication_log"
+
+const vectorTable = "__replication_vector"
+
+const logTableDefinition = `CREATE TABLE IF NOT EXISTS ` + logTable + ` (
+	collection_id INTEGER NOT NULL,
+	origin TEXT NOT NULL,
+	origin_id INTEGER NOT NULL,
+	record_id INTEGER,
+	deleted INTEGER NOT NULL,
+	version INTEGER NOT NULL,
+	version_origin TEXT NOT NULL,
+	PRIMARY KEY (collection_id, origin, origin_id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS ` + logTable + `_record ON ` + logTable + ` (collection_id, record_id);
+CREATE INDEX IF NOT EXISTS ` + logTable + `_version ON ` + logTable + ` (version_origin, version);
+CREATE TABLE IF NOT EXISTS ` + vectorTable + ` (
+	origin TEXT PRIMARY KEY,
+	version INTEGER NOT NULL
+)`
+
+var logColumns = []string{
+	"collection_id",
+	"origin",
+	"origin_id",
+	"record_id",
+	"deleted",
+	"version",
+	"version_origin",
+}
+
+// changeLog stores the version of every written record, and the version
+// vector of the node, next to the records, so that a write and its entry
+// commit together. The methods that take a runner use it, so that they can
+// run in the transaction of a write.
+type changeLog struct {
+	db *sql.DB
+}
+
+func (l *changeLog) create(ctx context.Context) error {
+	_, err := l.db.ExecContext(ctx, logTableDefinition)
+	return err
+}
+
+// get returns the entry of the record with key, or nil if it was never
+// logged.
+func (l *changeLog) get(ctx context.Context, runner sq.BaseRunner, collectionId int, key recordKey) (*logEntry, error) {
+	return l.getWhere(ctx, runner, sq.Eq{
+		"collection_id": collectionId,
+		"origin":        key.Origin,
+		"origin_id":     key.Id,
+	})
+}
+
+// getLocal returns the entry of the local record with id, or nil if it was
+// never logged.
+func (l *changeLog) getLocal(ctx context.Context, runner sq.BaseRunner, collectionId int, id int) (*logEntry, error) {
+	return l.getWhere(ctx, runner, sq.Eq{"collection_id": collectionId, "record_id": id})
+}
+
+func (l *changeLog) getWhere(ctx context.Context, runner sq.BaseRunner, where sq.Eq) (*logEntry, error) {
+	rows, err := sq.Select(logColumns...).
+		From(logTable).
+		Where(where).
+		RunWith(runner).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	return scanEntry(rows)
+}
+
+// nextId is the id of the next record created on this node in a collection.
+// Ids are never reused, even once the record is deleted.
+func (l *changeLog) nextId(ctx context.Context, runner sq.BaseRunner, collectionId int, self string) (int, error) {
+	var id int
+	err := sq.Select("coalesce(max(origin_id), 0) + 1").
+		From(logTable).
+		Where(sq.Eq{"collection_id": collectionId, "origin": self}).
+		RunWith(runner).
+		QueryRowContext(ctx).
+		Scan(&id)
+	return id, err
+}
+
+// put replaces the entry of a record, and adds its version to the vector if
+// advance is set.
+func (l *changeLog) put(ctx context.Context, runner sq.BaseRunner, entry logEntry, advance bool) error {
+	var recordId *int
+	if !entry.Deleted {
+		recordId = &entry.RecordId
+	}
+	_, err := sq.Replace(logTable).
+		Columns(logColumns...).
+		Values(
+			entry.CollectionId,
+			entry.Key.Origin,
+			entry.Key.Id,
+			recordId,
+			entry.Deleted,
+			int64(entry.Version.Timestamp),
+			entry.Version.Origin,
+		).
+		RunWith(runner).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !advance {
+		return nil
+	}
+	return l.advance(ctx, runner, entry.Version)
+}
+
+// advance adds a version to the vector.
+func (l *changeLog) advance(ctx context.Context, runner sq.BaseRunner, version Version) error {
+	_, err := sq.Insert(vectorTable).
+		Columns("origin", "version").
+		Values(version.Origin, int64(version.Timestamp)).
+		Suffix("ON CONFLICT (origin) DO UPDATE SET version = max(version, excluded.version)").
+		RunWith(runner).
+		ExecContext(ctx)
+	return err
+}
+
+// since returns the vector of the node, and the entries of the writes that
+// vector does not cover, as of the same moment.
+func (l *changeLog) since(ctx context.Context, vector VersionVector) (VersionVector, []logEntry, error) {
+	tx, err := l.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+	own, err := l.vector(ctx, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	uncovered := sq.Or{}
+	origins := []string{}
+	for origin, timestamp := range vector {
+		uncovered = append(uncovered, sq.And{
+			sq.Eq{"version_origin": origin},
+			sq.Gt{"version": int64(timestamp)},
+		})
+		origins = append(origins, origin)
+	}
+	uncovered = append(uncovered, sq.NotEq{"version_origin": origins})
+	rows, err := sq.Select(logColumns...).
+		From(logTable).
+		Where(uncovered).
+		OrderBy("version", "version_origin").
+		RunWith(tx).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	entries := []logEntry{}
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return own, entries, tx.Commit()
+}
+
+func (l *changeLog) vector(ctx context.Context, runner sq.BaseRunner) (VersionVector, error) {
+	rows, err := sq.Select("origin", "version").
+		From(vectorTable).
+		RunWith(runner).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	vector := VersionVector{}
+	for rows.Next() {
+		var origin string
+		var timestamp int64
+		if err := rows.Scan(&origin, &timestamp); err != nil {
+			return nil, err
+		}
+		vector[origin] = Timestamp(timestamp)
+	}
+	return vector, rows.Err()
+}
+
+func scanEntry(rows *sql.Rows) (*logEntry, error) {
+	entry := &logEntry{}
+	var recordId sql.NullInt64
+	var timestamp int64
+	if err := rows.Scan(
+		&entry.CollectionId,
+		&entry.Key.Origin,
+		&entry.Key.Id,
+		&recordId,
+		&entry.Deleted,
+		&timestamp,
+		&entry.Version.Origin,
+	); err != nil {
+		return nil, err
+	}
+	entry.RecordId = int(recordId.Int64)
+	entry.Version.Timestamp = Timestamp(timestamp)
+	return entry, nil
+}
@@ -0,0 +1,791 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-msgio"
+	"github.com/sashankg/hold/acl"
+	"github.com/sashankg/hold/dao"
+	"github.com/sashankg/hold/graphql"
+	"github.com/sashankg/hold/p2p"
+)
+
+var logger = logging.Logger("hold")
+
+// Protocol syncs the collections and records of two nodes. Every message is
+// a uvarint length followed by a JSON syncMessage. The client sends its
+// version vector, the server answers with its collections and the records
+// the vector does not cover, and the client sends back its own collections
+// and the records the vector of the server does not cover.
+const Protocol = "/hold/replication/2.0.0"
+
+const cMaxSyncMessageSize = 64 << 20
+
+type syncMessage struct {
+	Vector      VersionVector     `json:"vector"`
+	Collections []*dao.Collection `json:"collections,omitempty"`
+	Changes     []recordChange    `json:"changes,omitempty"`
+}
+
+// recordChange is the latest write of a record, with every stored field of
+// the record unless it was deleted. Collections are named by namespace and
+// name, and records by their recordKey, as ids differ between nodes. The
+// record has no id, and its references are recordKeys too.
+type recordChange struct {
+	Namespace  string          `json:"namespace"`
+	Collection string          `json:"collection"`
+	Key        recordKey       `json:"key"`
+	Deleted    bool            `json:"deleted,omitempty"`
+	Record     json.RawMessage `json:"record,omitempty"`
+	Version    Version         `json:"version"`
+}
+
+// Replicator keeps the records of a node in sync with other nodes.
+//
+// Every local write is logged with a hybrid logical clock timestamp, in the
+// same transaction as the write, and a sync exchanges the records whose
+// latest write the other node has not seen. Concurrent writes to a record
+// are resolved by last writer wins. Records are identified by the node that
+// created them and their id there, and get a local id of their own on every
+// other node. Collections and fields missing on one node are added, but
+// fields are never dropped or retyped, and blobs are not copied.
+//
+// Peers can only pull the collections they can read, and push to the ones
+// they can write. Collections and fields are only added for peers with
+// Admin, and deletes only for peers that can write every collection they
+// cascade to.
+type Replicator struct {
+	dao        dao.Dao
+	log        *changeLog
+	authorizer acl.Authorizer
+	clock      *Clock
+	listeners  []graphql.SchemaListener
+	// mutex lets one sync at a time apply changes
+	mutex      sync.Mutex
+	host       host.Host
+	self       string
+	removeHook func()
+}
+
+// NewReplicator returns a Replicator that keeps its log in recordDb, the
+// database of the records of dao.
+func NewReplicator(
+	dao dao.Dao,
+	recordDb *sql.DB,
+	authorizer acl.Authorizer,
+	now func() time.Time,
+	listeners ...graphql.SchemaListener,
+) *Replicator {
+	return &Replicator{
+		dao:        dao,
+		log:        &changeLog{recordDb},
+		authorizer: authorizer,
+		clock:      NewClock(now),
+		listeners:  listeners,
+	}
+}
+
+// Start logs the records that were written before replication started, then
+// logs every local write and serves Protocol on the host.
+func (r *Replicator) Start(ctx context.Context, h host.Host) error {
+	r.host = h
+	r.self = h.ID().String()
+	if err := r.log.create(ctx); err != nil {
+		return err
+	}
+	vector, err := r.log.vector(ctx, r.log.db)
+	if err != nil {
+		return err
+	}
+	for _, timestamp := range vector {
+		r.clock.Update(timestamp)
+	}
+	r.removeHook = r.dao.OnWrite(r.logWrite)
+	if err := r.backfill(ctx); err != nil {
+		r.removeHook()
+		return err
+	}
+	h.SetStreamHandler(Protocol, r.serveStream)
+	return nil
+}
+
+func (r *Replicator) Close() {
+	r.host.RemoveStreamHandler(Protocol)
+	r.removeHook()
+}
+
+// Run syncs with every peer once per interval until ctx is done.
+func (r *Replicator) Run(ctx context.Context, peers []peer.ID, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, id := range peers {
+			if err := r.Sync(ctx, id); err != nil {
+				logger.Warnw("error syncing", "peer", id, "error", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync pulls the changes of a peer, then pushes the changes it has not seen,
+// and returns once the peer applied them.
+func (r *Replicator) Sync(ctx context.Context, id peer.ID) error {
+	s, err := r.host.NewStream(ctx, id, Protocol)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	reader := msgio.NewVarintReaderSize(s, cMaxSyncMessageSize)
+	writer := msgio.NewVarintWriter(s)
+	ctx = p2p.WithPeer(ctx, id)
+
+	vector, err := r.log.vector(ctx, r.log.db)
+	if err != nil {
+		return err
+	}
+	if err := writeMessage(writer, &syncMessage{Vector: vector}); err != nil {
+		s.Reset()
+		return err
+	}
+	pulled := &syncMessage{}
+	if err := readMessage(reader, pulled); err != nil {
+		s.Reset()
+		return err
+	}
+	if err := r.apply(ctx, pulled); err != nil {
+		s.Reset()
+		return err
+	}
+	pushed, err := r.changesSince(ctx, pulled.Vector)
+	if err != nil {
+		s.Reset()
+		return err
+	}
+	if err := writeMessage(writer, pushed); err != nil {
+		s.Reset()
+		return err
+	}
+	// the server closes the stream once the pushed changes are applied
+	if err := s.CloseWrite(); err != nil {
+		s.Reset()
+		return err
+	}
+	if _, err := reader.ReadMsg(); err != io.EOF {
+		s.Reset()
+		return fmt.Errorf("sync with %s did not finish: %v", id, err)
+	}
+	return nil
+}
+
+func (r *Replicator) serveStream(s network.Stream) {
+	defer s.Close()
+	ctx := p2p.WithPeer(context.Background(), s.Conn().RemotePeer())
+	if !r.authorizer.Known(ctx) {
+		s.Reset()
+		return
+	}
+	reader := msgio.NewVarintReaderSize(s, cMaxSyncMessageSize)
+	writer := msgio.NewVarintWriter(s)
+	request := &syncMessage{}
+	if err := readMessage(reader, request); err != nil {
+		logger.Warnw("error reading sync message", "error", err)
+		s.Reset()
+		return
+	}
+	pulled, err := r.changesSince(ctx, request.Vector)
+	if err != nil {
+		logger.Warnw("error reading changes", "error", err)
+		s.Reset()
+		return
+	}
+	if err := writeMessage(writer, pulled); err != nil {
+		s.Reset()
+		return
+	}
+	pushed := &syncMessage{}
+	if err := readMessage(reader, pushed); err != nil {
+		logger.Warnw("error reading sync message", "error", err)
+		s.Reset()
+		return
+	}
+	if err := r.apply(ctx, pushed); err != nil {
+		logger.Errorw("error applying changes", "error", err)
+		s.Reset()
+	}
+}
+
+// appliedChange is a remote change that apply is writing.
+type appliedChange struct {
+	collectionId int
+	key          recordKey
+	// recordId is the local id of the record, or 0 if it is new
+	recordId int
+	version  Version
+	advance  bool
+}
+
+type appliedChangeKey struct{}
+
+// errSuperseded is returned by applyChange when the record has a later write
+// than the change.
+var errSuperseded = errors.New("change is superseded by a later write")
+
+// errForbidden is the error of a change that the peer cannot write, which is
+// dropped rather than sent again.
+var errForbidden = errors.New("change is forbidden")
+
+// logWrite is the dao.WriteHook that logs every write to the records. Local
+// writes get a version of this node. The record written by apply keeps the
+// remote version, unless a local write that committed since apply compared
+// the versions is later, and the records a remote delete cascades to are
+// local writes.
+func (r *Replicator) logWrite(ctx context.Context, recordTx *sql.Tx, changes []dao.Change) error {
+	applied, _ := ctx.Value(appliedChangeKey{}).(*appliedChange)
+	for _, change := range changes {
+		if applied != nil && applied.writes(change) {
+			current, err := r.log.get(ctx, recordTx, change.CollectionId, applied.key)
+			if err != nil {
+				return err
+			}
+			if current != nil && !applied.version.After(current.Version) {
+				return errSuperseded
+			}
+			if err := r.log.put(ctx, recordTx, logEntry{
+				CollectionId: change.CollectionId,
+				Key:          applied.key,
+				RecordId:     change.Id,
+				Deleted:      change.Operation == dao.ChangeDelete,
+				Version:      applied.version,
+			}, applied.advance); err != nil {
+				return err
+			}
+			continue
+		}
+		entry, err := r.log.getLocal(ctx, recordTx, change.CollectionId, change.Id)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			id, err := r.log.nextId(ctx, recordTx, change.CollectionId, r.self)
+			if err != nil {
+				return err
+			}
+			entry = &logEntry{CollectionId: change.CollectionId, Key: recordKey{Origin: r.self, Id: id}}
+		}
+		entry.RecordId = change.Id
+		entry.Deleted = change.Operation == dao.ChangeDelete
+		entry.Version = Version{Timestamp: r.clock.Now(), Origin: r.self}
+		if err := r.log.put(ctx, recordTx, *entry, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writes reports whether change is the write of the applied change, rather
+// than one that cascades from it.
+func (a *appliedChange) writes(change dao.Change) bool {
+	if change.CollectionId != a.collectionId {
+		return false
+	}
+	if a.recordId == 0 {
+		return change.Operation == dao.ChangeSet
+	}
+	return change.Id == a.recordId
+}
+
+// backfill logs every record without a log entry as a local write.
+func (r *Replicator) backfill(ctx context.Context) error {
+	collections, err := r.dao.ListCollections(ctx)
+	if err != nil {
+		return err
+	}
+	for _, collection := range collections {
+		params := dao.ListParams{First: dao.MaxPageSize}
+		for {
+			page, err := r.listIds(ctx, collection, params)
+			if err != nil {
+				return err
+			}
+			for _, edge := range page.Edges {
+				if err := r.backfillRecord(ctx, collection.Id, edge.Node.Id); err != nil {
+					return err
+				}
+			}
+			if !page.PageInfo.HasNextPage {
+				break
+			}
+			params.After = page.PageInfo.EndCursor
+		}
+	}
+	return nil
+}
+
+func (r *Replicator) backfillRecord(ctx context.Context, collectionId int, id int) error {
+	tx, err := r.log.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	entry, err := r.log.getLocal(ctx, tx, collectionId, id)
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		return nil
+	}
+	originId, err := r.log.nextId(ctx, tx, collectionId, r.self)
+	if err != nil {
+		return err
+	}
+	if err := r.log.put(ctx, tx, logEntry{
+		CollectionId: collectionId,
+		Key:          recordKey{Origin: r.self, Id: originId},
+		RecordId:     id,
+		Version:      Version{Timestamp: r.clock.Now(), Origin: r.self},
+	}, true); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type idPage struct {
+	Edges []struct {
+		Node struct {
+			Id int `json:"id"`
+		} `json:"node"`
+	} `json:"edges"`
+	PageInfo struct {
+		HasNextPage bool   `json:"hasNextPage"`
+		EndCursor   string `json:"endCursor"`
+	} `json:"pageInfo"`
+}
+
+func (r *Replicator) listIds(ctx context.Context, collection *dao.Collection, params dao.ListParams) (*idPage, error) {
+	data, err := r.dao.ListRecords(ctx, params, []dao.Selection{
+		{FieldName: dao.ConnectionEdges, Subselections: []dao.Selection{
+			{FieldName: dao.EdgeNode, Subselections: idSelection},
+		}},
+		{FieldName: dao.ConnectionPageInfo, Subselections: []dao.Selection{
+			{FieldName: "hasNextPage"},
+			{FieldName: "endCursor"},
+		}},
+	}, collection.Id)
+	if err != nil {
+		return nil, err
+	}
+	page := &idPage{}
+	return page, json.Unmarshal(data, page)
+}
+
+// changesSince is the sync message with the collections the peer of ctx can
+// read, and the records in them that vector does not cover.
+func (r *Replicator) changesSince(ctx context.Context, vector VersionVector) (*syncMessage, error) {
+	own, entries, err := r.log.since(ctx, vector)
+	if err != nil {
+		return nil, err
+	}
+	collections, err := r.dao.ListCollections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	message := &syncMessage{
+		Vector:      own,
+		Collections: []*dao.Collection{},
+		Changes:     []recordChange{},
+	}
+	readable := map[int]*dao.Collection{}
+	for _, collection := range collections {
+		if r.authorizer.Allowed(ctx, collection.Domain, collection.Name, acl.Read) {
+			readable[collection.Id] = collection
+			message.Collections = append(message.Collections, collection)
+		}
+	}
+	for _, entry := range entries {
+		collection, ok := readable[entry.CollectionId]
+		if !ok {
+			continue
+		}
+		change := recordChange{
+			Namespace:  collection.Domain,
+			Collection: collection.Name,
+			Key:        entry.Key,
+			Deleted:    entry.Deleted,
+			Version:    entry.Version,
+		}
+		if !entry.Deleted {
+			record, err := r.remoteRecord(ctx, collection, entry.RecordId)
+			// a record deleted since the log was read, or one that refers
+			// to a deleted record, has a later write that is sent instead
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			change.Record = record
+		}
+		message.Changes = append(message.Changes, change)
+	}
+	return message, nil
+}
+
+// remoteRecord reads the stored fields of a record, without its id and with
+// its references as recordKeys.
+func (r *Replicator) remoteRecord(ctx context.Context, collection *dao.Collection, id int) (json.RawMessage, error) {
+	data, err := r.dao.GetRecord(ctx, id, dao.StoredSelection(collection), collection.Id)
+	if err != nil {
+		return nil, err
+	}
+	record := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	delete(record, dao.IdField)
+	for name, value := range record {
+		field := collection.Fields[name]
+		if field.Ref == 0 {
+			continue
+		}
+		ids := []*int{}
+		if field.IsList {
+			err = json.Unmarshal(value, &ids)
+		} else {
+			ids = append(ids, nil)
+			err = json.Unmarshal(value, &ids[0])
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]*recordKey, len(ids))
+		for i, id := range ids {
+			if id == nil {
+				continue
+			}
+			entry, err := r.log.getLocal(ctx, r.log.db, field.Ref, *id)
+			if err != nil {
+				return nil, err
+			}
+			if entry == nil {
+				return nil, sql.ErrNoRows
+			}
+			keys[i] = &entry.Key
+		}
+		if field.IsList {
+			record[name], err = json.Marshal(keys)
+		} else {
+			record[name], err = json.Marshal(keys[0])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(record)
+}
+
+// apply adds the collections of a sync message, and writes the changes that
+// are newer than the local ones, as the peer of ctx.
+//
+// The vector of the node only moves past a change of an origin once the
+// change is written or superseded, and the vector of the message covers it.
+// Once a change of an origin cannot be applied, such as a record without a
+// non-null field of the local collection, or one that refers to a record
+// that is not written yet, the later changes of the origin are still written
+// but do not move the vector, so that they are sent again by the next sync.
+func (r *Replicator) apply(ctx context.Context, message *syncMessage) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.applySchema(ctx, message.Collections); err != nil {
+		return err
+	}
+	collections, err := r.dao.ListCollections(ctx)
+	if err != nil {
+		return err
+	}
+	bySpec := map[dao.CollectionSpec]*dao.Collection{}
+	for _, collection := range collections {
+		bySpec[collectionSpec(collection)] = collection
+	}
+	source, _ := p2p.PeerFromContext(ctx)
+	sourceCtx := dao.WithChangeSource(ctx, source.String())
+	failed := map[string]bool{}
+	for _, change := range message.Changes {
+		advance := !failed[change.Version.Origin] && message.Vector.Covers(change.Version)
+		if !r.authorizer.Allowed(ctx, change.Namespace, change.Collection, acl.Write) {
+			err = errForbidden
+		} else if collection, ok := bySpec[dao.CollectionSpec{Name: change.Collection, Namespace: change.Namespace}]; !ok {
+			err = fmt.Errorf("unknown collection %s", change.Collection)
+		} else if forbidden := r.forbiddenCascade(ctx, collections, collection, change); forbidden != nil {
+			logger.Warnw("skipping delete that cascades to a collection the peer cannot write",
+				"collection", change.Collection, "key", change.Key, "cascade", forbidden.Name)
+			err = errForbidden
+		} else {
+			err = r.applyChange(sourceCtx, collection, change, advance)
+		}
+		switch {
+		case errors.Is(err, errSuperseded), errors.Is(err, errForbidden):
+			if advance {
+				if err := r.log.advance(ctx, r.log.db, change.Version); err != nil {
+					return err
+				}
+			}
+		case err != nil:
+			logger.Warnw("error replicating", "collection", change.Collection, "key", change.Key, "error", err)
+			failed[change.Version.Origin] = true
+		}
+	}
+	return nil
+}
+
+// forbiddenCascade returns a collection that a replicated delete would
+// delete or patch records of, but the peer of ctx cannot write, or nil.
+func (r *Replicator) forbiddenCascade(
+	ctx context.Context,
+	collections []*dao.Collection,
+	collection *dao.Collection,
+	change recordChange,
+) *dao.Collection {
+	if !change.Deleted {
+		return nil
+	}
+	for _, cascade := range dao.CascadeCollections(collections, collection.Id) {
+		if !r.authorizer.Allowed(ctx, cascade.Domain, cascade.Name, acl.Write) {
+			return cascade
+		}
+	}
+	return nil
+}
+
+// applyChange writes a change, unless the local record has a later write.
+// The write is logged by logWrite, with the version of the change.
+func (r *Replicator) applyChange(ctx context.Context, collection *dao.Collection, change recordChange, advance bool) error {
+	entry, err := r.log.get(ctx, r.log.db, collection.Id, change.Key)
+	if err != nil {
+		return err
+	}
+	if entry != nil && !change.Version.After(entry.Version) {
+		return errSuperseded
+	}
+	r.clock.Update(change.Version.Timestamp)
+	applied := &appliedChange{
+		collectionId: collection.Id,
+		key:          change.Key,
+		version:      change.Version,
+		advance:      advance,
+	}
+	if entry != nil {
+		applied.recordId = entry.RecordId
+	}
+	ctx = context.WithValue(ctx, appliedChangeKey{}, applied)
+	if change.Deleted {
+		if applied.recordId == 0 {
+			// there is no local record, but the delete still supersedes
+			// earlier writes of it
+			return r.log.put(ctx, r.log.db, logEntry{
+				CollectionId: collection.Id,
+				Key:          change.Key,
+				Deleted:      true,
+				Version:      change.Version,
+			}, advance)
+		}
+		_, err := r.dao.DeleteRecord(ctx, applied.recordId, idSelection, collection.Id)
+		return err
+	}
+	values, err := r.localValues(ctx, collection, change.Record)
+	if err != nil {
+		return err
+	}
+	if applied.recordId != 0 {
+		values[dao.IdField] = applied.recordId
+	}
+	_, err = r.dao.SetRecord(ctx, values, idSelection, collection.Id)
+	return err
+}
+
+// localValues are the values of a remote record, with the references
+// translated to local ids. Fields that only the remote collection has are
+// left out.
+func (r *Replicator) localValues(ctx context.Context, collection *dao.Collection, record json.RawMessage) (map[string]any, error) {
+	remote := map[string]json.RawMessage{}
+	if err := json.Unmarshal(record, &remote); err != nil {
+		return nil, err
+	}
+	values := map[string]any{}
+	for name, value := range remote {
+		field, ok := collection.Fields[name]
+		if !ok || !field.IsStored() {
+			continue
+		}
+		if field.Ref == 0 {
+			var v any
+			if err := json.Unmarshal(value, &v); err != nil {
+				return nil, err
+			}
+			values[name] = v
+			continue
+		}
+		keys := []*recordKey{}
+		var err error
+		if field.IsList {
+			err = json.Unmarshal(value, &keys)
+		} else {
+			keys = append(keys, nil)
+			err = json.Unmarshal(value, &keys[0])
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]any, len(keys))
+		for i, key := range keys {
+			if key == nil {
+				continue
+			}
+			entry, err := r.log.get(ctx, r.log.db, field.Ref, *key)
+			if err != nil {
+				return nil, err
+			}
+			if entry == nil || entry.Deleted {
+				return nil, fmt.Errorf("field %s refers to a record that is not written", name)
+			}
+			ids[i] = entry.RecordId
+		}
+		if field.IsList {
+			values[name] = ids
+		} else {
+			values[name] = ids[0]
+		}
+	}
+	return values, nil
+}
+
+// applySchema adds the collections and fields that the peer of ctx can
+// write and are missing locally, if the peer has Admin like the schema
+// route needs. Object fields are added once every new collection exists,
+// with the reference translated to the local id.
+func (r *Replicator) applySchema(ctx context.Context, remoteCollections []*dao.Collection) error {
+	if !r.authorizer.Allowed(ctx, "", "", acl.Admin) {
+		return nil
+	}
+	collections, err := r.dao.ListCollections(ctx)
+	if err != nil {
+		return err
+	}
+	bySpec := map[dao.CollectionSpec]*dao.Collection{}
+	for _, collection := range collections {
+		bySpec[collectionSpec(collection)] = collection
+	}
+	remoteSpecs := map[int]dao.CollectionSpec{}
+	for _, remote := range remoteCollections {
+		remoteSpecs[remote.Id] = collectionSpec(remote)
+	}
+
+	type pendingField struct {
+		collection *dao.Collection
+		field      dao.CollectionField
+	}
+	newCollections := []*dao.Collection{}
+	pendingFields := []pendingField{}
+	for _, remote := range remoteCollections {
+		if !r.authorizer.Allowed(ctx, remote.Domain, remote.Name, acl.Write) {
+			continue
+		}
+		collection, exists := bySpec[collectionSpec(remote)]
+		if !exists {
+			collection = &dao.Collection{
+				Name:    remote.Name,
+				Domain:  remote.Domain,
+				Version: remote.Version,
+				Fields:  map[string]dao.CollectionField{},
+			}
+			bySpec[collectionSpec(remote)] = collection
+			newCollections = append(newCollections, collection)
+		}
+		for _, name := range sortedFieldNames(remote) {
+			field := remote.Fields[name]
+			if _, ok := collection.Fields[name]; ok {
+				continue
+			}
+			if !exists && field.Ref == 0 {
+				collection.Fields[name] = field
+				continue
+			}
+			pendingFields = append(pendingFields, pendingField{collection, field})
+		}
+	}
+	if len(newCollections) == 0 && len(pendingFields) == 0 {
+		return nil
+	}
+	if len(newCollections) > 0 {
+		if err := r.dao.AddCollections(ctx, newCollections); err != nil {
+			return err
+		}
+	}
+	for _, pending := range pendingFields {
+		if pending.field.Ref > 0 {
+			ref, ok := bySpec[remoteSpecs[pending.field.Ref]]
+			if !ok {
+				logger.Warnw("skipping field with an unknown reference", "collection", pending.collection.Name, "field", pending.field.Name)
+				continue
+			}
+			pending.field.Ref = ref.Id
+		}
+		if err := r.dao.AddCollectionField(ctx, pending.collection, pending.field); err != nil {
+			return err
+		}
+	}
+	for _, listener := range r.listeners {
+		if err := listener.SchemaChanged(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var idSelection = []dao.Selection{{FieldName: dao.IdField}}
+
+func sortedFieldNames(collection *dao.Collection) []string {
+	names := make([]string, 0, len(collection.Fields))
+	for name := range collection.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func collectionSpec(collection *dao.Collection) dao.CollectionSpec {
+	return dao.CollectionSpec{Name: collection.Name, Namespace: collection.Domain}
+}
+
+func writeMessage(writer msgio.Writer, message *syncMessage) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return writer.WriteMsg(body)
+}
+
+func readMessage(reader msgio.Reader, message *syncMessage) error {
+	body, err := reader.ReadMsg()
+	if err != nil {
+		return err
+	}
+	defer reader.ReleaseMsg(body)
+	return json.Unmarshal(body, message)
+}
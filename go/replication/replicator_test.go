@@ -0,0 +1,246 @@
+package replication_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sashankg/hold/acl"
+	"github.com/sashankg/hold/dao"
+	"github.com/sashankg/hold/replication"
+	"github.com/sashankg/hold/testing/util"
+	"github.com/stretchr/testify/require"
+)
+
+type replica struct {
+	dao        dao.Dao
+	host       host.Host
+	replicator *replication.Replicator
+}
+
+func newReplica(t *testing.T, mn mocknet.Mocknet, now time.Time) *replica {
+	h, err := mn.GenPeer()
+	require.NoError(t, err)
+	return newReplicaWithPolicy(t, h, now, acl.Policy{Open: true})
+}
+
+func newReplicaWithPolicy(t *testing.T, h host.Host, now time.Time, policy acl.Policy) *replica {
+	memoryDao := util.NewMemoryDao(t)
+	authorizer, err := acl.NewAcl(policy, h.ID())
+	require.NoError(t, err)
+	return &replica{
+		dao:        memoryDao,
+		host:       h,
+		replicator: replication.NewReplicator(memoryDao, memoryDao.RecordDb, authorizer, func() time.Time { return now }),
+	}
+}
+
+func (r *replica) collectionId(t *testing.T, name string) int {
+	id, err := r.dao.GetCollectionId(context.Background(), dao.CollectionSpec{Name: name})
+	require.NoError(t, err)
+	return id
+}
+
+func (r *replica) post(t *testing.T, id int) string {
+	record, err := r.dao.GetRecord(context.Background(), id, []dao.Selection{
+		{FieldName: "title"},
+		{FieldName: "author", Subselections: []dao.Selection{{FieldName: "name"}}},
+	}, r.collectionId(t, "Post"))
+	require.NoError(t, err)
+	return string(record)
+}
+
+func (r *replica) names(t *testing.T) []string {
+	data, err := r.dao.ListRecords(context.Background(), dao.ListParams{First: 10}, []dao.Selection{
+		{FieldName: dao.ConnectionEdges, Subselections: []dao.Selection{
+			{FieldName: dao.EdgeNode, Subselections: []dao.Selection{{FieldName: "name"}}},
+		}},
+	}, r.collectionId(t, "Person"))
+	require.NoError(t, err)
+	page := struct {
+		Edges []struct {
+			Node struct {
+				Name string `json:"name"`
+			} `json:"node"`
+		} `json:"edges"`
+	}{}
+	require.NoError(t, json.Unmarshal(data, &page))
+	names := []string{}
+	for _, edge := range page.Edges {
+		names = append(names, edge.Node.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newPeople returns two connected replicas, and adds the Person and Post
+// collections to the phone.
+func newPeople(t *testing.T, mn mocknet.Mocknet, now time.Time) (phone *replica, laptop *replica) {
+	ctx := context.Background()
+	phone = newReplica(t, mn, now)
+	// the laptop clock is ahead, so its writes win
+	laptop = newReplica(t, mn, now.Add(time.Minute))
+	require.NoError(t, mn.LinkAll())
+	_, err := mn.ConnectPeers(phone.host.ID(), laptop.host.ID())
+	require.NoError(t, err)
+	person := &dao.Collection{
+		Name:    "Person",
+		Version: "1",
+		Fields:  map[string]dao.CollectionField{"name": {Name: "name", Type: "String"}},
+	}
+	require.NoError(t, phone.dao.AddCollections(ctx, []*dao.Collection{person}))
+	require.NoError(t, phone.dao.AddCollections(ctx, []*dao.Collection{{
+		Name:    "Post",
+		Version: "1",
+		Fields: map[string]dao.CollectionField{
+			"title":  {Name: "title", Type: "String"},
+			"author": {Name: "author", Type: "Person", Ref: person.Id},
+		},
+	}}))
+	return phone, laptop
+}
+
+func TestReplicatorSync(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New()
+	defer mn.Close()
+	phone, laptop := newPeople(t, mn, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	// written before replication started
+	_, err := phone.dao.SetRecord(ctx, map[string]any{"name": "ada"}, nil, phone.collectionId(t, "Person"))
+	require.NoError(t, err)
+
+	require.NoError(t, phone.replicator.Start(ctx, phone.host))
+	defer phone.replicator.Close()
+	require.NoError(t, laptop.replicator.Start(ctx, laptop.host))
+	defer laptop.replicator.Close()
+
+	_, err = phone.dao.SetRecord(ctx, map[string]any{"title": "hello", "author": 1}, nil, phone.collectionId(t, "Post"))
+	require.NoError(t, err)
+	require.NoError(t, laptop.replicator.Sync(ctx, phone.host.ID()))
+	require.JSONEq(t, `{"title": "hello", "author": {"name": "ada"}}`, laptop.post(t, 1))
+
+	// concurrent writes to the same record
+	_, err = phone.dao.PatchRecord(ctx, 1, map[string]any{"title": "from phone"}, nil, phone.collectionId(t, "Post"))
+	require.NoError(t, err)
+	_, err = laptop.dao.PatchRecord(ctx, 1, map[string]any{"title": "from laptop"}, nil, laptop.collectionId(t, "Post"))
+	require.NoError(t, err)
+	require.NoError(t, phone.replicator.Sync(ctx, laptop.host.ID()))
+	require.JSONEq(t, `{"title": "from laptop", "author": {"name": "ada"}}`, phone.post(t, 1))
+	require.JSONEq(t, `{"title": "from laptop", "author": {"name": "ada"}}`, laptop.post(t, 1))
+
+	// pushed by the phone, along with the reference set to null
+	_, err = phone.dao.DeleteRecord(ctx, 1, nil, phone.collectionId(t, "Person"))
+	require.NoError(t, err)
+	require.NoError(t, phone.replicator.Sync(ctx, laptop.host.ID()))
+	_, err = laptop.dao.GetRecord(ctx, 1, nil, laptop.collectionId(t, "Person"))
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	require.JSONEq(t, `{"title": "from laptop", "author": null}`, laptop.post(t, 1))
+
+	// nothing is left to sync
+	require.NoError(t, laptop.replicator.Sync(ctx, phone.host.ID()))
+	require.JSONEq(t, `{"title": "from laptop", "author": null}`, phone.post(t, 1))
+
+	// created on both nodes with the same local id
+	_, err = phone.dao.SetRecord(ctx, map[string]any{"name": "bob"}, nil, phone.collectionId(t, "Person"))
+	require.NoError(t, err)
+	_, err = laptop.dao.SetRecord(ctx, map[string]any{"name": "grace"}, nil, laptop.collectionId(t, "Person"))
+	require.NoError(t, err)
+	require.NoError(t, phone.replicator.Sync(ctx, laptop.host.ID()))
+	require.Equal(t, []string{"bob", "grace"}, phone.names(t))
+	require.Equal(t, []string{"bob", "grace"}, laptop.names(t))
+}
+
+func TestReplicatorSyncRetriesChanges(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New()
+	defer mn.Close()
+	phone, laptop := newPeople(t, mn, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	require.NoError(t, phone.replicator.Start(ctx, phone.host))
+	defer phone.replicator.Close()
+	require.NoError(t, laptop.replicator.Start(ctx, laptop.host))
+	defer laptop.replicator.Close()
+
+	_, err := phone.dao.SetRecord(ctx, map[string]any{"name": "ada"}, nil, phone.collectionId(t, "Person"))
+	require.NoError(t, err)
+	_, err = phone.dao.SetRecord(ctx, map[string]any{"title": "hello", "author": 1}, nil, phone.collectionId(t, "Post"))
+	require.NoError(t, err)
+	// the author is now sent after the post that refers to it
+	_, err = phone.dao.PatchRecord(ctx, 1, map[string]any{"name": "ada lovelace"}, nil, phone.collectionId(t, "Person"))
+	require.NoError(t, err)
+
+	require.NoError(t, laptop.replicator.Sync(ctx, phone.host.ID()))
+	require.Equal(t, []string{"ada lovelace"}, laptop.names(t))
+	_, err = laptop.dao.GetRecord(ctx, 1, nil, laptop.collectionId(t, "Post"))
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// the vector did not move past the post, so it is sent again
+	require.NoError(t, laptop.replicator.Sync(ctx, phone.host.ID()))
+	require.JSONEq(t, `{"title": "hello", "author": {"name": "ada lovelace"}}`, laptop.post(t, 1))
+}
+
+func TestReplicatorSyncAuthorization(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New()
+	defer mn.Close()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	phone := newReplica(t, mn, now)
+	laptopHost, err := mn.GenPeer()
+	require.NoError(t, err)
+	// the phone can write the default namespace, but not change the schema
+	laptop := newReplicaWithPolicy(t, laptopHost, now.Add(time.Minute), acl.Policy{
+		Roles: map[string]acl.Role{
+			"writer": {Namespaces: map[string][]acl.Permission{"": {acl.Read, acl.Write}}},
+		},
+		Peers: map[string][]string{phone.host.ID().String(): {"writer"}},
+	})
+	require.NoError(t, mn.LinkAll())
+	_, err = mn.ConnectPeers(phone.host.ID(), laptop.host.ID())
+	require.NoError(t, err)
+
+	person := func() *dao.Collection {
+		return &dao.Collection{
+			Name:    "Person",
+			Version: "1",
+			Fields:  map[string]dao.CollectionField{"name": {Name: "name", Type: "String"}},
+		}
+	}
+	require.NoError(t, phone.dao.AddCollections(ctx, []*dao.Collection{person(), {
+		Name:    "Note",
+		Version: "1",
+		Fields:  map[string]dao.CollectionField{"text": {Name: "text", Type: "String"}},
+	}}))
+	laptopPerson := person()
+	require.NoError(t, laptop.dao.AddCollections(ctx, []*dao.Collection{laptopPerson}))
+	require.NoError(t, laptop.dao.AddCollections(ctx, []*dao.Collection{{
+		Name:    "Badge",
+		Domain:  "private",
+		Version: "1",
+		Fields: map[string]dao.CollectionField{
+			"person": {Name: "person", Type: "Person", Ref: laptopPerson.Id, OnDelete: dao.OnDeleteCascade},
+		},
+	}}))
+
+	require.NoError(t, phone.replicator.Start(ctx, phone.host))
+	defer phone.replicator.Close()
+	require.NoError(t, laptop.replicator.Start(ctx, laptop.host))
+	defer laptop.replicator.Close()
+
+	_, err = phone.dao.SetRecord(ctx, map[string]any{"name": "ada"}, nil, phone.collectionId(t, "Person"))
+	require.NoError(t, err)
+	require.NoError(t, phone.replicator.Sync(ctx, laptop.host.ID()))
+	require.Equal(t, []string{"ada"}, laptop.names(t))
+	_, err = laptop.dao.GetCollectionId(ctx, dao.CollectionSpec{Name: "Note"})
+	require.Error(t, err)
+
+	// the delete would cascade to the badges of the private namespace
+	_, err = phone.dao.DeleteRecord(ctx, 1, nil, phone.collectionId(t, "Person"))
+	require.NoError(t, err)
+	require.NoError(t, phone.replicator.Sync(ctx, laptop.host.ID()))
+	require.Equal(t, []string{"ada"}, laptop.names(t))
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sashankg/hold/acl"
+	"gopkg.in/yaml.v3"
+)
+
+// Routes that can be enabled in Config.Routes.
+const (
+	RouteGraph         = "graph"
+	RouteSchema        = "schema"
+	RouteUpload        = "upload"
+	RouteBlob          = "blob"
+	RouteFiles         = "files"
+	RouteGc            = "gc"
+	RoutePprof         = "pprof"
+	RouteStatus        = "status"
+	RouteTokens        = "tokens"
+	RouteSubscriptions = "subscriptions"
+	RouteReplication   = "replication"
+)
+
+var allRoutes = []string{RouteGraph, RouteSchema, RouteUpload, RouteBlob, RouteFiles, RouteGc, RoutePprof, RouteStatus, RouteTokens, RouteSubscriptions, RouteReplication}
+
+// Config is loaded from a YAML file, then overridden by HOLD_* environment
+// variables and then by flags. Relative paths are relative to DataDir.
+type Config struct {
+	DataDir      string   `yaml:"dataDir"`
+	IdentityPath string   `yaml:"identityPath"`
+	BlobDir      string   `yaml:"blobDir"`
+	StaticDir    string   `yaml:"staticDir"`
+	Relays       []string `yaml:"relays"`
+	// RelayReservations is how many of Relays to hold a reservation on at
+	// once. The others are failed over to.
+	RelayReservations int `yaml:"relayReservations"`
+	// Replicas are the multiaddrs of the nodes to replicate records with.
+	Replicas    []string `yaml:"replicas"`
+	ListenAddrs []string `yaml:"listenAddrs"`
+	LogLevel    string   `yaml:"logLevel"`
+	Routes      []string `yaml:"routes"`
+	// MaxUploadSize is the largest resumable upload in bytes.
+	MaxUploadSize int64 `yaml:"maxUploadSize"`
+	// Acl gives peers access to namespaces. It can only be set in the file.
+	Acl acl.Policy `yaml:"acl"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		DataDir:           ".",
+		IdentityPath:      "server.key",
+		BlobDir:           "blobs",
+		StaticDir:         "static",
+		LogLevel:          "info",
+		Routes:            allRoutes,
+		MaxUploadSize:     4 << 30,
+		RelayReservations: 1,
+	}
+}
+
+// LoadConfig reads the config file given by the -config flag or HOLD_CONFIG,
+// applies the overrides and validates the result.
+func LoadConfig(args []string, getenv func(string) string) (*Config, error) {
+	config := DefaultConfig()
+	flags := flag.NewFlagSet("hold", flag.ContinueOnError)
+	configPath := flags.String("config", getenv("HOLD_CONFIG"), "path of a YAML config file")
+	dataDir := flags.String("data-dir", "", "directory of the databases, identity and blobs")
+	identityPath := flags.String("identity", "", "path of the libp2p identity key")
+	blobDir := flags.String("blob-dir", "", "directory of the blob store")
+	staticDir := flags.String("static-dir", "", "directory of static pages")
+	relays := flags.String("relays", "", "comma separated multiaddrs of circuit relays")
+	relayReservations := flags.String("relay-reservations", "", "number of relays to hold a reservation on")
+	replicas := flags.String("replicas", "", "comma separated multiaddrs of nodes to replicate with")
+	listenAddrs := flags.String("listen", "", "comma separated multiaddrs to listen on")
+	logLevel := flags.String("log-level", "", "one of debug, info, warn, error")
+	routes := flags.String("routes", "", "comma separated routes to enable: "+strings.Join(allRoutes, ", "))
+	maxUploadSize := flags.String("max-upload-size", "", "largest resumable upload in bytes")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configPath != "" {
+		file, err := os.ReadFile(*configPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading config: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(file))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parsing config %s: %w", *configPath, err)
+		}
+	}
+
+	overrides := []struct {
+		env   string
+		flag  string
+		value *string
+		apply func(string) error
+	}{
+		{"HOLD_DATA_DIR", "data-dir", dataDir, setString(&config.DataDir)},
+		{"HOLD_IDENTITY", "identity", identityPath, setString(&config.IdentityPath)},
+		{"HOLD_BLOB_DIR", "blob-dir", blobDir, setString(&config.BlobDir)},
+		{"HOLD_STATIC_DIR", "static-dir", staticDir, setString(&config.StaticDir)},
+		{"HOLD_RELAYS", "relays", relays, setList(&config.Relays)},
+		{"HOLD_RELAY_RESERVATIONS", "relay-reservations", relayReservations, func(value string) error {
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid relay reservations: %s", value)
+			}
+			config.RelayReservations = count
+			return nil
+		}},
+		{"HOLD_REPLICAS", "replicas", replicas, setList(&config.Replicas)},
+		{"HOLD_LISTEN", "listen", listenAddrs, setList(&config.ListenAddrs)},
+		{"HOLD_LOG_LEVEL", "log-level", logLevel, setString(&config.LogLevel)},
+		{"HOLD_ROUTES", "routes", routes, setList(&config.Routes)},
+		{"HOLD_MAX_UPLOAD_SIZE", "max-upload-size", maxUploadSize, func(value string) error {
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid max upload size: %s", value)
+			}
+			config.MaxUploadSize = size
+			return nil
+		}},
+	}
+	setFlags := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+	for _, override := range overrides {
+		if value := getenv(override.env); value != "" {
+			if err := override.apply(value); err != nil {
+				return nil, fmt.Errorf("%s: %w", override.env, err)
+			}
+		}
+		if setFlags[override.flag] {
+			if err := override.apply(*override.value); err != nil {
+				return nil, fmt.Errorf("-%s: %w", override.flag, err)
+			}
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Validate checks every setting, and reports all the invalid ones at once.
+func (c *Config) Validate() error {
+	errs := []error{}
+	if c.DataDir == "" {
+		errs = append(errs, errors.New("dataDir is required"))
+	} else if info, err := os.Stat(c.DataDir); err != nil || !info.IsDir() {
+		errs = append(errs, fmt.Errorf("dataDir %s is not a directory", c.DataDir))
+	}
+	if c.IdentityPath == "" {
+		errs = append(errs, errors.New("identityPath is required"))
+	}
+	if c.BlobDir == "" {
+		errs = append(errs, errors.New("blobDir is required"))
+	}
+	for _, relay := range c.Relays {
+		if _, err := peer.AddrInfoFromString(relay); err != nil {
+			errs = append(errs, fmt.Errorf("relay %s is not a multiaddr with a peer id: %w", relay, err))
+		}
+	}
+	if c.RelayReservations <= 0 {
+		errs = append(errs, errors.New("relayReservations needs to be positive"))
+	}
+	for _, replica := range c.Replicas {
+		if _, err := peer.AddrInfoFromString(replica); err != nil {
+			errs = append(errs, fmt.Errorf("replica %s is not a multiaddr with a peer id: %w", replica, err))
+		}
+	}
+	for _, addr := range c.ListenAddrs {
+		if _, err := multiaddr.NewMultiaddr(addr); err != nil {
+			errs = append(errs, fmt.Errorf("listen addr %s is not a multiaddr: %w", addr, err))
+		}
+	}
+	if _, err := logging.LevelFromString(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("logLevel %s is not one of debug, info, warn, error", c.LogLevel))
+	}
+	for _, route := range c.Routes {
+		if !c.isKnownRoute(route) {
+			errs = append(errs, fmt.Errorf("route %s is not one of %s", route, strings.Join(allRoutes, ", ")))
+		}
+	}
+	if c.MaxUploadSize <= 0 {
+		errs = append(errs, errors.New("maxUploadSize needs to be positive"))
+	}
+	if _, err := acl.NewAcl(c.Acl, ""); err != nil {
+		errs = append(errs, fmt.Errorf("acl: %w", err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func (c *Config) isKnownRoute(route string) bool {
+	for _, known := range allRoutes {
+		if route == known {
+			return true
+		}
+	}
+	return false
+}
+
+// RouteEnabled reports whether a route is in Routes.
+func (c *Config) RouteEnabled(route string) bool {
+	for _, enabled := range c.Routes {
+		if route == enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// Path resolves a path in the config against DataDir.
+func (c *Config) Path(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(c.DataDir, path)
+}
+
+func setString(target *string) func(string) error {
+	return func(value string) error {
+		*target = value
+		return nil
+	}
+}
+
+func setList(target *[]string) func(string) error {
+	return func(value string) error {
+		*target = []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*target = append(*target, item)
+			}
+		}
+		return nil
+	}
+}
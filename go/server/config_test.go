@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testRelay = "/ip4/127.0.0.1/tcp/4002/ws/p2p/QmNpBvAKWrjigDHP4Mn3LpqCmin5F2K9TiVFoFGTC6ayV3"
+
+func TestLoadConfig(t *testing.T) {
+	dataDir := t.TempDir()
+	configPath := filepath.Join(dataDir, "hold.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+dataDir: `+dataDir+`
+relays:
+  - `+testRelay+`
+logLevel: debug
+routes: [graph, schema]
+acl:
+  roles:
+    reader:
+      namespaces:
+        "": [read]
+  peers:
+    "*": [reader]
+`), 0o644))
+
+	env := map[string]string{
+		"HOLD_CONFIG":             configPath,
+		"HOLD_LOG_LEVEL":          "warn",
+		"HOLD_BLOB_DIR":           "/var/lib/hold/blobs",
+		"HOLD_RELAY_RESERVATIONS": "2",
+		"HOLD_REPLICAS":           testRelay,
+	}
+	config, err := LoadConfig([]string{"-log-level", "error", "-listen", "/ip4/0.0.0.0/tcp/4001"}, func(key string) string {
+		return env[key]
+	})
+	require.NoError(t, err)
+	require.Equal(t, dataDir, config.DataDir)
+	require.Equal(t, []string{testRelay}, config.Relays)
+	require.Equal(t, 2, config.RelayReservations)
+	require.Equal(t, []string{testRelay}, config.Replicas)
+	require.Equal(t, []string{"/ip4/0.0.0.0/tcp/4001"}, config.ListenAddrs)
+	require.Equal(t, "error", config.LogLevel)
+	require.Equal(t, "/var/lib/hold/blobs", config.Path(config.BlobDir))
+	require.Equal(t, filepath.Join(dataDir, "server.key"), config.Path(config.IdentityPath))
+	require.True(t, config.RouteEnabled(RouteSchema))
+	require.False(t, config.RouteEnabled(RouteUpload))
+	require.Equal(t, []string{"reader"}, config.Acl.Peers["*"])
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	_, err := LoadConfig([]string{
+		"-data-dir", filepath.Join(t.TempDir(), "missing"),
+		"-relays", "/ip4/127.0.0.1/tcp/4002",
+		"-replicas", "/ip4/127.0.0.1/tcp/4003",
+		"-log-level", "loud",
+		"-routes", "graph,admin",
+	}, func(string) string { return "" })
+	require.ErrorContains(t, err, "dataDir")
+	require.ErrorContains(t, err, "relay /ip4/127.0.0.1/tcp/4002")
+	require.ErrorContains(t, err, "replica /ip4/127.0.0.1/tcp/4003")
+	require.ErrorContains(t, err, "logLevel loud")
+	require.ErrorContains(t, err, "route admin")
+
+	configPath := filepath.Join(t.TempDir(), "hold.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte("relay: typo\n"), 0o644))
+	_, err = LoadConfig([]string{"-config", configPath}, func(string) string { return "" })
+	require.ErrorContains(t, err, "field relay not found")
+}
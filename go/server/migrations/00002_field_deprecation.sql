@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE `collection_fields` ADD COLUMN deprecation_reason TEXT;
+
+-- +goose Down
+ALTER TABLE `collection_fields` DROP COLUMN deprecation_reason;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE `collection_fields` ADD COLUMN is_non_null INTEGER;
+
+-- +goose Down
+ALTER TABLE `collection_fields` DROP COLUMN is_non_null;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE `collection_fields` ADD COLUMN inverse_of TEXT;
+
+-- +goose Down
+ALTER TABLE `collection_fields` DROP COLUMN inverse_of;
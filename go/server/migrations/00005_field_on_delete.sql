@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE `collection_fields` ADD COLUMN on_delete TEXT;
+
+-- +goose Down
+ALTER TABLE `collection_fields` DROP COLUMN on_delete;
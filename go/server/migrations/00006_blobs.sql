@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE `blobs` (
+    digest TEXT PRIMARY KEY,
+    size INTEGER NOT NULL,
+    mime_type TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE blobs;
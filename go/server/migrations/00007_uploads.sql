@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE `uploads` (
+    id TEXT PRIMARY KEY,
+    length INTEGER NOT NULL,
+    upload_offset INTEGER NOT NULL DEFAULT 0,
+    metadata TEXT NOT NULL,
+    mime_type TEXT NOT NULL,
+    expected_digest TEXT NOT NULL,
+    blob_digest TEXT,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE uploads;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- the log is kept with the records, so that it is written in the same
+-- transaction as them
+DROP INDEX replication_log_origin;
+DROP TABLE replication_log;
+DROP TABLE replication_vector;
+
+-- +goose Down
+CREATE TABLE `replication_log` (
+    collection_id INTEGER NOT NULL,
+    record_id INTEGER NOT NULL,
+    deleted INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    origin TEXT NOT NULL,
+    PRIMARY KEY (collection_id, record_id)
+);
+
+CREATE INDEX replication_log_origin ON replication_log (origin, version);
+
+CREATE TABLE `replication_vector` (
+    origin TEXT PRIMARY KEY,
+    version INTEGER NOT NULL
+);
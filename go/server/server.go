@@ -1,92 +1,192 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"database/sql"
 	"embed"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"time"
 
-	_ "github.com/ipfs/go-log"
+	logging "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p"
 	gostream "github.com/libp2p/go-libp2p-gostream"
-	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
-	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/libp2p/go-libp2p/core/peerstore"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pressly/goose/v3"
+	"github.com/sashankg/hold/acl"
+	"github.com/sashankg/hold/blobs"
+	"github.com/sashankg/hold/core"
 	"github.com/sashankg/hold/dao"
 	"github.com/sashankg/hold/graphql"
 	"github.com/sashankg/hold/handlers"
+	"github.com/sashankg/hold/p2p"
+	"github.com/sashankg/hold/replication"
 	"github.com/sashankg/hold/util"
 )
 
+// logger is shared by the packages of hold under the name hold, so that
+// logLevel sets its level along with those of libp2p.
+var logger = logging.Logger("hold")
+
 //go:embed migrations/*.sql
 var migrations embed.FS
 
 func main() {
+	config, err := LoadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	logLevel, _ := logging.LevelFromString(config.LogLevel)
+	logging.SetAllLoggers(logLevel)
+
 	goose.SetBaseFS(migrations)
 
-	schemaDb, err := NewSchemaDb()
+	schemaDb, err := NewSchemaDb(config.Path("schema.db"))
 	if err != nil {
 		panic(err)
 	}
 
-	recordDb, err := NewRecordDb()
+	recordDb, err := NewRecordDb(config.Path("record.db"))
 	if err != nil {
 		panic(err)
 	}
 
 	daoObj := dao.NewDao(schemaDb, recordDb)
 
-	validator := graphql.NewValidator(daoObj)
-	resolver := graphql.NewResolver(daoObj)
+	blobStore, err := blobs.NewStore(schemaDb, config.Path(config.BlobDir), &blobs.Sha256Hasher{})
+	if err != nil {
+		panic(err)
+	}
+	uploads, err := blobs.NewUploads(schemaDb, blobStore, 24*time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	collector := blobs.NewGarbageCollector(blobStore, daoObj, 24*time.Hour)
 
-	privKey, err := util.LoadIdentity("server.key")
+	privKey, err := util.LoadIdentity(config.Path(config.IdentityPath))
+	if err != nil {
+		panic(err)
+	}
+	self, err := peer.IDFromPrivateKey(privKey)
 	if err != nil {
 		panic(err)
 	}
 
-	relayAddrInfo, err := peer.AddrInfoFromString(
-		"/ip4/127.0.0.1/tcp/4002/ws/p2p/QmNpBvAKWrjigDHP4Mn3LpqCmin5F2K9TiVFoFGTC6ayV3",
-	)
+	authorizer, err := acl.NewAcl(config.Acl, self)
 	if err != nil {
 		panic(err)
 	}
-	println("Relay ID", relayAddrInfo.ID.String())
-	println("Relay Addr", relayAddrInfo.Addrs[0].String())
+	if config.Acl.Open {
+		logger.Warn("The ACL is open, every peer has full access")
+	} else if len(config.Acl.Peers) == 0 {
+		logger.Warn("No peers in the ACL, only this node has access")
+	}
 
-	host, err := libp2p.New(
+	validator := graphql.NewValidator(daoObj, authorizer)
+	resolver := graphql.NewResolver(daoObj, blobStore)
+	introspector, err := graphql.NewIntrospector(context.Background(), daoObj)
+	if err != nil {
+		panic(err)
+	}
+	registrar := graphql.NewRegistrar(daoObj, introspector)
+
+	tokens, err := acl.NewTokens(privKey, time.Now)
+	if err != nil {
+		panic(err)
+	}
+
+	replicator := replication.NewReplicator(daoObj, recordDb, authorizer, time.Now, introspector)
+
+	relays := make([]peer.AddrInfo, len(config.Relays))
+	for i, relay := range config.Relays {
+		// already validated by LoadConfig
+		relayAddrInfo, _ := peer.AddrInfoFromString(relay)
+		relays[i] = *relayAddrInfo
+		logger.Infow("Relay", "relay", relayAddrInfo)
+	}
+
+	var host host.Host
+	relayOptions := p2p.DefaultRelayOptions()
+	relayOptions.Reservations = config.RelayReservations
+	relayManager := p2p.NewRelayManager(relays, p2p.HostReserver(&host), relayOptions)
+	node := p2p.NewNode(relayManager)
+
+	options := append([]libp2p.Option{
 		libp2p.Identity(privKey),
-		libp2p.EnableAutoRelayWithStaticRelays(
-			[]peer.AddrInfo{
-				*relayAddrInfo,
-			},
-		),
-	)
+	}, node.Options()...)
+	if len(config.ListenAddrs) > 0 {
+		options = append(options, libp2p.ListenAddrStrings(config.ListenAddrs...))
+	}
+	host, err = libp2p.New(options...)
 	if err != nil {
 		panic(err)
 	}
 
-	println("Host ID", host.ID().String())
+	logger.Infow("Host ID", "id", host.ID())
 	for _, addr := range host.Network().ListenAddresses() {
-		println("Listening on", addr.String())
+		logger.Infow("Listening on", "addr", addr)
 	}
 
-	reservation, err := client.Reserve(context.Background(), host, *relayAddrInfo)
-	if err != nil {
+	if err := node.Start(host); err != nil {
 		panic(err)
 	}
-	// println("Reservation", reservation.Addrs[0].String())
-	println("Reservation", reservation.LimitData)
+	runCtx, stopRunning := context.WithCancel(context.Background())
+	if len(relays) > 0 {
+		go relayManager.Run(runCtx)
+	}
 
 	mux := http.NewServeMux()
-	mux.Handle("/graph", handlers.NewGraphqlHandler(validator, resolver))
-	mux.Handle("/upload", handlers.NewUploadHandler(&handlers.FnvHasher{}))
+	graphqlHandler := handlers.NewGraphqlHandler(validator, resolver, introspector, authorizer, tokens)
+	graphqlWsHandler := handlers.NewGraphqlWsHandler(graphqlHandler)
+	routes := map[string]core.Route{
+		RouteGraph:         graphqlHandler,
+		RouteSchema:        handlers.NewSchemaHandler(registrar, daoObj, authorizer),
+		RouteUpload:        handlers.NewUploadHandler(blobStore, filepath.Join(config.Path(config.StaticDir), "upload.html"), authorizer),
+		RouteBlob:          handlers.NewBlobHandler(blobStore, authorizer),
+		RouteFiles:         handlers.NewTusHandler(uploads, config.MaxUploadSize, authorizer),
+		RouteGc:            handlers.NewGcHandler(collector, authorizer),
+		RouteStatus:        handlers.NewStatusHandler(node, authorizer),
+		RouteTokens:        handlers.NewTokenHandler(tokens, authorizer),
+		RouteSubscriptions: graphqlWsHandler,
+	}
+	for name, route := range routes {
+		if config.RouteEnabled(name) {
+			mux.Handle(route.Route(), route)
+		}
+	}
+	if config.RouteEnabled(RouteGraph) {
+		host.SetStreamHandler(handlers.GraphqlProtocol, graphqlHandler.ServeStream)
+	}
+	if config.RouteEnabled(RouteSubscriptions) {
+		host.SetStreamHandler(handlers.GraphqlWsProtocol, graphqlWsHandler.ServeStream)
+	}
+	if config.RouteEnabled(RouteReplication) {
+		if err := replicator.Start(context.Background(), host); err != nil {
+			panic(err)
+		}
+		replicas := make([]peer.ID, len(config.Replicas))
+		for i, replica := range config.Replicas {
+			// already validated by LoadConfig
+			replicaAddrInfo, _ := peer.AddrInfoFromString(replica)
+			host.Peerstore().AddAddrs(replicaAddrInfo.ID, replicaAddrInfo.Addrs, peerstore.PermanentAddrTTL)
+			replicas[i] = replicaAddrInfo.ID
+			logger.Infow("Replica", "replica", replicaAddrInfo)
+		}
+		if len(replicas) > 0 {
+			go replicator.Run(runCtx, replicas, time.Minute)
+		}
+	}
+	if config.RouteEnabled(RoutePprof) {
+		mux.Handle("/debug/pprof/", requireAdmin(authorizer, pprof.Handler("heap")))
+	}
 
 	server := NewServer(mux)
 
@@ -98,7 +198,25 @@ func main() {
 	go func() {
 		err := server.Serve(listener)
 		if err != nil {
-			println(err.Error())
+			logger.Errorw("error serving", "error", err)
+		}
+	}()
+
+	go func() {
+		for now := range time.Tick(time.Hour) {
+			if _, err := uploads.Expire(context.Background(), now); err != nil {
+				logger.Errorw("error expiring uploads", "error", err)
+			}
+		}
+	}()
+	go func() {
+		for range time.Tick(24 * time.Hour) {
+			report, err := collector.Collect(context.Background(), false)
+			if err != nil {
+				logger.Errorw("error collecting blobs", "error", err)
+				continue
+			}
+			logger.Infow("Collected blobs", "deleted", len(report.Deleted), "freedBytes", report.FreedBytes)
 		}
 	}()
 
@@ -109,19 +227,24 @@ func main() {
 		panic(err)
 	}
 
+	stopRunning()
+	if config.RouteEnabled(RouteReplication) {
+		replicator.Close()
+	}
+	node.Close()
 	host.Close()
 }
 
 func NewServer(serveMux *http.ServeMux) *http.Server {
-	serveMux.Handle("/debug/pprof/", pprof.Handler("heap"))
 	return &http.Server{
-		Handler:  serveMux,
-		ErrorLog: log.Default(),
+		Handler:     serveMux,
+		ErrorLog:    log.Default(),
+		ConnContext: p2p.ConnContext,
 	}
 }
 
-func NewSchemaDb() (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", "schema.db")
+func NewSchemaDb(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return nil, err
 	}
@@ -134,68 +257,17 @@ func NewSchemaDb() (*sql.DB, error) {
 	return db, err
 }
 
-func NewRecordDb() (*sql.DB, error) {
-	return sql.Open("sqlite3", "record.db")
+func NewRecordDb(path string) (*sql.DB, error) {
+	return sql.Open("sqlite3", path)
 }
 
-func NewHttpProxy(h http.Handler) network.StreamHandler {
-	return func(s network.Stream) {
-		defer s.Close()
-		// Create a new buffered reader, as ReadRequest needs one.
-		// The buffered reader reads from our stream, on which we
-		// have sent the HTTP request
-		buf := bufio.NewReader(s)
-		b, err := io.ReadAll(buf)
-		fmt.Println("%w", b)
-
-		// Read the HTTP request from the buffer
-		req, err := http.ReadRequest(buf)
-		if err != nil {
-			s.Reset()
-			fmt.Println(err)
+// requireAdmin serves handler only to peers with the Admin permission.
+func requireAdmin(authorizer acl.Authorizer, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorizer.Allowed(r.Context(), "", "", acl.Admin) {
+			w.WriteHeader(http.StatusForbidden)
 			return
 		}
-		defer req.Body.Close()
-
-		h.ServeHTTP(&ResponseWriter{
-			Writer: s,
-		}, req)
-	}
-}
-
-type ResponseWriter struct {
-	header      http.Header
-	wroteHeader bool
-	Writer      io.Writer
-}
-
-var _ http.ResponseWriter = &ResponseWriter{}
-
-// Header implements http.ResponseWriter.
-func (r *ResponseWriter) Header() http.Header {
-	return r.header
-}
-
-// WriteHeader implements http.ResponseWriter.
-func (r *ResponseWriter) WriteHeader(statusCode int) {
-	if r.wroteHeader {
-		return
-	}
-	r.Writer.Write(
-		[]byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, http.StatusText(statusCode))),
-	)
-	for k, v := range r.header {
-		for _, vv := range v {
-			r.Writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", k, vv)))
-		}
-	}
-	r.Writer.Write([]byte("\r\n"))
-}
-
-// Write implements http.ResponseWriter.
-func (r *ResponseWriter) Write(b []byte) (int, error) {
-	if !r.wroteHeader {
-		r.WriteHeader(http.StatusOK)
-	}
-	return r.Writer.Write(b)
+		handler.ServeHTTP(w, r)
+	})
 }
@@ -12,6 +12,7 @@ package mocks
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	dao "github.com/sashankg/hold/dao"
 	gomock "go.uber.org/mock/gomock"
@@ -68,6 +69,35 @@ func (mr *MockDaoMockRecorder) AddCollections(ctx, collection any) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddCollections", reflect.TypeOf((*MockDao)(nil).AddCollections), ctx, collection)
 }
 
+// DeleteRecord mocks base method.
+func (m *MockDao) DeleteRecord(ctx context.Context, id int, selection []dao.Selection, collectionId int) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteRecord", ctx, id, selection, collectionId)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteRecord indicates an expected call of DeleteRecord.
+func (mr *MockDaoMockRecorder) DeleteRecord(ctx, id, selection, collectionId any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteRecord", reflect.TypeOf((*MockDao)(nil).DeleteRecord), ctx, id, selection, collectionId)
+}
+
+// DropCollectionField mocks base method.
+func (m *MockDao) DropCollectionField(ctx context.Context, collection *dao.Collection, fieldName string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DropCollectionField", ctx, collection, fieldName)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DropCollectionField indicates an expected call of DropCollectionField.
+func (mr *MockDaoMockRecorder) DropCollectionField(ctx, collection, fieldName any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DropCollectionField", reflect.TypeOf((*MockDao)(nil).DropCollectionField), ctx, collection, fieldName)
+}
+
 // FindCollectionById mocks base method.
 func (m *MockDao) FindCollectionById(ctx context.Context, id int) (*dao.Collection, error) {
 	m.ctrl.T.Helper()
@@ -127,3 +157,193 @@ func (mr *MockDaoMockRecorder) GetRecord(ctx, id, selection, collectionId any) *
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRecord", reflect.TypeOf((*MockDao)(nil).GetRecord), ctx, id, selection, collectionId)
 }
+
+// GetRecordAsOf mocks base method.
+func (m *MockDao) GetRecordAsOf(ctx context.Context, id int, asOf time.Time, selection []dao.Selection, collectionId int) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRecordAsOf", ctx, id, asOf, selection, collectionId)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRecordAsOf indicates an expected call of GetRecordAsOf.
+func (mr *MockDaoMockRecorder) GetRecordAsOf(ctx, id, asOf, selection, collectionId any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRecordAsOf", reflect.TypeOf((*MockDao)(nil).GetRecordAsOf), ctx, id, asOf, selection, collectionId)
+}
+
+// ListCollections mocks base method.
+func (m *MockDao) ListCollections(ctx context.Context) ([]*dao.Collection, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListCollections", ctx)
+	ret0, _ := ret[0].([]*dao.Collection)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListCollections indicates an expected call of ListCollections.
+func (mr *MockDaoMockRecorder) ListCollections(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListCollections", reflect.TypeOf((*MockDao)(nil).ListCollections), ctx)
+}
+
+// ListRecordHistory mocks base method.
+func (m *MockDao) ListRecordHistory(ctx context.Context, id, first int, selection []dao.Selection, collectionId int) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListRecordHistory", ctx, id, first, selection, collectionId)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListRecordHistory indicates an expected call of ListRecordHistory.
+func (mr *MockDaoMockRecorder) ListRecordHistory(ctx, id, first, selection, collectionId any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRecordHistory", reflect.TypeOf((*MockDao)(nil).ListRecordHistory), ctx, id, first, selection, collectionId)
+}
+
+// ListRecords mocks base method.
+func (m *MockDao) ListRecords(ctx context.Context, params dao.ListParams, selection []dao.Selection, collectionId int) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListRecords", ctx, params, selection, collectionId)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListRecords indicates an expected call of ListRecords.
+func (mr *MockDaoMockRecorder) ListRecords(ctx, params, selection, collectionId any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRecords", reflect.TypeOf((*MockDao)(nil).ListRecords), ctx, params, selection, collectionId)
+}
+
+// OnChange mocks base method.
+func (m *MockDao) OnChange(listener dao.ChangeListener) func() {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OnChange", listener)
+	ret0, _ := ret[0].(func())
+	return ret0
+}
+
+// OnChange indicates an expected call of OnChange.
+func (mr *MockDaoMockRecorder) OnChange(listener any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnChange", reflect.TypeOf((*MockDao)(nil).OnChange), listener)
+}
+
+// OnWrite mocks base method.
+func (m *MockDao) OnWrite(hook dao.WriteHook) func() {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OnWrite", hook)
+	ret0, _ := ret[0].(func())
+	return ret0
+}
+
+// OnWrite indicates an expected call of OnWrite.
+func (mr *MockDaoMockRecorder) OnWrite(hook any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnWrite", reflect.TypeOf((*MockDao)(nil).OnWrite), hook)
+}
+
+// PatchRecord mocks base method.
+func (m *MockDao) PatchRecord(ctx context.Context, id int, values map[string]any, selection []dao.Selection, collectionId int) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PatchRecord", ctx, id, values, selection, collectionId)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PatchRecord indicates an expected call of PatchRecord.
+func (mr *MockDaoMockRecorder) PatchRecord(ctx, id, values, selection, collectionId any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PatchRecord", reflect.TypeOf((*MockDao)(nil).PatchRecord), ctx, id, values, selection, collectionId)
+}
+
+// ReferencedBlobs mocks base method.
+func (m *MockDao) ReferencedBlobs(ctx context.Context) (map[string]bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReferencedBlobs", ctx)
+	ret0, _ := ret[0].(map[string]bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReferencedBlobs indicates an expected call of ReferencedBlobs.
+func (mr *MockDaoMockRecorder) ReferencedBlobs(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReferencedBlobs", reflect.TypeOf((*MockDao)(nil).ReferencedBlobs), ctx)
+}
+
+// RetypeCollectionField mocks base method.
+func (m *MockDao) RetypeCollectionField(ctx context.Context, collection *dao.Collection, field dao.CollectionField) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RetypeCollectionField", ctx, collection, field)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RetypeCollectionField indicates an expected call of RetypeCollectionField.
+func (mr *MockDaoMockRecorder) RetypeCollectionField(ctx, collection, field any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetypeCollectionField", reflect.TypeOf((*MockDao)(nil).RetypeCollectionField), ctx, collection, field)
+}
+
+// SearchRecords mocks base method.
+func (m *MockDao) SearchRecords(ctx context.Context, params dao.SearchParams, selection []dao.Selection, collectionId int) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchRecords", ctx, params, selection, collectionId)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchRecords indicates an expected call of SearchRecords.
+func (mr *MockDaoMockRecorder) SearchRecords(ctx, params, selection, collectionId any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchRecords", reflect.TypeOf((*MockDao)(nil).SearchRecords), ctx, params, selection, collectionId)
+}
+
+// SetCollectionVersion mocks base method.
+func (m *MockDao) SetCollectionVersion(ctx context.Context, collection *dao.Collection) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetCollectionVersion", ctx, collection)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetCollectionVersion indicates an expected call of SetCollectionVersion.
+func (mr *MockDaoMockRecorder) SetCollectionVersion(ctx, collection any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetCollectionVersion", reflect.TypeOf((*MockDao)(nil).SetCollectionVersion), ctx, collection)
+}
+
+// SetRecord mocks base method.
+func (m *MockDao) SetRecord(ctx context.Context, values map[string]any, selection []dao.Selection, collectionId int) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetRecord", ctx, values, selection, collectionId)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetRecord indicates an expected call of SetRecord.
+func (mr *MockDaoMockRecorder) SetRecord(ctx, values, selection, collectionId any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetRecord", reflect.TypeOf((*MockDao)(nil).SetRecord), ctx, values, selection, collectionId)
+}
+
+// UpdateCollectionField mocks base method.
+func (m *MockDao) UpdateCollectionField(ctx context.Context, collection *dao.Collection, field dao.CollectionField) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateCollectionField", ctx, collection, field)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateCollectionField indicates an expected call of UpdateCollectionField.
+func (mr *MockDaoMockRecorder) UpdateCollectionField(ctx, collection, field any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCollectionField", reflect.TypeOf((*MockDao)(nil).UpdateCollectionField), ctx, collection, field)
+}
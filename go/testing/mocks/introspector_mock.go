@@ -0,0 +1,107 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: graphql/introspector.go
+//
+// Generated by this command:
+//
+//	mockgen -source=graphql/introspector.go -destination=testing/mocks/introspector_mock.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	graphql "github.com/graphql-go/graphql"
+	ast "github.com/graphql-go/graphql/language/ast"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockIntrospector is a mock of Introspector interface.
+type MockIntrospector struct {
+	ctrl     *gomock.Controller
+	recorder *MockIntrospectorMockRecorder
+}
+
+// MockIntrospectorMockRecorder is the mock recorder for MockIntrospector.
+type MockIntrospectorMockRecorder struct {
+	mock *MockIntrospector
+}
+
+// NewMockIntrospector creates a new mock instance.
+func NewMockIntrospector(ctrl *gomock.Controller) *MockIntrospector {
+	mock := &MockIntrospector{ctrl: ctrl}
+	mock.recorder = &MockIntrospectorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIntrospector) EXPECT() *MockIntrospectorMockRecorder {
+	return m.recorder
+}
+
+// Introspect mocks base method.
+func (m *MockIntrospector) Introspect(ctx context.Context, doc *ast.Document) *graphql.Result {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Introspect", ctx, doc)
+	ret0, _ := ret[0].(*graphql.Result)
+	return ret0
+}
+
+// Introspect indicates an expected call of Introspect.
+func (mr *MockIntrospectorMockRecorder) Introspect(ctx, doc any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Introspect", reflect.TypeOf((*MockIntrospector)(nil).Introspect), ctx, doc)
+}
+
+// SchemaChanged mocks base method.
+func (m *MockIntrospector) SchemaChanged(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SchemaChanged", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SchemaChanged indicates an expected call of SchemaChanged.
+func (mr *MockIntrospectorMockRecorder) SchemaChanged(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SchemaChanged", reflect.TypeOf((*MockIntrospector)(nil).SchemaChanged), ctx)
+}
+
+// MockSchemaListener is a mock of SchemaListener interface.
+type MockSchemaListener struct {
+	ctrl     *gomock.Controller
+	recorder *MockSchemaListenerMockRecorder
+}
+
+// MockSchemaListenerMockRecorder is the mock recorder for MockSchemaListener.
+type MockSchemaListenerMockRecorder struct {
+	mock *MockSchemaListener
+}
+
+// NewMockSchemaListener creates a new mock instance.
+func NewMockSchemaListener(ctrl *gomock.Controller) *MockSchemaListener {
+	mock := &MockSchemaListener{ctrl: ctrl}
+	mock.recorder = &MockSchemaListenerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSchemaListener) EXPECT() *MockSchemaListenerMockRecorder {
+	return m.recorder
+}
+
+// SchemaChanged mocks base method.
+func (m *MockSchemaListener) SchemaChanged(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SchemaChanged", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SchemaChanged indicates an expected call of SchemaChanged.
+func (mr *MockSchemaListenerMockRecorder) SchemaChanged(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SchemaChanged", reflect.TypeOf((*MockSchemaListener)(nil).SchemaChanged), ctx)
+}
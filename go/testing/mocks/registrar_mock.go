@@ -0,0 +1,58 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: graphql/registrar.go
+//
+// Generated by this command:
+//
+//	mockgen -source=graphql/registrar.go -destination=testing/mocks/registrar_mock.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	ast "github.com/graphql-go/graphql/language/ast"
+	dao "github.com/sashankg/hold/dao"
+	graphql "github.com/sashankg/hold/graphql"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockRegistrar is a mock of Registrar interface.
+type MockRegistrar struct {
+	ctrl     *gomock.Controller
+	recorder *MockRegistrarMockRecorder
+}
+
+// MockRegistrarMockRecorder is the mock recorder for MockRegistrar.
+type MockRegistrarMockRecorder struct {
+	mock *MockRegistrar
+}
+
+// NewMockRegistrar creates a new mock instance.
+func NewMockRegistrar(ctrl *gomock.Controller) *MockRegistrar {
+	mock := &MockRegistrar{ctrl: ctrl}
+	mock.recorder = &MockRegistrarMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRegistrar) EXPECT() *MockRegistrarMockRecorder {
+	return m.recorder
+}
+
+// RegisterSchema mocks base method.
+func (m *MockRegistrar) RegisterSchema(arg0 context.Context, arg1 *ast.Document, arg2 graphql.RegisterOptions) ([]*dao.Collection, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RegisterSchema", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]*dao.Collection)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RegisterSchema indicates an expected call of RegisterSchema.
+func (mr *MockRegistrarMockRecorder) RegisterSchema(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterSchema", reflect.TypeOf((*MockRegistrar)(nil).RegisterSchema), arg0, arg1, arg2)
+}
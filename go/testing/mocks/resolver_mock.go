@@ -14,6 +14,7 @@ import (
 	reflect "reflect"
 
 	ast "github.com/graphql-go/graphql/language/ast"
+	graphql "github.com/sashankg/hold/graphql"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -54,3 +55,18 @@ func (mr *MockResolverMockRecorder) Resolve(arg0, arg1 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Resolve", reflect.TypeOf((*MockResolver)(nil).Resolve), arg0, arg1)
 }
+
+// Subscribe mocks base method.
+func (m *MockResolver) Subscribe(arg0 context.Context, arg1 *ast.Document) (<-chan graphql.SubscriptionResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Subscribe", arg0, arg1)
+	ret0, _ := ret[0].(<-chan graphql.SubscriptionResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Subscribe indicates an expected call of Subscribe.
+func (mr *MockResolverMockRecorder) Subscribe(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Subscribe", reflect.TypeOf((*MockResolver)(nil).Subscribe), arg0, arg1)
+}
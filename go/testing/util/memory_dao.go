@@ -20,17 +20,20 @@ type memoryDao struct {
 func NewMemoryDao(t *testing.T) *memoryDao {
 	schemaDb, err := sql.Open("sqlite3", ":memory:")
 	require.NoError(t, err)
+	// every connection to :memory: is a separate database
+	schemaDb.SetMaxOpenConns(1)
 
 	cwd, err := os.Getwd()
 	require.NoError(t, err)
 
 	goose.SetLogger(goose.NopLogger())
-	goose.SetBaseFS(os.DirFS(path.Join(cwd, "..", "migrations")))
+	goose.SetBaseFS(os.DirFS(path.Join(cwd, "..", "server", "migrations")))
 	require.NoError(t, goose.SetDialect("sqlite3"))
 	require.NoError(t, goose.Up(schemaDb, "."))
 
 	recordDb, err := sql.Open("sqlite3", ":memory:")
 	require.NoError(t, err)
+	recordDb.SetMaxOpenConns(1)
 
 	return &memoryDao{
 		Dao:      dao.NewDao(schemaDb, recordDb),
@@ -38,3 +41,18 @@ func NewMemoryDao(t *testing.T) *memoryDao {
 		RecordDb: recordDb,
 	}
 }
+
+// SkipWithoutSearch skips a test of full-text search if go-sqlite3 was built
+// without the sqlite_fts5 tag, unless the test runs in CI, which always
+// builds with it.
+func SkipWithoutSearch(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	if _, err := db.Exec(`CREATE VIRTUAL TABLE search USING fts5(text)`); err != nil {
+		if os.Getenv("CI") != "" {
+			t.Fatal("full-text search needs -tags sqlite_fts5: " + err.Error())
+		}
+		t.Skip("full-text search needs -tags sqlite_fts5: " + err.Error())
+	}
+}